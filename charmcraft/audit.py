@@ -0,0 +1,356 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Find known vulnerabilities in the dependencies installed in the charm.
+
+The vulnerabilities are searched in a local snapshot of a database in the OSV
+format (https://ossf.github.io/osv-schema/), for example the export of the
+PyPI ecosystem from https://osv.dev, unzipped in a directory.
+"""
+
+import json
+import logging
+import math
+import pathlib
+import re
+from collections import namedtuple
+from email.parser import HeaderParser
+
+from charmcraft.cmdbase import CommandError
+
+logger = logging.getLogger(__name__)
+
+# the ecosystem of the packages installed in the charm
+OSV_ECOSYSTEM = "PyPI"
+
+# the severities, from the lowest to the highest
+SEVERITIES = ("unknown", "low", "medium", "high", "critical")
+
+# some databases use other names for the severities
+_SEVERITY_ALIASES = {"moderate": "medium"}
+
+# the lower limit of the CVSS score for each severity
+_CVSS_SEVERITIES = [(9.0, "critical"), (7.0, "high"), (4.0, "medium"), (0.1, "low")]
+
+# the weights of the CVSS v3 base metrics, to calculate the score from the vector;
+# the Privileges Required ones are (unchanged scope, changed scope)
+_CVSS3_WEIGHTS = {
+    "AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+    "AC": {"L": 0.77, "H": 0.44},
+    "PR": {"N": (0.85, 0.85), "L": (0.62, 0.68), "H": (0.27, 0.5)},
+    "UI": {"N": 0.85, "R": 0.62},
+    "S": {"U": False, "C": True},
+    "C": {"H": 0.56, "L": 0.22, "N": 0},
+    "I": {"H": 0.56, "L": 0.22, "N": 0},
+    "A": {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+# a simplified PEP 440 version
+_VERSION_RE = re.compile(
+    r"^v?(?:(?P<epoch>\d+)!)?(?P<release>\d+(?:\.\d+)*)"
+    r"(?:[-_.]?(?P<pre_l>alpha|beta|preview|pre|rc|a|b|c)[-_.]?(?P<pre_n>\d*))?"
+    r"(?:-(?P<post_n1>\d+)|[-_.]?(?P<post_l>post|rev|r)[-_.]?(?P<post_n2>\d*))?"
+    r"(?:[-_.]?dev[-_.]?(?P<dev_n>\d*))?"
+    r"(?:\+[a-z0-9.]+)?$"
+)
+_PRE_RELEASE_ORDER = {
+    "alpha": 0,
+    "a": 0,
+    "beta": 1,
+    "b": 1,
+    "c": 2,
+    "rc": 2,
+    "pre": 2,
+    "preview": 2,
+}
+
+Distribution = namedtuple("Distribution", "name version")
+Vulnerability = namedtuple(
+    "Vulnerability", "id aliases package version severity summary fixed_versions"
+)
+
+
+def normalize_name(name):
+    """Normalize a package name, as PyPI does."""
+    return re.sub(r"[-_.]+", "-", name).lower()
+
+
+def version_key(version):
+    """Return a key to sort versions, following a simplified PEP 440 ordering."""
+    match = _VERSION_RE.match(version.strip().lower())
+    if match is None:
+        # not a proper version, just use the numbers in it
+        return (0, tuple(int(x) for x in re.findall(r"\d+", version)), (1,), -1, (1,))
+
+    release = [int(x) for x in match.group("release").split(".")]
+    while len(release) > 1 and release[-1] == 0:
+        release.pop()
+    epoch = int(match.group("epoch") or 0)
+
+    pre_l = match.group("pre_l")
+    post_n = match.group("post_n1") or match.group("post_n2")
+    dev_n = match.group("dev_n")
+    has_post = match.group("post_n1") is not None or match.group("post_l") is not None
+    if pre_l is not None:
+        pre = (0, _PRE_RELEASE_ORDER[pre_l], int(match.group("pre_n") or 0))
+    elif dev_n is not None and not has_post:
+        # a development release goes before the pre-releases
+        pre = (-1,)
+    else:
+        pre = (1,)
+    post = int(post_n or 0) if has_post else -1
+    dev = (0, int(dev_n or 0)) if dev_n is not None else (1,)
+    return (epoch, tuple(release), pre, post, dev)
+
+
+def severity_level(severity):
+    """Return the position of the severity, to compare it with others."""
+    return SEVERITIES.index(severity)
+
+
+def _cvss3_roundup(value):
+    """Round up to one decimal, as defined in the CVSS v3.1 specification."""
+    int_input = round(value * 100000)
+    if int_input % 10000 == 0:
+        return int_input / 100000
+    return (math.floor(int_input / 10000) + 1) / 10
+
+
+def cvss3_base_score(vector):
+    """Calculate the base score from a CVSS v3 vector (None if not a valid one)."""
+    prefix, _, metrics = vector.partition("/")
+    if prefix not in ("CVSS:3.0", "CVSS:3.1"):
+        return None
+    values = {}
+    for metric in metrics.split("/"):
+        name, _, value = metric.partition(":")
+        values[name] = value
+    try:
+        weights = {
+            name: options[values[name]] for name, options in _CVSS3_WEIGHTS.items()
+        }
+    except KeyError:
+        return None
+
+    changed = weights["S"]
+    iss = 1 - (1 - weights["C"]) * (1 - weights["I"]) * (1 - weights["A"])
+    if changed:
+        impact = 7.52 * (iss - 0.029) - 3.25 * (iss - 0.02) ** 15
+    else:
+        impact = 6.42 * iss
+    if impact <= 0:
+        return 0.0
+    privileges = weights["PR"][1 if changed else 0]
+    exploitability = 8.22 * weights["AV"] * weights["AC"] * privileges * weights["UI"]
+    if changed:
+        return _cvss3_roundup(min(1.08 * (impact + exploitability), 10))
+    return _cvss3_roundup(min(impact + exploitability, 10))
+
+
+def _parse_severity(value):
+    """Get one of the supported severities from the database's value (or None).
+
+    The value may be the severity itself, a CVSS score, or a CVSS v3 vector.
+    """
+    if not isinstance(value, str):
+        return None
+    value = value.strip()
+    if value.startswith("CVSS:"):
+        score = cvss3_base_score(value)
+        if score is None:
+            return None
+    else:
+        value = value.lower()
+        value = _SEVERITY_ALIASES.get(value, value)
+        if value in SEVERITIES:
+            return value
+        try:
+            score = float(value)
+        except ValueError:
+            return None
+    for limit, severity in _CVSS_SEVERITIES:
+        if score >= limit:
+            return severity
+    return None
+
+
+def get_installed_distributions(venvpath):
+    """Return the name and version of the distributions installed in the venv.
+
+    The distributions are sorted by name.
+    """
+    distributions = []
+    metadata_files = list(venvpath.glob("*.dist-info/METADATA"))
+    metadata_files.extend(venvpath.glob("*.egg-info/PKG-INFO"))
+    for filepath in metadata_files:
+        headers = HeaderParser().parsestr(filepath.read_text(errors="replace"))
+        name, version = headers.get("Name"), headers.get("Version")
+        if name and version:
+            distributions.append(Distribution(normalize_name(name), version))
+    return sorted(distributions)
+
+
+class VulnerabilityDatabase:
+    """The known vulnerabilities for the packages, from a local OSV snapshot."""
+
+    def __init__(self, dirpath):
+        self.dirpath = pathlib.Path(dirpath)
+        if not self.dirpath.is_dir():
+            raise CommandError(
+                "Cannot use the vulnerabilities database: {!r} is not a "
+                "directory.".format(str(self.dirpath))
+            )
+        # the records affecting each package, by package name
+        self._records = {}
+        for filepath in sorted(self.dirpath.rglob("*.json")):
+            try:
+                record = json.loads(filepath.read_text())
+            except ValueError as exc:
+                raise CommandError(
+                    "Invalid record in the vulnerabilities database {!r}: {}".format(
+                        str(filepath), exc
+                    )
+                )
+            if not isinstance(record, dict) or not isinstance(record.get("id"), str):
+                logger.warning(
+                    "Ignoring malformed record in the vulnerabilities database %r.",
+                    str(filepath),
+                )
+                continue
+            for affected in record.get("affected") or []:
+                if not isinstance(affected, dict):
+                    continue
+                package = affected.get("package") or {}
+                if package.get("ecosystem") != OSV_ECOSYSTEM or "name" not in package:
+                    continue
+                name = normalize_name(package["name"])
+                self._records.setdefault(name, []).append((record, affected))
+
+    @staticmethod
+    def _is_affected(version, affected):
+        """Tell if the version is affected, as listed or in the ranges of versions."""
+        if version in (affected.get("versions") or []):
+            return True
+
+        key = version_key(version)
+        for range_ in affected.get("ranges") or []:
+            if range_.get("type") not in ("ECOSYSTEM", "SEMVER"):
+                continue
+            events = []
+            for event in range_.get("events") or []:
+                for kind, event_version in event.items():
+                    if kind == "introduced" and event_version == "0":
+                        events.append(((-1,), kind))
+                    else:
+                        events.append((version_key(event_version), kind))
+
+            is_affected = False
+            for event_key, kind in sorted(events):
+                if kind == "introduced" and key >= event_key:
+                    is_affected = True
+                elif kind == "fixed" and key >= event_key:
+                    is_affected = False
+                elif kind == "last_affected" and key > event_key:
+                    is_affected = False
+            if is_affected:
+                return True
+        return False
+
+    @staticmethod
+    def _get_severity(record, affected):
+        """Get the severity from the different places it may be in the record."""
+        candidates = [
+            (record.get("database_specific") or {}).get("severity"),
+            (affected.get("ecosystem_specific") or {}).get("severity"),
+            (affected.get("database_specific") or {}).get("severity"),
+        ]
+        candidates.extend(item.get("score") for item in record.get("severity") or [])
+        for candidate in candidates:
+            severity = _parse_severity(candidate)
+            if severity is not None:
+                return severity
+        return "unknown"
+
+    def find(self, distribution):
+        """Return the vulnerabilities affecting the distribution."""
+        vulnerabilities = []
+        for record, affected in self._records.get(distribution.name, []):
+            if not self._is_affected(distribution.version, affected):
+                continue
+            fixed_versions = sorted(
+                {
+                    event["fixed"]
+                    for range_ in affected.get("ranges") or []
+                    for event in range_.get("events") or []
+                    if "fixed" in event
+                },
+                key=version_key,
+            )
+            vulnerabilities.append(
+                Vulnerability(
+                    id=record["id"],
+                    aliases=record.get("aliases") or [],
+                    package=distribution.name,
+                    version=distribution.version,
+                    severity=self._get_severity(record, affected),
+                    summary=record.get("summary") or "",
+                    fixed_versions=fixed_versions,
+                )
+            )
+        return vulnerabilities
+
+
+def audit(distributions, database, ignore=()):
+    """Find the vulnerabilities in the distributions.
+
+    Return the vulnerabilities found and those that were ignored (by their ids or
+    aliases), separately.
+    """
+    ignore = set(ignore)
+    found = []
+    ignored = []
+    for distribution in distributions:
+        for vuln in database.find(distribution):
+            if vuln.id in ignore or ignore.intersection(vuln.aliases):
+                ignored.append(vuln)
+            else:
+                found.append(vuln)
+    return found, ignored
+
+
+def get_blocking(vulnerabilities, threshold):
+    """Return the vulnerabilities with a severity at or above the threshold.
+
+    Those of unknown severity are always included, as they may be of any severity.
+    """
+    level = severity_level(threshold)
+    return [
+        v
+        for v in vulnerabilities
+        if v.severity == "unknown" or severity_level(v.severity) >= level
+    ]
+
+
+def load_database(config, dirpath=None):
+    """Load the database from the indicated directory or the one in the config.
+
+    Return None if no database was indicated.
+    """
+    if dirpath is None:
+        if config.audit.database is None:
+            return None
+        dirpath = config.project.dirpath / config.audit.database
+    return VulnerabilityDatabase(pathlib.Path(dirpath).expanduser())
@@ -0,0 +1,273 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Verify that the relations and options of a bundle match the charms it uses."""
+
+import logging
+import pathlib
+import tempfile
+import zipfile
+import zlib
+from collections import namedtuple
+
+import yaml
+from requests.exceptions import RequestException
+
+from charmcraft import config as config_module
+from charmcraft.cmdbase import CommandError
+from charmcraft.config import CONFIG_OPTION_TYPES, is_valid_option_value
+from charmcraft.metadata import (
+    CHARM_CONFIG,
+    CHARM_METADATA,
+    get_charm_config,
+    get_metadata,
+)
+
+logger = logging.getLogger(__name__)
+
+# what is known about the charm used by an application: its endpoints (by name) and
+# its configuration options (by name, as declared in config.yaml)
+CharmInfo = namedtuple("CharmInfo", "endpoints options")
+
+# an endpoint of a charm: where it is declared in the metadata, and its interface
+Endpoint = namedtuple("Endpoint", "role interface")
+
+# the sections of the metadata that declare the charm's endpoints
+ENDPOINT_ROLES = ("provides", "requires", "peers")
+
+# the endpoint all charms provide implicitly
+JUJU_INFO_ENDPOINT = "juju-info"
+
+# the option values that Juju replaces with the content of a file when deploying
+_INCLUDE_PREFIXES = ("include-file://", "include-base64://")
+
+
+def _build_charm_info(metadata, charm_config):
+    """Build the charm's info from its metadata and configuration."""
+    endpoints = {JUJU_INFO_ENDPOINT: Endpoint("provides", JUJU_INFO_ENDPOINT)}
+    for role in ENDPOINT_ROLES:
+        for name, relation in (metadata.get(role) or {}).items():
+            # Juju accepts the interface directly instead of the relation's details
+            if isinstance(relation, dict):
+                relation = relation.get("interface")
+            endpoints[name] = Endpoint(role, relation)
+    options = (charm_config or {}).get("options") or {}
+    return CharmInfo(endpoints, options)
+
+
+def load_charm_directory(dirpath):
+    """Return the info of the charm in a project directory."""
+    config = config_module.load(dirpath)
+    metadata = get_metadata(config, dirpath)
+    return _build_charm_info(metadata, get_charm_config(config, dirpath))
+
+
+def load_charm_package(filepath):
+    """Return the info of a packed charm."""
+    if not zipfile.is_zipfile(str(filepath)):
+        raise CommandError(
+            "Cannot open {!r}: it is not a charm file.".format(str(filepath))
+        )
+    contents = {}
+    with zipfile.ZipFile(str(filepath)) as zf:
+        names = zf.namelist()
+        for filename in (CHARM_METADATA, CHARM_CONFIG):
+            if filename in names:
+                contents[filename] = yaml.safe_load(zf.read(filename))
+    return _build_charm_info(
+        contents.get(CHARM_METADATA) or {}, contents.get(CHARM_CONFIG)
+    )
+
+
+def _fetch_charm(name, channel, fetch):
+    """Return the info of a charm from Charmhub (None if it cannot be fetched)."""
+    with tempfile.TemporaryDirectory(prefix="charmcraft-bundle-") as tmpdir:
+        filepath = pathlib.Path(tmpdir) / "{}.charm".format(name)
+        try:
+            fetch(name, channel, filepath)
+        except (CommandError, RequestException) as exc:
+            logger.warning("Cannot fetch charm %r to verify the bundle: %s", name, exc)
+            return None
+        try:
+            return load_charm_package(filepath)
+        except (CommandError, zipfile.BadZipFile, zlib.error, yaml.YAMLError) as exc:
+            logger.warning("Cannot load charm %r to verify the bundle: %s", name, exc)
+            return None
+
+
+def _check_options(app_name, options, charm_info):
+    """Verify that the options set for the application exist in the charm."""
+    problems = []
+    for key, value in (options or {}).items():
+        option = charm_info.options.get(key)
+        if option is None:
+            problems.append(
+                "The option {!r} of application {!r} does not exist in its "
+                "charm.".format(key, app_name)
+            )
+            continue
+
+        option_type = (option or {}).get("type")
+        if option_type not in CONFIG_OPTION_TYPES:
+            continue
+        if isinstance(value, str) and value.startswith(_INCLUDE_PREFIXES):
+            continue
+        if not is_valid_option_value(value, option_type):
+            problems.append(
+                "The option {!r} of application {!r} must be of type {!r}.".format(
+                    key, app_name, option_type
+                )
+            )
+    return problems
+
+
+def _is_compatible(endpoint1, endpoint2):
+    """Tell if the endpoints can be related: same interface, provider and requirer."""
+    if endpoint1.interface != endpoint2.interface:
+        return False
+    return {endpoint1.role, endpoint2.role} == {"provides", "requires"}
+
+
+def _expand_relations(relations):
+    """Return the relations as pairs of endpoints.
+
+    Juju still supports the legacy form that relates an endpoint with several ones at
+    once (e.g. `[app1:ep, [app2:ep, app3:ep]]`), which is expanded into all its pairs.
+    """
+    expanded = []
+    for relation in relations:
+        if (
+            isinstance(relation, list)
+            and len(relation) == 2
+            and isinstance(relation[0], str)
+            and isinstance(relation[1], list)
+        ):
+            expanded.extend([relation[0], other] for other in relation[1])
+        else:
+            expanded.append(relation)
+    return expanded
+
+
+def _check_relation(relation, applications, charms):
+    """Verify that the relation connects existing and compatible endpoints."""
+    if not (
+        isinstance(relation, list)
+        and len(relation) == 2
+        and all(isinstance(item, str) for item in relation)
+    ):
+        return [
+            "The relation {!r} must be a pair of application endpoints.".format(
+                relation
+            )
+        ]
+    description = "between {!r} and {!r}".format(*relation)
+
+    sides = []
+    for item in relation:
+        app_name, _, endpoint_name = item.partition(":")
+        if app_name not in applications:
+            return [
+                "The relation {} refers to the unknown application {!r}.".format(
+                    description, app_name
+                )
+            ]
+        sides.append((app_name, endpoint_name))
+
+    # the endpoints can only be verified when both charms are known
+    if any(app_name not in charms for app_name, _ in sides):
+        return []
+
+    candidates = []
+    for app_name, endpoint_name in sides:
+        endpoints = charms[app_name].endpoints
+        if not endpoint_name:
+            # Juju will choose among all of them
+            candidates.append(list(endpoints.values()))
+        elif endpoint_name in endpoints:
+            candidates.append([endpoints[endpoint_name]])
+        else:
+            return [
+                "The relation {} uses the endpoint {!r}, which does not exist in "
+                "the charm of application {!r}.".format(
+                    description, endpoint_name, app_name
+                )
+            ]
+
+    for endpoint1 in candidates[0]:
+        for endpoint2 in candidates[1]:
+            if _is_compatible(endpoint1, endpoint2):
+                return []
+
+    if all(len(endpoints) == 1 for endpoints in candidates):
+        (endpoint1,), (endpoint2,) = candidates
+        if endpoint1.interface != endpoint2.interface:
+            return [
+                "The relation {} connects different interfaces ({!r} and "
+                "{!r}).".format(description, endpoint1.interface, endpoint2.interface)
+            ]
+        return [
+            "The relation {} must connect a provided endpoint with a required "
+            "one.".format(description)
+        ]
+    return ["The relation {} has no compatible endpoints.".format(description)]
+
+
+def check_bundle(bundle, dirpath, fetch=None):
+    """Verify the relations and options of the bundle; return the problems found.
+
+    Only the charms of the applications that are local (a charm project or a packed
+    charm, relative to the bundle's directory) are verified, and those in Charmhub if
+    a function to fetch them (with the name, channel, and destination path) is given.
+    """
+    applications = bundle.get("applications") or bundle.get("services") or {}
+    problems = []
+    charms = {}
+    fetched = {}
+    for app_name, app in applications.items():
+        app = app or {}
+        charm = app.get("charm")
+        if not isinstance(charm, str) or not charm:
+            continue
+
+        if charm.startswith((".", "/")):
+            charm_path = dirpath / charm
+            if charm_path.is_dir():
+                charms[app_name] = load_charm_directory(charm_path)
+            elif charm_path.is_file():
+                charms[app_name] = load_charm_package(charm_path)
+            else:
+                problems.append(
+                    "The charm {!r} of application {!r} does not exist.".format(
+                        charm, app_name
+                    )
+                )
+        elif fetch is not None:
+            # remove the optional schema ('cs:', 'ch:', etc) from the name
+            name = charm.split(":", 1)[-1]
+            channel = app.get("channel") or "stable"
+            if (name, channel) not in fetched:
+                fetched[(name, channel)] = _fetch_charm(name, channel, fetch)
+            if fetched[(name, channel)] is not None:
+                charms[app_name] = fetched[(name, channel)]
+        else:
+            logger.debug("Not verifying application %r: charm not local", app_name)
+
+    for app_name, charm_info in charms.items():
+        options = (applications[app_name] or {}).get("options")
+        problems.extend(_check_options(app_name, options, charm_info))
+    for relation in _expand_relations(bundle.get("relations") or []):
+        problems.extend(_check_relation(relation, applications, charms))
+    return problems
@@ -0,0 +1,325 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""The cache shared by all the projects, to avoid downloading and installing again."""
+
+import datetime
+import hashlib
+import logging
+import os
+import pathlib
+import re
+import shutil
+import tempfile
+import time
+from collections import namedtuple
+
+import appdirs
+
+logger = logging.getLogger(__name__)
+
+# the directories inside the cache for pip's own cache (downloads and built wheels),
+# the installed dependencies (one directory per requirements and platform), and the
+# responses from the Store (see the Store's client)
+PIP_DIRNAME = "pip"
+VENVS_DIRNAME = "venvs"
+STORE_DIRNAME = "store"
+
+# the description of each section of the cache, in the order to be shown
+SECTIONS = [
+    (PIP_DIRNAME, "Downloads and built wheels"),
+    (VENVS_DIRNAME, "Installed dependencies"),
+    (STORE_DIRNAME, "Store responses"),
+]
+
+# the options in a requirements file that include other files, which also affect
+# what is installed
+_INCLUDE_RE = re.compile(r"^(?:-r|--requirement|-c|--constraint)[=\s]*(.+)$")
+
+# the options in a requirements file that include other requirement files, only
+# constraints, or install a local directory in editable mode
+_REQUIREMENT_INCLUDE_RE = re.compile(r"^(?:-r|--requirement)[=\s]*(.+)$")
+_CONSTRAINT_INCLUDE_RE = re.compile(r"^(?:-c|--constraint)[=\s]*(.+)$")
+_EDITABLE_RE = re.compile(r"^(?:-e|--editable)\b")
+
+# a requirement by name (with optional extras), capturing the name and its version
+# specifiers
+_NAMED_REQUIREMENT_RE = re.compile(
+    r"^([A-Za-z0-9][A-Za-z0-9._-]*)\s*(?:\[[^\]]*\])?\s*([^@]*)$"
+)
+
+SectionUsage = namedtuple("SectionUsage", "name description dirpath entries size")
+
+
+def get_cache_dirpath():
+    """Return the directory of the cache."""
+    return pathlib.Path(appdirs.user_cache_dir("charmcraft"))
+
+
+def get_pip_cache_dirpath():
+    """Return the directory for pip's own cache."""
+    return get_cache_dirpath() / PIP_DIRNAME
+
+
+def _hash_requirements(hasher, filepath, seen):
+    """Feed the hasher with the requirements file and the files included by it.
+
+    Only the content is used (the included files are referenced in it relative to
+    the including one), so the same requirements in different projects share the key.
+    """
+    filepath = filepath.resolve()
+    if filepath in seen:
+        return
+    seen.add(filepath)
+    try:
+        content = filepath.read_bytes()
+    except OSError:
+        # pip will complain about it when installing
+        content = b""
+    hasher.update(content + b"\0")
+    for line in content.decode("utf8", errors="replace").splitlines():
+        match = _INCLUDE_RE.match(line.strip())
+        if match:
+            _hash_requirements(hasher, filepath.parent / match.group(1).strip(), seen)
+
+
+def _parse_requirements(filepath, seen, requirements, constraints):
+    """Collect the requirements and constraints in the file and those included by it.
+
+    Comments, continuation lines and options (except the editable ones, which are
+    requirements themselves) are handled the same way pip does.
+    """
+    filepath = filepath.resolve()
+    if filepath in seen:
+        return
+    seen.add(filepath)
+    try:
+        content = filepath.read_text(encoding="utf8", errors="replace")
+    except OSError:
+        # pip will complain about it when installing
+        return
+    content = re.sub(r"\\\n", " ", content)
+    for line in content.splitlines():
+        line = re.sub(r"(^|\s)#.*$", "", line).strip()
+        if not line:
+            continue
+        match = _REQUIREMENT_INCLUDE_RE.match(line)
+        if match:
+            _parse_requirements(
+                filepath.parent / match.group(1), seen, requirements, constraints
+            )
+            continue
+        match = _CONSTRAINT_INCLUDE_RE.match(line)
+        if match:
+            # the constraints files are not requirements, even if they include others
+            _parse_requirements(
+                filepath.parent / match.group(1), seen, constraints, constraints
+            )
+        elif _EDITABLE_RE.match(line) or not line.startswith("-"):
+            requirements.append(line)
+
+
+def _get_pinned_name(requirement):
+    """Return the normalized name of the requirement if pinned to a version, else None.
+
+    Local paths, URLs and editable requirements may change without the requirement
+    changing, and those not pinned to an exact version change when a new one is
+    released.
+    """
+    if _EDITABLE_RE.match(requirement):
+        return None
+    requirement = requirement.split(";")[0].split("--hash")[0]
+    match = _NAMED_REQUIREMENT_RE.match(requirement)
+    if match is None:
+        # a local path or a URL
+        return None
+    name, specifiers = match.groups()
+    if any(
+        spec.strip().startswith("==") and "*" not in spec
+        for spec in specifiers.split(",")
+    ):
+        return re.sub(r"[-_.]+", "-", name).lower()
+    return None
+
+
+def get_unpinned_requirement(requirement_paths):
+    """Return the first requirement that is not pinned, None if all of them are.
+
+    A requirement is pinned to a version by itself or by a constraint. The dependencies
+    installed for unpinned requirements cannot be reused from the cache, as what they
+    resolve to may have changed since they were installed.
+    """
+    requirements = []
+    constraints = []
+    seen = set()
+    for filepath in requirement_paths:
+        _parse_requirements(pathlib.Path(filepath), seen, requirements, constraints)
+
+    pinned_by_constraints = set(map(_get_pinned_name, constraints))
+    for requirement in requirements:
+        if _get_pinned_name(requirement) is not None:
+            continue
+        match = _NAMED_REQUIREMENT_RE.match(requirement.split(";")[0])
+        if match is not None:
+            name = re.sub(r"[-_.]+", "-", match.group(1)).lower()
+            if name in pinned_by_constraints:
+                continue
+        return requirement
+    return None
+
+
+def get_venv_key(requirement_paths, platform_id):
+    """Return the key of the installed dependencies in the cache.
+
+    It depends on the content of the requirement files (and those included by them),
+    and the platform where the dependencies will be used.
+    """
+    hasher = hashlib.sha256(platform_id.encode("utf8") + b"\0")
+    seen = set()
+    for filepath in requirement_paths:
+        _hash_requirements(hasher, pathlib.Path(filepath), seen)
+    return hasher.hexdigest()
+
+
+def link_tree(srcdir, destdir):
+    """Replicate the source directory in the destination using hard links.
+
+    The files are copied if they cannot be linked (e.g. in a different filesystem).
+    """
+    for dirpath, dirnames, filenames in os.walk(str(srcdir)):
+        dirpath = pathlib.Path(dirpath)
+        dest_dirpath = destdir / dirpath.relative_to(srcdir)
+        dest_dirpath.mkdir(parents=True, exist_ok=True)
+        for name in dirnames + filenames:
+            srcpath = dirpath / name
+            destpath = dest_dirpath / name
+            if srcpath.is_symlink():
+                destpath.symlink_to(os.readlink(str(srcpath)))
+            elif srcpath.is_file():
+                try:
+                    os.link(str(srcpath), str(destpath))
+                except OSError:
+                    shutil.copy2(str(srcpath), str(destpath))
+
+
+def get_venv(key):
+    """Return the directory of the cached installed dependencies (None if missing).
+
+    The dependencies are marked as used, to be kept when pruning the cache.
+    """
+    cached_dirpath = get_cache_dirpath() / VENVS_DIRNAME / key
+    if not cached_dirpath.is_dir():
+        return None
+    os.utime(str(cached_dirpath))
+    return cached_dirpath
+
+
+def restore_venv(key, venvpath):
+    """Put the cached installed dependencies in the venv path, if available.
+
+    Return if the dependencies were found in the cache.
+    """
+    cached_dirpath = get_venv(key)
+    if cached_dirpath is None:
+        return False
+    link_tree(cached_dirpath, venvpath)
+    return True
+
+
+def store_venv(key, venvpath):
+    """Save the installed dependencies in the cache, to be reused later."""
+    if not venvpath.is_dir():
+        return
+    venvs_dirpath = get_cache_dirpath() / VENVS_DIRNAME
+    venvs_dirpath.mkdir(parents=True, exist_ok=True)
+    cached_dirpath = venvs_dirpath / key
+    if cached_dirpath.exists():
+        return
+
+    # build it aside and then move it in place, so a partial copy is never used
+    tmp_dirpath = pathlib.Path(tempfile.mkdtemp(prefix=".tmp-", dir=str(venvs_dirpath)))
+    try:
+        link_tree(venvpath, tmp_dirpath)
+        tmp_dirpath.rename(cached_dirpath)
+    except OSError as exc:
+        # probably stored in parallel by other build, nothing to worry about
+        logger.debug("Cannot store the dependencies in the cache: %r", exc)
+        shutil.rmtree(str(tmp_dirpath), ignore_errors=True)
+
+
+def _get_size(dirpath):
+    """Return the size of the files in the directory (counting hard links once)."""
+    seen = set()
+    size = 0
+    for filepath in dirpath.rglob("*"):
+        stat = filepath.lstat()
+        if (stat.st_dev, stat.st_ino) not in seen:
+            seen.add((stat.st_dev, stat.st_ino))
+            size += stat.st_size
+    return size
+
+
+def get_usage():
+    """Return the usage of each section of the cache."""
+    usage = []
+    for name, description in SECTIONS:
+        dirpath = get_cache_dirpath() / name
+        if dirpath.is_dir():
+            entries = len(list(dirpath.iterdir()))
+            size = _get_size(dirpath)
+        else:
+            entries = size = 0
+        usage.append(SectionUsage(name, description, dirpath, entries, size))
+    return usage
+
+
+def prune(older_than=None):
+    """Remove from the cache what was not used recently, or everything.
+
+    Without a time delta all the cache is removed; otherwise only the installed
+    dependencies not used during that time. Return how many items were removed
+    and the freed space.
+    """
+    cache_dirpath = get_cache_dirpath()
+    if older_than is None:
+        to_remove = [cache_dirpath / name for name, _ in SECTIONS]
+    else:
+        limit = time.time() - older_than.total_seconds()
+        venvs_dirpath = cache_dirpath / VENVS_DIRNAME
+        to_remove = []
+        if venvs_dirpath.is_dir():
+            to_remove = [
+                dirpath
+                for dirpath in venvs_dirpath.iterdir()
+                if dirpath.stat().st_mtime < limit
+            ]
+
+    removed = freed = 0
+    for dirpath in to_remove:
+        if not dirpath.exists():
+            continue
+        freed += _get_size(dirpath)
+        shutil.rmtree(str(dirpath))
+        removed += 1
+    return removed, freed
+
+
+def days(value):
+    """Convert a number of days to a time delta (for argparse)."""
+    value = int(value)
+    if value < 0:
+        raise ValueError("the number of days cannot be negative")
+    return datetime.timedelta(days=value)
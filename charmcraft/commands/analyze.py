@@ -0,0 +1,128 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Infrastructure for the 'analyze' command."""
+
+import logging
+import pathlib
+import tempfile
+import zipfile
+
+from charmcraft import interfaces, linters
+from charmcraft.cmdbase import BaseCommand, CommandError
+from charmcraft.utils import useful_filepath
+
+logger = logging.getLogger(__name__)
+
+_overview = """
+Analyze a charm or bundle to find problems before uploading it.
+
+The indicated file is the `.charm` or bundle's `.zip` produced by
+`charmcraft pack`. The result of each check is shown, and the command
+fails if any problem is found.
+
+These checks are done:
+
+- icon: the `icon.svg` (if present) must be a well-formed SVG with
+  a {size}x{size} viewBox, not bigger than {max_size} KiB, and must not
+  include scripts, event handlers or references to external resources
+
+- containers: for sidecar charms, each container must use a declared
+  `oci-image` resource, and mount declared `filesystem` storage in
+  absolute and distinct locations; also, all the `oci-image` resources
+  must be used by some container
+
+- interfaces: the interfaces of the charm's relations must exist in
+  the interfaces catalog; this is done only if a catalog is indicated
+  with `--interfaces-catalog` or configured in `charmcraft.yaml`
+
+Use `--icon-preview` to also render the icon to a PNG file, to see
+how it will look (this needs the `{renderer}` tool).
+""".format(
+    size=linters.ICON_CANVAS_SIZE,
+    max_size=linters.ICON_MAX_SIZE // 1024,
+    renderer=linters.RENDERER,
+)
+
+
+class AnalyzeCommand(BaseCommand):
+    """Analyze a charm or bundle."""
+
+    name = "analyze"
+    help_msg = "Analyze a charm or bundle to find problems"
+    overview = _overview
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument(
+            "filepath", type=useful_filepath, help="The charm or bundle to analyze"
+        )
+        parser.add_argument(
+            "--icon-preview",
+            type=pathlib.Path,
+            metavar="PNG-FILE",
+            help="Render the icon to this file, to preview it",
+        )
+        parser.add_argument(
+            "--interfaces-catalog",
+            type=pathlib.Path,
+            metavar="DIRECTORY",
+            help="The interfaces catalog to check the relations against; defaults "
+            "to the one configured in charmcraft.yaml, if any",
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        filepath = parsed_args.filepath
+        if not zipfile.is_zipfile(str(filepath)):
+            raise CommandError(
+                "Cannot open {!r}: it is not a charm or bundle file.".format(
+                    str(filepath)
+                )
+            )
+
+        catalog = interfaces.load_catalog(self.config, parsed_args.interfaces_catalog)
+        with tempfile.TemporaryDirectory(prefix="charmcraft-analyze-") as tmpdir:
+            basedir = pathlib.Path(tmpdir)
+            with zipfile.ZipFile(str(filepath)) as zf:
+                zf.extractall(tmpdir)
+            # the packed charm has its own metadata.yaml, charmcraft.yaml is not used
+            results = linters.analyze(None, basedir, catalog)
+
+            if parsed_args.icon_preview is not None:
+                icon_filepath = basedir / linters.ICON_FILENAME
+                if not icon_filepath.exists():
+                    raise CommandError(
+                        "Cannot render the icon preview: {!r} does not include "
+                        "an icon.".format(str(filepath))
+                    )
+                linters.render_icon(icon_filepath, parsed_args.icon_preview)
+                logger.info("Icon preview rendered to %r.", str(parsed_args.icon_preview))
+
+        total = 0
+        for result in results:
+            if result.problems:
+                logger.info("%s: %d problem(s)", result.name, len(result.problems))
+                for problem in result.problems:
+                    logger.info("- %s", problem)
+            else:
+                logger.info("%s: ok", result.name)
+            total += len(result.problems)
+
+        if total:
+            raise CommandError(
+                "Found {} problem(s) in {!r}.".format(total, str(filepath))
+            )
@@ -0,0 +1,175 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Infrastructure for the 'audit' command."""
+
+import logging
+import pathlib
+import tempfile
+import zipfile
+
+from tabulate import tabulate
+
+from charmcraft import audit
+from charmcraft.cmdbase import BaseCommand, CommandError
+from charmcraft.commands.build import BUILD_DIRNAME, VENV_DIRNAME
+from charmcraft.metadata import get_metadata
+from charmcraft.utils import useful_filepath
+
+logger = logging.getLogger(__name__)
+
+_overview = """
+Search the charm's dependencies for known vulnerabilities.
+
+The dependencies installed in the charm's virtualenv are compared
+against a local snapshot of a vulnerabilities database in the OSV
+format (for example, the PyPI export from https://osv.dev, unzipped
+in a directory). Nothing is downloaded, so the database needs to be
+refreshed periodically to find the latest vulnerabilities.
+
+By default the dependencies of the last build of the project are
+audited (those in the `build` directory if it was built with
+`--staging`, otherwise those in the charm file in the project's
+directory); a `.charm` file can be indicated instead.
+
+The database is indicated with `--database`, or configured in
+`charmcraft.yaml`, where a severity threshold (which also makes
+`charmcraft pack` fail) and vulnerabilities to ignore can be
+specified too:
+
+    audit:
+      database: ../osv-pypi
+      severity-threshold: high
+      ignore:
+        - PYSEC-2021-123
+
+The command fails if any vulnerability of the threshold's severity
+or higher is found.
+"""
+
+
+class AuditCommand(BaseCommand):
+    """Audit the charm's dependencies."""
+
+    name = "audit"
+    help_msg = "Search the charm's dependencies for known vulnerabilities"
+    overview = _overview
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument(
+            "filepath",
+            nargs="?",
+            type=useful_filepath,
+            help="The charm to audit; defaults to the last build of the project",
+        )
+        parser.add_argument(
+            "--database",
+            type=pathlib.Path,
+            metavar="DIRECTORY",
+            help="The vulnerabilities database; defaults to the one configured in "
+            "charmcraft.yaml",
+        )
+        parser.add_argument(
+            "--severity-threshold",
+            choices=audit.SEVERITIES,
+            help="Fail if a vulnerability of this severity or higher is found; "
+            "defaults to the one configured in charmcraft.yaml",
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        database = audit.load_database(self.config, parsed_args.database)
+        if database is None:
+            raise CommandError(
+                "No vulnerabilities database indicated: use --database or configure "
+                "it in charmcraft.yaml."
+            )
+
+        if parsed_args.filepath is None:
+            venvpath = self.config.project.dirpath / BUILD_DIRNAME / VENV_DIRNAME
+            charm_filepath = self._get_built_charm()
+            if venvpath.is_dir():
+                distributions = audit.get_installed_distributions(venvpath)
+            elif charm_filepath is not None:
+                distributions = self._get_charm_distributions(charm_filepath)
+            else:
+                raise CommandError(
+                    "No dependencies to audit: the project has not been built, or "
+                    "it has no dependencies."
+                )
+        else:
+            distributions = self._get_charm_distributions(parsed_args.filepath)
+
+        found, ignored = audit.audit(
+            distributions, database, self.config.audit.ignore
+        )
+        if found:
+            headers = ["Package", "Version", "Vulnerability", "Severity", "Fixed in"]
+            data = [
+                (
+                    vuln.package,
+                    vuln.version,
+                    vuln.id,
+                    vuln.severity,
+                    ", ".join(vuln.fixed_versions) or "-",
+                )
+                for vuln in found
+            ]
+            table = tabulate(data, headers=headers, tablefmt="plain", numalign="left")
+            for line in table.splitlines():
+                logger.info(line)
+        logger.info(
+            "Audited %d dependencies: found %d vulnerabilities (%d ignored).",
+            len(distributions),
+            len(found),
+            len(ignored),
+        )
+
+        threshold = parsed_args.severity_threshold
+        if threshold is None:
+            threshold = self.config.audit.severity_threshold
+        if threshold is None:
+            return
+        blocking = audit.get_blocking(found, threshold)
+        if blocking:
+            raise CommandError(
+                "Found {} vulnerabilities with severity {!r} or higher.".format(
+                    len(blocking), threshold
+                )
+            )
+
+    def _get_built_charm(self):
+        """Return the charm file built in the project's directory (None if missing)."""
+        dirpath = self.config.project.dirpath
+        metadata = get_metadata(self.config, dirpath)
+        if not metadata.get("name"):
+            return None
+        filepath = dirpath / (metadata["name"] + ".charm")
+        return filepath if filepath.is_file() else None
+
+    def _get_charm_distributions(self, filepath):
+        """Get the distributions installed in the indicated charm."""
+        if not zipfile.is_zipfile(str(filepath)):
+            raise CommandError(
+                "Cannot open {!r}: it is not a charm file.".format(str(filepath))
+            )
+        with tempfile.TemporaryDirectory(prefix="charmcraft-audit-") as tmpdir:
+            with zipfile.ZipFile(str(filepath)) as zf:
+                zf.extractall(tmpdir)
+            return audit.get_installed_distributions(
+                pathlib.Path(tmpdir) / VENV_DIRNAME
+            )
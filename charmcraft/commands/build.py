@@ -20,22 +20,35 @@ import errno
 import logging
 import os
 import pathlib
+import re
 import shutil
 import subprocess
+import tempfile
+import time
 import zipfile
+from collections import namedtuple
 
-import yaml
-
+from charmcraft import audit, cache, git
 from charmcraft.cmdbase import BaseCommand, CommandError
+from charmcraft.compression import ParallelZipFile
 from charmcraft.jujuignore import JujuIgnore, default_juju_ignore
-from charmcraft.utils import make_executable, create_manifest
+from charmcraft.metadata import get_actions, get_legacy_files, get_metadata
+from charmcraft.utils import (
+    BASE_SYSTEMS,
+    create_manifest,
+    get_manifest_content,
+    get_os_platform,
+    make_executable,
+)
+from charmcraft.verify import ensure_deployable
 
 logger = logging.getLogger(__name__)
 
 # Some constants that are used through the code.
-CHARM_METADATA = "metadata.yaml"
 BUILD_DIRNAME = "build"
 VENV_DIRNAME = "venv"
+VERSION_FILENAME = "version"
+MANIFEST_FILENAME = "manifest.yaml"
 
 # The file name and template for the dispatch script
 DISPATCH_FILENAME = "dispatch"
@@ -52,6 +65,59 @@ JUJU_DISPATCH_PATH="${{JUJU_DISPATCH_PATH:-$0}}" PYTHONPATH=lib:venv ./{entrypoi
 MANDATORY_HOOK_NAMES = {"install", "start", "upgrade-charm"}
 HOOKS_DIR = "hooks"
 
+# The hooks for the events every charm may receive, and those for the events of each
+# relation, storage and container declared in the metadata, to provide all of them
+# (and one per action) for old Juju without support for the dispatch mechanism
+STANDARD_HOOK_NAMES = {
+    "collect-metrics",
+    "config-changed",
+    "install",
+    "leader-elected",
+    "leader-settings-changed",
+    "post-series-upgrade",
+    "pre-series-upgrade",
+    "remove",
+    "start",
+    "stop",
+    "update-status",
+    "upgrade-charm",
+}
+RELATION_HOOK_SUFFIXES = (
+    "relation-created",
+    "relation-joined",
+    "relation-changed",
+    "relation-departed",
+    "relation-broken",
+)
+STORAGE_HOOK_SUFFIXES = ("storage-attached", "storage-detaching")
+CONTAINER_HOOK_SUFFIXES = ("pebble-ready",)
+ACTIONS_DIR = "actions"
+
+# The Python version, ABI and platforms to get the dependencies for, when the charm will
+# run in a base with a different Python than the one in the current system
+PythonTarget = namedtuple("PythonTarget", "version abi platforms")
+
+# A file generated by charmcraft to be included in the package, with its content (bytes)
+GeneratedFile = namedtuple("GeneratedFile", "content executable")
+
+# the Python version pip is running with, from its '--version' output
+_PIP_PYTHON_RE = re.compile(r"\(python (\d+\.\d+)\)")
+
+
+def get_event_hook_names(metadata):
+    """Return the names of the hooks for all the events the charm may receive."""
+    hooknames = set(STANDARD_HOOK_NAMES)
+    groups = [
+        (metadata.get(key), RELATION_HOOK_SUFFIXES)
+        for key in ("requires", "provides", "peers")
+    ]
+    groups.append((metadata.get("storage"), STORAGE_HOOK_SUFFIXES))
+    groups.append((metadata.get("containers"), CONTAINER_HOOK_SUFFIXES))
+    for names, suffixes in groups:
+        for name in names or ():
+            hooknames.update("{}-{}".format(name, suffix) for suffix in suffixes)
+    return hooknames
+
 
 def _pip_needs_system():
     """Determine whether pip3 defaults to --user, needing --system to turn it off."""
@@ -95,6 +161,116 @@ def relativise(src, dst):
     return pathlib.Path(os.path.relpath(str(dst), str(src.parent)))
 
 
+def get_python_target(config):
+    """Return the Python target for the base configured in the project (if any)."""
+    base = config.parts.charm.base
+    if base is None:
+        return None
+
+    system = BASE_SYSTEMS[(base.name, base.channel)]
+    major, minor = (int(part) for part in system.python_version.split("."))
+    abi = "cp{}{}".format(major, minor)
+    if (major, minor) < (3, 8):
+        # the "pymalloc" flag, removed in Python 3.8
+        abi += "m"
+
+    # the wheels for the base's glibc version or older ones (pip itself adds those
+    # for the versions before 'manylinux2014', i.e. glibc 2.17)
+    machine = get_os_platform().machine
+    glibc_major, glibc_minor = system.glibc_version
+    platforms = [
+        "manylinux_{}_{}_{}".format(glibc_major, glibc, machine)
+        for glibc in range(glibc_minor, 17, -1)
+    ]
+    platforms.append("manylinux2014_{}".format(machine))
+    return PythonTarget(version=system.python_version, abi=abi, platforms=platforms)
+
+
+def get_pip_python_version(pip_cmd):
+    """Return the version of the Python pip runs with (None if cannot be found)."""
+    try:
+        proc = subprocess.run(
+            pip_cmd + ["--version"],
+            stdout=subprocess.PIPE,
+            stderr=subprocess.DEVNULL,
+            universal_newlines=True,
+        )
+    except OSError:
+        return None
+    match = _PIP_PYTHON_RE.search(proc.stdout)
+    if proc.returncode or match is None:
+        return None
+    return match.group(1)
+
+
+def _get_pip_command(target):
+    """Return the pip command to use for the target, and if it needs to cross install.
+
+    A pip running with the target's Python version is used if it's available in the
+    system; otherwise pip is told to get the wheels built for the target.
+    """
+    pip_cmd = ["pip3"]
+    if target is None or get_pip_python_version(pip_cmd) == target.version:
+        return pip_cmd, False
+
+    interpreter = shutil.which("python" + target.version)
+    if interpreter is not None:
+        pip_cmd = [interpreter, "-m", "pip"]
+        if get_pip_python_version(pip_cmd) == target.version:
+            logger.debug("Using %s to install the dependencies", interpreter)
+            return pip_cmd, False
+
+    logger.debug("Installing the dependencies as wheels for Python %s", target.version)
+    return ["pip3"], True
+
+
+def install_dependencies(
+    requirement_paths, venvpath, target=None, cache_dirpath=None
+):
+    """Install the dependencies listed in the requirement files in the venv directory.
+
+    If a Python target is indicated, the dependencies are installed for it. If a cache
+    directory is indicated, pip keeps there the downloaded and built wheels.
+    """
+    pip_cmd, cross_install = _get_pip_command(target)
+    retcode = polite_exec(pip_cmd + ["list"])
+    if retcode:
+        raise CommandError("problems using pip")
+
+    cmd = pip_cmd + [
+        "install",  # base command
+        "--target={}".format(venvpath),  # put all the resulting files in that specific dir
+    ]
+    if cache_dirpath is not None:
+        cmd.append("--cache-dir={}".format(cache_dirpath))
+    if cross_install:
+        # only wheels can be installed for other Python than the one running pip
+        cmd.extend(
+            [
+                "--python-version={}".format(target.version),
+                "--implementation=cp",
+                "--abi={}".format(target.abi),
+                "--only-binary=:all:",
+            ]
+        )
+        cmd.extend("--platform={}".format(platform) for platform in target.platforms)
+    if _pip_needs_system():
+        logger.debug("adding --system to work around pip3 defaulting to --user")
+        cmd.append("--system")
+    for reqspath in requirement_paths:
+        cmd.append("--requirement={}".format(reqspath))  # the dependencies file(s)
+    retcode = polite_exec(cmd)
+    if retcode:
+        if cross_install:
+            raise CommandError(
+                "problems installing dependencies: Python {} is not available in this "
+                "system, so all the dependencies need to be provided as wheels "
+                "compatible with it, which do not exist for some of them (see "
+                "above).".format(target.version)
+            )
+        raise CommandError("problems installing dependencies")
+
+
 class Builder:
     """The package builder."""
 
@@ -102,27 +278,71 @@ class Builder:
         self.charmdir = args["from"]
         self.entrypoint = args["entrypoint"]
         self.requirement_paths = args["requirement"]
+        self.staging = args.get("staging", False)
+        self.no_cache = args.get("no_cache", False)
 
         self.buildpath = self.charmdir / BUILD_DIRNAME
         self.ignore_rules = self._load_juju_ignore()
         self.config = config
 
     def run(self):
-        """Build the charm."""
+        """Build the charm.
+
+        The files are streamed into the package, unless a staging tree is requested
+        (the charm is assembled there before packing it, to be inspected if needed).
+        """
+        if self.staging:
+            zipname = self.build_staged()
+        else:
+            zipname = self.build_streamed()
+        ensure_deployable(zipname)
+
+        logger.info("Created '%s'.", zipname)
+        return zipname
+
+    def build_staged(self):
+        """Assemble the charm in the build directory, then pack it."""
         logger.debug("Building charm in '%s'", self.buildpath)
 
         if self.buildpath.exists():
             shutil.rmtree(str(self.buildpath))
         self.buildpath.mkdir()
 
-        create_manifest(self.buildpath, self.config.project.started_at)
-
         linked_entrypoint = self.handle_generic_paths()
+        self.handle_legacy_files()
+        create_manifest(
+            self.buildpath,
+            self.config.project.started_at,
+            base=self.config.parts.charm.base,
+        )
+        self.handle_version()
         self.handle_dispatcher(linked_entrypoint)
         self.handle_dependencies()
-        zipname = self.handle_package()
-
-        logger.info("Created '%s'.", zipname)
+        self.handle_audit()
+        return self.handle_package()
+
+    def build_streamed(self):
+        """Pack the charm streaming the files into it, without a staging tree."""
+        logger.debug("Building charm streaming the files into the package")
+
+        # the path in the package of each file, and its source (a file in disk or the
+        # content generated here), in the same order as they are processed when staging
+        entries = {}
+        self._collect_project_files(entries)
+        self._collect_legacy_files(entries)
+        self._collect_manifest(entries)
+        self._collect_version(entries)
+        self._collect_dispatcher(entries)
+
+        with tempfile.TemporaryDirectory(prefix="charmcraft-build-") as tmpdir:
+            venvpath = self._prepare_dependencies(pathlib.Path(tmpdir))
+            if venvpath is not None:
+                self.handle_audit(venvpath)
+                self._collect_venv(entries, venvpath)
+
+            zipname = self._get_zipname()
+            logger.debug("Creating the package itself")
+            self._write_package(zipname, entries)
         return zipname
 
     def _load_juju_ignore(self):
@@ -138,29 +358,28 @@ class Builder:
 
         It also verifies that the linked dir or file is inside the project.
         """
-        resolved_path = src_path.resolve()
-        if self.charmdir in resolved_path.parents:
-            relative_link = relativise(src_path, resolved_path)
+        if self._is_internal_symlink(src_path):
+            relative_link = relativise(src_path, src_path.resolve())
             dest_path.symlink_to(relative_link)
-        else:
-            rel_path = src_path.relative_to(self.charmdir)
-            logger.warning(
-                "Ignoring symlink because targets outside the project: '%s'", rel_path
-            )
 
-    def handle_generic_paths(self):
-        """Handle all files and dirs except what's ignored and what will be handled later.
+    def _is_internal_symlink(self, src_path):
+        """Tell if the symlink points inside the project, warning if not."""
+        if self.charmdir in src_path.resolve().parents:
+            return True
+        rel_path = src_path.relative_to(self.charmdir)
+        logger.warning(
+            "Ignoring symlink because targets outside the project: '%s'", rel_path
+        )
+        return False
 
-        Works differently for the different file types:
-        - regular files: hard links
-        - directories: created
-        - symlinks: respected if are internal to the project
-        - other types (blocks, mount points, etc): ignored
-        """
-        logger.debug("Linking in generic paths")
+    def _walk_project(self, reldir=pathlib.Path(".")):
+        """Yield the directories and files in the project that are not ignored.
 
+        Each item is the path relative to the project, the absolute one, and if it is
+        a directory. Only directories, regular files and symlinks are yielded.
+        """
         for basedir, dirnames, filenames in os.walk(
-            str(self.charmdir), followlinks=False
+            str(self.charmdir / reldir), followlinks=False
         ):
             abs_basedir = pathlib.Path(basedir)
             rel_basedir = abs_basedir.relative_to(self.charmdir)
@@ -169,17 +388,11 @@ class Builder:
             ignored = []
             for pos, name in enumerate(dirnames):
                 rel_path = rel_basedir / name
-                abs_path = abs_basedir / name
-
                 if self.ignore_rules.match(str(rel_path), is_dir=True):
                     logger.debug("Ignoring directory because of rules: '%s'", rel_path)
                     ignored.append(pos)
-                elif abs_path.is_symlink():
-                    dest_path = self.buildpath / rel_path
-                    self.create_symlink(abs_path, dest_path)
                 else:
-                    dest_path = self.buildpath / rel_path
-                    dest_path.mkdir(mode=abs_path.stat().st_mode)
+                    yield rel_path, abs_basedir / name, True
 
             # in the future don't go inside ignored directories
             for pos in reversed(ignored):
@@ -192,27 +405,160 @@ class Builder:
 
                 if self.ignore_rules.match(str(rel_path), is_dir=False):
                     logger.debug("Ignoring file because of rules: '%s'", rel_path)
-                elif abs_path.is_symlink():
-                    dest_path = self.buildpath / rel_path
-                    self.create_symlink(abs_path, dest_path)
-                elif abs_path.is_file():
-                    dest_path = self.buildpath / rel_path
-                    try:
-                        os.link(str(abs_path), str(dest_path))
-                    except PermissionError:
-                        # when not allowed to create hard links
-                        shutil.copy2(str(abs_path), str(dest_path))
-                    except OSError as e:
-                        if e.errno != errno.EXDEV:
-                            raise
-                        shutil.copy2(str(abs_path), str(dest_path))
+                elif abs_path.is_symlink() or abs_path.is_file():
+                    yield rel_path, abs_path, False
                 else:
                     logger.debug("Ignoring file because of type: '%s'", rel_path)
 
+    def _is_ignored(self, rel_path, is_dir):
+        """Tell if the path, or any of the directories that contain it, is ignored."""
+        for parent in rel_path.parents:
+            if parent != pathlib.Path(".") and self.ignore_rules.match(
+                str(parent), is_dir=True
+            ):
+                return True
+        return self.ignore_rules.match(str(rel_path), is_dir=is_dir)
+
+    def handle_generic_paths(self):
+        """Handle all files and dirs except what's ignored and what will be handled later.
+
+        Works differently for the different file types:
+        - regular files: hard links
+        - directories: created
+        - symlinks: respected if are internal to the project
+        - other types (blocks, mount points, etc): ignored
+        """
+        logger.debug("Linking in generic paths")
+
+        for rel_path, abs_path, is_dir in self._walk_project():
+            dest_path = self.buildpath / rel_path
+            if abs_path.is_symlink():
+                self.create_symlink(abs_path, dest_path)
+            elif is_dir:
+                dest_path.mkdir(mode=abs_path.stat().st_mode)
+            else:
+                try:
+                    os.link(str(abs_path), str(dest_path))
+                except PermissionError:
+                    # when not allowed to create hard links
+                    shutil.copy2(str(abs_path), str(dest_path))
+                except OSError as e:
+                    if e.errno != errno.EXDEV:
+                        raise
+                    shutil.copy2(str(abs_path), str(dest_path))
+
         # the linked entrypoint is calculated here because it's when it's really in the build dir
         linked_entrypoint = self.buildpath / self.entrypoint.relative_to(self.charmdir)
         return linked_entrypoint
 
+    def _collect_project_files(self, entries, reldir=pathlib.Path("."), prefix=None):
+        """Add the project's files to the package entries.
+
+        The files in a symlinked directory are included under the symlink's path, as
+        if the directory was copied there (the ignore rules apply to where they are).
+        """
+        for rel_path, abs_path, is_dir in self._walk_project(reldir):
+            pkg_path = rel_path
+            if prefix is not None:
+                pkg_path = prefix / rel_path.relative_to(reldir)
+
+            if not abs_path.is_symlink():
+                if not is_dir:
+                    entries[pkg_path.as_posix()] = abs_path
+                continue
+
+            if not self._is_internal_symlink(abs_path):
+                continue
+            target = abs_path.resolve()
+            target_relpath = target.relative_to(self.charmdir)
+            if not target.exists() or self._is_ignored(target_relpath, is_dir):
+                logger.debug("Ignoring symlink because of its target: '%s'", rel_path)
+            elif not target.is_dir():
+                entries[pkg_path.as_posix()] = target
+            elif target in abs_path.parents:
+                logger.debug("Ignoring symlink as it creates a loop: '%s'", rel_path)
+            else:
+                self._collect_project_files(entries, target_relpath, pkg_path)
+
+    def _collect_manifest(self, entries):
+        """Add the generated manifest to the package entries."""
+        if MANIFEST_FILENAME in entries:
+            raise CommandError(
+                "Cannot write the manifest as there is already a 'manifest.yaml' in disk."
+            )
+        manifest = get_manifest_content(
+            self.config.project.started_at, base=self.config.parts.charm.base
+        )
+        entries[MANIFEST_FILENAME] = GeneratedFile(manifest.encode("utf8"), False)
+
+    def handle_legacy_files(self):
+        """Write the metadata, actions and config files declared in charmcraft.yaml."""
+        legacy_files = get_legacy_files(self.config, self.charmdir)
+        for filename, content in legacy_files.items():
+            logger.debug("Writing %r from the project's config", filename)
+            (self.buildpath / filename).write_text(content)
+
+    def _collect_legacy_files(self, entries):
+        """Add the files declared in charmcraft.yaml to the package entries."""
+        legacy_files = get_legacy_files(self.config, self.charmdir)
+        for filename, content in legacy_files.items():
+            logger.debug("Including %r from the project's config", filename)
+            entries[filename] = GeneratedFile(content.encode("utf8"), False)
+
+    def handle_version(self):
+        """Write the charm's version file, built from the project's git repository.
+
+        Nothing is done if the project already provides the file, or if it does not
+        live in a git repository.
+        """
+        version_path = self.buildpath / VERSION_FILENAME
+        if version_path.exists():
+            logger.debug("Using the version file provided by the project")
+            return
+
+        version = self._build_version()
+        if version is not None:
+            logger.debug("Writing version file: %r", version)
+            version_path.write_text(version + "\n")
+
+    def _collect_version(self, entries):
+        """Add the charm's version file to the package entries (see handle_version)."""
+        if VERSION_FILENAME in entries:
+            logger.debug("Using the version file provided by the project")
+            return
+
+        version = self._build_version()
+        if version is not None:
+            logger.debug("Including version file: %r", version)
+            content = (version + "\n").encode("utf8")
+            entries[VERSION_FILENAME] = GeneratedFile(content, False)
+
+    def _build_version(self):
+        """Build the charm's version from the project's git repository (if any)."""
+        template = self.config.parts.charm.version
+        if not git.is_repository(self.charmdir):
+            if template is not None:
+                logger.warning(
+                    "Cannot build the charm version from the configured template: "
+                    "the project is not in a git repository."
+                )
+            return None
+
+        try:
+            version = git.build_version(self.charmdir, template)
+        except CommandError:
+            if git.has_head(self.charmdir):
+                raise
+            logger.warning(
+                "Cannot build the charm version: the git repository has no commits."
+            )
+            return None
+        if git.is_dirty(self.charmdir):
+            logger.warning(
+                "The project has uncommitted changes, the charm version is %r.", version
+            )
+        return version
+
     def handle_dispatcher(self, linked_entrypoint):
         """Handle modern and classic dispatch mechanisms."""
         # dispatch mechanism, create one if wasn't provided by the project
@@ -245,8 +591,7 @@ class Builder:
                     node.name,
                 )
 
-        # include the mandatory ones and those we need to replace
-        hooknames = MANDATORY_HOOK_NAMES | {x.name for x in current_hooks_to_replace}
+        hooknames = self._get_hook_names(x.name for x in current_hooks_to_replace)
         for hookname in hooknames:
             logger.debug("Creating the %r hook script pointing to dispatch", hookname)
             dest_hook = dest_hookpath / hookname
@@ -254,53 +599,287 @@ class Builder:
                 relative_link = relativise(dest_hook, dispatch_path)
                 dest_hook.symlink_to(relative_link)
 
+        if self.config.parts.charm.legacy_hooks:
+            self._link_actions(dispatch_path)
+
+    def _get_hook_names(self, replaced):
+        """Return the names of the hooks to point to the dispatch script.
+
+        Those are the mandatory ones and the replaced ones (or the hooks for all the
+        events, if configured).
+        """
+        hooknames = MANDATORY_HOOK_NAMES | set(replaced)
+        if self.config.parts.charm.legacy_hooks:
+            metadata = get_metadata(self.config, self.charmdir)
+            hooknames |= get_event_hook_names(metadata)
+        return hooknames
+
+    def _get_action_names(self):
+        """Return the names of the actions declared by the charm."""
+        return list(get_actions(self.config, self.charmdir))
+
+    def _link_actions(self, dispatch_path):
+        """Create a script pointing to dispatch for each action declared by the charm.
+
+        The actions already provided by the project are left untouched.
+        """
+        actions = self._get_action_names()
+        if not actions:
+            return
+
+        dest_actionpath = self.buildpath / ACTIONS_DIR
+        if not dest_actionpath.exists():
+            dest_actionpath.mkdir()
+        for action_name in actions:
+            dest_action = dest_actionpath / action_name
+            if dest_action.exists():
+                logger.debug("Using the %r action provided by the project", action_name)
+                continue
+            logger.debug(
+                "Creating the %r action script pointing to dispatch", action_name
+            )
+            relative_link = relativise(dest_action, dispatch_path)
+            dest_action.symlink_to(relative_link)
+
+    def _collect_dispatcher(self, entries):
+        """Add the dispatch script, and the hooks and actions using it, to the entries.
+
+        Those provided by the project are respected, except the hooks that point to the
+        entrypoint directly (see handle_dispatcher).
+        """
+        if DISPATCH_FILENAME not in entries:
+            logger.debug("Creating the dispatch mechanism")
+            dispatch_content = DISPATCH_CONTENT.format(
+                entrypoint_relative_path=self.entrypoint.relative_to(self.charmdir)
+            )
+            entries[DISPATCH_FILENAME] = GeneratedFile(
+                dispatch_content.encode("utf8"), True
+            )
+        dispatch = entries[DISPATCH_FILENAME]
+
+        entrypoint = self.entrypoint.resolve()
+        replaced = []
+        for pkg_path, source in list(entries.items()):
+            hookpath = pathlib.PurePosixPath(pkg_path)
+            if (
+                str(hookpath.parent) == HOOKS_DIR
+                and isinstance(source, pathlib.Path)
+                and source.resolve() == entrypoint
+            ):
+                logger.debug(
+                    "Replacing existing hook %r as it's a symlink to the entrypoint",
+                    hookpath.name,
+                )
+                del entries[pkg_path]
+                replaced.append(hookpath.name)
+
+        for hookname in sorted(self._get_hook_names(replaced)):
+            entries.setdefault("{}/{}".format(HOOKS_DIR, hookname), dispatch)
+
+        if self.config.parts.charm.legacy_hooks:
+            for action_name in self._get_action_names():
+                action_path = "{}/{}".format(ACTIONS_DIR, action_name)
+                if action_path in entries:
+                    logger.debug(
+                        "Using the %r action provided by the project", action_name
+                    )
+                else:
+                    entries[action_path] = dispatch
+
     def handle_dependencies(self):
         """Handle from-directory and virtualenv dependencies."""
         logger.debug("Installing dependencies")
 
         # virtualenv with other dependencies (if any)
-        if self.requirement_paths:
-            retcode = polite_exec(["pip3", "list"])
-            if retcode:
-                raise CommandError("problems using pip")
+        if not self.requirement_paths:
+            return
+
+        # reuse the dependencies installed before for the same requirements and
+        # platform, by this or other project
+        venvpath = self.buildpath / VENV_DIRNAME
+        target = get_python_target(self.config)
+        cache_key = None
+        if self._can_cache_dependencies():
+            cache_key = self._get_dependencies_cache_key(target)
+            if cache.restore_venv(cache_key, venvpath):
+                logger.debug("Using the cached dependencies %s", cache_key)
+                return
+
+        install_dependencies(
+            self.requirement_paths,
+            venvpath,
+            target,
+            cache_dirpath=cache.get_pip_cache_dirpath(),
+        )
+        if cache_key is not None:
+            cache.store_venv(cache_key, venvpath)
 
-            venvpath = self.buildpath / VENV_DIRNAME
-            cmd = [
-                "pip3",
-                "install",  # base command
-                "--target={}".format(
-                    venvpath
-                ),  # put all the resulting files in that specific dir
-            ]
-            if _pip_needs_system():
-                logger.debug("adding --system to work around pip3 defaulting to --user")
-                cmd.append("--system")
-            for reqspath in self.requirement_paths:
-                cmd.append(
-                    "--requirement={}".format(reqspath)
-                )  # the dependencies file(s)
-            retcode = polite_exec(cmd)
-            if retcode:
-                raise CommandError("problems installing dependencies")
+    def _prepare_dependencies(self, tmpdir):
+        """Install the dependencies (if any); return the directory where they are.
 
-    def handle_package(self):
-        """Handle the final package creation."""
-        logger.debug("Parsing the project's metadata")
-        with (self.charmdir / CHARM_METADATA).open("rt", encoding="utf8") as fh:
-            metadata = yaml.safe_load(fh)
+        The dependencies installed before are used directly from the cache.
+        """
+        logger.debug("Installing dependencies")
+        if not self.requirement_paths:
+            return None
+
+        target = get_python_target(self.config)
+        cache_key = None
+        if self._can_cache_dependencies():
+            cache_key = self._get_dependencies_cache_key(target)
+            venvpath = cache.get_venv(cache_key)
+            if venvpath is not None:
+                logger.debug("Using the cached dependencies %s", cache_key)
+                return venvpath
+
+        venvpath = tmpdir / VENV_DIRNAME
+        install_dependencies(
+            self.requirement_paths,
+            venvpath,
+            target,
+            cache_dirpath=cache.get_pip_cache_dirpath(),
+        )
+        if cache_key is not None:
+            cache.store_venv(cache_key, venvpath)
+        return venvpath
 
-        logger.debug("Creating the package itself")
-        zipname = metadata["name"] + ".charm"
-        zipfh = zipfile.ZipFile(zipname, "w", zipfile.ZIP_DEFLATED)
-        for dirpath, dirnames, filenames in os.walk(self.buildpath, followlinks=True):
+    def _collect_venv(self, entries, venvpath):
+        """Add the installed dependencies to the package entries."""
+        for dirpath, _, filenames in os.walk(str(venvpath), followlinks=True):
             dirpath = pathlib.Path(dirpath)
             for filename in filenames:
                 filepath = dirpath / filename
-                zipfh.write(str(filepath), str(filepath.relative_to(self.buildpath)))
+                pkg_path = pathlib.PurePosixPath(
+                    VENV_DIRNAME, filepath.relative_to(venvpath)
+                )
+                entries[pkg_path.as_posix()] = filepath
 
-        zipfh.close()
+    def _can_cache_dependencies(self):
+        """Tell if the dependencies can be reused from (and stored in) the cache.
+
+        It's not possible if what the requirements resolve to may change without them
+        changing (local paths, URLs, or not pinned versions), or if not wanted.
+        """
+        if self.no_cache:
+            logger.debug("Not using the dependencies cache, as requested")
+            return False
+        unpinned = cache.get_unpinned_requirement(self.requirement_paths)
+        if unpinned is not None:
+            logger.debug(
+                "Not using the dependencies cache, as the requirement %r is not pinned "
+                "to a version",
+                unpinned,
+            )
+            return False
+        return True
+
+    def _get_dependencies_cache_key(self, target):
+        """Return the key of the dependencies in the cache."""
+        return cache.get_venv_key(
+            self.requirement_paths, self._get_dependencies_platform(target)
+        )
+
+    def _get_dependencies_platform(self, target):
+        """Return where the dependencies will run, to identify them in the cache."""
+        os_platform = get_os_platform()
+        base = self.config.parts.charm.base
+        if base is None:
+            system = "{}-{}".format(os_platform.system, os_platform.release)
+            python_version = get_pip_python_version(["pip3"])
+        else:
+            system = "{}-{}".format(base.name, base.channel)
+            python_version = target.version
+        return "{}-{}-python{}".format(system, os_platform.machine, python_version)
+
+    def handle_audit(self, venvpath=None):
+        """Search the installed dependencies for known vulnerabilities.
+
+        Nothing is done if no vulnerabilities database is configured or there are no
+        dependencies; the build fails if a vulnerability reaches the configured
+        severity threshold.
+        """
+        if venvpath is None:
+            venvpath = self.buildpath / VENV_DIRNAME
+        database = audit.load_database(self.config)
+        if database is None or not venvpath.exists():
+            return
+
+        logger.debug("Auditing the dependencies")
+        distributions = audit.get_installed_distributions(venvpath)
+        found, ignored = audit.audit(distributions, database, self.config.audit.ignore)
+        for vuln in found:
+            logger.warning(
+                "The dependency %s %s is affected by %s (severity: %s, fixed in: %s).",
+                vuln.package,
+                vuln.version,
+                vuln.id,
+                vuln.severity,
+                ", ".join(vuln.fixed_versions) or "-",
+            )
+        if ignored:
+            logger.debug("Ignored %d vulnerabilities as configured", len(ignored))
+
+        threshold = self.config.audit.severity_threshold
+        if threshold is None:
+            return
+        blocking = audit.get_blocking(found, threshold)
+        for vuln in blocking:
+            if vuln.severity == "unknown":
+                logger.warning(
+                    "The severity of %s is unknown, so it is considered to reach the "
+                    "threshold; if it is not relevant for the charm, add it to the "
+                    "'ignore' list of the 'audit' configuration.",
+                    vuln.id,
+                )
+        if blocking:
+            raise CommandError(
+                "Found {} vulnerabilities with severity {!r} or higher in the charm's "
+                "dependencies: {}.".format(
+                    len(blocking), threshold, ", ".join(v.id for v in blocking)
+                )
+            )
+
+    def handle_package(self):
+        """Handle the final package creation."""
+        zipname = self._get_zipname()
+
+        logger.debug("Creating the package itself")
+        with self._open_package(zipname) as zipfh:
+            for dirpath, dirnames, filenames in os.walk(
+                self.buildpath, followlinks=True
+            ):
+                dirpath = pathlib.Path(dirpath)
+                for filename in filenames:
+                    filepath = dirpath / filename
+                    zipfh.write(filepath, filepath.relative_to(self.buildpath))
         return zipname
 
+    def _open_package(self, zipname):
+        """Open the package to write, compressing as configured."""
+        compression = self.config.compression
+        return ParallelZipFile(
+            zipname, level=compression.level, workers=compression.workers
+        )
+
+    def _get_zipname(self):
+        """Return the name of the package, from the project's metadata."""
+        logger.debug("Parsing the project's metadata")
+        metadata = get_metadata(self.config, self.charmdir)
+        return metadata["name"] + ".charm"
+
+    def _write_package(self, zipname, entries):
+        """Write the package with the files from disk or generated."""
+        date_time = time.localtime()[:6]
+        with self._open_package(zipname) as zipfh:
+            for pkg_path, source in entries.items():
+                if isinstance(source, GeneratedFile):
+                    info = zipfile.ZipInfo(pkg_path, date_time=date_time)
+                    mode = 0o100755 if source.executable else 0o100644
+                    info.external_attr = mode << 16
+                    zipfh.writestr(info, source.content)
+                else:
+                    zipfh.write(source, pkg_path)
+
 
 class Validator:
     """A validator of all received options."""
@@ -309,6 +888,8 @@ class Validator:
         "from",  # this needs to be processed first, as it's a base dir to find other files
         "entrypoint",
         "requirement",
+        "staging",
+        "no_cache",
     ]
 
     def __init__(self):
@@ -383,6 +964,14 @@ class Validator:
                 )
         return filepaths
 
+    def validate_staging(self, staging):
+        """Nothing to validate, only if the staging tree was requested."""
+        return bool(staging)
+
+    def validate_no_cache(self, no_cache):
+        """Nothing to validate, only if the dependencies cache should not be used."""
+        return bool(no_cache)
+
 
 _overview = """
 Build a charm operator package.
@@ -395,6 +984,39 @@ You must be inside a charm directory with a valid `metadata.yaml`,
 framework, and an operator entrypoint, usually `src/charm.py`.
 
 See `charmcraft init` to create a template charm directory structure.
+
+The charm's metadata, actions and config can also be declared in the
+`metadata`, `actions` and `config` sections of charmcraft.yaml instead
+of their own files; those files are then generated in the package.
+
+The dependencies are installed for the Python of the current system;
+set `parts.charm.base` in charmcraft.yaml to install them for the
+Python of the system where the charm will run instead, for example:
+
+    parts:
+      charm:
+        base:
+          name: ubuntu
+          channel: "20.04"
+
+If that Python is not available in the current system, only
+dependencies provided as compatible wheels can be installed.
+
+The charm is run through a `dispatch` script; for Juju versions without
+support for it, set `parts.charm.legacy-hooks` to true in charmcraft.yaml
+to also create a hook for each event the charm may receive (including
+those of its relations, storage and containers) and a script for each
+action, all pointing to the dispatch script.
+
+The downloaded and built wheels, and the installed dependencies, are
+kept in a cache shared by all the projects, so they are reused when
+building with the same requirements for the same platform; see
+`charmcraft cache` to inspect or prune it.
+
+The project files, the generated ones and the dependencies are written
+directly to the package, applying the ignore rules on the way. Use
+`--staging` to assemble the charm in the `build` directory first, to
+inspect its content when debugging.
 """
 
 
@@ -412,7 +1034,7 @@ class BuildCommand(BaseCommand):
             "-f",
             "--from",
             type=pathlib.Path,
-            help="Charm directory with metadata.yaml where the build "
+            help="Charm directory with the charm's metadata where the build "
             "takes place; defaults to '.'",
         )
         parser.add_argument(
@@ -430,6 +1052,18 @@ class BuildCommand(BaseCommand):
             help="File(s) listing needed PyPI dependencies (can be used multiple "
             "times); defaults to 'requirements.txt'",
         )
+        parser.add_argument(
+            "--staging",
+            action="store_true",
+            help="Assemble the charm in the 'build' directory before packing it, "
+            "to inspect it (for debugging)",
+        )
+        parser.add_argument(
+            "--no-cache",
+            action="store_true",
+            help="Install the dependencies again, instead of reusing those installed "
+            "before for the same requirements",
+        )
 
     def run(self, parsed_args):
         """Run the command."""
@@ -0,0 +1,129 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Infrastructure for the 'cache' command."""
+
+import logging
+
+from humanize import naturalsize
+from tabulate import tabulate
+
+from charmcraft import cache
+from charmcraft.cmdbase import BaseCommand
+
+logger = logging.getLogger(__name__)
+
+# by default, prune the installed dependencies not used in this amount of days
+DEFAULT_PRUNE_DAYS = 30
+
+_overview = """
+Show or prune the cache shared by all the projects.
+
+When building, the wheels downloaded and built by pip, and the
+dependencies installed for each set of requirements and platform, are
+kept in a cache, so they are reused by later builds of any project.
+The responses from Charmhub used by the listing commands when offline
+are also kept there.
+
+The 'info' action shows where the cache is and how much space each
+part of it uses.
+
+The 'prune' action removes the installed dependencies that were not
+used in the last days (see --older-than), or everything with --all.
+"""
+
+
+class CacheCommand(BaseCommand):
+    """Show or prune the cache shared by all the projects."""
+
+    name = "cache"
+    help_msg = "Show or prune the cache shared by all the projects"
+    overview = _overview
+    needs_config = False
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument(
+            "action",
+            choices=["info", "prune"],
+            help="What to do with the cache",
+        )
+        parser.add_argument(
+            "--older-than",
+            metavar="DAYS",
+            type=cache.days,
+            default=cache.days(DEFAULT_PRUNE_DAYS),
+            help="When pruning, remove the installed dependencies not used in that "
+            "amount of days; defaults to {}".format(DEFAULT_PRUNE_DAYS),
+        )
+        parser.add_argument(
+            "--all",
+            action="store_true",
+            help="When pruning, remove everything in the cache",
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        if parsed_args.action == "info":
+            self._show_info()
+        else:
+            self._prune(parsed_args)
+
+    def _show_info(self):
+        """Show where the cache is and the space used by each part."""
+        logger.info("Cache directory: %s", cache.get_cache_dirpath())
+
+        usage = cache.get_usage()
+        headers = ["Content", "Directory", "Entries", "Size"]
+        data = [
+            (
+                section.description,
+                section.name,
+                section.entries,
+                naturalsize(section.size, gnu=True),
+            )
+            for section in usage
+        ]
+        table = tabulate(
+            data,
+            headers=headers,
+            tablefmt="plain",
+            colalign=["left", "left", "right", "right"],
+        )
+        for line in table.splitlines():
+            logger.info(line)
+
+        total = sum(section.size for section in usage)
+        logger.info("Total size: %s", naturalsize(total, gnu=True))
+
+    def _prune(self, parsed_args):
+        """Remove what was not used recently, or everything."""
+        if parsed_args.all:
+            _, freed = cache.prune()
+            logger.info(
+                "Removed everything from the cache, freeing %s.",
+                naturalsize(freed, gnu=True),
+            )
+            return
+
+        removed, freed = cache.prune(parsed_args.older_than)
+        logger.info(
+            "Removed %d installed dependencies sets not used in the last %d days, "
+            "freeing %s.",
+            removed,
+            parsed_args.older_than.days,
+            naturalsize(freed, gnu=True),
+        )
@@ -0,0 +1,146 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Infrastructure for the 'deps' command."""
+
+import logging
+import pathlib
+import tempfile
+
+from charmcraft import cache, deps
+from charmcraft.audit import normalize_name
+from charmcraft.cmdbase import BaseCommand, CommandError
+from charmcraft.commands.build import (
+    VENV_DIRNAME,
+    Validator,
+    get_python_target,
+    install_dependencies,
+)
+
+logger = logging.getLogger(__name__)
+
+_overview = """
+Show the tree of the charm's dependencies, and explain why a package
+is included.
+
+The project's requirement files are resolved as when building the
+charm (the packages are installed in a temporary directory), and the
+resulting dependencies are shown as a tree, from the direct requirements
+(those listed in the requirement files) to the transitive ones (those
+pulled in by other packages).
+
+Use `--why` to show the chains of requirements that pulled in a
+package, for example:
+
+    charmcraft deps --why pyyaml
+"""
+
+
+class DepsCommand(BaseCommand):
+    """Show the charm's dependencies tree."""
+
+    name = "deps"
+    help_msg = "Show the charm's dependencies tree and why a package is included"
+    overview = _overview
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument(
+            "-r",
+            "--requirement",
+            action="append",
+            type=pathlib.Path,
+            help="File(s) listing needed PyPI dependencies (can be used multiple "
+            "times); defaults to 'requirements.txt'",
+        )
+        parser.add_argument(
+            "--why",
+            metavar="PACKAGE",
+            help="Show the chains of requirements that pulled in this package",
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        validator = Validator()
+        validator.basedir = self.config.project.dirpath
+        requirement_paths = validator.validate_requirement(parsed_args.requirement)
+        if not requirement_paths:
+            raise CommandError(
+                "No requirements to resolve: use --requirement or provide a "
+                "'requirements.txt' file."
+            )
+
+        # the direct requirements, with the file where they were found
+        direct = {}
+        for filepath in requirement_paths:
+            for name in deps.parse_requirements(filepath):
+                direct.setdefault(name, filepath.name)
+
+        with tempfile.TemporaryDirectory(prefix="charmcraft-deps-") as tmpdir:
+            venvpath = pathlib.Path(tmpdir) / VENV_DIRNAME
+            install_dependencies(
+                requirement_paths,
+                venvpath,
+                get_python_target(self.config),
+                cache_dirpath=cache.get_pip_cache_dirpath(),
+            )
+            packages = deps.get_installed_packages(venvpath)
+
+        if parsed_args.why is None:
+            self._show_tree(direct, packages)
+        else:
+            self._show_why(normalize_name(parsed_args.why), direct, packages)
+
+    def _show_tree(self, direct, packages):
+        """Show the dependencies tree."""
+        for depth, package, is_direct, repeated in deps.get_tree(direct, packages):
+            marks = ["direct" if is_direct else "transitive"]
+            if repeated and package.requires:
+                marks.append("see above")
+            logger.info(
+                "%s%s %s (%s)",
+                "  " * depth,
+                package.name,
+                package.version,
+                ", ".join(marks),
+            )
+
+    def _show_why(self, name, direct, packages):
+        """Show the chains of requirements that pulled in the package."""
+        if name not in packages:
+            raise CommandError(
+                "The package {!r} is not among the charm's dependencies.".format(name)
+            )
+
+        package = packages[name]
+        chains = deps.find_chains(name, direct, packages)
+        if not chains:
+            logger.info(
+                "%s %s is installed, but no chain of requirements to it was found.",
+                package.name,
+                package.version,
+            )
+            return
+
+        logger.info("%s %s is pulled in by:", package.name, package.version)
+        for chain in chains:
+            logger.info(
+                "- %s -> %s",
+                direct[chain[0]],
+                " -> ".join(
+                    "{} {}".format(item, packages[item].version) for item in chain
+                ),
+            )
@@ -0,0 +1,138 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Infrastructure for the 'integrations' command."""
+
+import logging
+import pathlib
+
+from tabulate import tabulate
+
+from charmcraft import integrations
+from charmcraft.cmdbase import BaseCommand, CommandError
+
+logger = logging.getLogger(__name__)
+
+# the formats in which the integrations can be shown
+FORMATS = ("table", "json", "dot")
+
+_overview = """
+Show which of the charms in a directory tree can be related to each other.
+
+All the charms in the directory (by default, the project directory)
+and its subdirectories are found, and the interfaces of the endpoints
+they require are matched with those they provide (from `metadata.yaml`
+or the `metadata` section of `charmcraft.yaml`). Hidden directories
+and the `build`, `node_modules` and `venv` ones are not scanned.
+
+The result is shown as a matrix of requirers (rows) and providers
+(columns), with the interfaces that relate them, followed by the
+interfaces that are only provided or only required in the charms (so
+they have no counterpart in the tree).
+
+Use `--format=json` or `--format=dot` to get the result as a JSON
+document or as a Graphviz graph, for example:
+
+    charmcraft integrations --format=dot -o charms.dot
+    dot -Tsvg charms.dot -o charms.svg
+"""
+
+
+class IntegrationsCommand(BaseCommand):
+    """Show which charms in a directory tree can be related to each other."""
+
+    name = "integrations"
+    help_msg = "Show which charms in a directory tree can be related to each other"
+    overview = _overview
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument(
+            "directory",
+            nargs="?",
+            type=pathlib.Path,
+            help="The directory to find the charms in; defaults to the project "
+            "directory",
+        )
+        parser.add_argument(
+            "--format",
+            choices=FORMATS,
+            default="table",
+            help="How to show the integrations (defaults to 'table')",
+        )
+        parser.add_argument(
+            "-o",
+            "--output",
+            type=pathlib.Path,
+            metavar="FILEPATH",
+            help="Write the integrations to this file instead of showing them",
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        basedir = parsed_args.directory
+        if basedir is None:
+            basedir = self.config.project.dirpath
+        if not basedir.is_dir():
+            raise CommandError("Cannot access directory {!r}.".format(str(basedir)))
+
+        charms = integrations.find_charms(basedir)
+        if not charms:
+            raise CommandError("No charms found in {!r}.".format(str(basedir)))
+        found = integrations.find_integrations(charms)
+        only_provided, only_required = integrations.find_unmatched(charms)
+
+        if parsed_args.format == "json":
+            content = integrations.render_json(
+                charms, found, only_provided, only_required
+            )
+        elif parsed_args.format == "dot":
+            content = integrations.render_dot(
+                charms, found, only_provided, only_required
+            )
+        else:
+            content = self._render_table(charms, found, only_provided, only_required)
+
+        if parsed_args.output is None:
+            for line in content.splitlines():
+                logger.info(line)
+        else:
+            parsed_args.output.write_text(content + "\n")
+            logger.info(
+                "Wrote the integrations of %d charm(s) to %r.",
+                len(charms),
+                str(parsed_args.output),
+            )
+
+    def _render_table(self, charms, found, only_provided, only_required):
+        """Return the matrix of requirers and providers, and the unmatched interfaces."""
+        headers = ["Requirer \\ Provider"] + [charm.name for charm in charms]
+        matrix = integrations.get_matrix(charms, found)
+        data = [
+            [requirer.name] + [", ".join(cell) or "-" for cell in row]
+            for requirer, row in zip(charms, matrix)
+        ]
+        lines = tabulate(data, headers=headers, tablefmt="plain").splitlines()
+
+        for title, unmatched in [
+            ("Interfaces only provided (no requirer in the charms):", only_provided),
+            ("Interfaces only required (no provider in the charms):", only_required),
+        ]:
+            if unmatched:
+                lines.append(title)
+                for interface, endpoints in unmatched.items():
+                    lines.append("- {}: {}".format(interface, ", ".join(endpoints)))
+        return "\n".join(lines)
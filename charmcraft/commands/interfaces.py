@@ -0,0 +1,154 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Infrastructure for the 'interfaces' command."""
+
+import json
+import logging
+import pathlib
+
+from tabulate import tabulate
+
+from charmcraft import interfaces
+from charmcraft.cmdbase import BaseCommand, CommandError
+
+logger = logging.getLogger(__name__)
+
+_overview = """
+List the relation interfaces in a local catalog, and export their schemas.
+
+The catalog is a directory (for example, a vendored git checkout of
+interface specifications) holding a directory per interface, each one
+with a directory per version (`v0`, `v1`, etc.), which may include
+the JSON schemas for the relation data of each side of the relation
+(`provider.json` and `requirer.json`). The interfaces may also be
+inside an `interfaces` directory in the catalog.
+
+The catalog is indicated with `--catalog`, or configured in
+`charmcraft.yaml`:
+
+    interfaces:
+      catalog: ../interfaces-catalog
+
+The interfaces used by the charm's relations are indicated in the
+list. Use `--export` to write the schemas for those interfaces (latest
+version) to a directory, from where they can be used to generate the
+validators for the relation data.
+"""
+
+
+class InterfacesCommand(BaseCommand):
+    """List the interfaces in the catalog and export their schemas."""
+
+    name = "interfaces"
+    help_msg = "List the relation interfaces in a catalog and export their schemas"
+    overview = _overview
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument(
+            "--catalog",
+            type=pathlib.Path,
+            metavar="DIRECTORY",
+            help="The interfaces catalog; defaults to the one configured in "
+            "charmcraft.yaml",
+        )
+        parser.add_argument(
+            "--export",
+            type=pathlib.Path,
+            metavar="DIRECTORY",
+            help="Write the schemas of the interfaces used by the charm to "
+            "this directory",
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        catalog = interfaces.load_catalog(self.config, parsed_args.catalog)
+        if catalog is None:
+            raise CommandError(
+                "No interfaces catalog indicated: use --catalog or configure it "
+                "in charmcraft.yaml."
+            )
+        relations = interfaces.get_relations(self.config, self.config.project.dirpath)
+
+        if not catalog.names:
+            logger.info("No interfaces found in the catalog.")
+        else:
+            headers = ["Interface", "Versions", "Schemas", "Used in"]
+            data = []
+            for name in catalog.names:
+                versions = catalog.get_versions(name)
+                if versions:
+                    schemas = [
+                        role
+                        for role in interfaces.ROLES
+                        if catalog.get_schema(name, role) is not None
+                    ]
+                else:
+                    schemas = []
+                used_in = [
+                    "{} ({})".format(relation.name, relation.section)
+                    for relation in relations
+                    if relation.interface == name
+                ]
+                data.append(
+                    (
+                        name,
+                        ", ".join("v{}".format(v) for v in versions) or "-",
+                        ", ".join(schemas) or "-",
+                        ", ".join(used_in) or "-",
+                    )
+                )
+            table = tabulate(data, headers=headers, tablefmt="plain", numalign="left")
+            for line in table.splitlines():
+                logger.info(line)
+
+        if parsed_args.export is not None:
+            self._export(catalog, relations, parsed_args.export)
+
+    def _export(self, catalog, relations, export_dirpath):
+        """Write the schemas of the interfaces used by the relations."""
+        exported = set()
+        for relation in relations:
+            if relation.interface not in catalog:
+                logger.warning(
+                    "Interface %r (used in relation %r) not found in the catalog.",
+                    relation.interface,
+                    relation.name,
+                )
+                continue
+            versions = catalog.get_versions(relation.interface)
+            if not versions:
+                continue
+            version = versions[-1]
+            for role in interfaces.ROLES:
+                schema = catalog.get_schema(relation.interface, role, version)
+                if schema is None:
+                    continue
+                filepath = (
+                    export_dirpath
+                    / relation.interface
+                    / "v{}".format(version)
+                    / (role + ".json")
+                )
+                filepath.parent.mkdir(parents=True, exist_ok=True)
+                filepath.write_text(json.dumps(schema, indent=4, sort_keys=True) + "\n")
+                exported.add(filepath)
+        logger.info(
+            "Exported %d schema(s) for the charm's relations to %r.",
+            len(exported),
+            str(export_dirpath),
+        )
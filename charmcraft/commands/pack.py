@@ -16,18 +16,32 @@
 
 """Infrastructure for the 'pack' command."""
 
+import datetime
+import functools
+import hashlib
+import json
 import logging
+import pathlib
+import tempfile
 import zipfile
 from argparse import Namespace
 
+import yaml
+
+from charmcraft import __version__, bundle, git, interfaces, linters
+from charmcraft import config as config_module
 from charmcraft.cmdbase import BaseCommand, CommandError
 from charmcraft.commands import build
+from charmcraft.commands.store.export import download_charm
+from charmcraft.commands.store.store import Store
+from charmcraft.compression import DEFAULT_LEVEL, ParallelZipFile
 from charmcraft.utils import (
     SingleOptionEnsurer,
     create_manifest,
     load_yaml,
     useful_filepath,
 )
+from charmcraft.verify import ensure_deployable
 
 logger = logging.getLogger(__name__)
 
@@ -35,12 +49,11 @@ logger = logging.getLogger(__name__)
 MANDATORY_FILES = {"bundle.yaml", "manifest.yaml", "README.md"}
 
 
-def build_zip(zippath, basedir, fpaths):
-    """Build the final file."""
-    zipfh = zipfile.ZipFile(zippath, "w", zipfile.ZIP_DEFLATED)
-    for fpath in fpaths:
-        zipfh.write(fpath, fpath.relative_to(basedir))
-    zipfh.close()
+def build_zip(zippath, basedir, fpaths, level=DEFAULT_LEVEL, workers=None):
+    """Build the final file, compressing the files with the indicated workers."""
+    with ParallelZipFile(zippath, level=level, workers=workers) as zipfh:
+        for fpath in fpaths:
+            zipfh.write(fpath, fpath.relative_to(basedir))
 
 
 def get_paths_to_include(config):
@@ -66,6 +79,100 @@ def get_paths_to_include(config):
     return sorted(allpaths)
 
 
+def fetch_charm(store, name, channel, filepath):
+    """Download the charm released in the channel, to verify the bundle using it."""
+    download_charm(store, name, channel, filepath)
+
+
+def relocate_path(filepath, srcdir, destdir):
+    """Return the equivalent path in destdir if the file is inside srcdir."""
+    filepath = filepath.expanduser().absolute()
+    try:
+        relative = filepath.relative_to(srcdir)
+    except ValueError:
+        return filepath
+    return destdir / relative
+
+
+def get_artifact_info(filepath):
+    """Return the name, type, version and details of a packed charm or bundle."""
+    hashers = {"sha256": hashlib.sha256(), "sha384": hashlib.sha384()}
+    with filepath.open("rb") as fh:
+        while True:
+            data = fh.read(2 ** 20)
+            if not data:
+                break
+            for hasher in hashers.values():
+                hasher.update(data)
+
+    with zipfile.ZipFile(str(filepath)) as zf:
+        names = zf.namelist()
+        if "metadata.yaml" in names:
+            artifact_type = "charm"
+            metadata = yaml.safe_load(zf.read("metadata.yaml"))
+        else:
+            artifact_type = "bundle"
+            metadata = yaml.safe_load(zf.read("bundle.yaml"))
+        manifest = {}
+        if "manifest.yaml" in names:
+            manifest = yaml.safe_load(zf.read("manifest.yaml"))
+        version = None
+        if build.VERSION_FILENAME in names:
+            version = zf.read(build.VERSION_FILENAME).decode("utf8").strip()
+
+    bases = manifest.get("bases", [])
+    details = {
+        "path": str(filepath.absolute()),
+        "size": filepath.stat().st_size,
+        "sha256": hashers["sha256"].hexdigest(),
+        "sha384": hashers["sha384"].hexdigest(),
+        "bases": bases,
+        "architectures": sorted(
+            {arch for base in bases for arch in base.get("architectures", [])}
+        ),
+    }
+    return metadata.get("name"), artifact_type, version, details
+
+
+def write_report(report_path, artifact_paths, started_at):
+    """Write a JSON report about the packed artifacts, for other tools to use.
+
+    Each artifact has its name, type and version; they are also at the top of the
+    report when all the artifacts agree on them (None otherwise, or if nothing was
+    packed).
+    """
+    finished_at = datetime.datetime.utcnow()
+    artifacts = []
+    for filepath in artifact_paths:
+        name, artifact_type, version, details = get_artifact_info(filepath)
+        artifacts.append(dict(details, name=name, type=artifact_type, version=version))
+
+    summary = {}
+    for key in ("name", "type", "version"):
+        values = {artifact[key] for artifact in artifacts}
+        summary[key] = values.pop() if len(values) == 1 else None
+
+    report = {
+        "charmcraft-version": __version__,
+        "name": summary["name"],
+        "type": summary["type"],
+        "version": summary["version"],
+        "artifacts": artifacts,
+        "timings": {
+            "started-at": started_at.isoformat() + "Z",
+            "finished-at": finished_at.isoformat() + "Z",
+            "duration": round((finished_at - started_at).total_seconds(), 3),
+        },
+    }
+    try:
+        report_path.write_text(json.dumps(report, indent=4) + "\n")
+    except OSError as exc:
+        raise CommandError(
+            "Cannot write the report to {!r}: {}.".format(str(report_path), exc.strerror)
+        )
+    logger.info("Report written to '%s'.", report_path)
+
+
 _overview = """
 Build and pack a charm operator package or a bundle.
 
@@ -76,10 +183,37 @@ For the charm you must be inside a charm directory with a valid
 `metadata.yaml`, `requirements.txt` including the `ops` package
 for the Python operator framework, and an operator entrypoint,
 usually `src/charm.py`.  See `charmcraft init` to create a
-template charm directory structure.
+template charm directory structure. The charm's metadata, actions
+and config can also be declared in the `metadata`, `actions` and
+`config` sections of charmcraft.yaml, to generate their files in
+the package.
 
 For the bundle you must already have a `bundle.yaml` (can be
-generated by Juju) and a README.md file.
+generated by Juju) and a README.md file. The relations and options of
+the applications whose charms are local (a charm project or a packed
+charm, relative to the bundle's directory) are verified against those
+charms: the endpoints must exist with matching interfaces, and the
+options must exist in the charm's config with values of their type.
+Use `--fetch-charms` to also verify the applications whose charms are
+in Charmhub, downloading them.
+
+By default the charm is built from what is on disk, including any
+uncommitted change. Use `--from-git` to build it from what is
+committed in a git ref (HEAD if not specified) instead.
+
+Use `--release-mode` when packing a charm to be released: the pack
+is refused if the project has uncommitted changes or untracked files
+(unless `--allow-dirty` is also given), as they would be included
+in the charm.
+
+The checks done by `charmcraft analyze` are also run on the project
+(including the relations' interfaces if a catalog is configured),
+showing a warning for each problem found.
+
+Use `--report` to write a JSON file describing what was packed (the
+path, size, digests, bases, name and version of each artifact, and
+how long it took), so other steps in a
+CI pipeline can upload and release without parsing the output.
 """
 
 
@@ -117,12 +251,55 @@ class PackCommand(BaseCommand):
                 "times); defaults to 'requirements.txt'"
             ),
         )
+        parser.add_argument(
+            "--from-git",
+            nargs="?",
+            const="HEAD",
+            metavar="REF",
+            help=(
+                "Build the charm from what is committed in the git ref, not from "
+                "the files on disk; defaults to HEAD if no ref is given"
+            ),
+        )
+        parser.add_argument(
+            "--release-mode",
+            action="store_true",
+            help="Refuse to pack the charm if the project has uncommitted changes",
+        )
+        parser.add_argument(
+            "--allow-dirty",
+            action="store_true",
+            help="Pack in release mode even if the project has uncommitted changes",
+        )
+        parser.add_argument(
+            "--report",
+            type=pathlib.Path,
+            metavar="FILE",
+            help="Write a JSON report describing the packed charm or bundle",
+        )
+        parser.add_argument(
+            "--fetch-charms",
+            action="store_true",
+            help="Download the bundle's charms from Charmhub to verify the bundle",
+        )
+        parser.add_argument(
+            "--no-cache",
+            action="store_true",
+            help=(
+                "Install the dependencies again, instead of reusing those installed "
+                "before for the same requirements"
+            ),
+        )
 
     def run(self, parsed_args):
         """Run the command."""
         # decide if this will work on a charm or a bundle
         if self.config.type == "charm" or not self.config.project.config_provided:
-            self._pack_charm(parsed_args)
+            if parsed_args.fetch_charms:
+                raise CommandError(
+                    "The --fetch-charms option is valid only when packing a bundle"
+                )
+            zipname = self._pack_charm(parsed_args)
         else:
             if parsed_args.entrypoint is not None:
                 raise CommandError(
@@ -132,16 +309,129 @@ class PackCommand(BaseCommand):
                 raise CommandError(
                     "The -r/--requirement option is valid only when packing a charm"
                 )
-            self._pack_bundle()
+            if parsed_args.from_git is not None:
+                raise CommandError(
+                    "The --from-git option is valid only when packing a charm"
+                )
+            if parsed_args.release_mode:
+                raise CommandError(
+                    "The --release-mode option is valid only when packing a charm"
+                )
+            if parsed_args.no_cache:
+                raise CommandError(
+                    "The --no-cache option is valid only when packing a charm"
+                )
+            zipname = self._pack_bundle(fetch_charms=parsed_args.fetch_charms)
+
+        if parsed_args.report is not None:
+            write_report(
+                parsed_args.report,
+                [pathlib.Path(zipname)],
+                self.config.project.started_at,
+            )
 
     def _pack_charm(self, parsed_args):
         """Pack a charm."""
+        project_dirpath = self.config.project.dirpath
+        if parsed_args.from_git is None:
+            if parsed_args.release_mode:
+                self._check_clean_tree(parsed_args.allow_dirty)
+            return self._build_charm(
+                project_dirpath,
+                parsed_args.entrypoint,
+                parsed_args.requirement,
+                parsed_args.no_cache,
+                self.config,
+            )
+
+        # what is committed is clean by definition, no need to check the tree
+        if not git.is_repository(project_dirpath):
+            raise CommandError(
+                "Cannot pack from git: the project is not in a git repository."
+            )
+        with tempfile.TemporaryDirectory(prefix="charmcraft-pack-") as tmpdir:
+            export_dirpath = pathlib.Path(tmpdir)
+            export_config = self._export_from_git(parsed_args.from_git, export_dirpath)
+
+            # the entrypoint and requirements inside the project are used from the export
+            srcdir = project_dirpath.expanduser().absolute()
+            entrypoint = parsed_args.entrypoint
+            if entrypoint is not None:
+                entrypoint = relocate_path(entrypoint, srcdir, export_dirpath)
+            requirement = parsed_args.requirement
+            if requirement is not None:
+                requirement = [
+                    relocate_path(fpath, srcdir, export_dirpath) for fpath in requirement
+                ]
+            return self._build_charm(
+                export_dirpath,
+                entrypoint,
+                requirement,
+                parsed_args.no_cache,
+                export_config,
+            )
+
+    def _check_clean_tree(self, allow_dirty):
+        """Verify that everything in the project is committed, to pack for release."""
+        project_dirpath = self.config.project.dirpath
+        if not git.is_repository(project_dirpath):
+            problem = "the project is not in a git repository, so it cannot be verified"
+        elif git.is_dirty(project_dirpath, untracked=True):
+            problem = "the project has uncommitted changes or untracked files"
+        else:
+            return
+
+        if allow_dirty:
+            logger.warning("Packing in release mode although %s.", problem)
+            return
+        raise CommandError(
+            "Cannot pack in release mode: {}; commit everything, use --from-git, or "
+            "use --allow-dirty to pack anyway.".format(problem)
+        )
+
+    def _export_from_git(self, ref, export_dirpath):
+        """Export the project from the git ref, including its version file.
+
+        Return the configuration committed in the ref, to build the exported project.
+        """
+        project_dirpath = self.config.project.dirpath
+        commit = git.export_tree(project_dirpath, ref, export_dirpath)
+        logger.info("Packing the charm from git ref %r (commit %s).", ref, commit[:7])
+
+        # same timestamp for all the run, even if the configuration is loaded again
+        export_config = config_module.load(export_dirpath)
+        project = export_config.project.copy(
+            update={"started_at": self.config.project.started_at}
+        )
+        export_config = export_config.copy(update={"project": project})
+
+        # the version is for the exported commit, as the export is not a repository
+        version_path = export_dirpath / build.VERSION_FILENAME
+        if not version_path.exists():
+            template = export_config.parts.charm.version
+            version = git.build_version(project_dirpath, template, ref)
+            logger.debug("Writing version file: %r", version)
+            version_path.write_text(version + "\n")
+        return export_config
+
+    def _run_linters(self, config, dirpath):
+        """Run the checks on the project, warning about any problem found."""
+        catalog = interfaces.load_catalog(config)
+        for result in linters.analyze(config, dirpath, catalog):
+            for problem in result.problems:
+                logger.warning("The %r check found a problem: %s", result.name, problem)
+
+    def _build_charm(self, dirpath, entrypoint, requirement, no_cache, config):
+        """Build the charm from the indicated directory, with its configuration."""
+        self._run_linters(config, dirpath)
+
         # adapt arguments to use the build infrastructure
         parsed_args = Namespace(
             **{
-                "from": self.config.project.dirpath,
-                "entrypoint": parsed_args.entrypoint,
-                "requirement": parsed_args.requirement,
+                "from": dirpath,
+                "entrypoint": entrypoint,
+                "requirement": requirement,
+                "no_cache": no_cache,
             }
         )
 
@@ -149,10 +439,24 @@ class PackCommand(BaseCommand):
         validator = build.Validator()
         args = validator.process(parsed_args)
         logger.debug("working arguments: %s", args)
-        builder = build.Builder(args, self.config)
-        builder.run()
+        builder = build.Builder(args, config)
+        return builder.run()
+
+    def _check_bundle(self, bundle_config, fetch_charms):
+        """Verify the bundle against its charms, failing if any problem is found."""
+        fetch = None
+        if fetch_charms:
+            fetch = functools.partial(fetch_charm, Store(self.config.charmhub))
+        problems = bundle.check_bundle(
+            bundle_config, self.config.project.dirpath, fetch=fetch
+        )
+        if problems:
+            raise CommandError(
+                "The bundle does not match its charms:\n"
+                + "\n".join("- " + problem for problem in problems)
+            )
 
-    def _pack_bundle(self):
+    def _pack_bundle(self, fetch_charms=False):
         """Pack a bundle."""
         # get the config files
         bundle_filepath = self.config.project.dirpath / "bundle.yaml"
@@ -176,11 +480,21 @@ class PackCommand(BaseCommand):
 
         # pack everything
         project = self.config.project
+        self._check_bundle(bundle_config, fetch_charms)
+        self._run_linters(self.config, project.dirpath)
         manifest_filepath = create_manifest(project.dirpath, project.started_at)
         try:
             paths = get_paths_to_include(self.config)
             zipname = project.dirpath / (bundle_name + ".zip")
-            build_zip(zipname, project.dirpath, paths)
+            build_zip(
+                zipname,
+                project.dirpath,
+                paths,
+                level=self.config.compression.level,
+                workers=self.config.compression.workers,
+            )
         finally:
             manifest_filepath.unlink()
+        ensure_deployable(zipname)
         logger.info("Created '%s'.", zipname)
+        return zipname
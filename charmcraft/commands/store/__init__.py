@@ -17,11 +17,13 @@
 """Commands related to Charmhub."""
 
 import ast
+import datetime
 import hashlib
 import json
 import logging
 import pathlib
 import string
+import sys
 import tempfile
 import textwrap
 import zipfile
@@ -29,10 +31,12 @@ from collections import namedtuple
 from operator import attrgetter
 
 import yaml
-from humanize import naturalsize
+from humanize import naturaldelta, naturalsize
 from tabulate import tabulate
 
+from charmcraft import git, linters
 from charmcraft.cmdbase import BaseCommand, CommandError
+from charmcraft.notifications import build_payload, notify
 from charmcraft.utils import (
     ResourceOption,
     SingleOptionEnsurer,
@@ -40,6 +44,8 @@ from charmcraft.utils import (
     useful_filepath,
 )
 
+from . import policy
+from .export import build_export, download_charm, normalize_channel, verify_export
 from .store import Store
 from .registry import ImageHandler
 
@@ -61,8 +67,14 @@ OCIImageSpec = namedtuple("OCIImageSpec", "organization name reference")
 INIT_TEMPLATE_TOKEN = b"TEMPLATE-TODO"
 
 
-def get_name_from_metadata():
-    """Return the name if present and plausible in metadata.yaml."""
+def get_name_from_metadata(config=None):
+    """Return the name if present and plausible in the charm's metadata.
+
+    It's taken from the 'metadata' section of charmcraft.yaml (if the config is given
+    and has it), or from metadata.yaml.
+    """
+    if config is not None and config.metadata is not None:
+        return config.metadata.name
     try:
         with open("metadata.yaml", "rb") as fh:
             metadata = yaml.safe_load(fh)
@@ -72,6 +84,61 @@ def get_name_from_metadata():
     return charm_name
 
 
+def add_offline_option(parser):
+    """Add the option to work offline, with the data cached from the Store."""
+    parser.add_argument(
+        "--offline",
+        action="store_true",
+        help="Do not hit the Store, show the data cached the last time it was hit",
+    )
+
+
+def add_override_policy_option(parser):
+    """Add the option to release without enforcing the release policy."""
+    parser.add_argument(
+        "--override-policy",
+        action="store_true",
+        help="Release even if the configured release policy does not allow it "
+        "(the override is logged)",
+    )
+
+
+def show_cache_age(store):
+    """Tell the user how old the shown data is, when working offline."""
+    timestamp = store.get_cache_timestamp()
+    if timestamp is not None:
+        age = naturaldelta(datetime.datetime.utcnow() - timestamp)
+        logger.info("Working offline, showing the data cached %s ago.", age)
+
+
+def group_channels(channels):
+    """Group the channels by track, in the order they should be shown.
+
+    Return a list of (track, channels, branches) where the channels of each track
+    follow their fallbacks and the branches are listed apart.
+    """
+    all_tracks = []
+    per_track = {}
+    for channel in channels:
+        # it's super rare to have a more than just a bunch of tracks (furthermore, normally
+        # there's only one), so it's ok to do this sequential search
+        if channel.track not in all_tracks:
+            all_tracks.append(channel.track)
+
+        nonbranches_list, branches_list = per_track.setdefault(channel.track, ([], []))
+        if channel.branch is None:
+            # insert branch right after its fallback
+            for idx, stored in enumerate(nonbranches_list, 1):
+                if stored.name == channel.fallback:
+                    nonbranches_list.insert(idx, channel)
+                    break
+            else:
+                nonbranches_list.append(channel)
+        else:
+            branches_list.append(channel)
+    return [(track,) + per_track[track] for track in all_tracks]
+
+
 def create_importable_name(charm_name):
     """Convert a charm name to something that is importable in python."""
     return charm_name.replace("-", "_")
@@ -345,6 +412,10 @@ class UploadCommand(BaseCommand):
         will report details of the failure, otherwise it will give you the
         new charm or bundle revision.
 
+        The new revision can be released right away with `--release`,
+        following the release policy configured in charmcraft.yaml (see
+        the `release` command).
+
         Upload will take you through login if needed.
     """
     )
@@ -360,6 +431,7 @@ class UploadCommand(BaseCommand):
             action="append",
             help="The channel(s) to release to (this option can be indicated multiple times)",
         )
+        add_override_policy_option(parser)
 
     def _validate_template_is_handled(self, filepath):
         """Verify the zip does not have any file with the 'init' template TODO marker.
@@ -391,10 +463,27 @@ class UploadCommand(BaseCommand):
         result = store.upload(name, parsed_args.filepath)
         if result.ok:
             logger.info("Revision %s of %r created", result.revision, str(name))
+            # the revision is not released yet (and may never be, if the policy or
+            # Charmhub refuse it), so the release is notified separately
+            notify(self.config, build_payload("upload", name, result.revision))
             if parsed_args.release:
                 # also release!
+                policy.enforce(
+                    self.config.release_policy,
+                    store,
+                    name,
+                    result.revision,
+                    parsed_args.release,
+                    override=parsed_args.override_policy,
+                )
                 store.release(name, result.revision, parsed_args.release)
                 logger.info("Revision released to %s", ", ".join(parsed_args.release))
+                notify(
+                    self.config,
+                    build_payload(
+                        "release", name, result.revision, parsed_args.release
+                    ),
+                )
         else:
             logger.info("Upload failed with status %r:", result.status)
             for error in result.errors:
@@ -416,7 +505,13 @@ class ListRevisionsCommand(BaseCommand):
            Revision    Version    Created at    Status
            1           1          2020-11-15    released
 
-        Listing revisions will take you through login if needed.
+        If the versions were built from a git repository when packing
+        (see the `parts.charm.version` option in charmcraft.yaml), the
+        commit each revision came from is also shown.
+
+        Listing revisions will take you through login if needed. Use
+        --offline to show the revisions cached the last time they were
+        retrieved.
     """
     )
     common = True
@@ -424,16 +519,28 @@ class ListRevisionsCommand(BaseCommand):
     def fill_parser(self, parser):
         """Add own parameters to the general parser."""
         parser.add_argument("name", help="The name of the charm or bundle")
+        add_offline_option(parser)
 
     def run(self, parsed_args):
         """Run the command."""
-        store = Store(self.config.charmhub)
+        store = Store(self.config.charmhub, offline=parsed_args.offline)
         result = store.list_revisions(parsed_args.name)
+        if parsed_args.offline:
+            show_cache_age(store)
         if not result:
             logger.info("No revisions found.")
             return
 
+        # the commit column is only shown if any version was built from git
+        commits = {}
+        for item in result:
+            commit, dirty = git.get_commit_from_version(item.version)
+            if commit is not None:
+                commits[item.revision] = commit + (" (dirty)" if dirty else "")
+
         headers = ["Revision", "Version", "Created at", "Status"]
+        if commits:
+            headers.insert(2, "Commit")
         data = []
         for item in sorted(result, key=attrgetter("revision"), reverse=True):
             # use just the status or include error message/code in it (if exist)
@@ -443,14 +550,15 @@ class ListRevisionsCommand(BaseCommand):
             else:
                 status = item.status
 
-            data.append(
-                [
-                    item.revision,
-                    item.version,
-                    item.created_at.strftime("%Y-%m-%d"),
-                    status,
-                ]
-            )
+            row = [
+                item.revision,
+                item.version,
+                item.created_at.strftime("%Y-%m-%d"),
+                status,
+            ]
+            if commits:
+                row.insert(2, commits.get(item.revision, ""))
+            data.append(row)
 
         table = tabulate(data, headers=headers, tablefmt="plain", numalign="left")
         for line in table.splitlines():
@@ -498,6 +606,36 @@ class ReleaseCommand(BaseCommand):
             charmcraft release mycharm --revision=14 \\
                 --channel=beta --resource=thedb:4
 
+        A warning is shown for each OCI image resource (the images for the
+        charm's containers) which is not indicated and does not already have
+        a revision attached in the channel.
+
+        A release policy can be configured in charmcraft.yaml to avoid
+        releasing untested revisions, requiring that the revision is
+        already released to the next lower risk of the track (optionally
+        for a minimum time), asking for confirmation before releasing to
+        stable, and restricting tracks; for example:
+
+            release-policy:
+              require-lower-risk: true
+              soak-hours: 48
+              confirm-stable: true
+              restricted-tracks: [legacy]
+
+        The release policy can also be configured for all the projects in
+        the user's config.yaml (like ~/.config/charmcraft/config.yaml); the
+        one in charmcraft.yaml takes precedence.
+
+        Use `--override-policy` to release anyway (the override is logged).
+
+        With `--git-tag` (or `enabled: true` in the `git-tag` section of
+        charmcraft.yaml) an annotated tag is created in the project's git
+        repository for each channel, on the commit included in the released
+        revision's version, like `mycharm/stable/r42`. The tag name can be
+        changed with `--tag-template` (using the fields name, revision,
+        channel, track, risk and version) and the tags can be pushed to the
+        configured remote with `--push-tag`.
+
         Listing revisions will take you through login if needed.
     """
     )
@@ -530,10 +668,175 @@ class ReleaseCommand(BaseCommand):
                 "(this option can be indicated multiple times)"
             ),
         )
+        add_override_policy_option(parser)
+        parser.add_argument(
+            "--git-tag",
+            action="store_true",
+            help="Tag the released commit in the project's git repository",
+        )
+        parser.add_argument(
+            "--tag-template",
+            help="The template for the name of the git tags (implies --git-tag)",
+        )
+        parser.add_argument(
+            "--push-tag",
+            action="store_true",
+            help="Push the git tags to the remote (implies --git-tag)",
+        )
+
+    def _get_git_tags(self, store, name, revision, channels, template):
+        """Get the commit of the revision and the tags to create on it.
+
+        Everything is verified before releasing, to not release something that
+        cannot be tagged afterwards.
+        """
+        dirpath = self.config.project.dirpath
+        if not git.is_repository(dirpath):
+            raise CommandError(
+                "Cannot tag the release: the project is not in a git repository."
+            )
+        versions = {item.revision: item.version for item in store.list_revisions(name)}
+        if revision not in versions:
+            raise CommandError(
+                "Cannot tag the release: revision {} not found.".format(revision)
+            )
+        version = versions[revision]
+        commit, dirty = git.get_commit_from_version(version)
+        if commit is None:
+            raise CommandError(
+                "Cannot tag the release: version {!r} does not include a "
+                "commit.".format(version)
+            )
+        if dirty:
+            raise CommandError(
+                "Cannot tag the release: version {!r} was built from a dirty "
+                "tree.".format(version)
+            )
+        if not git.has_commit(dirpath, commit):
+            raise CommandError(
+                "Cannot tag the release: commit {!r} not found in the "
+                "repository.".format(commit)
+            )
+
+        problem = git.validate_tag_template(template)
+        if problem is not None:
+            raise CommandError("Cannot tag the release: {}.".format(problem))
+        tags = []
+        for channel in channels:
+            track, risk = normalize_channel(channel).split("/")[:2]
+            tag = template.format(
+                name=name,
+                revision=revision,
+                channel=channel,
+                track=track,
+                risk=risk,
+                version=version,
+            )
+            if not git.is_valid_tag_name(dirpath, tag):
+                raise CommandError(
+                    "Cannot tag the release: {!r} is not a valid tag name.".format(tag)
+                )
+            if git.has_tag(dirpath, tag):
+                raise CommandError(
+                    "Cannot tag the release: tag {!r} already exists.".format(tag)
+                )
+            tags.append(tag)
+        return commit, tags
+
+    def _create_git_tags(self, name, revision, channels, commit, tags, push, remote):
+        """Create the tags on the commit (and push them, if requested)."""
+        dirpath = self.config.project.dirpath
+        message = "Revision {} of {!r} released to {}".format(
+            revision, name, ", ".join(channels)
+        )
+        for tag in tags:
+            try:
+                git.create_tag(dirpath, tag, commit, message)
+            except CommandError as exc:
+                raise CommandError(
+                    "Revision {} was released, but the git tag {!r} could not be "
+                    "created: {}".format(revision, tag, exc)
+                )
+        logger.info("Tagged commit %s as %s.", commit, ", ".join(tags))
+        if not push:
+            return
+        try:
+            git.push_tags(dirpath, remote, tags)
+        except CommandError as exc:
+            logger.warning("Cannot push the git tags to %r: %s", remote, exc)
+        else:
+            logger.info("Pushed the git tags to %r.", remote)
+
+    def _check_container_resources(self, store, name, channels, resources):
+        """Warn about the OCI image resources that will not have a revision attached.
+
+        Those are the resources that are not indicated in the command line and are
+        not already attached in the store for the channel.
+        """
+        indicated = {resource.name for resource in resources}
+        missing = [
+            resource.name
+            for resource in store.list_resources(name)
+            if resource.resource_type == linters.CONTAINER_RESOURCE_TYPE
+            and resource.name not in indicated
+        ]
+        if not missing:
+            return
+
+        channel_map, _, _ = store.list_releases(name)
+        for channel in channels:
+            attached = {
+                resource.name
+                for release in channel_map
+                if release.channel == normalize_channel(channel)
+                for resource in release.resources
+                if resource.revision is not None
+            }
+            for resource_name in missing:
+                if resource_name not in attached:
+                    logger.warning(
+                        "The OCI image resource %r has no revision attached in "
+                        "channel %r; use --resource to indicate one.",
+                        resource_name,
+                        channel,
+                    )
 
     def run(self, parsed_args):
         """Run the command."""
         store = Store(self.config.charmhub)
+        policy.enforce(
+            self.config.release_policy,
+            store,
+            parsed_args.name,
+            parsed_args.revision,
+            parsed_args.channel,
+            override=parsed_args.override_policy,
+        )
+
+        git_tag = self.config.git_tag
+        tag_template = parsed_args.tag_template or git_tag.template
+        tagging = (
+            parsed_args.git_tag
+            or parsed_args.tag_template is not None
+            or parsed_args.push_tag
+            or git_tag.enabled
+        )
+        if tagging:
+            commit, tags = self._get_git_tags(
+                store,
+                parsed_args.name,
+                parsed_args.revision,
+                parsed_args.channel,
+                tag_template,
+            )
+
+        try:
+            self._check_container_resources(
+                store, parsed_args.name, parsed_args.channel, parsed_args.resource
+            )
+        except CommandError as exc:
+            # just a verification, it must not prevent the release
+            logger.debug("Cannot verify the resources to attach: %s", exc)
         store.release(
             parsed_args.name,
             parsed_args.revision,
@@ -551,6 +854,26 @@ class ReleaseCommand(BaseCommand):
                 )
             )
         logger.info(msg, *args)
+        notify(
+            self.config,
+            build_payload(
+                "release",
+                parsed_args.name,
+                parsed_args.revision,
+                parsed_args.channel,
+                parsed_args.resource,
+            ),
+        )
+        if tagging:
+            self._create_git_tags(
+                parsed_args.name,
+                parsed_args.revision,
+                parsed_args.channel,
+                commit,
+                tags,
+                parsed_args.push_tag or git_tag.push,
+                git_tag.remote,
+            )
 
 
 class StatusCommand(BaseCommand):
@@ -575,7 +898,9 @@ class StatusCommand(BaseCommand):
                    beta       -          -
                    edge       1          1
 
-        Showing channels will take you through login if needed.
+        Showing channels will take you through login if needed. Use
+        --offline to show the channel map cached the last time it was
+        retrieved (for example, if the Store is not reachable).
     """
     )
     common = True
@@ -583,6 +908,7 @@ class StatusCommand(BaseCommand):
     def fill_parser(self, parser):
         """Add own parameters to the general parser."""
         parser.add_argument("name", help="The name of the charm or bundle")
+        add_offline_option(parser)
 
     def _build_resources_repr(self, resources):
         """Build a representation of a list of resources."""
@@ -594,8 +920,10 @@ class StatusCommand(BaseCommand):
 
     def run(self, parsed_args):
         """Run the command."""
-        store = Store(self.config.charmhub)
+        store = Store(self.config.charmhub, offline=parsed_args.offline)
         channel_map, channels, revisions = store.list_releases(parsed_args.name)
+        if parsed_args.offline:
+            show_cache_age(store)
         if not channel_map:
             logger.info("Nothing has been released yet.")
             return
@@ -605,29 +933,8 @@ class StatusCommand(BaseCommand):
         revisions_by_revno = {item.revision: item for item in revisions}
 
         # process and order the channels, while preserving the tracks order
-        all_tracks = []
-        per_track = {}
-        branch_present = False
-        for channel in channels:
-            # it's super rare to have a more than just a bunch of tracks (furthermore, normally
-            # there's only one), so it's ok to do this sequential search
-            if channel.track not in all_tracks:
-                all_tracks.append(channel.track)
-
-            nonbranches_list, branches_list = per_track.setdefault(
-                channel.track, ([], [])
-            )
-            if channel.branch is None:
-                # insert branch right after its fallback
-                for idx, stored in enumerate(nonbranches_list, 1):
-                    if stored.name == channel.fallback:
-                        nonbranches_list.insert(idx, channel)
-                        break
-                else:
-                    nonbranches_list.append(channel)
-            else:
-                branches_list.append(channel)
-                branch_present = True
+        grouped = group_channels(channels)
+        branch_present = any(branches for _, _, branches in grouped)
 
         headers = ["Track", "Channel", "Version", "Revision"]
         resources_present = any(release.resources for release in channel_map)
@@ -639,10 +946,9 @@ class StatusCommand(BaseCommand):
         # show everything, grouped by tracks, with regular channels at first and
         # branches (if any) after those
         data = []
-        for track in all_tracks:
+        for track, channels, branches in grouped:
             release_shown_for_this_track = False
             shown_track = track
-            channels, branches = per_track[track]
 
             for channel in channels:
                 description = channel.risk
@@ -684,6 +990,110 @@ class StatusCommand(BaseCommand):
             logger.info(line)
 
 
+class TuiCommand(BaseCommand):
+    """Manage the releases of a charm or bundle in an interactive grid."""
+
+    name = "tui"
+    help_msg = "Manage the releases of a charm or bundle in an interactive grid"
+    overview = textwrap.dedent(
+        """
+        Show the channel map of a charm or bundle in an interactive grid,
+        to plan several releases and do them all at once.
+
+        The grid shows the channels (with the revisions and resources
+        released in each one, for all the bases) and the revisions of the
+        package. Move with the arrow keys, and switch between both lists
+        with tab:
+
+        - press enter on a revision to select it, or `s` on a channel to
+          select all the revisions released there (with their resources),
+          and then press enter on the channels where to release them
+
+        - press `a` on a channel to attach a resource revision to its
+          releases, indicated as `<name>:<revision>`
+
+        - press `x` on a channel to undo what was planned there
+
+        - press `c` to see all the planned releases and confirm them, or
+          `q` to leave without releasing anything (if a release fails, the
+          ones already done are reported)
+
+        The release policy configured in charmcraft.yaml is verified for
+        all the planned releases before doing them; use `--override-policy`
+        to release anyway (the override is logged).
+
+        This needs an interactive terminal, and will take you through login
+        if needed.
+    """
+    )
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument("name", help="The name of the charm or bundle")
+        add_override_policy_option(parser)
+
+    def run(self, parsed_args):
+        """Run the command."""
+        if not (sys.stdin.isatty() and sys.stdout.isatty()):
+            raise CommandError(
+                "The 'tui' command needs an interactive terminal; use the 'status' "
+                "and 'release' commands instead."
+            )
+
+        # curses is not available in all the platforms (e.g. Windows), so only imported
+        # when the grid is really used
+        import curses
+
+        from .tui import ReleasePlan, ReleasesGrid
+
+        store = Store(self.config.charmhub)
+        channel_map, channels, revisions = store.list_releases(parsed_args.name)
+        resources = store.list_resources(parsed_args.name)
+        ordered = [
+            channel
+            for _, track_channels, branches in group_channels(channels)
+            for channel in track_channels + branches
+        ]
+        if not ordered:
+            raise CommandError(
+                "The package {!r} has no channels to release to.".format(
+                    parsed_args.name
+                )
+            )
+
+        plan = ReleasePlan(parsed_args.name, ordered, channel_map, revisions, resources)
+        grid = ReleasesGrid(
+            plan, self.config.release_policy, override=parsed_args.override_policy
+        )
+        if not curses.wrapper(grid.run):
+            logger.info("Nothing released.")
+            return
+
+        violations = plan.get_violations(self.config.release_policy)
+        if violations:
+            logger.warning(
+                "Overriding the release policy to release revisions of %r.",
+                parsed_args.name,
+            )
+            for violation in violations:
+                logger.warning("- %s", violation)
+        for revision, channels, resources in plan.apply(store):
+            msg = "Revision %d of %r released to %s"
+            args = [revision, parsed_args.name, ", ".join(channels)]
+            if resources:
+                msg += " (attaching resources: %s)"
+                args.append(
+                    ", ".join("{!r} r{}".format(r.name, r.revision) for r in resources)
+                )
+            logger.info(msg, *args)
+            notify(
+                self.config,
+                build_payload(
+                    "release", parsed_args.name, revision, channels, resources
+                ),
+            )
+
+
 class _BadLibraryPathError(CommandError):
     """Subclass to provide a specific error for a bad library path."""
 
@@ -887,8 +1297,8 @@ class CreateLibCommand(BaseCommand):
         a new library in your charm which you are publishing for others.
 
         This command MUST be run inside your charm directory with a valid
-        metadata.yaml. It will create the Python library with API version 0
-        initially:
+        metadata.yaml (or the charm's metadata in charmcraft.yaml). It will
+        create the Python library with API version 0 initially:
 
           lib/charms/<yourcharm>/v0/<name>.py
 
@@ -920,11 +1330,12 @@ class CreateLibCommand(BaseCommand):
                 "characters and underscore, starting with alpha."
             )
 
-        charm_name = get_name_from_metadata()
+        charm_name = get_name_from_metadata(self.config)
         if charm_name is None:
             raise CommandError(
-                "Cannot find a valid charm name in metadata.yaml. Check you are in a charm "
-                "directory with metadata.yaml."
+                "Cannot find a valid charm name in metadata.yaml nor in the 'metadata' "
+                "section of charmcraft.yaml. Check you are in a charm directory with any "
+                "of them."
             )
 
         # '-' is valid in charm names, but not in a python import
@@ -984,11 +1395,12 @@ class PublishLibCommand(BaseCommand):
 
     def run(self, parsed_args):
         """Run the command."""
-        charm_name = get_name_from_metadata()
+        charm_name = get_name_from_metadata(self.config)
         if charm_name is None:
             raise CommandError(
-                "Can't access name in 'metadata.yaml' file. The 'publish-lib' command needs to "
-                "be executed in a valid project's directory."
+                "Can't access name in 'metadata.yaml' file nor in the 'metadata' section of "
+                "charmcraft.yaml. The 'publish-lib' command needs to be executed in a valid "
+                "project's directory."
             )
 
         if parsed_args.library:
@@ -1084,6 +1496,17 @@ class PublishLibCommand(BaseCommand):
                 lib_data.api,
                 lib_data.patch,
             )
+            notify(
+                self.config,
+                build_payload(
+                    "publish-lib",
+                    lib_data.charm_name,
+                    library={
+                        "name": lib_data.full_name,
+                        "version": "{}.{}".format(lib_data.api, lib_data.patch),
+                    },
+                ),
+            )
 
 
 class FetchLibCommand(BaseCommand):
@@ -1217,28 +1640,32 @@ class ListLibCommand(BaseCommand):
             "name",
             nargs="?",
             help=(
-                "The name of the charm (optional, will get the name from"
-                "metadata.yaml if not given)"
+                "The name of the charm (optional, will get the name from "
+                "the charm's metadata if not given)"
             ),
         )
+        add_offline_option(parser)
 
     def run(self, parsed_args):
         """Run the command."""
         if parsed_args.name:
             charm_name = parsed_args.name
         else:
-            charm_name = get_name_from_metadata()
+            charm_name = get_name_from_metadata(self.config)
             if charm_name is None:
                 raise CommandError(
-                    "Can't access name in 'metadata.yaml' file. The 'list-lib' command must "
-                    "either be executed from a valid project directory, or specify a charm "
-                    "name using the --charm-name option."
+                    "Can't access name in 'metadata.yaml' file nor in the 'metadata' section "
+                    "of charmcraft.yaml. The 'list-lib' command must either be executed from "
+                    "a valid project directory, or specify a charm name using the "
+                    "--charm-name option."
                 )
 
         # get tips from the Store
-        store = Store(self.config.charmhub)
+        store = Store(self.config.charmhub, offline=parsed_args.offline)
         to_query = [{"charm_name": charm_name}]
         libs_tips = store.get_libraries_tips(to_query)
+        if parsed_args.offline:
+            show_cache_age(store)
 
         if not libs_tips:
             logger.info("No libraries found for charm %s.", charm_name)
@@ -1273,11 +1700,14 @@ class ListResourcesCommand(BaseCommand):
         parser.add_argument(
             "charm_name", metavar="charm-name", help="The name of the charm"
         )
+        add_offline_option(parser)
 
     def run(self, parsed_args):
         """Run the command."""
-        store = Store(self.config.charmhub)
+        store = Store(self.config.charmhub, offline=parsed_args.offline)
         result = store.list_resources(parsed_args.charm_name)
+        if parsed_args.offline:
+            show_cache_age(store)
         if not result:
             logger.info("No resources associated to %s.", parsed_args.charm_name)
             return
@@ -1452,7 +1882,9 @@ class ListResourceRevisionsCommand(BaseCommand):
            Revision    Created at     Size
            1           2020-11-15   183151
 
-        Listing revisions will take you through login if needed.
+        Listing revisions will take you through login if needed. Use
+        --offline to show the revisions cached the last time they were
+        retrieved.
     """
     )
 
@@ -1466,13 +1898,16 @@ class ListResourceRevisionsCommand(BaseCommand):
         parser.add_argument(
             "resource_name", metavar="resource-name", help="The resource name"
         )
+        add_offline_option(parser)
 
     def run(self, parsed_args):
         """Run the command."""
-        store = Store(self.config.charmhub)
+        store = Store(self.config.charmhub, offline=parsed_args.offline)
         result = store.list_resource_revisions(
             parsed_args.charm_name, parsed_args.resource_name
         )
+        if parsed_args.offline:
+            show_cache_age(store)
         if not result:
             logger.info("No revisions found.")
             return
@@ -1494,3 +1929,284 @@ class ListResourceRevisionsCommand(BaseCommand):
         )
         for line in table.splitlines():
             logger.info(line)
+
+
+class ExportCommand(BaseCommand):
+    """Export a released charm with all its resources to deploy it off-line."""
+
+    name = "export"
+    help_msg = "Export a released charm and its resources to an archive"
+    overview = textwrap.dedent(
+        """
+        Collect everything needed to deploy a charm in an environment without
+        access to Charmhub, and save it all in a single archive.
+
+        The archive includes the charm artifact released in the indicated
+        channel, all its file resources, and the OCI images referenced by its
+        image resources (saved in OCI Image Layout format). It also includes
+        a manifest listing the revisions and hashes of everything, which is
+        used by the `verify-export` command to check the archive integrity
+        at the destination.
+
+        For example:
+
+           $ charmcraft export my-charm --channel=stable -o my-charm.tar
+           Exported revision 7 of 'my-charm' from channel 'latest/stable' to 'my-charm.tar'.
+
+        Exporting will take you through login if needed.
+    """
+    )
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument("name", help="The name of the charm to export")
+        parser.add_argument(
+            "--channel",
+            default="stable",
+            help="The channel to export the charm from (defaults to 'stable')",
+        )
+        parser.add_argument(
+            "-o",
+            "--output",
+            type=pathlib.Path,
+            help="The file to save the archive to (defaults to <name>.tar)",
+        )
+        parser.add_argument(
+            "--architecture",
+            help="The architecture of the charm and OCI images to export (defaults "
+            "to the local one)",
+        )
+        parser.add_argument(
+            "--series",
+            help="The series of the charm to export, if the channel has releases for "
+            "several ones",
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        output = parsed_args.output
+        if output is None:
+            output = pathlib.Path("{}.tar".format(parsed_args.name))
+
+        store = Store(self.config.charmhub)
+        manifest = build_export(
+            store,
+            parsed_args.name,
+            parsed_args.channel,
+            output,
+            architecture=parsed_args.architecture,
+            series=parsed_args.series,
+        )
+        logger.info(
+            "Exported revision %s of %r from channel %r to %r.",
+            manifest["revision"],
+            parsed_args.name,
+            manifest["channel"],
+            str(output),
+        )
+        for resource in manifest["resources"]:
+            logger.info(
+                "- resource %r (%s) revision %s",
+                resource["name"],
+                resource["type"],
+                resource["revision"],
+            )
+
+
+class VerifyExportCommand(BaseCommand):
+    """Verify the integrity of an archive produced by the export command."""
+
+    name = "verify-export"
+    help_msg = "Verify the integrity of an exported archive"
+    overview = textwrap.dedent(
+        """
+        Check that an archive produced by the `export` command is complete
+        and not corrupted, comparing the hashes and sizes of all the included
+        files with what is detailed in its manifest.
+
+        This command does not need access to Charmhub.
+    """
+    )
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument(
+            "filepath", type=useful_filepath, help="The archive to verify"
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        manifest, problems = verify_export(parsed_args.filepath)
+        if problems:
+            for problem in problems:
+                logger.info("- %s", problem)
+            raise CommandError(
+                "The archive {!r} is corrupted ({} problem(s) found).".format(
+                    str(parsed_args.filepath), len(problems)
+                )
+            )
+        logger.info(
+            "Archive %r verified: revision %s of %r from channel %r, %d files.",
+            str(parsed_args.filepath),
+            manifest["revision"],
+            manifest["name"],
+            manifest["channel"],
+            len(manifest["files"]),
+        )
+
+
+def _get_zip_contents(filepath):
+    """Return the checksum and size of each file in the zip."""
+    with zipfile.ZipFile(str(filepath)) as zf:
+        return {info.filename: (info.CRC, info.file_size) for info in zf.infolist()}
+
+
+def diff_artifacts(old_filepath, new_filepath):
+    """Return the status and path of the files changed between two charm artifacts."""
+    old_files = _get_zip_contents(old_filepath)
+    new_files = _get_zip_contents(new_filepath)
+
+    changed = []
+    for path in sorted(old_files.keys() | new_files.keys()):
+        if path not in new_files:
+            changed.append(("D", path))
+        elif path not in old_files:
+            changed.append(("A", path))
+        elif old_files[path] != new_files[path]:
+            changed.append(("M", path))
+    return changed
+
+
+class ChangelogCommand(BaseCommand):
+    """Show what changed between the revisions released in two channels."""
+
+    name = "changelog"
+    help_msg = "Show what changed between the revisions released in two channels"
+    overview = textwrap.dedent(
+        """
+        Show what changed between the revisions released in two channels,
+        for example before promoting what is in edge to stable.
+
+        If the versions of both revisions were built from git (see the
+        `parts.charm.version` option in charmcraft.yaml) and the commits
+        are present in the project's repository, the commit log and the
+        changed files between them are shown. Otherwise, both charm
+        artifacts are downloaded and the files changed between them are
+        shown instead.
+
+        For example:
+
+           $ charmcraft changelog mycharm --from stable --to edge
+           From revision 5 (latest/stable, version v1.0-0-gabc1234)
+             to revision 7 (latest/edge, version v1.0-2-gdef5678)
+           Commits:
+           def5678 Fix the upgrade
+           bcd3456 Support the new relation
+           Changed files:
+           M  src/charm.py
+
+        Showing the changelog will take you through login if needed.
+    """
+    )
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument("name", help="The name of the charm")
+        parser.add_argument(
+            "--from",
+            dest="from_channel",
+            default="stable",
+            help="The channel with the old revision (defaults to 'stable')",
+        )
+        parser.add_argument(
+            "--to",
+            dest="to_channel",
+            default="edge",
+            help="The channel with the new revision (defaults to 'edge')",
+        )
+
+    def _resolve(self, channel_map, revisions, channel):
+        """Get the revision released in the channel, and its version."""
+        channel = normalize_channel(channel)
+        for release in channel_map:
+            if release.channel == channel:
+                break
+        else:
+            raise CommandError("Nothing is released in channel {!r}.".format(channel))
+        versions = {item.revision: item.version for item in revisions}
+        return channel, release.revision, versions.get(release.revision)
+
+    def _get_commits(self, old_version, new_version):
+        """Map both versions to commits in the project's repository.
+
+        Return both commits, or the reason why that was not possible.
+        """
+        dirpath = self.config.project.dirpath
+        if not git.is_repository(dirpath):
+            return None, "the project is not in a git repository"
+        commits = []
+        for version in (old_version, new_version):
+            commit, _ = git.get_commit_from_version(version)
+            if commit is None:
+                return None, "version {!r} does not include a commit".format(version)
+            if not git.has_commit(dirpath, commit):
+                return None, "commit {!r} not found in the repository".format(commit)
+            commits.append(commit)
+        return commits, None
+
+    def _diff_artifacts(self, store, name, old_release, new_release):
+        """Download the artifacts for both releases and compare them.
+
+        Each release is the channel and the revision resolved for it, which is
+        verified to still be the one released when downloading.
+        """
+        with tempfile.TemporaryDirectory() as tmpdir:
+            filepaths = []
+            for channel, revision in (old_release, new_release):
+                filepath = pathlib.Path(tmpdir) / "{}_r{}.charm".format(name, revision)
+                download_charm(store, name, channel, filepath, revision=revision)
+                filepaths.append(filepath)
+            return diff_artifacts(*filepaths)
+
+    def run(self, parsed_args):
+        """Run the command."""
+        name = parsed_args.name
+        store = Store(self.config.charmhub)
+        channel_map, _, revisions = store.list_releases(name)
+        old_channel, old_revision, old_version = self._resolve(
+            channel_map, revisions, parsed_args.from_channel
+        )
+        new_channel, new_revision, new_version = self._resolve(
+            channel_map, revisions, parsed_args.to_channel
+        )
+        logger.info(
+            "From revision %s (%s, version %s)", old_revision, old_channel, old_version
+        )
+        logger.info(
+            "  to revision %s (%s, version %s)", new_revision, new_channel, new_version
+        )
+        if old_revision == new_revision:
+            logger.info("Both channels have the same revision, nothing changed.")
+            return
+
+        commits, problem = self._get_commits(old_version, new_version)
+        if commits is None:
+            logger.info(
+                "Git history not available (%s), comparing the charm artifacts.",
+                problem,
+            )
+            changed = self._diff_artifacts(
+                store, name, (old_channel, old_revision), (new_channel, new_revision)
+            )
+        else:
+            dirpath = self.config.project.dirpath
+            logger.info("Commits:")
+            for line in git.get_log(dirpath, *commits):
+                logger.info(line)
+            changed = git.get_changed_files(dirpath, *commits)
+
+        logger.info("Changed files:")
+        if not changed:
+            logger.info("(none)")
+        for status, path in changed:
+            logger.info("%s  %s", status, path)
@@ -16,14 +16,22 @@
 
 """A client to hit the Store."""
 
+import datetime
+import hashlib
+import json
 import logging
 import os
+import pathlib
 import platform
+import shutil
 import webbrowser
+from collections import namedtuple
 from http.cookiejar import MozillaCookieJar
+from urllib.parse import urlparse
 
 import appdirs
 import requests
+from dateutil import parser
 from macaroonbakery import httpbakery
 from requests.adapters import HTTPAdapter
 from requests.exceptions import RequestException
@@ -41,6 +49,9 @@ logger = logging.getLogger("charmcraft.commands.store")
 
 TESTING_ENV_PREFIXES = ["TRAVIS", "AUTOPKGTEST_TMP"]
 
+# the size of the blocks to write to disk when downloading
+DOWNLOAD_CHUNK_SIZE = 2 ** 20
+
 
 def build_user_agent():
     """Build the charmcraft's user agent."""
@@ -82,11 +93,15 @@ class _AuthHolder:
     get a "security" recommendation (related: issue #52).
     """
 
-    def __init__(self):
+    def __init__(self, on_new_credentials=None):
         self._cookiejar_filepath = appdirs.user_config_dir("charmcraft.credentials")
         self._cookiejar = None
         self._client = None
 
+        # called when the user authenticated while doing a request (the credentials
+        # may be for other account)
+        self._on_new_credentials = on_new_credentials
+
     def clear_credentials(self):
         """Clear stored credentials."""
         if os.path.exists(self._cookiejar_filepath):
@@ -112,6 +127,9 @@ class _AuthHolder:
             )
             os.fchmod(fd, 0o600)
             self._cookiejar.save(fd)
+            self._old_cookies = list(self._cookiejar)
+            if self._on_new_credentials is not None:
+                self._on_new_credentials()
 
     def _load_credentials(self):
         """Load credentials and set up internal auth request objects."""
@@ -138,13 +156,13 @@ class _AuthHolder:
         # for comparison after hitting the endpoint
         self._old_cookies = list(self._cookiejar)
 
-    def request(self, method, url, body):
-        """Do a request."""
+    def request(self, method, url, body, headers=None):
+        """Do a request (optionally with extra headers)."""
         if self._client is None:
             # load everything on first usage
             self._load_credentials()
 
-        headers = {"User-Agent": build_user_agent()}
+        headers = dict(headers or {}, **{"User-Agent": build_user_agent()})
 
         # this request through the bakery lib will automatically catch any authentication
         # problem and (if any) ask the user to authenticate and retry the original request; if
@@ -183,17 +201,113 @@ def _storage_push(monitor, storage_base_url):
     return response
 
 
+def _storage_pull(url, filepath):
+    """Pull bytes from the storage into the indicated file."""
+    headers = {"User-Agent": build_user_agent()}
+    retries = Retry(total=5, backoff_factor=2, status_forcelist=[500, 502, 503, 504])
+
+    with requests.Session() as session:
+        session.mount("https://", HTTPAdapter(max_retries=retries))
+
+        try:
+            response = session.get(url, headers=headers, stream=True)
+            if response.ok:
+                with filepath.open("wb") as fh:
+                    for chunk in response.iter_content(chunk_size=DOWNLOAD_CHUNK_SIZE):
+                        fh.write(chunk)
+        except RequestException as err:
+            raise CommandError(
+                "Network error when downloading file: {}({!r})".format(
+                    err.__class__.__name__, str(err)
+                )
+            )
+
+    return response
+
+
+CachedResponse = namedtuple("CachedResponse", "data etag last_modified timestamp")
+
+
+class ResponseCache:
+    """A disk cache of the Store responses, with what is needed to revalidate them.
+
+    There is a different cache for each Store (as indicated by its API URL). It is
+    cleared whenever the credentials change (login, logout, or authenticating again
+    while doing a request), as the responses may be for other account.
+    """
+
+    def __init__(self, api_base_url):
+        netloc = urlparse(api_base_url).netloc or "default"
+        self.dirpath = (
+            pathlib.Path(appdirs.user_cache_dir("charmcraft")) / "store" / netloc
+        )
+
+    def _get_filepath(self, method, urlpath, body):
+        """Get the path of the file for the cached response."""
+        key = json.dumps([method, urlpath, body], sort_keys=True)
+        return self.dirpath / (hashlib.sha256(key.encode("utf8")).hexdigest() + ".json")
+
+    def get(self, method, urlpath, body=None):
+        """Return the cached response (None if not cached or unusable)."""
+        filepath = self._get_filepath(method, urlpath, body)
+        if not filepath.exists():
+            return None
+        try:
+            stored = json.loads(filepath.read_text())
+            return CachedResponse(
+                data=stored["data"],
+                etag=stored["etag"],
+                last_modified=stored["last-modified"],
+                timestamp=parser.parse(stored["timestamp"]),
+            )
+        except (OSError, ValueError, KeyError) as exc:
+            logger.debug("Cannot use the cached response for %s: %r", urlpath, exc)
+            return None
+
+    def set(self, method, urlpath, body, data, etag, last_modified):
+        """Store the response in the cache."""
+        stored = {
+            "urlpath": urlpath,
+            "data": data,
+            "etag": etag,
+            "last-modified": last_modified,
+            "timestamp": datetime.datetime.utcnow().isoformat(),
+        }
+        self.dirpath.mkdir(parents=True, exist_ok=True)
+        filepath = self._get_filepath(method, urlpath, body)
+        fd = os.open(str(filepath), os.O_WRONLY | os.O_CREAT | os.O_TRUNC, 0o600)
+        with open(fd, "wt", encoding="utf8") as fh:
+            json.dump(stored, fh)
+
+    def clear(self):
+        """Remove all the cached responses."""
+        if self.dirpath.exists():
+            shutil.rmtree(str(self.dirpath))
+            logger.debug("Cache cleared: directory '%s' removed", self.dirpath)
+
+
 class Client:
-    """Lightweight layer above _AuthHolder to present a more network oriented interface."""
+    """Lightweight layer above _AuthHolder to present a more network oriented interface.
+
+    The responses to GET requests are cached on disk, and revalidated with the Store
+    using their ETag or modification time. When working offline the Store is not
+    hit at all, and the cached responses are used.
+    """
 
-    def __init__(self, api_base_url, storage_base_url):
-        self._auth_client = _AuthHolder()
+    def __init__(self, api_base_url, storage_base_url, offline=False):
         self.api_base_url = api_base_url.rstrip("/")
         self.storage_base_url = storage_base_url.rstrip("/")
+        self.offline = offline
+        self._cache = ResponseCache(self.api_base_url)
+        self._auth_client = _AuthHolder(on_new_credentials=self._cache.clear)
+
+        # the timestamp of the oldest cached response used when working offline
+        self.cache_timestamp = None
 
     def clear_credentials(self):
-        """Clear stored credentials."""
+        """Clear stored credentials (and the responses cached with them)."""
         self._auth_client.clear_credentials()
+        self._cache.clear()
 
     def _parse_store_error(self, response):
         """Get the proper error from the Store response."""
@@ -222,27 +336,79 @@ class Client:
             messages.append(msg)
         return "Store failure! " + "; ".join(messages)
 
-    def _hit(self, method, urlpath, body=None):
-        """Issue a request to the Store."""
+    def _request(self, method, urlpath, body=None, headers=None):
+        """Issue a request to the Store, returning the raw response."""
+        if self.offline:
+            raise CommandError(
+                "Cannot work offline: {} {} needs to hit the Store.".format(
+                    method, urlpath
+                )
+            )
         url = self.api_base_url + urlpath
         logger.debug("Hitting the store: %s %s %s", method, url, body)
-        resp = self._auth_client.request(method, url, body)
+        resp = self._auth_client.request(method, url, body, headers=headers)
         if not resp.ok:
             raise CommandError(self._parse_store_error(resp))
 
         logger.debug("Store ok: %s", resp.status_code)
+        return resp
+
+    def _hit(self, method, urlpath, body=None):
+        """Issue a request to the Store."""
+        resp = self._request(method, urlpath, body)
         # XXX Facundo 2020-06-30: we need to wrap this .json() call, and raise UnknownError (after
         # logging in debug the received raw response). This would catch weird "html" responses,
         # for example, without making charmcraft to badly crash. Related: issue #73.
         data = resp.json()
         return data
 
-    def get(self, urlpath):
-        """GET something from the Store."""
+    def _cached_hit(self, method, urlpath, body=None):
+        """Issue a request to the Store, using and refreshing the cached response."""
+        cached = self._cache.get(method, urlpath, body)
+        if self.offline:
+            if cached is None:
+                raise CommandError(
+                    "Cannot work offline: there is no cached data from the Store "
+                    "for {}; run the command again with network access.".format(urlpath)
+                )
+            logger.debug("Using the cached response for %s", urlpath)
+            if self.cache_timestamp is None or cached.timestamp < self.cache_timestamp:
+                self.cache_timestamp = cached.timestamp
+            return cached.data
+
+        headers = {}
+        if cached is not None:
+            if cached.etag:
+                headers["If-None-Match"] = cached.etag
+            if cached.last_modified:
+                headers["If-Modified-Since"] = cached.last_modified
+        resp = self._request(method, urlpath, body, headers=headers)
+
+        if cached is not None and resp.status_code == 304:
+            logger.debug("The cached response for %s is still valid", urlpath)
+            data, etag, last_modified = cached.data, cached.etag, cached.last_modified
+        else:
+            data = resp.json()
+            if resp.status_code != 200:
+                return data
+            etag = resp.headers.get("ETag")
+            last_modified = resp.headers.get("Last-Modified")
+        self._cache.set(method, urlpath, body, data, etag, last_modified)
+        return data
+
+    def get(self, urlpath, cache=True):
+        """GET something from the Store (using the cache, unless indicated)."""
+        if cache:
+            return self._cached_hit("GET", urlpath)
         return self._hit("GET", urlpath)
 
-    def post(self, urlpath, body):
-        """POST a body (json-encoded) to the Store."""
+    def post(self, urlpath, body, cache=False):
+        """POST a body (json-encoded) to the Store.
+
+        The response is cached only if indicated (for requests that just query).
+        """
+        if cache:
+            return self._cached_hit("POST", urlpath, body)
         return self._hit("POST", urlpath, body)
 
     def push(self, filepath):
@@ -278,3 +444,15 @@ class Client:
         upload_id = result["upload_id"]
         logger.debug("Uploading bytes ended, id %s", upload_id)
         return upload_id
+
+    def download(self, url, filepath):
+        """Download the content from the URL (usually the Storage) to filepath."""
+        logger.debug("Starting to download %s into %s", url, filepath)
+        response = _storage_pull(url, filepath)
+        if not response.ok:
+            raise CommandError(
+                "Failure while downloading file: [{}] {!r}".format(
+                    response.status_code, response.content
+                )
+            )
+        logger.debug("Downloading bytes ended, saved in %s", filepath)
@@ -0,0 +1,328 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Build and verify archives to deploy released charms without access to Charmhub."""
+
+import datetime
+import hashlib
+import json
+import logging
+import pathlib
+import tarfile
+import tempfile
+
+import yaml
+
+from charmcraft import __version__
+from charmcraft.cmdbase import CommandError
+from charmcraft.utils import ARCH_TRANSLATIONS, get_os_platform
+
+from .registry import ImageExporter
+
+logger = logging.getLogger("charmcraft.commands.store")
+
+# the file inside the archive describing everything else
+EXPORT_MANIFEST = "export-manifest.yaml"
+
+# the directories inside the archive for the different resources
+RESOURCES_DIRNAME = "resources"
+IMAGES_DIRNAME = "images"
+
+
+def get_file_hash(filepath, algorithm="sha256"):
+    """Return the hash of the file content, using the indicated algorithm."""
+    hasher = hashlib.new(algorithm)
+    with filepath.open("rb") as fh:
+        while True:
+            data = fh.read(2 ** 20)
+            if not data:
+                break
+            hasher.update(data)
+    return hasher.hexdigest()
+
+
+def normalize_channel(channel):
+    """Return the full channel name, including the default track if not specified."""
+    risks = ("stable", "candidate", "beta", "edge")
+    if channel.split("/")[0] in risks:
+        channel = "latest/" + channel
+    return channel
+
+
+def _download_verified(store, download, filepath):
+    """Download the indicated item and verify that its content is correct."""
+    filepath.parent.mkdir(parents=True, exist_ok=True)
+    store.download(download.url, filepath)
+    obtained_hash = get_file_hash(filepath)
+    if obtained_hash != download.hash_sha256:
+        raise CommandError(
+            "Downloaded file {!r} is corrupted (expected hash {!r}, got {!r}).".format(
+                filepath.name, download.hash_sha256, obtained_hash
+            )
+        )
+
+
+def download_charm(store, name, channel, filepath, revision=None):
+    """Download the charm released in the channel, return its revision.
+
+    If a revision is indicated, verify that it is the one still released there.
+    """
+    downloads = store.get_release_downloads(name, channel)
+    if revision is not None and downloads.revision != revision:
+        raise CommandError(
+            "Charmhub informed revision {} to download, but revision {} is the one "
+            "expected in channel {!r}.".format(downloads.revision, revision, channel)
+        )
+    logger.debug("Downloading revision %d of charm %r", downloads.revision, name)
+    _download_verified(store, downloads.download, filepath)
+    return downloads.revision
+
+
+def _export_image(store, resource, basedir, architecture):
+    """Save the OCI image pointed by the resource as an OCI layout directory."""
+    # what is stored in Charmhub for an image resource is a JSON with the image
+    # location in the registry and the needed credentials to pull it
+    with tempfile.TemporaryDirectory() as tmpdir:
+        image_info_path = pathlib.Path(tmpdir) / "image-info.json"
+        _download_verified(store, resource.download, image_info_path)
+        image_info = json.loads(image_info_path.read_text())
+
+    image_name = image_info["ImageName"]
+    logger.debug("Exporting image %r for resource %r", image_name, resource.name)
+    layout_dirname = "{}_r{}".format(resource.name, resource.revision)
+    exporter = ImageExporter(
+        image_name, image_info.get("Username"), image_info.get("Password")
+    )
+    digest = exporter.save(basedir / IMAGES_DIRNAME / layout_dirname, architecture)
+    return image_name, digest, "{}/{}".format(IMAGES_DIRNAME, layout_dirname)
+
+
+def _build_files_list(basedir):
+    """Return the path, hash and size of all the files under basedir."""
+    files = []
+    for filepath in sorted(basedir.glob("**/*")):
+        if filepath.is_file():
+            files.append(
+                {
+                    "path": filepath.relative_to(basedir).as_posix(),
+                    "sha256": get_file_hash(filepath),
+                    "size": filepath.stat().st_size,
+                }
+            )
+    return files
+
+
+def _platform_matches(platform, architecture, series):
+    """Tell if a release for the platform can be used in the architecture and series."""
+    if platform is None:
+        return True
+    if platform.architecture not in (architecture, "all"):
+        return False
+    return series is None or platform.series in (series, "all")
+
+
+def _find_release(channel_map, name, channel, architecture, series):
+    """Return the release in the channel for the architecture (and series, if given).
+
+    The same channel may have different revisions for different platforms; those
+    built for the architecture are preferred over those for all of them.
+    """
+    in_channel = [release for release in channel_map if release.channel == channel]
+    if not in_channel:
+        raise CommandError(
+            "Nothing is released in channel {!r} for {!r}.".format(channel, name)
+        )
+
+    candidates = [
+        release
+        for release in in_channel
+        if _platform_matches(release.platform, architecture, series)
+    ]
+    specific = [
+        release
+        for release in candidates
+        if release.platform is not None
+        and release.platform.architecture == architecture
+    ]
+    if specific:
+        candidates = specific
+    platform_desc = "architecture {!r}".format(architecture)
+    if series is not None:
+        platform_desc += " and series {!r}".format(series)
+    if not candidates:
+        raise CommandError(
+            "Nothing is released in channel {!r} for {!r} for {}.".format(
+                channel, name, platform_desc
+            )
+        )
+    if len(candidates) > 1:
+        raise CommandError(
+            "Several revisions of {!r} are released in channel {!r} for {} (for "
+            "series {}); indicate which one to export with --series.".format(
+                name,
+                channel,
+                platform_desc,
+                ", ".join(sorted({repr(rel.platform.series) for rel in candidates})),
+            )
+        )
+    return candidates[0]
+
+
+def build_export(store, name, channel, output_filepath, architecture=None, series=None):
+    """Collect everything released in a channel for a charm and save it in an archive.
+
+    The archive is a tar file that includes the charm itself, all its file resources,
+    the OCI images pointed by the image resources (in OCI Image Layout format), and a
+    manifest detailing revisions and hashes of everything. What is exported is the
+    release for the architecture (and series, if given) in that channel.
+    """
+    if architecture is None:
+        machine = get_os_platform().machine
+        architecture = ARCH_TRANSLATIONS.get(machine, machine)
+
+    # find out what is released in the channel
+    channel = normalize_channel(channel)
+    channel_map, _, _ = store.list_releases(name)
+    release = _find_release(channel_map, name, channel, architecture, series)
+    released_resources = {res.name: res.revision for res in release.resources}
+
+    # get where to download all that from, for the same architecture
+    downloads = store.get_release_downloads(name, channel, architecture)
+    if downloads.revision != release.revision:
+        raise CommandError(
+            "Charmhub informed revision {} to download, but revision {} is the one "
+            "released in channel {!r}.".format(
+                downloads.revision, release.revision, channel
+            )
+        )
+
+    with tempfile.TemporaryDirectory() as tmpdir:
+        basedir = pathlib.Path(tmpdir)
+
+        charm_filename = "{}_r{}.charm".format(name, release.revision)
+        logger.debug("Downloading charm revision %d", release.revision)
+        _download_verified(store, downloads.download, basedir / charm_filename)
+
+        resources = []
+        for resource in downloads.resources:
+            if released_resources.get(resource.name) != resource.revision:
+                raise CommandError(
+                    "Charmhub informed revision {} to download for resource {!r}, but "
+                    "revision {} is the one attached in channel {!r}.".format(
+                        resource.revision,
+                        resource.name,
+                        released_resources.get(resource.name),
+                        channel,
+                    )
+                )
+            info = {
+                "name": resource.name,
+                "type": resource.resource_type,
+                "revision": resource.revision,
+            }
+            if resource.resource_type == "oci-image":
+                image_name, digest, layout_path = _export_image(
+                    store, resource, basedir, architecture
+                )
+                info.update(image=image_name, digest=digest, path=layout_path)
+            else:
+                resource_path = "{}/{}_r{}".format(
+                    RESOURCES_DIRNAME, resource.name, resource.revision
+                )
+                logger.debug("Downloading resource %r", resource.name)
+                _download_verified(store, resource.download, basedir / resource_path)
+                info.update(path=resource_path)
+            resources.append(info)
+
+        manifest = {
+            "charmcraft-version": __version__,
+            "exported-at": datetime.datetime.utcnow().isoformat() + "Z",
+            "name": name,
+            "channel": channel,
+            "revision": release.revision,
+            "architecture": architecture,
+            "charm": charm_filename,
+            "resources": resources,
+            "files": _build_files_list(basedir),
+        }
+        (basedir / EXPORT_MANIFEST).write_text(yaml.dump(manifest))
+
+        with tarfile.open(str(output_filepath), "w") as tar:
+            for filepath in sorted(basedir.iterdir()):
+                tar.add(str(filepath), arcname=filepath.name)
+
+    return manifest
+
+
+def verify_export(filepath):
+    """Verify the integrity of an exported archive.
+
+    Return the archive's manifest and the list of problems found (if any).
+    """
+    try:
+        tar = tarfile.open(str(filepath), "r")
+    except (tarfile.TarError, OSError) as exc:
+        raise CommandError("Cannot open {!r}: {}.".format(str(filepath), exc))
+
+    with tar:
+        members = {}
+        for member in tar.getmembers():
+            if member.name.startswith("/") or ".." in member.name.split("/"):
+                raise CommandError(
+                    "Invalid path in the archive: {!r}.".format(member.name)
+                )
+            if member.isfile():
+                members[member.name] = member
+
+        if EXPORT_MANIFEST not in members:
+            raise CommandError(
+                "The archive {!r} is not a charmcraft export ({!r} not found).".format(
+                    str(filepath), EXPORT_MANIFEST
+                )
+            )
+        try:
+            manifest = yaml.safe_load(tar.extractfile(members.pop(EXPORT_MANIFEST)))
+            files = manifest["files"]
+        except (yaml.error.YAMLError, KeyError, TypeError):
+            raise CommandError("Bad {!r} file in the archive.".format(EXPORT_MANIFEST))
+
+        problems = []
+        for item in files:
+            member = members.pop(item["path"], None)
+            if member is None:
+                problems.append("Missing file: {!r}.".format(item["path"]))
+                continue
+            if member.size != item["size"]:
+                problems.append(
+                    "Wrong size for file {!r}: expected {}, got {}.".format(
+                        item["path"], item["size"], member.size
+                    )
+                )
+                continue
+            hasher = hashlib.sha256()
+            fh = tar.extractfile(member)
+            while True:
+                data = fh.read(2 ** 20)
+                if not data:
+                    break
+                hasher.update(data)
+            if hasher.hexdigest() != item["sha256"]:
+                problems.append("Wrong hash for file {!r}.".format(item["path"]))
+
+        for name in sorted(members):
+            problems.append("File not listed in the manifest: {!r}.".format(name))
+
+    return manifest, problems
@@ -0,0 +1,150 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Enforce the project's release policy when releasing revisions."""
+
+import datetime
+import logging
+import sys
+
+from humanize import naturaldelta
+
+from charmcraft.cmdbase import CommandError
+
+from .export import normalize_channel
+
+logger = logging.getLogger("charmcraft.commands.store")
+
+# the channel risks, from the lowest to the highest
+RISKS = ("edge", "beta", "candidate", "stable")
+
+
+def split_channel(channel):
+    """Return the track, risk and branch of the channel (None if not present)."""
+    track, risk, branch = (normalize_channel(channel).split("/") + [None, None])[:3]
+    return track, risk, branch
+
+
+def get_violations(policy, channel_map, revision, channels, now=None):
+    """Return the reasons why the release is not allowed by the policy.
+
+    The channel map is the current releases of the package (only needed if the
+    policy requires the revision to be released in lower risks).
+    """
+    if now is None:
+        now = datetime.datetime.now(datetime.timezone.utc)
+    require_lower_risk = policy.require_lower_risk or policy.soak_hours is not None
+
+    violations = []
+    for channel in channels:
+        track, risk, branch = split_channel(channel)
+        if track in policy.restricted_tracks:
+            violations.append(
+                "The track {!r} is restricted (releasing to {!r}).".format(
+                    track, channel
+                )
+            )
+            continue
+
+        # branches are temporary and not followed by default, no need to go through
+        # the lower risks for them (and invalid risks are reported by the Store)
+        if not require_lower_risk or branch is not None or risk not in RISKS[1:]:
+            continue
+
+        lower_channel = "{}/{}".format(track, RISKS[RISKS.index(risk) - 1])
+        released = [
+            release
+            for release in channel_map
+            if release.channel == lower_channel and release.revision == revision
+        ]
+        if not released:
+            violations.append(
+                "Revision {} needs to be released to {!r} before going to {!r}.".format(
+                    revision, lower_channel, channel
+                )
+            )
+            continue
+
+        if policy.soak_hours is not None:
+            # there is one release per base, the soak starts with the first one
+            soak_time = now - min(release.released_at for release in released)
+            if soak_time < datetime.timedelta(hours=policy.soak_hours):
+                violations.append(
+                    "Revision {} has been in {!r} for {}, but it needs {} hours "
+                    "there before going to {!r}.".format(
+                        revision,
+                        lower_channel,
+                        naturaldelta(soak_time),
+                        policy.soak_hours,
+                        channel,
+                    )
+                )
+    return violations
+
+
+def needs_confirmation(policy, channels):
+    """Tell if the policy requires the user to confirm the release to the channels."""
+    if not policy.confirm_stable:
+        return False
+    for channel in channels:
+        _, risk, branch = split_channel(channel)
+        if risk == "stable" and branch is None:
+            return True
+    return False
+
+
+def enforce(policy, store, name, revision, channels, override=False):
+    """Verify that the release is allowed by the policy, or log the override.
+
+    The user is asked for confirmation when the policy requires it, which is not
+    possible if the terminal is not interactive.
+    """
+    channel_map = []
+    if policy.require_lower_risk or policy.soak_hours is not None:
+        channel_map, _, _ = store.list_releases(name)
+    violations = get_violations(policy, channel_map, revision, channels)
+
+    if override:
+        logger.warning(
+            "Overriding the release policy to release revision %d of %r to %s.",
+            revision,
+            name,
+            ", ".join(channels),
+        )
+        for violation in violations:
+            logger.warning("- %s", violation)
+        return
+
+    if violations:
+        raise CommandError(
+            "The release is not allowed by the release policy: {} Use "
+            "--override-policy to release anyway.".format(" ".join(violations))
+        )
+
+    if needs_confirmation(policy, channels):
+        if not sys.stdin.isatty():
+            raise CommandError(
+                "The release policy requires confirmation to release to a stable "
+                "channel, but the terminal is not interactive. Use "
+                "--override-policy to release anyway."
+            )
+        answer = input(
+            "Release revision {} of {!r} to {}? [y/N] ".format(
+                revision, name, ", ".join(channels)
+            )
+        )
+        if answer.strip().lower() not in ("y", "yes"):
+            raise CommandError("Release cancelled.")
@@ -16,6 +16,9 @@
 
 """Module to work with OCI registries."""
 
+import base64
+import hashlib
+import json
 import logging
 from urllib.request import parse_http_list, parse_keqv_list
 
@@ -29,6 +32,7 @@ logger = logging.getLogger(__name__)
 MANIFEST_LISTS = "application/vnd.docker.distribution.manifest.list.v2+json"
 MANIFEST_V2_MIMETYPE = "application/vnd.docker.distribution.manifest.v2+json"
 LAYER_MIMETYPE = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+OCI_INDEX_MIMETYPE = "application/vnd.oci.image.index.v1+json"
 JSON_RELATED_MIMETYPES = {
     "application/json",
     "application/vnd.docker.distribution.manifest.v1+prettyjws",  # signed manifest
@@ -194,6 +198,26 @@ class OCIRegistry:
             digest = response.headers["Docker-Content-Digest"]
         return (None, digest, response.text)
 
+    def get_blob(self, digest, filepath):
+        """Download the blob for the indicated digest into filepath, verifying its content."""
+        url = self._get_url("blobs/{}".format(digest))
+        logger.debug("Getting blob %s", digest)
+        response = self._hit("GET", url, stream=True)
+        assert_response_ok(response)
+
+        algorithm, expected_hash = digest.split(":", 1)
+        hasher = hashlib.new(algorithm)
+        with filepath.open("wb") as fh:
+            for chunk in response.iter_content(chunk_size=2 ** 20):
+                hasher.update(chunk)
+                fh.write(chunk)
+        if hasher.hexdigest() != expected_hash:
+            raise CommandError(
+                "Downloaded blob {!r} is corrupted (got hash {!r}).".format(
+                    digest, hasher.hexdigest()
+                )
+            )
+
 
 class PublicDockerhubRegistry(OCIRegistry):
     """Dockerhub registry without special credentials."""
@@ -221,3 +245,90 @@ class ImageHandler:
         _, digest, _ = self.dst_registry.get_manifest(reference)
         final_fqu = self.dst_registry.get_fully_qualified_url(digest)
         return final_fqu
+
+
+def parse_image_name(image_name):
+    """Split a fully qualified image name in server, organization, name, and reference.
+
+    The image name is something like "server.com/some/orga/name@sha256:123abc" (the
+    reference can also be a tag, like "server.com/orga/name:latest").
+    """
+    server, path = image_name.split("/", 1)
+    if "@" in path:
+        path, reference = path.split("@", 1)
+    elif ":" in path.rsplit("/", 1)[-1]:
+        path, reference = path.rsplit(":", 1)
+    else:
+        reference = "latest"
+    organization, name = path.rsplit("/", 1)
+    return server, organization, name, reference
+
+
+class ImageExporter:
+    """Save images from a registry in OCI layout directories."""
+
+    def __init__(self, image_name, username=None, password=None):
+        server, organization, name, self.reference = parse_image_name(image_name)
+        self.registry = OCIRegistry(server, organization, name)
+        if username is not None:
+            credentials = "{}:{}".format(username, password).encode("utf8")
+            encoded_credentials = base64.b64encode(credentials).decode("ascii")
+            self.registry.auth_encoded_credentials = encoded_credentials
+
+    def _save_blob(self, layout_dir, digest, content=None):
+        """Save a blob in the layout directory, downloading it if content not given."""
+        algorithm, hash_value = digest.split(":", 1)
+        blob_path = layout_dir / "blobs" / algorithm / hash_value
+        blob_path.parent.mkdir(parents=True, exist_ok=True)
+        if content is None:
+            self.registry.get_blob(digest, blob_path)
+        else:
+            blob_path.write_bytes(content)
+        return blob_path
+
+    def save(self, layout_dir, architecture):
+        """Save the image in the given directory using the OCI Image Layout format.
+
+        If the image is multi-platform only the manifest for the indicated architecture
+        is saved.
+        """
+        manifests, digest, raw_manifest = self.registry.get_manifest(self.reference)
+        if manifests is not None:
+            for item in manifests:
+                if item["platform"]["architecture"] == architecture:
+                    break
+            else:
+                raise CommandError(
+                    "The image {!r} is not available for architecture {!r}.".format(
+                        self.reference, architecture
+                    )
+                )
+            _, digest, raw_manifest = self.registry.get_manifest(item["digest"])
+
+        manifest = json.loads(raw_manifest)
+        layout_dir.mkdir(parents=True, exist_ok=True)
+        self._save_blob(layout_dir, manifest["config"]["digest"])
+        for layer in manifest["layers"]:
+            self._save_blob(layout_dir, layer["digest"])
+        manifest_bytes = raw_manifest.encode("utf8")
+        self._save_blob(layout_dir, digest, content=manifest_bytes)
+
+        index = {
+            "schemaVersion": 2,
+            "mediaType": OCI_INDEX_MIMETYPE,
+            "manifests": [
+                {
+                    "mediaType": manifest.get("mediaType", MANIFEST_V2_MIMETYPE),
+                    "digest": digest,
+                    "size": len(manifest_bytes),
+                    "annotations": {
+                        "org.opencontainers.image.ref.name": self.reference,
+                    },
+                },
+            ],
+        }
+        (layout_dir / "index.json").write_text(json.dumps(index))
+        (layout_dir / "oci-layout").write_text(
+            json.dumps({"imageLayoutVersion": "1.0.0"})
+        )
+        return digest
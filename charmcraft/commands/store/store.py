@@ -35,13 +35,19 @@ Uploaded = namedtuple("Uploaded", "ok status revision errors")
 # time, and now it's the moment to do it (also in Release below!)
 Revision = namedtuple("Revision", "revision version created_at status errors")
 Error = namedtuple("Error", "message code")
-Release = namedtuple("Release", "revision channel expires_at resources")
+Release = namedtuple(
+    "Release", "revision channel expires_at resources released_at platform"
+)
+Platform = namedtuple("Platform", "architecture os series")
 Channel = namedtuple("Channel", "name fallback track risk branch")
 Library = namedtuple(
     "Library", "api content content_hash lib_id lib_name charm_name patch"
 )
 Resource = namedtuple("Resource", "name optional revision resource_type")
 ResourceRevision = namedtuple("ResourceRevision", "revision created_at size")
+Download = namedtuple("Download", "url hash_sha256 size")
+ResourceDownload = namedtuple("ResourceDownload", "name resource_type revision download")
+ReleaseDownloads = namedtuple("ReleaseDownloads", "revision download resources")
 
 # those statuses after upload that flag that the review ended (and if it ended succesfully or not)
 UPLOAD_ENDING_STATUSES = {
@@ -92,6 +98,16 @@ def _build_library(resp):
     return lib
 
 
+def _build_download(item):
+    """Build a Download from a response item."""
+    download = Download(
+        url=item["url"],
+        hash_sha256=item["hash-sha-256"],
+        size=item["size"],
+    )
+    return download
+
+
 def _build_resource(item):
     """Build a Resource from a response item."""
     resource = Resource(
@@ -106,8 +122,14 @@ def _build_resource(item):
 class Store:
     """The main interface to the Store's API."""
 
-    def __init__(self, charmhub_config):
-        self._client = Client(charmhub_config.api_url, charmhub_config.storage_url)
+    def __init__(self, charmhub_config, offline=False):
+        self._client = Client(
+            charmhub_config.api_url, charmhub_config.storage_url, offline=offline
+        )
+
+    def get_cache_timestamp(self):
+        """Return when the oldest cached data used while working offline was stored."""
+        return self._client.cache_timestamp
 
     def login(self):
         """Login into the store.
@@ -173,7 +195,7 @@ class Store:
         logger.debug("Upload %s started, got status url %s", upload_id, status_url)
 
         while True:
-            response = self._client.get(status_url)
+            response = self._client.get(status_url, cache=False)
             logger.debug("Status checked: %s", response)
 
             # as we're asking for a single upload_id, the response will always have only one item
@@ -231,13 +253,21 @@ class Store:
             if expires_at is not None:
                 # `datetime.datetime.fromisoformat` is available only since Py3.7
                 expires_at = parser.parse(expires_at)
+            released_at = parser.parse(item["when"])
             resources = [_build_resource(r) for r in item["resources"]]
+            platform = Platform(
+                architecture=item["platform"]["architecture"],
+                os=item["platform"]["os"],
+                series=item["platform"]["series"],
+            )
             channel_map.append(
                 Release(
                     revision=item["revision"],
                     channel=item["channel"],
                     expires_at=expires_at,
                     resources=resources,
+                    released_at=released_at,
+                    platform=platform,
                 )
             )
 
@@ -256,6 +286,40 @@ class Store:
 
         return channel_map, channels, revisions
 
+    def get_release_downloads(self, name, channel, architecture):
+        """Get what needs to be downloaded for the release in a channel of a package.
+
+        The release is the one for the indicated architecture, as the same channel
+        may have different revisions for different architectures.
+        """
+        endpoint = (
+            "/v2/charms/info/{}?channel={}&architecture={}&fields="
+            "default-release.revision.revision,default-release.revision.download,"
+            "default-release.resources".format(name, channel, architecture)
+        )
+        response = self._client.get(endpoint)
+        release = response["default-release"]
+
+        resources = [
+            ResourceDownload(
+                name=item["name"],
+                resource_type=item["type"],
+                revision=item["revision"],
+                download=_build_download(item["download"]),
+            )
+            for item in release["resources"]
+        ]
+        result = ReleaseDownloads(
+            revision=release["revision"]["revision"],
+            download=_build_download(release["revision"]["download"]),
+            resources=resources,
+        )
+        return result
+
+    def download(self, url, filepath):
+        """Download the content from the indicated URL into filepath."""
+        self._client.download(url, filepath)
+
     def create_library_id(self, charm_name, lib_name):
         """Create a new library id."""
         endpoint = "/v1/charm/libraries/{}".format(charm_name)
@@ -309,7 +373,7 @@ class Store:
             if "api" in lib:
                 item["api"] = lib["api"]
             payload.append(item)
-        response = self._client.post(endpoint, payload)
+        response = self._client.post(endpoint, payload, cache=True)
         libraries = response["libraries"]
         result = {
             (item["library-id"], item["api"]): _build_library(item)
@@ -0,0 +1,482 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""An interactive grid to plan and do the releases of a package in its channels."""
+
+import curses
+import datetime
+from collections import namedtuple
+from operator import attrgetter
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.utils import ResourceOption
+
+from .policy import get_violations
+from .store import Release
+
+# a release planned in the grid, to be done when confirmed
+PlannedRelease = namedtuple("PlannedRelease", "revision channel resources")
+
+# the keys to move in the lists, and to act on the selected item
+KEYS_UP = (curses.KEY_UP, ord("k"))
+KEYS_DOWN = (curses.KEY_DOWN, ord("j"))
+KEYS_ENTER = (curses.KEY_ENTER, ord("\n"), ord("\r"), ord(" "))
+KEYS_CANCEL = (curses.KEY_DC, ord("x"))
+KEY_ESCAPE = 27
+
+# the focusable parts of the grid
+FOCUS_CHANNELS = "channels"
+FOCUS_REVISIONS = "revisions"
+
+_HELP_GRID = (
+    "[tab] switch list  [enter] select/release  [s] select channel's revisions  "
+    "[a] attach resource  [x] undo  [c] confirm  [q] quit"
+)
+_HELP_CONFIRM = "[y] do the releases  [n] go back"
+
+
+def _resources_repr(resources):
+    """Build a representation of a list of resources."""
+    if not resources:
+        return "-"
+    return ", ".join("{} (r{})".format(r.name, r.revision) for r in resources)
+
+
+def _revisions_repr(releases):
+    """Build a representation of the revisions of some (revision, resources)."""
+    revisions = ", ".join(str(revision) for revision, _ in releases)
+    if len(releases) == 1:
+        return "revision " + revisions
+    return "revisions " + revisions
+
+
+class ReleasePlan:
+    """The releases of a package in its channels, and those planned to be done.
+
+    The channels are indicated in the order they are shown. A channel may have
+    several releases (one for each base the package is released for), which are
+    handled together.
+    """
+
+    def __init__(self, name, channels, channel_map, revisions, resources):
+        self.name = name
+        self.channels = channels
+        self.channel_map = channel_map
+        self.revisions = sorted(revisions, key=attrgetter("revision"), reverse=True)
+        self.resources = resources
+
+        # the planned releases (several, for different bases) by channel, in the
+        # order they were planned
+        self.planned = {}
+
+    def get_releases(self, channel):
+        """Return the releases in the channel, including the planned ones.
+
+        They are (revision, resources), one for each base; also return if they are
+        planned (the releases are empty if nothing is released).
+        """
+        planned = self.planned.get(channel)
+        if planned is not None:
+            return tuple((item.revision, item.resources) for item in planned), True
+        releases = tuple(
+            (release.revision, tuple(release.resources))
+            for release in self.channel_map
+            if release.channel == channel
+        )
+        return releases, False
+
+    def get_version(self, revno):
+        """Return the version of the revision."""
+        for revision in self.revisions:
+            if revision.revision == revno:
+                return revision.version
+        return "-"
+
+    def release(self, revision, channel, resources=()):
+        """Plan to release the revision in the channel, with the resources."""
+        self.release_many(channel, [(revision, resources)])
+
+    def release_many(self, channel, releases):
+        """Plan to release several revisions in the channel (e.g. one for each base).
+
+        They are indicated as (revision, resources), and replace what is in the channel.
+        """
+        known = {item.revision for item in self.revisions}
+        for revision, _ in releases:
+            if revision not in known:
+                raise CommandError("Unknown revision {}.".format(revision))
+        self.planned.pop(channel, None)
+        self.planned[channel] = tuple(
+            PlannedRelease(revision, channel, tuple(resources))
+            for revision, resources in releases
+        )
+
+    def attach(self, channel, resource):
+        """Plan to release what is in the channel again, attaching the resource."""
+        releases, _ = self.get_releases(channel)
+        if not releases:
+            raise CommandError(
+                "Nothing is released in {!r} to attach the resource to.".format(channel)
+            )
+        known = [item.name for item in self.resources]
+        if resource.name not in known:
+            raise CommandError(
+                "Unknown resource {!r} (the package has: {}).".format(
+                    resource.name, ", ".join(known) or "none"
+                )
+            )
+        attached = []
+        for revision, resources in releases:
+            resources = [item for item in resources if item.name != resource.name]
+            attached.append((revision, resources + [resource]))
+        self.release_many(channel, attached)
+
+    def cancel(self, channel):
+        """Forget the release planned in the channel (if any)."""
+        self.planned.pop(channel, None)
+
+    def get_batches(self):
+        """Return the planned releases as (revision, channels, resources).
+
+        The releases of the same revision with the same resources are done together.
+        """
+        batches = {}
+        planned = [item for items in self.planned.values() for item in items]
+        for item in planned:
+            key = (item.revision, tuple((r.name, r.revision) for r in item.resources))
+            if key not in batches:
+                batches[key] = (item.revision, [], item.resources)
+            batches[key][1].append(item.channel)
+        return list(batches.values())
+
+    def get_violations(self, policy, now=None):
+        """Return the reasons why the planned releases are not allowed by the policy.
+
+        Each release is verified as if the previous ones were already done.
+        """
+        if now is None:
+            now = datetime.datetime.now(datetime.timezone.utc)
+        channel_map = list(self.channel_map)
+        violations = []
+        for channel, items in self.planned.items():
+            for item in items:
+                violations.extend(
+                    get_violations(policy, channel_map, item.revision, [channel], now)
+                )
+            channel_map = [
+                release for release in channel_map if release.channel != channel
+            ]
+            channel_map.extend(
+                Release(item.revision, channel, None, item.resources, now, None)
+                for item in items
+            )
+        return violations
+
+    def apply(self, store):
+        """Do the planned releases, yielding each as (revision, channels, resources).
+
+        Each release is yielded once done, so if one fails those yielded before are
+        the ones that were already done.
+        """
+        for revision, channels, resources in self.get_batches():
+            store.release(self.name, revision, channels, resources)
+            yield revision, channels, resources
+        self.planned.clear()
+
+
+def _addline(screen, y, text, attr=0):
+    """Write a line in the screen, clipped to its size."""
+    height, width = screen.getmaxyx()
+    if 0 <= y < height:
+        screen.addnstr(y, 0, text, width - 1, attr)
+
+
+def _format_row(row, widths):
+    """Return the row with its values aligned in columns."""
+    return "  ".join(value.ljust(width) for value, width in zip(row, widths)).rstrip()
+
+
+def _get_window(cursor, length, size):
+    """Return the first item to show in a list so the cursor is visible."""
+    if length <= size:
+        return 0
+    return min(max(0, cursor - size // 2), length - size)
+
+
+class ReleasesGrid:
+    """The interactive grid showing the channel map and the planned releases."""
+
+    def __init__(self, plan, policy, override=False):
+        self.plan = plan
+        self.policy = policy
+        self.override = override
+
+        self.focus = FOCUS_CHANNELS
+        self.channel_cursor = 0
+        self.revision_cursor = 0
+        self.confirming = False
+        self.message = ""
+
+        # the revisions (with their resources) to release in the channels
+        self.selected = None
+
+        # set when the user leaves the grid, and if the planned releases are confirmed
+        self.finished = False
+        self.confirmed = False
+
+    @property
+    def current_channel(self):
+        """Return the name of the channel under the cursor."""
+        return self.plan.channels[self.channel_cursor].name
+
+    def _move(self, delta):
+        """Move the cursor of the focused list."""
+        if self.focus == FOCUS_CHANNELS:
+            limit = len(self.plan.channels) - 1
+            self.channel_cursor = min(max(0, self.channel_cursor + delta), limit)
+        else:
+            limit = len(self.plan.revisions) - 1
+            self.revision_cursor = min(max(0, self.revision_cursor + delta), limit)
+
+    def _select(self, releases):
+        """Select the revisions (with their resources) to release."""
+        self.selected = tuple((revision, tuple(res)) for revision, res in releases)
+        self.focus = FOCUS_CHANNELS
+        self.message = "Selected {}: press enter on the channels to release.".format(
+            _revisions_repr(self.selected)
+        )
+
+    def _release(self):
+        """Plan the release of the selected revisions in the current channel."""
+        if self.selected is None:
+            self.message = "Select a revision first (from the revisions or a channel)."
+            return
+        self.plan.release_many(self.current_channel, self.selected)
+        self.message = "Planned to release {} in {!r}.".format(
+            _revisions_repr(self.selected), self.current_channel
+        )
+
+    def _start_confirmation(self):
+        """Show the planned releases to confirm them."""
+        if not self.plan.planned:
+            self.message = "Nothing planned to release."
+            return
+        self.confirming = True
+        self.message = ""
+
+    def _confirm(self):
+        """Finish with the planned releases, if the release policy allows them."""
+        if self.plan.get_violations(self.policy) and not self.override:
+            self.message = (
+                "The release policy does not allow these releases; "
+                "use --override-policy to release anyway."
+            )
+            return
+        self.confirmed = True
+        self.finished = True
+
+    def attach_resource(self, value):
+        """Plan to attach a resource (as "<name>:<revision>") in the current channel."""
+        try:
+            resource = ResourceOption()(value)
+            self.plan.attach(self.current_channel, resource)
+        except (ValueError, CommandError) as exc:
+            self.message = "Cannot attach the resource: {}".format(exc)
+            return
+        self.message = "Planned to attach {} (r{}) in {!r}.".format(
+            resource.name, resource.revision, self.current_channel
+        )
+
+    def handle_key(self, key):
+        """Act according to the key pressed by the user."""
+        if self.confirming:
+            if key == ord("y"):
+                self._confirm()
+            elif key in (ord("n"), ord("q"), KEY_ESCAPE):
+                self.confirming = False
+                self.message = ""
+            return
+
+        self.message = ""
+        if key in KEYS_UP:
+            self._move(-1)
+        elif key in KEYS_DOWN:
+            self._move(1)
+        elif key == ord("\t"):
+            if self.focus == FOCUS_CHANNELS and self.plan.revisions:
+                self.focus = FOCUS_REVISIONS
+            else:
+                self.focus = FOCUS_CHANNELS
+        elif key in KEYS_ENTER:
+            if self.focus == FOCUS_REVISIONS:
+                revision = self.plan.revisions[self.revision_cursor].revision
+                self._select([(revision, ())])
+            else:
+                self._release()
+        elif key == ord("s") and self.focus == FOCUS_CHANNELS:
+            releases, _ = self.plan.get_releases(self.current_channel)
+            if not releases:
+                self.message = "Nothing is released in {!r}.".format(
+                    self.current_channel
+                )
+            else:
+                self._select(releases)
+        elif key in KEYS_CANCEL and self.focus == FOCUS_CHANNELS:
+            self.plan.cancel(self.current_channel)
+        elif key == ord("c"):
+            self._start_confirmation()
+        elif key in (ord("q"), KEY_ESCAPE):
+            self.finished = True
+
+    def _draw_grid(self, screen, top, bottom):
+        """Draw the channels and the revisions lists."""
+        plan = self.plan
+        channel_rows = []
+        for channel in plan.channels:
+            releases, planned = plan.get_releases(channel.name)
+            revisions = [revision for revision, _ in releases]
+            # the resources are shown once if all the releases have the same ones
+            resources = dict.fromkeys(_resources_repr(res) for _, res in releases)
+            channel_rows.append(
+                (
+                    "*" if planned else " ",
+                    channel.name,
+                    ", ".join(str(revision) for revision in revisions) or "-",
+                    ", ".join(plan.get_version(revision) for revision in revisions)
+                    or "-",
+                    "; ".join(resources) or "-",
+                )
+            )
+        revision_rows = [
+            (
+                str(item.revision),
+                item.version,
+                item.created_at.strftime("%Y-%m-%d %H:%M"),
+                item.status,
+            )
+            for item in plan.revisions
+        ]
+
+        # the channels get the upper half of the screen, the revisions the rest
+        available = bottom - top - 4
+        channels_size = max(1, min(len(channel_rows), available // 2))
+        revisions_size = max(1, available - channels_size)
+        sections = [
+            (
+                "Channels",
+                ("", "Channel", "Revision", "Version", "Resources"),
+                channel_rows,
+                self.channel_cursor,
+                channels_size,
+                FOCUS_CHANNELS,
+            ),
+            (
+                "Revisions",
+                ("Revision", "Version", "Created at", "Status"),
+                revision_rows,
+                self.revision_cursor,
+                revisions_size,
+                FOCUS_REVISIONS,
+            ),
+        ]
+        y = top
+        for title, headers, rows, cursor, size, focus in sections:
+            widths = [
+                max(len(row[idx]) for row in [headers] + rows)
+                for idx in range(len(headers))
+            ]
+            _addline(screen, y, title, curses.A_BOLD)
+            line = "  " + _format_row(headers, widths)
+            _addline(screen, y + 1, line, curses.A_UNDERLINE)
+            y += 2
+            start = _get_window(cursor, len(rows), size)
+            for idx, row in enumerate(rows[start : start + size], start):
+                if idx == cursor and self.focus == focus:
+                    line = "> " + _format_row(row, widths)
+                    _addline(screen, y, line, curses.A_REVERSE)
+                else:
+                    _addline(screen, y, "  " + _format_row(row, widths))
+                y += 1
+            y += 1
+
+    def _draw_confirmation(self, screen, top):
+        """Draw the planned releases, and the policy violations."""
+        y = top
+        _addline(screen, y, "Planned releases", curses.A_BOLD)
+        for revision, channels, resources in self.plan.get_batches():
+            y += 1
+            line = "- revision {} to {}".format(revision, ", ".join(channels))
+            if resources:
+                line += " (attaching resources: {})".format(_resources_repr(resources))
+            _addline(screen, y, line)
+
+        violations = self.plan.get_violations(self.policy)
+        if violations:
+            y += 2
+            if self.override:
+                title = "Release policy violations (overridden)"
+            else:
+                title = "Release policy violations"
+            _addline(screen, y, title, curses.A_BOLD)
+            for violation in violations:
+                y += 1
+                _addline(screen, y, "- " + violation)
+
+    def draw(self, screen):
+        """Draw the whole grid."""
+        height, _ = screen.getmaxyx()
+        screen.erase()
+        title = "Releases of {!r}".format(self.plan.name)
+        if self.selected is not None:
+            title += " (selected {})".format(_revisions_repr(self.selected))
+        _addline(screen, 0, title, curses.A_BOLD)
+        if self.confirming:
+            self._draw_confirmation(screen, 2)
+            help_text = _HELP_CONFIRM
+        else:
+            self._draw_grid(screen, 2, height - 2)
+            help_text = _HELP_GRID
+        _addline(screen, height - 2, self.message)
+        _addline(screen, height - 1, help_text, curses.A_DIM)
+        screen.refresh()
+
+    def _ask(self, screen, prompt):
+        """Ask the user for a value in the status line."""
+        height, width = screen.getmaxyx()
+        _addline(screen, height - 2, prompt)
+        screen.clrtoeol()
+        curses.echo()
+        curses.curs_set(1)
+        try:
+            value = screen.getstr(height - 2, len(prompt), width - len(prompt) - 1)
+        finally:
+            curses.noecho()
+            curses.curs_set(0)
+        return value.decode("utf8").strip()
+
+    def run(self, screen):
+        """Show the grid until the user leaves it; return if the plan was confirmed."""
+        curses.curs_set(0)
+        while not self.finished:
+            self.draw(screen)
+            key = screen.getch()
+            if key == ord("a") and not self.confirming:
+                value = self._ask(screen, "Resource to attach (<name>:<revision>): ")
+                if value:
+                    self.attach_resource(value)
+            else:
+                self.handle_key(key)
+        return self.confirmed
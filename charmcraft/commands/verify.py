@@ -0,0 +1,74 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Infrastructure for the 'verify-artifact' command."""
+
+import logging
+
+from charmcraft.cmdbase import BaseCommand, CommandError
+from charmcraft.utils import useful_filepath
+from charmcraft.verify import verify_artifact
+
+logger = logging.getLogger(__name__)
+
+_overview = """
+Verify that a charm or bundle is deployable.
+
+The indicated file is a `.charm` or bundle's `.zip`, for example one
+built elsewhere or downloaded. These checks are done (the same ones
+`charmcraft build` and `charmcraft pack` do on what they produce):
+
+- the archive is not corrupted, and all its paths are relative and
+  inside it (no absolute paths or `..` components)
+
+- the symlinks in the archive point to files inside it
+
+- the `manifest.yaml` file (and `metadata.yaml` for charms, or
+  `bundle.yaml` for bundles) is present and is valid YAML
+
+- for charms, the `dispatch` script is executable, and the entrypoint
+  called by it is present (hooks and actions that are not executable
+  are just warned about)
+"""
+
+
+class VerifyArtifactCommand(BaseCommand):
+    """Verify that a charm or bundle is deployable."""
+
+    name = "verify-artifact"
+    help_msg = "Verify that a charm or bundle is deployable"
+    overview = _overview
+    needs_config = False
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument(
+            "filepath", type=useful_filepath, help="The charm or bundle to verify"
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        filepath = parsed_args.filepath
+        kind, problems = verify_artifact(filepath)
+        if problems:
+            for problem in problems:
+                logger.info("- %s", problem)
+            raise CommandError(
+                "The {} {!r} is not deployable ({} problem(s) found).".format(
+                    kind, str(filepath), len(problems)
+                )
+            )
+        logger.info("The %s %r is deployable.", kind, str(filepath))
@@ -0,0 +1,363 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Infrastructure for the 'workspace' command."""
+
+import argparse
+import logging
+import os
+import pathlib
+import subprocess
+import sys
+import time
+from concurrent.futures import FIRST_COMPLETED, ThreadPoolExecutor, wait
+
+from tabulate import tabulate
+
+from charmcraft.cmdbase import BaseCommand, CommandError
+from charmcraft.utils import load_yaml
+
+logger = logging.getLogger(__name__)
+
+# the file at the root of the repository listing all the projects
+WORKSPACE_FILENAME = "charmcraft-workspace.yaml"
+
+# the commands that can be run in the workspace, and the project types they apply to
+WORKSPACE_COMMANDS = {
+    "analyze": {"charm", "bundle"},
+    "pack": {"charm", "bundle"},
+    "fetch-lib": {"charm"},
+    "publish-lib": {"charm"},
+    "upload": {"charm", "bundle"},
+}
+
+# the results for each project
+RESULT_OK = "ok"
+RESULT_FAILED = "failed"
+RESULT_SKIPPED = "skipped"
+
+
+class Project:
+    """A charm or bundle project in the workspace."""
+
+    def __init__(self, dirpath, relpath):
+        self.dirpath = dirpath
+        self.relpath = relpath
+
+        config = load_yaml(dirpath / "charmcraft.yaml") or {}
+        self.type = config.get("type") or "charm"
+        if self.type == "bundle":
+            bundle = load_yaml(dirpath / "bundle.yaml") or {}
+            self.name = bundle.get("name")
+            self.charms = self._get_bundle_charms(bundle)
+        else:
+            # the metadata may be declared in charmcraft.yaml itself
+            metadata = config.get("metadata")
+            if metadata is None:
+                metadata = load_yaml(dirpath / "metadata.yaml") or {}
+            self.name = metadata.get("name")
+            self.charms = []
+        if not self.name:
+            raise CommandError(
+                "Cannot find the name of the {} in the workspace project {!r}.".format(
+                    self.type, relpath
+                )
+            )
+
+        # filled when resolving the dependencies in the workspace
+        self.depends_on = []
+
+    def _get_bundle_charms(self, bundle):
+        """Return the charms used by the bundle, as names or local paths."""
+        applications = bundle.get("applications") or bundle.get("services") or {}
+        charms = []
+        for app in applications.values():
+            charm = (app or {}).get("charm")
+            if not charm:
+                continue
+            if charm.startswith((".", "/")):
+                charms.append((self.dirpath / charm).resolve())
+            else:
+                # remove the optional schema ('cs:', 'ch:', etc) from the name
+                charms.append(charm.split(":", 1)[-1])
+        return charms
+
+    @property
+    def artifact(self):
+        """Return the path of the artifact produced when packing the project."""
+        extension = ".zip" if self.type == "bundle" else ".charm"
+        return self.dirpath / (self.name + extension)
+
+    def __repr__(self):
+        return "<Project {} ({}) at {!r}>".format(self.name, self.type, self.relpath)
+
+
+def find_workspace_file(dirpath):
+    """Find the workspace file in the indicated directory or any of its parents."""
+    dirpath = pathlib.Path(dirpath).resolve()
+    for candidate in [dirpath] + list(dirpath.parents):
+        filepath = candidate / WORKSPACE_FILENAME
+        if filepath.is_file():
+            return filepath
+    raise CommandError(
+        "Cannot find the workspace file {!r} in {!r} or any of its parents.".format(
+            WORKSPACE_FILENAME, str(dirpath)
+        )
+    )
+
+
+def load_workspace(filepath):
+    """Load the projects listed in the workspace file, resolving their dependencies."""
+    content = load_yaml(filepath)
+    if not isinstance(content, dict) or not isinstance(content.get("projects"), list):
+        raise CommandError(
+            "Bad workspace file {!r}: it must include a 'projects' list.".format(
+                str(filepath)
+            )
+        )
+
+    basedir = filepath.parent
+    projects = []
+    for relpath in content["projects"]:
+        if not isinstance(relpath, str) or not relpath or relpath.startswith("/"):
+            raise CommandError(
+                "Bad workspace file {!r}: {!r} must be a relative path.".format(
+                    str(filepath), relpath
+                )
+            )
+        dirpath = (basedir / relpath).resolve()
+        if not dirpath.is_dir():
+            raise CommandError(
+                "Bad workspace file {!r}: {!r} is not a directory.".format(
+                    str(filepath), relpath
+                )
+            )
+        projects.append(Project(dirpath, relpath))
+
+    # the bundles depend on the charms in the workspace that they contain
+    for project in projects:
+        for charm in project.charms:
+            for other in projects:
+                if other.type == "charm" and charm in (other.name, other.dirpath):
+                    project.depends_on.append(other)
+    return projects
+
+
+def select_projects(projects, selection):
+    """Return the projects indicated by directory or name (all if nothing indicated)."""
+    if not selection:
+        return list(projects)
+
+    selected = []
+    for item in selection:
+        for project in projects:
+            if item.rstrip("/") in (project.name, project.relpath.rstrip("/")):
+                if project not in selected:
+                    selected.append(project)
+                break
+        else:
+            raise CommandError("Project {!r} not found in the workspace.".format(item))
+    return selected
+
+
+def run_charmcraft(project, command, extra_args):
+    """Run charmcraft in the project directory; return the return code and output."""
+    cmd = [sys.executable, "-m", "charmcraft", command]
+    if command in ("analyze", "upload"):
+        cmd.append(str(project.artifact))
+    cmd.extend(extra_args)
+    logger.debug("Running %s in %r", cmd, str(project.dirpath))
+    proc = subprocess.run(
+        cmd,
+        cwd=str(project.dirpath),
+        stdout=subprocess.PIPE,
+        stderr=subprocess.STDOUT,
+        universal_newlines=True,
+    )
+    return proc.returncode, proc.stdout
+
+
+def run_projects(projects, runner, jobs):
+    """Run in parallel in all the projects, respecting their dependencies.
+
+    The runner is called with each project and must return the return code and
+    output of what was run. Projects whose dependencies did not finish ok are skipped.
+
+    Return the result and duration for each project.
+    """
+    results = {}
+    pending = list(projects)
+    running = {}
+
+    with ThreadPoolExecutor(max_workers=jobs) as executor:
+        while pending or running:
+            for project in list(pending):
+                deps = [dep for dep in project.depends_on if dep in projects]
+                if any(results.get(dep, (RESULT_OK,))[0] != RESULT_OK for dep in deps):
+                    logger.info(
+                        "Skipping %s: some of its dependencies did not finish ok.",
+                        project.name,
+                    )
+                    results[project] = (RESULT_SKIPPED, 0)
+                    pending.remove(project)
+                elif all(dep in results for dep in deps):
+                    logger.debug("Starting %s", project.name)
+                    future = executor.submit(_timed_run, runner, project)
+                    running[future] = project
+                    pending.remove(project)
+
+            if not running:
+                # everything pending was skipped
+                continue
+            done, _ = wait(running, return_when=FIRST_COMPLETED)
+            for future in done:
+                project = running.pop(future)
+                retcode, output, duration = future.result()
+                if retcode:
+                    results[project] = (RESULT_FAILED, duration)
+                    logger.info("%s failed:", project.name)
+                    for line in output.splitlines():
+                        logger.info("    %s", line)
+                else:
+                    results[project] = (RESULT_OK, duration)
+                    logger.info("%s finished ok.", project.name)
+                    for line in output.splitlines():
+                        logger.debug("    %s", line)
+    return results
+
+
+def _timed_run(runner, project):
+    """Call the runner for the project, also returning how long it took."""
+    start = time.monotonic()
+    try:
+        retcode, output = runner(project)
+    except Exception as exc:
+        retcode, output = 1, "Crashed: {!r}".format(exc)
+    return retcode, output, time.monotonic() - start
+
+
+_overview = """
+Run a command in several charm and bundle projects of a workspace.
+
+The workspace is defined by a `{filename}` file at the root of the
+repository (it is searched from the project directory upwards), which
+lists the directories of all the projects, for example:
+
+    projects:
+      - charms/database
+      - charms/webapp
+      - bundles/production
+
+The command is run in all the projects it applies to, or only in those
+selected with `--project` (by directory or name). Projects are processed
+in parallel, but bundles are always processed after the charms in the
+workspace they contain. A summary with the results is shown at the end.
+
+Supported commands are: {commands}. The options for the workspace
+must be given before the command, as everything after it is passed
+to the command itself, e.g.:
+
+    charmcraft workspace --project webapp upload --release edge
+""".format(
+    filename=WORKSPACE_FILENAME, commands=", ".join(sorted(WORKSPACE_COMMANDS))
+)
+
+
+class WorkspaceCommand(BaseCommand):
+    """Run a command in several projects of a workspace."""
+
+    name = "workspace"
+    help_msg = "Run a command in several projects of a workspace"
+    overview = _overview
+
+    def fill_parser(self, parser):
+        """Add own parameters to the general parser."""
+        parser.add_argument(
+            "command",
+            choices=sorted(WORKSPACE_COMMANDS),
+            help="The command to run in the projects",
+        )
+        parser.add_argument(
+            "--project",
+            action="append",
+            dest="projects",
+            help="The project to run the command in, by directory or name (can be "
+            "used multiple times); defaults to all the projects in the workspace",
+        )
+        parser.add_argument(
+            "-j",
+            "--jobs",
+            type=int,
+            default=os.cpu_count() or 1,
+            help="How many projects to process in parallel; defaults to the number "
+            "of processors",
+        )
+        parser.add_argument(
+            "extra_args",
+            nargs=argparse.REMAINDER,
+            help="Extra arguments for the command",
+        )
+
+    def run(self, parsed_args):
+        """Run the command."""
+        command = parsed_args.command
+        if parsed_args.jobs < 1:
+            raise CommandError("The number of jobs must be at least 1.")
+        extra_args = list(parsed_args.extra_args)
+        if extra_args and extra_args[0] == "--":
+            extra_args = extra_args[1:]
+
+        workspace_filepath = find_workspace_file(self.config.project.dirpath)
+        logger.debug("Using workspace file %r", str(workspace_filepath))
+        all_projects = load_workspace(workspace_filepath)
+        selected = select_projects(all_projects, parsed_args.projects)
+        projects = [p for p in selected if p.type in WORKSPACE_COMMANDS[command]]
+        if not projects:
+            logger.info("No projects in the workspace to run %r in.", command)
+            return
+
+        def runner(project):
+            if command in ("analyze", "upload") and not project.artifact.exists():
+                return 1, "Cannot find {!r}, pack the project first.".format(
+                    project.artifact.name
+                )
+            return run_charmcraft(project, command, extra_args)
+
+        logger.info(
+            "Running %r in %d project(s) (%d in parallel).",
+            command,
+            len(projects),
+            parsed_args.jobs,
+        )
+        results = run_projects(projects, runner, parsed_args.jobs)
+
+        headers = ["Project", "Type", "Result", "Time"]
+        data = []
+        for project in projects:
+            result, duration = results[project]
+            elapsed = "{:.1f}s".format(duration) if result != RESULT_SKIPPED else "-"
+            data.append((project.relpath, project.type, result, elapsed))
+        table = tabulate(data, headers=headers, tablefmt="plain", numalign="left")
+        for line in table.splitlines():
+            logger.info(line)
+
+        failed = [p for p in projects if results[p][0] != RESULT_OK]
+        if failed:
+            raise CommandError(
+                "Command {!r} did not finish ok in {} of {} project(s).".format(
+                    command, len(failed), len(projects)
+                )
+            )
@@ -0,0 +1,196 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Write zip files compressing their entries in several threads."""
+
+import collections
+import os
+import pathlib
+import platform
+import sys
+import zipfile
+import zlib
+from concurrent.futures import ThreadPoolExecutor
+
+# zlib's default compression level (a good balance between speed and size)
+DEFAULT_LEVEL = 6
+
+# how many entries can be waiting to be written per worker, to bound the memory
+# used by the compressed entries when the disk is slower than the workers
+PENDING_PER_WORKER = 4
+
+# files bigger than this are not loaded in memory to compress them in a worker, but
+# streamed into the zip by ZipFile itself
+STREAM_THRESHOLD = 2 ** 20
+
+# the already compressed entries are written using ZipFile's internals, so that is
+# only done in the CPython versions the tests were run with (add others here after
+# running the tests with them); in others (or with only one worker, where there is
+# nothing to parallelize) ZipFile does all the work
+TESTED_VERSIONS = [(3, 8), (3, 9), (3, 11)]
+PARALLEL_SUPPORTED = (
+    platform.python_implementation() == "CPython"
+    and sys.version_info[:2] in TESTED_VERSIONS
+)
+
+# ZipFile accepts the compression level only since Python 3.7; before, it always
+# uses zlib's default (which is also DEFAULT_LEVEL)
+COMPRESSLEVEL_SUPPORTED = sys.version_info >= (3, 7)
+
+
+def _compress(get_data, level):
+    """Get the data and compress it; return the CRC, the original size and the result.
+
+    It's run in the workers; zlib releases the GIL when compressing, so the entries
+    are really compressed in parallel.
+    """
+    data = get_data()
+    crc = zlib.crc32(data)
+    if level == 0:
+        return crc, len(data), data
+    compressor = zlib.compressobj(level, zlib.DEFLATED, -15)
+    return crc, len(data), compressor.compress(data) + compressor.flush()
+
+
+class ParallelZipFile:
+    """A zip file being written, compressing its entries in parallel.
+
+    The entries are written in the same order they were added, so the result does
+    not depend on how many workers are used. A compression level of 0 means the
+    entries are stored without compressing them.
+
+    With only one worker, or where ZipFile's internals are not supported, all the
+    entries are compressed and written by ZipFile itself, one after the other.
+
+    Using several workers only helps if there are processors available for them,
+    and it was not measured in machines with more than one; the numbers for any
+    machine can be obtained with tools/benchmark-compression.py.
+    """
+
+    def __init__(self, zippath, level=DEFAULT_LEVEL, workers=None):
+        if workers is None:
+            workers = os.cpu_count() or 1
+        self._level = level
+        self._level_kwargs = {}
+        if COMPRESSLEVEL_SUPPORTED:
+            self._level_kwargs["compresslevel"] = level
+        self._zipfile = zipfile.ZipFile(str(zippath), "w")
+        self._executor = None
+        if workers > 1 and PARALLEL_SUPPORTED:
+            self._executor = ThreadPoolExecutor(max_workers=workers)
+        self._pending = collections.deque()
+        self._max_pending = workers * PENDING_PER_WORKER
+
+    def write(self, filepath, arcname):
+        """Add the file from disk with the indicated name (following symlinks)."""
+        zinfo = zipfile.ZipInfo.from_file(str(filepath), str(arcname))
+        self._set_compression(zinfo)
+        if self._executor is None or zinfo.file_size > STREAM_THRESHOLD:
+            self._flush()
+            self._zipfile.write(
+                str(filepath),
+                str(arcname),
+                compress_type=zinfo.compress_type,
+                **self._level_kwargs,
+            )
+        elif zinfo.is_dir():
+            self._submit(zinfo, bytes)
+        else:
+            self._submit(zinfo, pathlib.Path(filepath).read_bytes)
+
+    def writestr(self, zinfo, data):
+        """Add the entry described by the ZipInfo with the indicated content."""
+        self._set_compression(zinfo)
+        if self._executor is None:
+            self._zipfile.writestr(
+                zinfo,
+                data,
+                compress_type=zinfo.compress_type,
+                **self._level_kwargs,
+            )
+        else:
+            self._submit(zinfo, lambda: data)
+
+    def _set_compression(self, zinfo):
+        """Decide if the entry is compressed or just stored."""
+        if self._level == 0 or zinfo.is_dir():
+            zinfo.compress_type = zipfile.ZIP_STORED
+        else:
+            zinfo.compress_type = zipfile.ZIP_DEFLATED
+
+    def _submit(self, zinfo, get_data):
+        """Compress the entry in a worker, writing the finished ones if too many."""
+        level = 0 if zinfo.compress_type == zipfile.ZIP_STORED else self._level
+        future = self._executor.submit(_compress, get_data, level)
+        self._pending.append((zinfo, future))
+        while len(self._pending) > self._max_pending:
+            self._write_next()
+
+    def _write_next(self):
+        """Write the oldest pending entry, waiting for its compression to finish."""
+        zinfo, future = self._pending.popleft()
+        crc, size, data = future.result()
+        zinfo.CRC = crc
+        zinfo.file_size = size
+        zinfo.compress_size = len(data)
+        zinfo.flag_bits = 0
+        if not zinfo.external_attr:
+            zinfo.external_attr = 0o600 << 16  # as ZipFile does: ?rw-------
+        zip64 = max(size, len(data)) > zipfile.ZIP64_LIMIT
+
+        # ZipFile does not support writing entries already compressed, so the local
+        # header and the data are written here as it does internally (the central
+        # directory is written by ZipFile itself when closing)
+        zf = self._zipfile
+        zf.fp.seek(zf.start_dir)
+        zinfo.header_offset = zf.fp.tell()
+        zf._writecheck(zinfo)
+        zf._didModify = True
+        zf.fp.write(zinfo.FileHeader(zip64))
+        zf.fp.write(data)
+        zf.start_dir = zf.fp.tell()
+        zf.filelist.append(zinfo)
+        zf.NameToInfo[zinfo.filename] = zinfo
+
+    def _flush(self):
+        """Write all the pending entries, to keep the order when ZipFile writes one."""
+        while self._pending:
+            self._write_next()
+
+    def close(self):
+        """Write all the pending entries and finish the zip file."""
+        try:
+            self._flush()
+        finally:
+            self._abort()
+
+    def _abort(self):
+        """Stop the workers and close the zip file, discarding the pending entries."""
+        for _, future in self._pending:
+            future.cancel()
+        self._pending.clear()
+        if self._executor is not None:
+            self._executor.shutdown(wait=True)
+        self._zipfile.close()
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, exc_type, exc_value, traceback):
+        if exc_type is None:
+            self.close()
+        else:
+            self._abort()
@@ -30,17 +30,133 @@ charmhub:
 parts:
   bundle:
     prime: [list of strings]
+  charm:
+    version: [string] optional, template for the charm's version file (using
+             fields from the git repository: describe, commit, short_commit,
+             branch and dirty); defaults to what `git describe` produces
+    legacy-hooks: [boolean] optional, create the hooks for all the events
+                  declared in metadata.yaml and the actions declared in
+                  actions.yaml (pointing to the dispatch script), for Juju
+                  versions without support for dispatch; defaults to false
+    base: optional, the system where the charm will run, to install the
+          dependencies for its Python version; defaults to the current system
+      name: [string] the name of the system, only "ubuntu" is supported
+      channel: [string] the version of the system, like "20.04"
+
+interfaces:
+  catalog: [string] optional, the directory of a local catalog of relation
+           interfaces to check the charm's relations against (absolute or
+           relative to the project's directory)
+
+audit:
+  database: [string] optional, the directory of a local snapshot of a
+            vulnerabilities database in the OSV format (absolute or relative
+            to the project's directory)
+  severity-threshold: [string] optional, one of "unknown", "low", "medium",
+                      "high" or "critical"; packing fails if a vulnerability
+                      of that severity or higher is found in the dependencies
+                      (or of unknown severity, as it may be any)
+  ignore: [list of strings] optional, ids (or aliases) of the vulnerabilities
+          to ignore
+
+release-policy:
+  require-lower-risk: [boolean] optional, a revision can be released to a
+                      channel only if it is already released in the next
+                      lower risk of the same track; defaults to false
+  soak-hours: [integer] optional, the minimum time (in hours) the revision
+              needs to have been released in the next lower risk (implies
+              require-lower-risk)
+  confirm-stable: [boolean] optional, ask for confirmation before releasing
+                  to a stable channel; defaults to false
+  restricted-tracks: [list of strings] optional, tracks where nothing can be
+                     released
+  (the release policy can also be set for all the projects in the user's
+  config.yaml, in charmcraft's directory of the user configuration, like
+  ~/.config/charmcraft/config.yaml; the one in charmcraft.yaml takes precedence)
+
+git-tag:
+  enabled: [boolean] optional, tag the released commit when releasing with the
+           `release` command (as its `--git-tag` option); defaults to false
+  template: [string] optional, template for the name of the annotated tag (using
+            the fields: name, revision, channel, track, risk and version);
+            defaults to "{name}/{channel}/r{revision}"
+  push: [boolean] optional, push the tags after creating them; defaults to false
+  remote: [string] optional, the remote to push the tags to; defaults to
+          "origin"
+
+notifications: [list] optional, what to notify after a successful `release`,
+               `upload` or `publish-lib`; each item with either:
+  url: [HttpUrl] a webhook to POST a JSON payload to
+  command: [string] a local command to run (from the project's directory,
+           split in its arguments as a shell does), receiving the JSON
+           payload in its standard input
+  and optionally:
+  events: [list of strings] which of "release", "upload" or "publish-lib" to
+          notify; defaults to all of them
+  timeout: [integer] seconds to wait for the webhook or the command; defaults
+           to 30
+
+compression:
+  level: [integer] optional, the compression level of the packed files, from 0
+         (not compressed) to 9 (the smallest, but slowest); defaults to 6
+  workers: [integer] optional, how many files are compressed at the same time
+           (in threads); defaults to the number of processors
+
+metadata: optional, the charm's metadata, instead of a metadata.yaml file (which
+          is generated when packing); as in that file, it must include the
+          charm's name, and the relations (requires, provides and peers) need
+          their interface:
+  name: [string] the charm's name (lowercase letters, digits and hyphens,
+        starting with a letter)
+  display-name, summary, description: [string] optional
+  maintainers, tags, series: [list of strings] optional
+  subordinate: [boolean] optional
+  requires, provides, peers: optional, the relations, by name; each one with
+                             its interface ([string], may be given directly
+                             instead of the whole relation), and optionally
+                             limit ([integer]), optional ([boolean]) and scope
+                             (one of "global" or "container")
+  storage, containers, resources, and any other key supported by Juju are
+  included as they are
+
+actions: optional, the charm's actions, instead of an actions.yaml file (which
+         is generated when packing), by name (lowercase letters and hyphens,
+         starting and ending with a letter); each one with:
+  description: [string] optional
+  params: [mapping] optional, the parameters, in the JSON Schema format
+  required: [list of strings] optional, the required parameters
+  additionalProperties: [boolean] optional
+  execution-group: [string] optional
+  parallel: [boolean] optional
+
+config: optional, the charm's configuration, instead of a config.yaml file (which
+        is generated when packing):
+  options: the configuration options, by name; each one with:
+    type: [string] one of "string", "int", "float", "boolean" or "secret"
+    description: [string] optional
+    default: optional, a value of the indicated type
 
 """
 
 import datetime
 import pathlib
+import re
+import shlex
 from typing import Any, Dict, List, Optional
 
+import appdirs
 import pydantic
 
+from charmcraft.audit import SEVERITIES
 from charmcraft.cmdbase import CommandError
-from charmcraft.utils import load_yaml
+from charmcraft.compression import DEFAULT_LEVEL
+from charmcraft.git import (
+    DEFAULT_TAG_TEMPLATE,
+    validate_tag_template,
+    validate_version_template,
+)
+from charmcraft.notifications import DEFAULT_TIMEOUT, EVENTS
+from charmcraft.utils import BASE_SYSTEMS, load_yaml
 
 
 class RelativePath(pydantic.StrictStr):
@@ -101,7 +217,7 @@ def format_pydantic_error_message(msg):
     return msg
 
 
-def format_pydantic_errors(errors):
+def format_pydantic_errors(errors, filename="charmcraft.yaml"):
     """Format errors.
 
     Example 1: Single error.
@@ -118,7 +234,7 @@ def format_pydantic_errors(errors):
     - field: <some field 2>
       reason: <some reason 2>
     """
-    combined = ["Bad charmcraft.yaml content:"]
+    combined = ["Bad {} content:".format(filename)]
     for error in errors:
         formatted_loc = format_pydantic_error_location(error["loc"])
         formatted_msg = format_pydantic_error_message(error["msg"])
@@ -136,12 +252,60 @@ class Part(
     prime: List[RelativePath] = []
 
 
+class Base(
+    pydantic.BaseModel, extra=pydantic.Extra.forbid, frozen=True, validate_all=True
+):
+    """Definition of the base, the system where the charm will run."""
+
+    name: pydantic.StrictStr
+    channel: pydantic.StrictStr
+
+    @pydantic.validator("channel")
+    def validate_channel(cls, channel, values):
+        """Verify that the base is one of the supported ones."""
+        name = values.get("name")
+        if name is not None and (name, channel) not in BASE_SYSTEMS:
+            supported = ", ".join(
+                "{} {}".format(*base) for base in sorted(BASE_SYSTEMS)
+            )
+            raise ValueError(
+                "unsupported base {} {} (supported ones are: {})".format(
+                    name, channel, supported
+                )
+            )
+        return channel
+
+
+class CharmPart(
+    pydantic.BaseModel,
+    extra=pydantic.Extra.forbid,
+    frozen=True,
+    validate_all=True,
+    allow_population_by_field_name=True,
+):
+    """Definition of the charm part."""
+
+    version: Optional[pydantic.StrictStr]
+    legacy_hooks: bool = pydantic.Field(False, alias="legacy-hooks")
+    base: Optional[Base]
+
+    @pydantic.validator("version")
+    def validate_version(cls, version):
+        """Verify the version template only uses supported fields."""
+        if version is not None:
+            problem = validate_version_template(version)
+            if problem is not None:
+                raise ValueError(problem)
+        return version
+
+
 class Parts(
     pydantic.BaseModel, extra=pydantic.Extra.forbid, frozen=True, validate_all=True
 ):
     """Definition of parts to build."""
 
     bundle: Part = Part()
+    charm: CharmPart = CharmPart()
 
     def get(self, part_name) -> Part:
         """Get part by name.
@@ -152,6 +316,8 @@ class Parts(
         """
         if part_name == "bundle":
             return self.bundle
+        if part_name == "charm":
+            return self.charm
         raise KeyError(part_name)
 
 
@@ -164,6 +330,139 @@ class CharmhubConfig(
     storage_url: pydantic.HttpUrl = "https://storage.snapcraftcontent.com"
 
 
+class InterfacesConfig(
+    pydantic.BaseModel, extra=pydantic.Extra.forbid, frozen=True, validate_all=True
+):
+    """Definition of the relation interfaces configuration."""
+
+    catalog: Optional[pydantic.StrictStr]
+
+
+class AuditConfig(
+    pydantic.BaseModel,
+    extra=pydantic.Extra.forbid,
+    frozen=True,
+    validate_all=True,
+    allow_population_by_field_name=True,
+):
+    """Definition of the vulnerabilities audit configuration."""
+
+    database: Optional[pydantic.StrictStr]
+    severity_threshold: Optional[pydantic.StrictStr] = pydantic.Field(
+        alias="severity-threshold"
+    )
+    ignore: List[pydantic.StrictStr] = []
+
+    @pydantic.validator("severity_threshold")
+    def validate_severity_threshold(cls, severity):
+        """Verify that the severity is one of the supported ones."""
+        if severity is not None and severity not in SEVERITIES:
+            raise ValueError("must be one of {}".format(", ".join(SEVERITIES)))
+        return severity
+
+
+class ReleasePolicyConfig(
+    pydantic.BaseModel,
+    extra=pydantic.Extra.forbid,
+    frozen=True,
+    validate_all=True,
+    allow_population_by_field_name=True,
+):
+    """Definition of the release policy configuration."""
+
+    require_lower_risk: bool = pydantic.Field(False, alias="require-lower-risk")
+    soak_hours: Optional[pydantic.StrictInt] = pydantic.Field(alias="soak-hours")
+    confirm_stable: bool = pydantic.Field(False, alias="confirm-stable")
+    restricted_tracks: List[pydantic.StrictStr] = pydantic.Field(
+        [], alias="restricted-tracks"
+    )
+
+    @pydantic.validator("soak_hours")
+    def validate_soak_hours(cls, soak_hours):
+        """Verify that the soak time is a positive number of hours."""
+        if soak_hours is not None and soak_hours <= 0:
+            raise ValueError("must be a positive number of hours")
+        return soak_hours
+
+
+class GitTagConfig(
+    pydantic.BaseModel,
+    extra=pydantic.Extra.forbid,
+    frozen=True,
+    validate_all=True,
+):
+    """Definition of the git tags created when releasing."""
+
+    enabled: bool = False
+    template: pydantic.StrictStr = DEFAULT_TAG_TEMPLATE
+    push: bool = False
+    remote: pydantic.StrictStr = "origin"
+
+    @pydantic.validator("template")
+    def validate_template(cls, template):
+        """Verify the template only uses supported fields."""
+        problem = validate_tag_template(template)
+        if problem is not None:
+            raise ValueError(problem)
+        return template
+
+
+class NotificationConfig(
+    pydantic.BaseModel,
+    extra=pydantic.Extra.forbid,
+    frozen=True,
+    validate_all=True,
+):
+    """Definition of a notification after a successful Charmhub operation."""
+
+    url: Optional[pydantic.HttpUrl]
+    command: Optional[pydantic.StrictStr]
+    events: List[pydantic.StrictStr] = list(EVENTS)
+    timeout: pydantic.StrictInt = DEFAULT_TIMEOUT
+
+    @pydantic.validator("command")
+    def validate_target(cls, command, values):
+        """Verify that the notification goes to either a webhook or a command."""
+        if "url" not in values:
+            # the URL was indicated but it's not valid, which is already reported
+            return command
+        if (command is None) == (values["url"] is None):
+            raise ValueError("either this or 'url' must be indicated, but not both")
+        return command
+
+    @pydantic.validator("command")
+    def validate_command(cls, command):
+        """Verify that the command can be split in its arguments, as it will be run."""
+        if command is None:
+            return command
+        try:
+            args = shlex.split(command)
+        except ValueError as exc:
+            raise ValueError("cannot be parsed as a command: {}".format(exc))
+        if not args:
+            raise ValueError("must not be empty")
+        return command
+
+    @pydantic.validator("events")
+    def validate_events(cls, events):
+        """Verify that the events are known."""
+        for event in events:
+            if event not in EVENTS:
+                raise ValueError(
+                    "unknown event {!r} (must be one of: {})".format(
+                        event, ", ".join(EVENTS)
+                    )
+                )
+        return events
+
+    @pydantic.validator("timeout")
+    def validate_timeout(cls, timeout):
+        """Verify that the timeout is a positive number of seconds."""
+        if timeout <= 0:
+            raise ValueError("must be a positive number of seconds")
+        return timeout
+
+
 class Project(
     pydantic.BaseModel, extra=pydantic.Extra.forbid, frozen=True, validate_all=True
 ):
@@ -176,6 +475,174 @@ class Project(
     started_at: datetime.datetime
 
 
+class CompressionConfig(
+    pydantic.BaseModel,
+    extra=pydantic.Extra.forbid,
+    frozen=True,
+    validate_all=True,
+):
+    """Definition of the packages compression configuration."""
+
+    level: pydantic.StrictInt = DEFAULT_LEVEL
+    workers: Optional[pydantic.StrictInt]
+
+    @pydantic.validator("level")
+    def validate_level(cls, level):
+        """Verify that the level is one of those supported by zlib."""
+        if not 0 <= level <= 9:
+            raise ValueError("must be between 0 and 9")
+        return level
+
+    @pydantic.validator("workers")
+    def validate_workers(cls, workers):
+        """Verify that there is at least one worker."""
+        if workers is not None and workers < 1:
+            raise ValueError("must be at least 1")
+        return workers
+
+
+# the valid names for charms and actions (as Juju validates them)
+_CHARM_NAME_RE = re.compile(r"^[a-z][a-z0-9]*(-[a-z0-9]*[a-z][a-z0-9]*)*$")
+_ACTION_NAME_RE = re.compile(r"^[a-z](?:[a-z-]*[a-z])?$")
+
+# the types of the charm's configuration options, with the Python types of their values
+CONFIG_OPTION_TYPES = {
+    "string": (str,),
+    "int": (int,),
+    "float": (int, float),
+    "boolean": (bool,),
+    "secret": (str,),
+}
+
+
+def is_valid_option_value(value, option_type):
+    """Tell if the value is valid for a configuration option of the indicated type."""
+    valid_types = CONFIG_OPTION_TYPES[option_type]
+    if isinstance(value, bool):
+        return bool in valid_types
+    return isinstance(value, valid_types)
+
+
+class Relation(
+    pydantic.BaseModel, extra=pydantic.Extra.forbid, frozen=True, validate_all=True
+):
+    """Definition of a relation declared in the charm's metadata."""
+
+    interface: pydantic.StrictStr
+    limit: Optional[pydantic.StrictInt]
+    optional: Optional[bool]
+    scope: Optional[pydantic.StrictStr]
+
+    @pydantic.validator("scope")
+    def validate_scope(cls, scope):
+        """Verify that the scope is one of those supported by Juju."""
+        if scope is not None and scope not in ("global", "container"):
+            raise ValueError("must be either 'global' or 'container'")
+        return scope
+
+
+class CharmMetadata(
+    pydantic.BaseModel,
+    extra=pydantic.Extra.allow,
+    frozen=True,
+    validate_all=True,
+    allow_population_by_field_name=True,
+):
+    """Definition of the charm's metadata.
+
+    The keys not defined here are included as they are, to support everything Juju
+    supports in the metadata.yaml file.
+    """
+
+    name: pydantic.StrictStr
+    display_name: Optional[pydantic.StrictStr] = pydantic.Field(alias="display-name")
+    summary: Optional[pydantic.StrictStr]
+    description: Optional[pydantic.StrictStr]
+    maintainers: Optional[List[pydantic.StrictStr]]
+    tags: Optional[List[pydantic.StrictStr]]
+    series: Optional[List[pydantic.StrictStr]]
+    subordinate: Optional[bool]
+    requires: Optional[Dict[pydantic.StrictStr, Relation]]
+    provides: Optional[Dict[pydantic.StrictStr, Relation]]
+    peers: Optional[Dict[pydantic.StrictStr, Relation]]
+
+    @pydantic.validator("name")
+    def validate_name(cls, name):
+        """Verify that the name is valid for a charm."""
+        if not _CHARM_NAME_RE.match(name):
+            raise ValueError(
+                "must use only lowercase letters, digits and hyphens, starting with "
+                "a letter"
+            )
+        return name
+
+    @pydantic.validator("requires", "provides", "peers", pre=True)
+    def expand_relations(cls, relations):
+        """Support giving directly the interface of the relations, as Juju does."""
+        if isinstance(relations, dict):
+            relations = {
+                name: {"interface": relation} if isinstance(relation, str) else relation
+                for name, relation in relations.items()
+            }
+        return relations
+
+
+class Action(
+    pydantic.BaseModel,
+    extra=pydantic.Extra.forbid,
+    frozen=True,
+    validate_all=True,
+    allow_population_by_field_name=True,
+):
+    """Definition of one of the charm's actions."""
+
+    description: Optional[pydantic.StrictStr]
+    params: Optional[Dict[pydantic.StrictStr, Any]]
+    required: Optional[List[pydantic.StrictStr]]
+    additional_properties: Optional[bool] = pydantic.Field(
+        alias="additionalProperties"
+    )
+    execution_group: Optional[pydantic.StrictStr] = pydantic.Field(
+        alias="execution-group"
+    )
+    parallel: Optional[bool]
+
+
+class ConfigOption(
+    pydantic.BaseModel, extra=pydantic.Extra.forbid, frozen=True, validate_all=True
+):
+    """Definition of one of the charm's configuration options."""
+
+    type: pydantic.StrictStr
+    description: Optional[pydantic.StrictStr]
+    default: Any = None
+
+    @pydantic.validator("type")
+    def validate_type(cls, option_type):
+        """Verify that the type is one of those supported by Juju."""
+        if option_type not in CONFIG_OPTION_TYPES:
+            raise ValueError("must be one of {}".format(", ".join(CONFIG_OPTION_TYPES)))
+        return option_type
+
+    @pydantic.validator("default")
+    def validate_default(cls, default, values):
+        """Verify that the default value is of the option's type."""
+        option_type = values.get("type")
+        if default is None or option_type is None:
+            return default
+        if not is_valid_option_value(default, option_type):
+            raise ValueError("must be a value of type {!r}".format(option_type))
+        return default
+
+
+class CharmConfig(
+    pydantic.BaseModel, extra=pydantic.Extra.forbid, frozen=True, validate_all=True
+):
+    """Definition of the charm's configuration."""
+
+    options: Dict[pydantic.StrictStr, ConfigOption] = {}
+
+
 class Config(
     pydantic.BaseModel,
     extra=pydantic.Extra.forbid,
@@ -186,6 +653,17 @@ class Config(
     type: Optional[str]
     charmhub: CharmhubConfig = CharmhubConfig()
     parts: Parts = Parts()
+    interfaces: InterfacesConfig = InterfacesConfig()
+    audit: AuditConfig = AuditConfig()
+    release_policy: ReleasePolicyConfig = pydantic.Field(
+        ReleasePolicyConfig(), alias="release-policy"
+    )
+    git_tag: GitTagConfig = pydantic.Field(GitTagConfig(), alias="git-tag")
+    notifications: List[NotificationConfig] = []
+    compression: CompressionConfig = CompressionConfig()
+    metadata: Optional[CharmMetadata]
+    actions: Optional[Dict[pydantic.StrictStr, Action]]
+    charm_config: Optional[CharmConfig] = pydantic.Field(alias="config")
     project: Project
 
     @pydantic.validator("type")
@@ -195,6 +673,24 @@ class Config(
             raise ValueError("must be either 'charm' or 'bundle'")
         return charm_type
 
+    @pydantic.validator("metadata", "actions", "charm_config")
+    def validate_only_for_charms(cls, section, values):
+        """Verify that the charm's sections are not used for bundles."""
+        if section is not None and values.get("type") == "bundle":
+            raise ValueError("only valid for charms")
+        return section
+
+    @pydantic.validator("actions")
+    def validate_action_names(cls, actions):
+        """Verify that the names of the actions are valid."""
+        for name in actions or {}:
+            if not _ACTION_NAME_RE.match(name):
+                raise ValueError(
+                    "invalid action name {!r} (must use only lowercase letters and "
+                    "hyphens, starting and ending with a letter)".format(name)
+                )
+        return actions
+
     @classmethod
     def unmarshal(cls, obj: Dict[str, Any], project: Project):
         """Unmarshal object with necessary translations and error handling.
@@ -232,8 +728,43 @@ class Config(
         return schema
 
 
+class UserConfig(
+    pydantic.BaseModel,
+    extra=pydantic.Extra.forbid,
+    frozen=True,
+):
+    """Definition of the user's configuration, for all the projects."""
+
+    release_policy: Optional[ReleasePolicyConfig] = pydantic.Field(
+        alias="release-policy"
+    )
+
+
+def get_user_config_filepath():
+    """Return the path of the user's configuration file."""
+    return pathlib.Path(appdirs.user_config_dir("charmcraft")) / "config.yaml"
+
+
+def load_user_config():
+    """Load the user's configuration (all defaults if there is none)."""
+    filepath = get_user_config_filepath()
+    content = load_yaml(filepath)
+    if content is None:
+        return UserConfig()
+    if not isinstance(content, dict):
+        raise CommandError("Bad {} content: it must be a mapping.".format(filepath))
+    try:
+        return UserConfig.parse_obj(content)
+    except pydantic.error_wrappers.ValidationError as error:
+        raise CommandError(format_pydantic_errors(error.errors(), str(filepath)))
+
+
 def load(dirpath):
-    """Load the config from charmcraft.yaml in the indicated directory."""
+    """Load the config from charmcraft.yaml in the indicated directory.
+
+    The settings in the user's configuration are used if the project does not
+    indicate them.
+    """
     if dirpath is None:
         dirpath = pathlib.Path.cwd()
     else:
@@ -241,6 +772,11 @@ def load(dirpath):
 
     now = datetime.datetime.utcnow()
 
+    user_config = load_user_config()
+    user_settings = {}
+    if user_config.release_policy is not None:
+        user_settings["release-policy"] = user_config.release_policy
+
     content = load_yaml(dirpath / "charmcraft.yaml")
     if content is None:
         # configuration is mandatory only for some commands; when not provided, it will
@@ -251,9 +787,12 @@ def load(dirpath):
                 config_provided=False,
                 started_at=now,
             ),
+            **user_settings,
         )
 
     else:
+        if isinstance(content, dict):
+            content = {**user_settings, **content}
         return Config.unmarshal(
             content,
             project=Project(
@@ -0,0 +1,169 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Explain the dependencies installed in the charm's virtualenv."""
+
+import re
+from collections import namedtuple
+from email.parser import HeaderParser
+
+from charmcraft.audit import normalize_name
+from charmcraft.cmdbase import CommandError
+
+# the name at the beginning of a requirement (or of a Requires-Dist metadata field)
+_NAME_RE = re.compile(r"^\s*([A-Za-z0-9][A-Za-z0-9._-]*)")
+
+# a requirement by URL, and the name of the project in it
+_URL_RE = re.compile(r"^\s*[a-z][a-z0-9+.-]*://")
+_EGG_RE = re.compile(r"#egg=([A-Za-z0-9][A-Za-z0-9._-]*)")
+
+# an option in a requirements file, and its value
+_OPTION_RE = re.compile(r"^(-[-a-z]*)[=\s]*(.*)$")
+
+# the options in a requirements file which include another file
+_INCLUDE_OPTIONS = ("-r", "--requirement")
+
+Package = namedtuple("Package", "name version requires")
+
+
+def _get_requirement_name(line):
+    """Get the normalized name of the project in a requirement line (or None)."""
+    if _URL_RE.match(line):
+        match = _EGG_RE.search(line)
+    else:
+        match = _NAME_RE.match(line)
+    if match is None:
+        return None
+    return normalize_name(match.group(1))
+
+
+def parse_requirements(filepath):
+    """Return the names of the projects required in the requirements file.
+
+    The files included by the requirements file are parsed too (but not the
+    constraints files, as they do not add requirements).
+    """
+    names = []
+    try:
+        content = filepath.read_text()
+    except OSError as exc:
+        raise CommandError(
+            "Cannot read the requirements file {!r}: {}".format(
+                str(filepath), exc.strerror
+            )
+        )
+    # the lines ending with a backslash continue in the next one
+    content = content.replace("\\\n", "")
+    for line in content.splitlines():
+        line = line.split(" #", 1)[0].strip()
+        if not line or line.startswith("#"):
+            continue
+        if line.startswith("-"):
+            option, value = _OPTION_RE.match(line).groups()
+            if option in _INCLUDE_OPTIONS:
+                included = filepath.parent / value
+                names.extend(parse_requirements(included))
+            elif option in ("-e", "--editable"):
+                name = _get_requirement_name(value)
+                if name is not None:
+                    names.append(name)
+            continue
+        name = _get_requirement_name(line)
+        if name is not None:
+            names.append(name)
+    return names
+
+
+def get_installed_packages(venvpath):
+    """Return the packages installed in the venv, with their requirements, by name.
+
+    Only the requirements that are installed are kept, which leaves out those not
+    applicable to the current environment or needed only by extras.
+    """
+    packages = {}
+    metadata_files = list(venvpath.glob("*.dist-info/METADATA"))
+    metadata_files.extend(venvpath.glob("*.egg-info/PKG-INFO"))
+    for filepath in metadata_files:
+        headers = HeaderParser().parsestr(filepath.read_text(errors="replace"))
+        name, version = headers.get("Name"), headers.get("Version")
+        if not name or not version:
+            continue
+        name = normalize_name(name)
+        requires = []
+        for requirement in headers.get_all("Requires-Dist") or []:
+            required_name = _get_requirement_name(requirement)
+            if required_name is not None and required_name not in requires:
+                requires.append(required_name)
+        packages[name] = Package(name, version, requires)
+
+    # only keep the requirements that were installed
+    for name, package in packages.items():
+        requires = sorted(req for req in package.requires if req in packages)
+        packages[name] = package._replace(requires=requires)
+    return packages
+
+
+def get_tree(direct, packages):
+    """Return the lines of the dependency tree.
+
+    Each line is a (depth, package, is_direct, repeated) tuple. The direct
+    requirements are the roots of the tree; the installed packages that are not
+    reached from them are also shown at the root. The requirements of a package
+    are not repeated if it was already shown (which also cuts the cycles).
+    """
+    lines = []
+    shown = set()
+
+    def walk(name, depth):
+        package = packages[name]
+        repeated = name in shown
+        lines.append((depth, package, name in direct, repeated))
+        if repeated:
+            return
+        shown.add(name)
+        for required in package.requires:
+            walk(required, depth + 1)
+
+    roots = [name for name in sorted(direct) if name in packages]
+    for name in roots:
+        walk(name, 0)
+    for name in sorted(packages):
+        if name not in shown:
+            walk(name, 0)
+    return lines
+
+
+def find_chains(target, direct, packages):
+    """Return the chains of requirements from the direct requirements to the target.
+
+    Each chain is a list of package names, starting with a direct requirement and
+    ending with the target.
+    """
+    chains = []
+
+    def walk(chain):
+        name = chain[-1]
+        if name == target:
+            chains.append(chain)
+            return
+        for required in packages[name].requires:
+            if required not in chain:
+                walk(chain + [required])
+
+    for name in sorted(direct):
+        if name in packages:
+            walk([name])
+    return chains
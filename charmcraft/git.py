@@ -0,0 +1,255 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Helpers to get information from the git repository where the project lives."""
+
+import logging
+import re
+import string
+import subprocess
+import tarfile
+import tempfile
+from collections import namedtuple
+
+from charmcraft.cmdbase import CommandError
+
+logger = logging.getLogger(__name__)
+
+# the marker added to the version when the working tree has uncommitted changes
+DIRTY_MARKER = "-dirty"
+
+# the fields that can be used in the version template
+VERSION_TEMPLATE_FIELDS = {"describe", "commit", "short_commit", "branch", "dirty"}
+
+# the template for the tags created when releasing, and the fields it can use
+DEFAULT_TAG_TEMPLATE = "{name}/{channel}/r{revision}"
+TAG_TEMPLATE_FIELDS = {"name", "revision", "channel", "track", "risk", "version"}
+
+# a commit hash at the end of the version, as produced by `git describe`, and
+# optionally followed by the dirty marker (a hash alone needs to include some letter,
+# to not be confused with numeric versions)
+_COMMIT_IN_VERSION_RE = re.compile(
+    r"(?:^(?=[0-9]*[a-f])|-g)(?P<commit>[0-9a-f]{7,40})"
+    r"(?P<dirty>" + re.escape(DIRTY_MARKER) + ")?$"
+)
+
+VersionInfo = namedtuple("VersionInfo", "describe commit short_commit branch dirty")
+
+
+def run_git(dirpath, *args):
+    """Run a git command in the indicated directory and return its stripped output."""
+    cmd = ["git", "-C", str(dirpath)] + list(args)
+    logger.debug("Running git command %s", cmd)
+    try:
+        proc = subprocess.run(
+            cmd,
+            stdout=subprocess.PIPE,
+            stderr=subprocess.PIPE,
+            universal_newlines=True,
+        )
+    except FileNotFoundError:
+        raise CommandError("Cannot run git: the 'git' executable was not found.")
+    if proc.returncode:
+        raise CommandError(
+            "Git command {} failed: {}".format(list(args), proc.stderr.strip())
+        )
+    return proc.stdout.strip()
+
+
+def is_repository(dirpath):
+    """Tell if the indicated directory is inside a git working tree."""
+    try:
+        result = run_git(dirpath, "rev-parse", "--is-inside-work-tree")
+    except CommandError:
+        return False
+    return result == "true"
+
+
+def has_head(dirpath):
+    """Tell if the repository has a current commit (it has none just after init)."""
+    try:
+        run_git(dirpath, "rev-parse", "--verify", "--quiet", "HEAD")
+    except CommandError:
+        return False
+    return True
+
+
+def is_dirty(dirpath, untracked=False):
+    """Tell if the working tree has uncommitted changes.
+
+    Untracked files are ignored unless indicated (the ones ignored by git never count).
+    """
+    untracked_option = "--untracked-files=" + ("normal" if untracked else "no")
+    result = run_git(dirpath, "status", "--porcelain", untracked_option, ".")
+    return bool(result)
+
+
+def get_version_info(dirpath, ref=None):
+    """Collect the information from the repository that can be used in the version.
+
+    By default the information is about the working tree; if a ref is given it is about
+    that committed state, which is never dirty.
+    """
+    rev = "HEAD" if ref is None else ref
+    commit = run_git(dirpath, "rev-parse", "--verify", rev + "^{commit}")
+    # a ref that is not a branch or tag (e.g. a commit hash) has no short name
+    branch = run_git(dirpath, "rev-parse", "--abbrev-ref", rev) or rev
+    describe = run_git(dirpath, "describe", "--tags", "--long", "--always", commit)
+    return VersionInfo(
+        describe=describe,
+        commit=commit,
+        short_commit=commit[:7],
+        branch=branch,
+        dirty=False if ref is not None else is_dirty(dirpath),
+    )
+
+
+def _get_template_fields(template):
+    """Return the names of the fields used in the template."""
+    return {
+        field_name
+        for _, field_name, _, _ in string.Formatter().parse(template)
+        if field_name is not None
+    }
+
+
+def _validate_template(template, valid_fields):
+    """Verify the template only uses the valid fields; return the problem if any."""
+    try:
+        fields = _get_template_fields(template)
+    except ValueError as exc:
+        return "invalid template ({})".format(exc)
+    unknown = fields - valid_fields
+    if unknown:
+        return "unknown field(s) in template: {} (valid ones are: {})".format(
+            ", ".join(sorted(unknown)), ", ".join(sorted(valid_fields))
+        )
+
+
+def validate_version_template(template):
+    """Verify the template only uses the supported fields; return the problem if any."""
+    return _validate_template(template, VERSION_TEMPLATE_FIELDS)
+
+
+def validate_tag_template(template):
+    """Verify the tag template only uses the supported fields; return the problem."""
+    return _validate_template(template, TAG_TEMPLATE_FIELDS)
+
+
+def build_version(dirpath, template=None, ref=None):
+    """Build the version string for the project in the indicated directory.
+
+    If a template is not given, the output of `git describe` is used. In any case, if
+    the working tree is dirty the version will include a clear marker for it. If a ref
+    is given the version is built for it instead of for the working tree.
+    """
+    info = get_version_info(dirpath, ref)
+    dirty = DIRTY_MARKER if info.dirty else ""
+    if template is None:
+        return info.describe + dirty
+
+    version = template.format(
+        describe=info.describe,
+        commit=info.commit,
+        short_commit=info.short_commit,
+        branch=info.branch,
+        dirty=dirty,
+    )
+    if info.dirty and "dirty" not in _get_template_fields(template):
+        version += dirty
+    return version
+
+
+def get_commit_from_version(version):
+    """Return the commit hash included in the version (if any), and if it was dirty."""
+    if not version:
+        return None, False
+    match = _COMMIT_IN_VERSION_RE.search(version)
+    if match is None:
+        return None, False
+    return match.group("commit"), match.group("dirty") is not None
+
+
+def has_commit(dirpath, commit):
+    """Tell if the commit is present in the repository."""
+    try:
+        run_git(dirpath, "cat-file", "-e", "{}^{{commit}}".format(commit))
+    except CommandError:
+        return False
+    return True
+
+
+def is_valid_tag_name(dirpath, tag):
+    """Tell if the name can be used for a tag."""
+    try:
+        run_git(dirpath, "check-ref-format", "refs/tags/" + tag)
+    except CommandError:
+        return False
+    return True
+
+
+def has_tag(dirpath, tag):
+    """Tell if the tag is present in the repository."""
+    try:
+        run_git(dirpath, "show-ref", "--verify", "--quiet", "refs/tags/" + tag)
+    except CommandError:
+        return False
+    return True
+
+
+def create_tag(dirpath, tag, commit, message):
+    """Create an annotated tag on the commit."""
+    run_git(dirpath, "tag", "--annotate", "--message", message, tag, commit)
+
+
+def push_tags(dirpath, remote, tags):
+    """Push the tags to the remote."""
+    run_git(dirpath, "push", "--quiet", remote, *["refs/tags/" + tag for tag in tags])
+
+
+def get_log(dirpath, start, end):
+    """Return the short hash and subject of the commits after start up to end."""
+    result = run_git(dirpath, "log", "--format=%h %s", "{}..{}".format(start, end))
+    return result.splitlines()
+
+
+def get_changed_files(dirpath, start, end):
+    """Return the status and path of the files changed between the two commits."""
+    result = run_git(dirpath, "diff", "--name-status", start, end)
+    changed = []
+    for line in result.splitlines():
+        status, *paths = line.split("\t")
+        changed.append((status[0], " -> ".join(paths)))
+    return changed
+
+
+def export_tree(dirpath, ref, destdir):
+    """Export the files of the indicated directory, as committed in the ref.
+
+    Only what is tracked by git in that ref is exported (nothing from the working
+    tree), and the directory is exported even if it is not the root of the repository.
+    """
+    toplevel = run_git(dirpath, "rev-parse", "--show-toplevel")
+    prefix = run_git(dirpath, "rev-parse", "--show-prefix")
+    commit = run_git(dirpath, "rev-parse", "--verify", ref + "^{commit}")
+    treeish = "{}:{}".format(commit, prefix) if prefix else commit
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tarpath = "{}/export.tar".format(tmpdir)
+        run_git(toplevel, "archive", "--format=tar", "--output", tarpath, treeish)
+        with tarfile.open(tarpath) as tarfh:
+            tarfh.extractall(str(destdir))
+    logger.debug("Exported %r from commit %s to %r", prefix or ".", commit, str(destdir))
+    return commit
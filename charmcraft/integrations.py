@@ -0,0 +1,228 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Find which of the charms in a directory tree can be related to each other."""
+
+import json
+import os
+import pathlib
+from collections import namedtuple
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.metadata import CHARM_METADATA
+from charmcraft.utils import load_yaml
+
+# the directories that are never scanned for charms (besides the hidden ones)
+SKIPPED_DIRNAMES = {"build", "node_modules", "venv"}
+
+# a charm found in the tree, with its endpoints (name -> interface) by role
+Charm = namedtuple("Charm", "name relpath provides requires")
+
+# a possible relation between two charms, from the requirer side to the provider one
+Integration = namedtuple(
+    "Integration", "requirer requirer_endpoint provider provider_endpoint interface"
+)
+
+
+def _get_endpoints(metadata, section):
+    """Return the interface of each endpoint declared in a section of the metadata."""
+    endpoints = {}
+    for name, relation in (metadata.get(section) or {}).items():
+        # Juju accepts the interface directly instead of the relation's details
+        if isinstance(relation, dict):
+            relation = relation.get("interface")
+        if isinstance(relation, str) and relation:
+            endpoints[name] = relation
+    return endpoints
+
+
+def _load_metadata(dirpath):
+    """Return the charm's metadata in the directory (None if it's not a charm)."""
+    config = load_yaml(dirpath / "charmcraft.yaml") or {}
+    if config.get("type", "charm") != "charm":
+        return None
+    # the metadata may be declared in charmcraft.yaml itself
+    metadata = config.get("metadata")
+    if metadata is None and (dirpath / CHARM_METADATA).is_file():
+        metadata = load_yaml(dirpath / CHARM_METADATA) or {}
+    return metadata
+
+
+def find_charms(basedir):
+    """Return the charms found in the directory tree, sorted by name."""
+    charms = {}
+    for dirpath, dirnames, _ in os.walk(str(basedir)):
+        dirnames[:] = sorted(
+            name
+            for name in dirnames
+            if not name.startswith(".") and name not in SKIPPED_DIRNAMES
+        )
+        dirpath = pathlib.Path(dirpath)
+        metadata = _load_metadata(dirpath)
+        if metadata is None:
+            continue
+
+        # a charm's directory is not scanned for other charms
+        dirnames[:] = []
+        relpath = str(dirpath.relative_to(basedir))
+        name = metadata.get("name")
+        if not name:
+            raise CommandError(
+                "Cannot find the name of the charm in {!r}.".format(relpath)
+            )
+        if name in charms:
+            raise CommandError(
+                "The charm name {!r} is used in more than one directory: {!r} and "
+                "{!r}.".format(name, charms[name].relpath, relpath)
+            )
+        charms[name] = Charm(
+            name,
+            relpath,
+            _get_endpoints(metadata, "provides"),
+            _get_endpoints(metadata, "requires"),
+        )
+    return [charms[name] for name in sorted(charms)]
+
+
+def find_integrations(charms):
+    """Return all the possible relations between the charms.
+
+    A relation is possible when an endpoint required by a charm and another provided
+    by a charm (maybe the same one) use the same interface.
+    """
+    integrations = []
+    for requirer in charms:
+        for requirer_endpoint, interface in sorted(requirer.requires.items()):
+            for provider in charms:
+                for provider_endpoint, provided in sorted(provider.provides.items()):
+                    if provided == interface:
+                        integrations.append(
+                            Integration(
+                                requirer.name,
+                                requirer_endpoint,
+                                provider.name,
+                                provider_endpoint,
+                                interface,
+                            )
+                        )
+    return integrations
+
+
+def find_unmatched(charms):
+    """Return the interfaces only provided and those only required in the charms.
+
+    Each is a dict with the endpoints (as "charm:endpoint") using the interface.
+    """
+    provided = {}
+    required = {}
+    for charm in charms:
+        sides = [(charm.provides, provided), (charm.requires, required)]
+        for endpoints, found in sides:
+            for endpoint, interface in sorted(endpoints.items()):
+                name = "{}:{}".format(charm.name, endpoint)
+                found.setdefault(interface, []).append(name)
+    only_provided = {
+        interface: sorted(provided[interface])
+        for interface in sorted(provided)
+        if interface not in required
+    }
+    only_required = {
+        interface: sorted(required[interface])
+        for interface in sorted(required)
+        if interface not in provided
+    }
+    return only_provided, only_required
+
+
+def get_matrix(charms, integrations):
+    """Return the interfaces that relate each requirer (rows) with each provider."""
+    matrix = {}
+    for item in integrations:
+        interfaces = matrix.setdefault((item.requirer, item.provider), [])
+        if item.interface not in interfaces:
+            interfaces.append(item.interface)
+    return [
+        [matrix.get((requirer.name, provider.name), []) for provider in charms]
+        for requirer in charms
+    ]
+
+
+def render_json(charms, integrations, only_provided, only_required):
+    """Return the charms and their possible relations as a JSON document."""
+    content = {
+        "charms": {
+            charm.name: {
+                "path": charm.relpath,
+                "provides": charm.provides,
+                "requires": charm.requires,
+            }
+            for charm in charms
+        },
+        "integrations": [
+            {
+                "requirer": "{}:{}".format(item.requirer, item.requirer_endpoint),
+                "provider": "{}:{}".format(item.provider, item.provider_endpoint),
+                "interface": item.interface,
+            }
+            for item in integrations
+        ],
+        "only-provided": only_provided,
+        "only-required": only_required,
+    }
+    return json.dumps(content, indent=4)
+
+
+def render_dot(charms, integrations, only_provided, only_required):
+    """Return the charms and their possible relations as a Graphviz DOT graph.
+
+    The edges go from the requirer to the provider; the interfaces without a
+    counterpart in the charms are shown as red dashed boxes.
+    """
+    lines = ["digraph integrations {", "    rankdir=LR;"]
+    for charm in charms:
+        lines.append("    {};".format(json.dumps(charm.name)))
+    for item in integrations:
+        lines.append(
+            "    {} -> {} [label={}];".format(
+                json.dumps(item.requirer),
+                json.dumps(item.provider),
+                json.dumps(
+                    "{} ({} -> {})".format(
+                        item.interface, item.requirer_endpoint, item.provider_endpoint
+                    )
+                ),
+            )
+        )
+
+    for unmatched, provider_side in [(only_provided, True), (only_required, False)]:
+        for interface, endpoints in unmatched.items():
+            node = json.dumps("interface:" + interface)
+            lines.append(
+                "    {} [label={}, shape=box, style=dashed, color=red];".format(
+                    node, json.dumps(interface)
+                )
+            )
+            for endpoint in endpoints:
+                charm_name, _, endpoint_name = endpoint.partition(":")
+                charm_node = json.dumps(charm_name)
+                edge = (node, charm_node) if provider_side else (charm_node, node)
+                lines.append(
+                    "    {} -> {} [label={}, style=dashed, color=red];".format(
+                        *edge, json.dumps(endpoint_name)
+                    )
+                )
+    lines.append("}")
+    return "\n".join(lines)
@@ -0,0 +1,205 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Support for a local catalog of relation interfaces and their schemas.
+
+The catalog is a directory (for example, a vendored git checkout of interface
+specifications) with the following structure, where the `interfaces` level is
+optional and each version may have a JSON schema for the relation data of each
+side of the relation:
+
+    interfaces/
+      <interface-name>/
+        v<N>/
+          provider.json
+          requirer.json
+"""
+
+import difflib
+import json
+import pathlib
+import re
+from collections import namedtuple
+
+import jsonschema
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.metadata import get_metadata
+
+# the optional subdirectory in the catalog holding the interfaces
+CATALOG_INTERFACES_DIRNAME = "interfaces"
+
+# the sides of a relation that may have a schema for their data
+ROLES = ("provider", "requirer")
+
+# the role of the charm in the relation, for each section of metadata.yaml
+RELATION_ROLES = {"provides": "provider", "requires": "requirer"}
+
+_VERSION_DIR_RE = re.compile(r"v(\d+)")
+
+# a relation declared by the charm
+Relation = namedtuple("Relation", "section name interface")
+
+
+def _load_schema(filepath):
+    """Load and verify a JSON schema from the catalog."""
+    try:
+        schema = json.loads(filepath.read_text())
+    except ValueError as exc:
+        raise CommandError(
+            "Invalid schema in the interfaces catalog {!r}: {}".format(
+                str(filepath), exc
+            )
+        )
+    try:
+        jsonschema.validators.validator_for(schema).check_schema(schema)
+    except jsonschema.exceptions.SchemaError as exc:
+        raise CommandError(
+            "Invalid schema in the interfaces catalog {!r}: {}".format(
+                str(filepath), exc.message
+            )
+        )
+    return schema
+
+
+class InterfacesCatalog:
+    """The interfaces in a local catalog, with their versions and schemas."""
+
+    def __init__(self, dirpath):
+        self.dirpath = pathlib.Path(dirpath)
+        if not self.dirpath.is_dir():
+            raise CommandError(
+                "Cannot use the interfaces catalog: {!r} is not a directory.".format(
+                    str(self.dirpath)
+                )
+            )
+        self._interfaces = self._load()
+
+    def _load(self):
+        """Load all the interfaces from disk."""
+        basedir = self.dirpath / CATALOG_INTERFACES_DIRNAME
+        if not basedir.is_dir():
+            basedir = self.dirpath
+
+        interfaces = {}
+        for interface_dir in sorted(basedir.iterdir()):
+            if not interface_dir.is_dir() or interface_dir.name.startswith("."):
+                continue
+            versions = {}
+            for version_dir in interface_dir.iterdir():
+                match = _VERSION_DIR_RE.fullmatch(version_dir.name)
+                if match is None or not version_dir.is_dir():
+                    continue
+                schemas = {}
+                for role in ROLES:
+                    schema_path = version_dir / (role + ".json")
+                    if schema_path.exists():
+                        schemas[role] = _load_schema(schema_path)
+                versions[int(match.group(1))] = schemas
+            interfaces[interface_dir.name] = versions
+        return interfaces
+
+    def __contains__(self, name):
+        return name in self._interfaces
+
+    @property
+    def names(self):
+        """Return the names of all the interfaces in the catalog."""
+        return sorted(self._interfaces)
+
+    def get_versions(self, name):
+        """Return the versions of the interface, sorted."""
+        return sorted(self._interfaces[name])
+
+    def suggest(self, name):
+        """Return the interface name closest to the given one, or None."""
+        matches = difflib.get_close_matches(name, self._interfaces, n=1)
+        return matches[0] if matches else None
+
+    def get_schema(self, name, role, version=None):
+        """Return the schema for the data of a side of the relation (None if missing).
+
+        If the version is not given, the latest one is used.
+        """
+        if name not in self._interfaces:
+            raise CommandError("Interface {!r} not found in the catalog.".format(name))
+        versions = self._interfaces[name]
+        if version is None:
+            if not versions:
+                return None
+            version = max(versions)
+        if version not in versions:
+            raise CommandError(
+                "Version {} of interface {!r} not found in the catalog.".format(
+                    version, name
+                )
+            )
+        return versions[version].get(role)
+
+    def get_validator(self, name, role, version=None):
+        """Return a validator for the data of a side of the relation, or None.
+
+        The validator is a `jsonschema` one (e.g. use its `iter_errors` method to get
+        the problems in some relation data); None is returned if there is no schema.
+        """
+        schema = self.get_schema(name, role, version)
+        if schema is None:
+            return None
+        return jsonschema.validators.validator_for(schema)(schema)
+
+
+def get_relations(config, basedir):
+    """Return the 'provides' and 'requires' relations declared in the metadata."""
+    metadata = get_metadata(config, basedir)
+    relations = []
+    for section in RELATION_ROLES:
+        for name, spec in (metadata.get(section) or {}).items():
+            interface = spec.get("interface") if isinstance(spec, dict) else None
+            relations.append(Relation(section, name, interface))
+    return relations
+
+
+def check_interfaces(config, basedir, catalog):
+    """Check the interfaces of the charm's relations against the catalog."""
+    problems = []
+    for relation in get_relations(config, basedir):
+        if relation.interface is None:
+            problems.append(
+                "the {} relation {!r} does not declare its interface".format(
+                    relation.section, relation.name
+                )
+            )
+        elif relation.interface not in catalog:
+            problem = "unknown interface {!r} in the {} relation {!r}".format(
+                relation.interface, relation.section, relation.name
+            )
+            suggestion = catalog.suggest(relation.interface)
+            if suggestion is not None:
+                problem += " (did you mean {!r}?)".format(suggestion)
+            problems.append(problem)
+    return problems
+
+
+def load_catalog(config, dirpath=None):
+    """Load the catalog from the indicated directory or the one in the config.
+
+    Return None if no catalog was indicated.
+    """
+    if dirpath is None:
+        if config.interfaces.catalog is None:
+            return None
+        dirpath = config.project.dirpath / config.interfaces.catalog
+    return InterfacesCatalog(pathlib.Path(dirpath).expanduser())
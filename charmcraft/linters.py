@@ -0,0 +1,317 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Check charms and bundles to find problems before they reach the store."""
+
+import logging
+import re
+import subprocess
+import xml.etree.ElementTree as ET
+from collections import namedtuple
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.interfaces import check_interfaces
+from charmcraft.metadata import get_metadata
+
+logger = logging.getLogger(__name__)
+
+# the icon shown in Charmhub for the charm or bundle
+ICON_FILENAME = "icon.svg"
+
+# the limits Charmhub expects for the icon
+ICON_MAX_SIZE = 256 * 1024
+ICON_CANVAS_SIZE = 100
+
+SVG_NAMESPACE = "http://www.w3.org/2000/svg"
+
+# elements that can run code or embed foreign content in the icon
+_FORBIDDEN_ELEMENTS = {"script", "foreignObject"}
+
+# a reference to something outside the icon (only local fragments and inline data
+# are allowed)
+_EXTERNAL_URL_RE = re.compile(r"url\(\s*['\"]?(?!\s*(?:#|data:))", re.IGNORECASE)
+_IMPORT_RE = re.compile(r"@import", re.IGNORECASE)
+
+# the executable used to render the icon
+RENDERER = "rsvg-convert"
+
+# the type of the resources holding the images for the workload containers, and the
+# type of the storage that can be mounted in them
+CONTAINER_RESOURCE_TYPE = "oci-image"
+CONTAINER_STORAGE_TYPE = "filesystem"
+
+# the result of a check: its name and the problems found
+CheckResult = namedtuple("CheckResult", "name problems")
+
+
+def _local_name(name):
+    """Return the name without the XML namespace."""
+    return name.rsplit("}", 1)[-1]
+
+
+def _is_external_reference(value):
+    """Tell if the link points outside the icon."""
+    value = value.strip()
+    return not (value.startswith("#") or value.startswith("data:"))
+
+
+def _check_viewbox(root):
+    """Verify the viewBox of the icon; return the problems found."""
+    viewbox = root.get("viewBox")
+    if viewbox is None:
+        return ["the root element must have a 'viewBox' attribute"]
+    try:
+        _, _, width, height = [float(x) for x in viewbox.replace(",", " ").split()]
+    except ValueError:
+        return ["the 'viewBox' attribute is invalid: {!r}".format(viewbox)]
+    if width != ICON_CANVAS_SIZE or height != ICON_CANVAS_SIZE:
+        return [
+            "the 'viewBox' must define a {size}x{size} canvas (got {width:g}x"
+            "{height:g})".format(size=ICON_CANVAS_SIZE, width=width, height=height)
+        ]
+    return []
+
+
+def _check_content(root):
+    """Verify that the icon does not run code nor reference external resources."""
+    problems = []
+    for element in root.iter():
+        name = _local_name(element.tag)
+        if name in _FORBIDDEN_ELEMENTS:
+            problems.append("it must not include {!r} elements".format(name))
+        style = element.text if name == "style" and element.text else ""
+        if _EXTERNAL_URL_RE.search(style) or _IMPORT_RE.search(style):
+            problems.append("its styles must not reference external resources")
+
+        for attrib, value in element.attrib.items():
+            attrib_name = _local_name(attrib)
+            if attrib_name.lower().startswith("on"):
+                problems.append(
+                    "it must not include event handlers ({!r} in {!r})".format(
+                        attrib_name, name
+                    )
+                )
+            elif attrib_name == "href" and _is_external_reference(value):
+                problems.append(
+                    "it must not reference external resources ({!r} in {!r})".format(
+                        value, name
+                    )
+                )
+            elif _EXTERNAL_URL_RE.search(value):
+                problems.append(
+                    "it must not reference external resources ({!r} in {!r})".format(
+                        attrib_name, name
+                    )
+                )
+
+    # report each problem once, keeping the order
+    return list(dict.fromkeys(problems))
+
+
+def validate_icon(filepath):
+    """Validate the icon file; return the problems found (empty if all is fine)."""
+    size = filepath.stat().st_size
+    if size > ICON_MAX_SIZE:
+        return [
+            "the file is too big ({} bytes, the limit is {})".format(size, ICON_MAX_SIZE)
+        ]
+
+    content = filepath.read_bytes()
+    if b"<!ENTITY" in content:
+        return ["it must not declare XML entities"]
+    try:
+        root = ET.fromstring(content)
+    except ET.ParseError as exc:
+        return ["it is not well-formed XML ({})".format(exc)]
+
+    if root.tag != "{{{}}}svg".format(SVG_NAMESPACE):
+        return [
+            "the root element must be 'svg' in the {!r} namespace (got {!r})".format(
+                SVG_NAMESPACE, root.tag
+            )
+        ]
+
+    return _check_viewbox(root) + _check_content(root)
+
+
+def check_icon(basedir):
+    """Check the icon of the charm or bundle, if present."""
+    filepath = basedir / ICON_FILENAME
+    if not filepath.exists():
+        return []
+    return validate_icon(filepath)
+
+
+def render_icon(filepath, output_filepath, size=ICON_CANVAS_SIZE):
+    """Render the icon to a PNG image, to preview it."""
+    cmd = [
+        RENDERER,
+        "--format=png",
+        "--width={}".format(size),
+        "--height={}".format(size),
+        "--output={}".format(output_filepath),
+        str(filepath),
+    ]
+    logger.debug("Rendering the icon: %s", cmd)
+    try:
+        proc = subprocess.run(
+            cmd,
+            stdout=subprocess.PIPE,
+            stderr=subprocess.PIPE,
+            universal_newlines=True,
+        )
+    except FileNotFoundError:
+        raise CommandError(
+            "Cannot render the icon: the {!r} executable was not found (it is "
+            "usually provided by the 'librsvg2-bin' package).".format(RENDERER)
+        )
+    if proc.returncode:
+        raise CommandError("Cannot render the icon: {}".format(proc.stderr.strip()))
+
+
+def _check_container_mounts(container_name, mounts, storage):
+    """Verify the mounts of a container against the declared storage."""
+    problems = []
+    locations = set()
+    for mount in mounts:
+        if not isinstance(mount, dict) or "storage" not in mount:
+            problems.append(
+                "a mount in container {!r} does not indicate its storage".format(
+                    container_name
+                )
+            )
+            continue
+        storage_name = mount["storage"]
+        if not isinstance(storage_name, str):
+            problems.append(
+                "the mount storage {!r} in container {!r} must be a string".format(
+                    storage_name, container_name
+                )
+            )
+        elif storage_name not in storage:
+            problems.append(
+                "container {!r} mounts the undeclared storage {!r}".format(
+                    container_name, storage_name
+                )
+            )
+        else:
+            storage_type = (storage[storage_name] or {}).get("type")
+            if storage_type != CONTAINER_STORAGE_TYPE:
+                problems.append(
+                    "container {!r} mounts storage {!r} which is of type {!r} (it "
+                    "must be {!r})".format(
+                        container_name,
+                        storage_name,
+                        storage_type,
+                        CONTAINER_STORAGE_TYPE,
+                    )
+                )
+
+        location = mount.get("location")
+        if location is None:
+            # it's fine, the location declared in the storage will be used
+            continue
+        if not isinstance(location, str):
+            problems.append(
+                "the mount location {!r} in container {!r} must be a string".format(
+                    location, container_name
+                )
+            )
+            continue
+        if not location.startswith("/"):
+            problems.append(
+                "the mount location {!r} in container {!r} must be an absolute "
+                "path".format(location, container_name)
+            )
+        if location in locations:
+            problems.append(
+                "the mount location {!r} is used more than once in container "
+                "{!r}".format(location, container_name)
+            )
+        locations.add(location)
+    return problems
+
+
+def check_containers(config, basedir):
+    """Cross-check the containers of a sidecar charm with its resources and storage."""
+    metadata = get_metadata(config, basedir)
+    containers = metadata.get("containers") or {}
+    if not containers:
+        return []
+    resources = metadata.get("resources") or {}
+    storage = metadata.get("storage") or {}
+
+    problems = []
+    used_resources = set()
+    for container_name, container in containers.items():
+        container = container or {}
+        resource_name = container.get("resource")
+        if resource_name is None:
+            if "bases" not in container:
+                problems.append(
+                    "container {!r} must indicate its image 'resource' (or its "
+                    "'bases')".format(container_name)
+                )
+        elif resource_name not in resources:
+            problems.append(
+                "container {!r} uses the undeclared resource {!r}".format(
+                    container_name, resource_name
+                )
+            )
+        else:
+            used_resources.add(resource_name)
+            resource_type = (resources[resource_name] or {}).get("type")
+            if resource_type != CONTAINER_RESOURCE_TYPE:
+                problems.append(
+                    "container {!r} uses resource {!r} which is of type {!r} (it "
+                    "must be {!r})".format(
+                        container_name,
+                        resource_name,
+                        resource_type,
+                        CONTAINER_RESOURCE_TYPE,
+                    )
+                )
+
+        mounts = container.get("mounts") or []
+        problems.extend(_check_container_mounts(container_name, mounts, storage))
+
+    for resource_name, resource in resources.items():
+        if resource_name in used_resources:
+            continue
+        if (resource or {}).get("type") == CONTAINER_RESOURCE_TYPE:
+            problems.append(
+                "the {!r} resource {!r} is not used by any container".format(
+                    CONTAINER_RESOURCE_TYPE, resource_name
+                )
+            )
+    return problems
+
+
+def analyze(config, basedir, catalog=None):
+    """Run all the checks on the charm or bundle in the directory.
+
+    The charm's metadata is taken from the config, if given and it has it (see
+    `get_metadata`). The relations' interfaces are also checked if an interfaces
+    catalog is given.
+    """
+    results = [
+        CheckResult("icon", check_icon(basedir)),
+        CheckResult("containers", check_containers(config, basedir)),
+    ]
+    if catalog is not None:
+        problems = check_interfaces(config, basedir, catalog)
+        results.append(CheckResult("interfaces", problems))
+    return results
@@ -22,7 +22,21 @@ import sys
 from collections import namedtuple
 
 from charmcraft import helptexts, config
-from charmcraft.commands import version, build, store, init, pack
+from charmcraft.commands import (
+    analyze,
+    audit,
+    build,
+    cache,
+    deps,
+    init,
+    integrations,
+    interfaces,
+    pack,
+    store,
+    verify,
+    version,
+    workspace,
+)
 from charmcraft.cmdbase import CommandError, BaseCommand
 from charmcraft.logsetup import message_handler
 
@@ -96,6 +110,14 @@ COMMAND_GROUPS = [
             HelpCommand,
             build.BuildCommand,
             pack.PackCommand,
+            analyze.AnalyzeCommand,
+            verify.VerifyArtifactCommand,
+            audit.AuditCommand,
+            deps.DepsCommand,
+            interfaces.InterfacesCommand,
+            integrations.IntegrationsCommand,
+            workspace.WorkspaceCommand,
+            cache.CacheCommand,
             init.InitCommand,
             version.VersionCommand,
         ],
@@ -118,6 +140,8 @@ COMMAND_GROUPS = [
             # release process, and show status
             store.ReleaseCommand,
             store.StatusCommand,
+            store.TuiCommand,
+            store.ChangelogCommand,
             # libraries support
             store.CreateLibCommand,
             store.PublishLibCommand,
@@ -127,6 +151,9 @@ COMMAND_GROUPS = [
             store.ListResourcesCommand,
             store.UploadResourceCommand,
             store.ListResourceRevisionsCommand,
+            # off-line deployments support
+            store.ExportCommand,
+            store.VerifyExportCommand,
         ],
     ),
 ]
@@ -0,0 +1,94 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""The charm's metadata, actions and config, from charmcraft.yaml or their own files."""
+
+import yaml
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.utils import load_yaml
+
+# the files Juju needs in the charm, which can be generated from charmcraft.yaml
+CHARM_METADATA = "metadata.yaml"
+CHARM_ACTIONS = "actions.yaml"
+CHARM_CONFIG = "config.yaml"
+
+
+def _dump(model):
+    """Return the content of a section as Juju expects it in its file."""
+    return model.dict(by_alias=True, exclude_none=True)
+
+
+def _get_sections(config):
+    """Return the content of the sections declared in charmcraft.yaml, by file."""
+    sections = {}
+    if config.metadata is not None:
+        sections[CHARM_METADATA] = _dump(config.metadata)
+    if config.actions is not None:
+        sections[CHARM_ACTIONS] = {
+            name: _dump(action) for name, action in config.actions.items()
+        }
+    if config.charm_config is not None:
+        sections[CHARM_CONFIG] = _dump(config.charm_config)
+    return sections
+
+
+def get_metadata(config, dirpath):
+    """Return the charm's metadata (empty if not declared).
+
+    It's taken from charmcraft.yaml (if the config is given and has it), or the
+    metadata.yaml file in the directory.
+    """
+    if config is not None and config.metadata is not None:
+        return _dump(config.metadata)
+    return load_yaml(dirpath / CHARM_METADATA) or {}
+
+
+def get_actions(config, dirpath):
+    """Return the charm's actions (empty if not declared).
+
+    They are taken from charmcraft.yaml, or the actions.yaml file in the directory.
+    """
+    if config.actions is not None:
+        return {name: _dump(action) for name, action in config.actions.items()}
+    return load_yaml(dirpath / CHARM_ACTIONS) or {}
+
+
+def get_charm_config(config, dirpath):
+    """Return the charm's configuration (empty if not declared).
+
+    It's taken from charmcraft.yaml, or the config.yaml file in the directory.
+    """
+    if config.charm_config is not None:
+        return _dump(config.charm_config)
+    return load_yaml(dirpath / CHARM_CONFIG) or {}
+
+
+def get_legacy_files(config, dirpath):
+    """Return the content of the files to generate for the sections in charmcraft.yaml.
+
+    It fails if a section is also provided by its file in the directory, as it would
+    not be clear which one to use.
+    """
+    files = {}
+    for filename, content in _get_sections(config).items():
+        if (dirpath / filename).exists():
+            raise CommandError(
+                "Cannot generate {!r}: its content is declared in charmcraft.yaml but "
+                "the project also has the file; remove one of them.".format(filename)
+            )
+        files[filename] = yaml.safe_dump(content, sort_keys=False)
+    return files
@@ -0,0 +1,128 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Notify other systems (with a webhook or a local command) after Charmhub operations.
+
+The notifications are configured in charmcraft.yaml, each one for some of the
+events. The payload is a JSON document with the event, the name of the charm or
+bundle, and the revision, channels and resources involved (if any); it is POSTed
+to the webhook's URL, or given to the command in its standard input (also in the
+CHARMCRAFT_EVENT, CHARMCRAFT_NAME, CHARMCRAFT_REVISION and CHARMCRAFT_CHANNELS
+environment variables).
+"""
+
+import json
+import logging
+import os
+import shlex
+import subprocess
+from urllib.parse import urlparse
+
+import requests
+from requests.exceptions import RequestException
+
+logger = logging.getLogger(__name__)
+
+# the operations that can be notified
+EVENTS = ("release", "upload", "publish-lib")
+
+# how long to wait for the webhook or command, in seconds
+DEFAULT_TIMEOUT = 30
+
+
+def build_payload(event, name, revision=None, channels=(), resources=(), **extra):
+    """Build the content to notify about the event."""
+    payload = {
+        "event": event,
+        "name": name,
+        "revision": revision,
+        "channels": list(channels),
+        "resources": [{"name": r.name, "revision": r.revision} for r in resources],
+    }
+    payload.update(extra)
+    return payload
+
+
+def _describe(notification):
+    """Describe where the notification goes (without the URL's path, may be secret)."""
+    if notification.url is not None:
+        return "webhook at {!r}".format(urlparse(notification.url).netloc)
+    return "command {!r}".format(notification.command)
+
+
+def _post(url, payload, timeout):
+    """POST the payload to the webhook."""
+    response = requests.post(url, json=payload, timeout=timeout)
+    response.raise_for_status()
+
+
+def _run_command(command, payload, timeout, cwd):
+    """Run the command, giving it the payload."""
+    revision = payload["revision"]
+    env = dict(
+        os.environ,
+        CHARMCRAFT_EVENT=payload["event"],
+        CHARMCRAFT_NAME=payload["name"],
+        CHARMCRAFT_REVISION="" if revision is None else str(revision),
+        CHARMCRAFT_CHANNELS=",".join(payload["channels"]),
+    )
+    proc = subprocess.run(
+        shlex.split(command),
+        input=json.dumps(payload).encode("utf8"),
+        stdout=subprocess.PIPE,
+        stderr=subprocess.STDOUT,
+        env=env,
+        cwd=str(cwd),
+        timeout=timeout,
+    )
+    for line in proc.stdout.decode("utf8", errors="replace").splitlines():
+        logger.debug("  :: %s", line)
+    if proc.returncode:
+        raise subprocess.SubprocessError(
+            "it exited with return code {}".format(proc.returncode)
+        )
+
+
+def notify(config, payload):
+    """Deliver the payload to the notifications configured for its event.
+
+    The failures are reported but not raised: the notified operation is already done.
+    """
+    for notification in config.notifications:
+        if payload["event"] not in notification.events:
+            continue
+        target = _describe(notification)
+        try:
+            if notification.url is not None:
+                _post(notification.url, payload, notification.timeout)
+            else:
+                _run_command(
+                    notification.command,
+                    payload,
+                    notification.timeout,
+                    config.project.dirpath,
+                )
+        except (
+            RequestException,
+            OSError,
+            subprocess.SubprocessError,
+            ValueError,
+        ) as exc:
+            logger.warning(
+                "Cannot notify the %s to the %s: %s", payload["event"], target, exc
+            )
+        else:
+            logger.debug("Notified the %s to the %s.", payload["event"], target)
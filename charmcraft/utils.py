@@ -49,6 +49,15 @@ ARCH_TRANSLATIONS = {
     "x86_64": "amd64",
 }
 
+# the Python and glibc versions in each of the supported bases (the systems where the
+# charm can run), needed to find the dependencies that are compatible with them
+BaseSystem = namedtuple("BaseSystem", "python_version glibc_version")
+BASE_SYSTEMS = {
+    ("ubuntu", "18.04"): BaseSystem(python_version="3.6", glibc_version=(2, 27)),
+    ("ubuntu", "20.04"): BaseSystem(python_version="3.8", glibc_version=(2, 31)),
+    ("ubuntu", "22.04"): BaseSystem(python_version="3.10", glibc_version=(2, 35)),
+}
+
 
 def make_executable(fh):
     """Make open file fh executable."""
@@ -182,10 +191,10 @@ def get_os_platform(filepath=pathlib.Path("/etc/os-release")):
     return OSPlatform(system=system, release=release, machine=machine)
 
 
-def create_manifest(basedir, started_at):
-    """Save context information for the charm execution.
+def get_manifest_content(started_at, base=None):
+    """Return the context information for the charm execution, as YAML.
 
-    Mostly to be used by builders.
+    The base where the charm will run defaults to the current system if not indicated.
     """
     os_platform = get_os_platform()
 
@@ -200,6 +209,8 @@ def create_manifest(basedir, started_at):
     name = os_platform.system.lower()
     name = name_translation.get(name, name)
     channel = channel_translation.get(os_platform.release, os_platform.release)
+    if base is not None:
+        name, channel = base.name, base.channel
 
     content = {
         "charmcraft-version": __version__,
@@ -212,10 +223,19 @@ def create_manifest(basedir, started_at):
             }
         ],
     }
+    return yaml.dump(content)
+
+
+def create_manifest(basedir, started_at, base=None):
+    """Save context information for the charm execution.
+
+    Mostly to be used by builders. The base where the charm will run defaults to the
+    current system if not indicated.
+    """
     filepath = basedir / "manifest.yaml"
     if filepath.exists():
         raise CommandError(
             "Cannot write the manifest as there is already a 'manifest.yaml' in disk."
         )
-    filepath.write_text(yaml.dump(content))
+    filepath.write_text(get_manifest_content(started_at, base))
     return filepath
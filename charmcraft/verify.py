@@ -0,0 +1,178 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Verify that a packed charm or bundle is deployable."""
+
+import logging
+import posixpath
+import re
+import stat
+import zipfile
+import zlib
+
+import yaml
+
+from charmcraft.cmdbase import CommandError
+
+logger = logging.getLogger(__name__)
+
+# the files every charm and bundle must include
+CHARM_FILES = ("metadata.yaml", "manifest.yaml")
+BUNDLE_FILES = ("bundle.yaml", "manifest.yaml", "README.md")
+
+# the entrypoint called by the dispatch script created by charmcraft
+_ENTRYPOINT_RE = re.compile(r"\s\./(\S+)\s*$", re.MULTILINE)
+
+
+def _get_mode(info):
+    """Return the Unix mode of the entry (0 if the archive does not record it)."""
+    return info.external_attr >> 16
+
+
+def _is_executable(info):
+    """Tell if the entry is executable (symlinks are verified separately)."""
+    mode = _get_mode(info)
+    return not stat.S_ISREG(mode) or bool(mode & 0o111)
+
+
+def _check_paths(infos):
+    """Verify that all the paths are relative and inside the archive."""
+    problems = []
+    for info in infos:
+        name = info.filename
+        if name.startswith("/") or ".." in name.split("/"):
+            problems.append(
+                "The path {!r} is outside the root of the artifact.".format(name)
+            )
+    return problems
+
+
+def _check_symlinks(zf, infos, names):
+    """Verify that the symlinks point to files inside the archive."""
+    problems = []
+    for info in infos:
+        if not stat.S_ISLNK(_get_mode(info)):
+            continue
+        target = zf.read(info).decode("utf8", errors="replace")
+        resolved = posixpath.normpath(
+            posixpath.join(posixpath.dirname(info.filename), target)
+        )
+        if target.startswith("/") or resolved == ".." or resolved.startswith("../"):
+            problems.append(
+                "The symlink {!r} points outside the artifact.".format(info.filename)
+            )
+        elif resolved not in names:
+            problems.append(
+                "The symlink {!r} points to {!r}, which is not in the artifact.".format(
+                    info.filename, resolved
+                )
+            )
+    return problems
+
+
+def _check_yaml_files(zf, names, filenames):
+    """Verify that the files are present and are YAML mappings."""
+    problems = []
+    for filename in filenames:
+        if filename not in names:
+            problems.append("The {!r} file is missing.".format(filename))
+            continue
+        if not filename.endswith(".yaml"):
+            continue
+        try:
+            content = yaml.safe_load(zf.read(filename))
+        except yaml.YAMLError:
+            content = None
+        if not isinstance(content, dict):
+            problems.append(
+                "The {!r} file is not a valid YAML mapping.".format(filename)
+            )
+    return problems
+
+
+def _check_executables(zf, infos, names):
+    """Verify the dispatch script and the entrypoint, warn about hooks and actions."""
+    problems = []
+    hooks = [info for info in infos if posixpath.dirname(info.filename) == "hooks"]
+    actions = [
+        info for info in infos if posixpath.dirname(info.filename) == "actions"
+    ]
+
+    # the files in these directories may be something else than hooks or actions (and
+    # are not run by Juju if there is a dispatch script), so they are just warned about
+    for info in hooks + actions:
+        if not _is_executable(info):
+            logger.warning("The %r file is not executable.", info.filename)
+
+    if "dispatch" not in names:
+        if not hooks:
+            problems.append("The charm has no 'dispatch' script nor hooks.")
+        return problems
+
+    if not _is_executable(zf.getinfo("dispatch")):
+        problems.append("The 'dispatch' file is not executable.")
+
+    # the entrypoint can only be found if the dispatch script is the one created
+    # by charmcraft
+    content = zf.read("dispatch").decode("utf8", errors="replace")
+    match = _ENTRYPOINT_RE.search(content)
+    if match is not None and match.group(1) not in names:
+        problems.append(
+            "The entrypoint {!r} called by the 'dispatch' script is missing.".format(
+                match.group(1)
+            )
+        )
+    return problems
+
+
+def verify_artifact(filepath):
+    """Verify the charm or bundle; return what it is and the problems found."""
+    if not zipfile.is_zipfile(str(filepath)):
+        raise CommandError(
+            "Cannot open {!r}: it is not a charm or bundle file.".format(str(filepath))
+        )
+
+    with zipfile.ZipFile(str(filepath)) as zf:
+        infos = zf.infolist()
+        names = {info.filename for info in infos}
+        kind = "bundle" if "bundle.yaml" in names else "charm"
+
+        try:
+            corrupted = zf.testzip()
+        except (zipfile.BadZipFile, zlib.error) as exc:
+            return kind, ["The artifact is corrupted: {}.".format(exc)]
+        if corrupted is not None:
+            return kind, ["The file {!r} is corrupted.".format(corrupted)]
+
+        problems = _check_paths(infos)
+        problems.extend(_check_symlinks(zf, infos, names))
+        if kind == "bundle":
+            problems.extend(_check_yaml_files(zf, names, BUNDLE_FILES))
+        else:
+            problems.extend(_check_yaml_files(zf, names, CHARM_FILES))
+            problems.extend(_check_executables(zf, infos, names))
+    return kind, problems
+
+
+def ensure_deployable(filepath):
+    """Verify the just packed charm or bundle, failing if it has problems."""
+    kind, problems = verify_artifact(filepath)
+    if problems:
+        raise CommandError(
+            "The packed {} {!r} is not deployable: {}".format(
+                kind, str(filepath), " ".join(problems)
+            )
+        )
@@ -20,10 +20,18 @@ _charmcraft()
 {
     local cur prev words cword cmd cmds
     cmds=(
+        analyze
+        audit
         build 
+        cache
+        changelog
         create-lib 
+        deps
+        export
         fetch-lib 
         help init 
+        integrations
+        interfaces
         list-lib 
         login 
         logout 
@@ -37,10 +45,14 @@ _charmcraft()
         resources
         revisions 
         status 
+        tui
         upload 
         upload-resource
+        verify-artifact
+        verify-export
         version 
         whoami
+        workspace
     )
     _init_completion || return
 
@@ -77,6 +89,32 @@ _charmcraft()
 
     # offer the options for the given command (and global ones, always available)
     case "$cmd" in
+        analyze)
+            case "$prev" in
+                --icon-preview)
+                    _filedir png
+                    ;;
+                --interfaces-catalog)
+                    _filedir -d
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "${globals[*]} --icon-preview --interfaces-catalog" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        audit)
+            case "$prev" in
+                --database)
+                    _filedir -d
+                    ;;
+                --severity-threshold)
+                    COMPREPLY=( $(compgen -W "unknown low medium high critical" -- "$cur") )
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "${globals[*]} --database --severity-threshold" -- "$cur") )
+                    ;;
+            esac
+            ;;
         build)
             case "$prev" in
                 -r|--requirement)
@@ -89,7 +127,50 @@ _charmcraft()
                     _filedir -d
                     ;;
                 *)
-                    COMPREPLY=( $(compgen -W "${globals[*]} --from --entrypoint --requirement" -- "$cur") )
+                    COMPREPLY=( $(compgen -W "${globals[*]} --from --entrypoint --requirement --staging" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        cache)
+            case "$prev" in
+                --older-than)
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "${globals[*]} info prune --older-than --all" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        deps)
+            case "$prev" in
+                -r|--requirement)
+                    _filedir txt
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "${globals[*]} --requirement --why" -- "$cur") )
+                    ;;
+            esac
+            ;;
+        integrations)
+            case "$prev" in
+                --format)
+                    COMPREPLY=( $(compgen -W "table json dot" -- "$cur") )
+                    ;;
+                -o|--output)
+                    _filedir
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "${globals[*]} --format --output" -- "$cur") )
+                    _filedir -d
+                    ;;
+            esac
+            ;;
+        interfaces)
+            case "$prev" in
+                --catalog|--export)
+                    _filedir -d
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "${globals[*]} --catalog --export" -- "$cur") )
                     ;;
             esac
             ;;
@@ -101,19 +182,28 @@ _charmcraft()
                 -e|--entrypoint)
                     _filedir py
                     ;;
+                --report)
+                    _filedir json
+                    ;;
                 *)
-                    COMPREPLY=( $(compgen -W "${globals[*]} --entrypoint --requirement" -- "$cur") )
+                    COMPREPLY=( $(compgen -W "${globals[*]} --entrypoint --requirement --from-git --release-mode --allow-dirty --report --fetch-charms" -- "$cur") )
                     ;;
             esac
             ;;
         release)
-            COMPREPLY=( $(compgen -W "${globals[*]} --revision --channel --resource" -- "$cur") )
+            COMPREPLY=( $(compgen -W "${globals[*]} --revision --channel --resource --override-policy --git-tag --tag-template --push-tag" -- "$cur") )
+            ;;
+        tui)
+            COMPREPLY=( $(compgen -W "${globals[*]} --override-policy" -- "$cur") )
             ;;
         init)
             COMPREPLY=( $(compgen -W "${globals[*]} --name --author --force" -- "$cur") )
             ;;
+        list-lib|resource-revisions|resources|revisions|status)
+            COMPREPLY=( $(compgen -W "${globals[*]} --offline" -- "$cur") )
+            ;;
         upload)
-            COMPREPLY=( $(compgen -W "${globals[*]} --release" -- "$cur") )
+            COMPREPLY=( $(compgen -W "${globals[*]} --release --override-policy" -- "$cur") )
             ;;
         upload-resource)
             case "$prev" in
@@ -125,6 +215,9 @@ _charmcraft()
                     ;;
             esac
             ;;
+        verify-artifact)
+            _filedir '@(charm|zip)'
+            ;;
         *)
             # by default just the global options
             COMPREPLY=( $(compgen -W "${globals[*]}" -- "$cur") )
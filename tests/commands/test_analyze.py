@@ -0,0 +1,163 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the 'analyze' command (code in commands/analyze.py)."""
+
+import logging
+import zipfile
+from argparse import Namespace
+from unittest.mock import patch
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.analyze import AnalyzeCommand
+from charmcraft.config import InterfacesConfig
+from charmcraft.linters import CheckResult
+
+BAD_ICON = '<svg xmlns="http://www.w3.org/2000/svg"><script/></svg>'
+GOOD_ICON = '<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100"/>'
+
+
+@pytest.fixture
+def build_charm(tmp_path):
+    """Build a charm file with the given files."""
+
+    def func(files):
+        filepath = tmp_path / "mycharm.charm"
+        with zipfile.ZipFile(str(filepath), "w") as zf:
+            for name, content in files.items():
+                zf.writestr(name, content)
+        return filepath
+
+    return func
+
+
+def test_analyze_ok(caplog, config, build_charm):
+    """The charm has no problems."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    filepath = build_charm({"metadata.yaml": "name: mycharm", "icon.svg": GOOD_ICON})
+
+    args = Namespace(filepath=filepath, icon_preview=None, interfaces_catalog=None)
+    AnalyzeCommand("group", config).run(args)
+    assert [rec.message for rec in caplog.records] == ["icon: ok", "containers: ok"]
+
+
+def test_analyze_problems(caplog, config, build_charm):
+    """The problems found are reported and the command fails."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    filepath = build_charm({"metadata.yaml": "name: mycharm", "icon.svg": BAD_ICON})
+
+    args = Namespace(filepath=filepath, icon_preview=None, interfaces_catalog=None)
+    with pytest.raises(CommandError) as cm:
+        AnalyzeCommand("group", config).run(args)
+    assert str(cm.value) == "Found 2 problem(s) in {!r}.".format(str(filepath))
+    assert [rec.message for rec in caplog.records] == [
+        "icon: 2 problem(s)",
+        "- the root element must have a 'viewBox' attribute",
+        "- it must not include 'script' elements",
+        "containers: ok",
+    ]
+
+
+def test_analyze_extracted_content(config, build_charm):
+    """The checks are run on the content of the charm."""
+    filepath = build_charm({"metadata.yaml": "name: mycharm"})
+
+    def fake_analyze(config, basedir, catalog):
+        assert config is None  # the charm's own metadata.yaml is used
+        assert (basedir / "metadata.yaml").read_text() == "name: mycharm"
+        return [CheckResult("test", [])]
+
+    args = Namespace(filepath=filepath, icon_preview=None, interfaces_catalog=None)
+    with patch("charmcraft.linters.analyze", side_effect=fake_analyze) as analyze_mock:
+        AnalyzeCommand("group", config).run(args)
+    analyze_mock.assert_called_once()
+
+
+def test_analyze_not_a_zip(config, tmp_path):
+    """The indicated file is not a charm or bundle."""
+    filepath = tmp_path / "something.charm"
+    filepath.write_text("not a zip")
+
+    args = Namespace(filepath=filepath, icon_preview=None, interfaces_catalog=None)
+    with pytest.raises(CommandError) as cm:
+        AnalyzeCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "Cannot open {!r}: it is not a charm or bundle file.".format(str(filepath))
+    )
+
+
+def test_analyze_icon_preview(caplog, config, build_charm, tmp_path):
+    """The icon is rendered if requested."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    filepath = build_charm({"icon.svg": GOOD_ICON})
+    preview = tmp_path / "preview.png"
+
+    def fake_render(icon_filepath, output_filepath):
+        assert icon_filepath.read_text() == GOOD_ICON
+        assert output_filepath == preview
+
+    args = Namespace(filepath=filepath, icon_preview=preview, interfaces_catalog=None)
+    with patch("charmcraft.linters.render_icon", side_effect=fake_render) as render_mock:
+        AnalyzeCommand("group", config).run(args)
+    render_mock.assert_called_once()
+    assert "Icon preview rendered to {!r}.".format(str(preview)) in [
+        rec.message for rec in caplog.records
+    ]
+
+
+def test_analyze_icon_preview_missing_icon(config, build_charm, tmp_path):
+    """Cannot preview the icon if the charm does not have one."""
+    filepath = build_charm({"metadata.yaml": "name: mycharm"})
+
+    args = Namespace(
+        filepath=filepath,
+        icon_preview=tmp_path / "preview.png",
+        interfaces_catalog=None,
+    )
+    with pytest.raises(CommandError) as cm:
+        AnalyzeCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "Cannot render the icon preview: {!r} does not include an icon.".format(
+            str(filepath)
+        )
+    )
+
+
+@pytest.mark.parametrize("from_config", [False, True])
+def test_analyze_interfaces_catalog(caplog, config, build_charm, tmp_path, from_config):
+    """The interfaces are checked if a catalog is indicated or configured."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    catalog_dir = tmp_path / "catalog"
+    (catalog_dir / "mysql").mkdir(parents=True)
+    metadata = "name: mycharm\nrequires:\n  db:\n    interface: mysql\n"
+    filepath = build_charm({"metadata.yaml": metadata})
+
+    if from_config:
+        config.set(interfaces=InterfacesConfig(catalog="catalog"))
+        interfaces_catalog = None
+    else:
+        interfaces_catalog = catalog_dir
+    args = Namespace(
+        filepath=filepath, icon_preview=None, interfaces_catalog=interfaces_catalog
+    )
+    AnalyzeCommand("group", config).run(args)
+    assert [rec.message for rec in caplog.records] == [
+        "icon: ok",
+        "containers: ok",
+        "interfaces: ok",
+    ]
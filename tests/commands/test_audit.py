@@ -0,0 +1,164 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the 'audit' command (code in commands/audit.py)."""
+
+import logging
+import zipfile
+from argparse import Namespace
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.audit import AuditCommand
+from charmcraft.config import AuditConfig
+from tests.factory import create_distribution, create_osv_record
+
+
+@pytest.fixture
+def database_dir(tmp_path):
+    """Provide a database with some vulnerabilities."""
+    dbpath = tmp_path / "osv"
+    create_osv_record(dbpath, "PYSEC-2021-1", "PyYAML", fixed="5.4", severity="high")
+    create_osv_record(dbpath, "PYSEC-2021-2", "ops", fixed="1.2.1", severity="low")
+    create_osv_record(dbpath, "PYSEC-2021-3", "requests", fixed="2.20")
+    return dbpath
+
+
+@pytest.fixture
+def built_venv(tmp_path):
+    """Provide the venv of a built charm."""
+    venvpath = tmp_path / "build" / "venv"
+    create_distribution(venvpath, "PyYAML", "5.3.1")
+    create_distribution(venvpath, "ops", "1.2.0")
+    create_distribution(venvpath, "requests", "2.25.1")
+    return venvpath
+
+
+def test_audit_built(caplog, config, database_dir, built_venv):
+    """Audit the dependencies of the last build."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    args = Namespace(filepath=None, database=database_dir, severity_threshold=None)
+    AuditCommand("group", config).run(args)
+
+    expected = [
+        "Package    Version    Vulnerability    Severity    Fixed in",
+        "ops        1.2.0      PYSEC-2021-2     low         1.2.1",
+        "pyyaml     5.3.1      PYSEC-2021-1     high        5.4",
+        "Audited 3 dependencies: found 2 vulnerabilities (0 ignored).",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_audit_charm_file(caplog, config, database_dir, tmp_path):
+    """Audit the dependencies included in a charm file."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    create_distribution(tmp_path / "charm" / "venv", "PyYAML", "5.4")
+    filepath = tmp_path / "mycharm.charm"
+    with zipfile.ZipFile(str(filepath), "w") as zf:
+        for path in (tmp_path / "charm").rglob("*"):
+            zf.write(str(path), str(path.relative_to(tmp_path / "charm")))
+
+    args = Namespace(filepath=filepath, database=database_dir, severity_threshold=None)
+    AuditCommand("group", config).run(args)
+    assert [rec.message for rec in caplog.records] == [
+        "Audited 1 dependencies: found 0 vulnerabilities (0 ignored).",
+    ]
+
+
+def test_audit_built_charm_file(caplog, config, database_dir, tmp_path):
+    """Audit the charm built in the project when there is no staging tree."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    (tmp_path / "metadata.yaml").write_text("name: mycharm")
+    create_distribution(tmp_path / "charm" / "venv", "PyYAML", "5.3.1")
+    with zipfile.ZipFile(str(tmp_path / "mycharm.charm"), "w") as zf:
+        for path in (tmp_path / "charm").rglob("*"):
+            zf.write(str(path), str(path.relative_to(tmp_path / "charm")))
+
+    args = Namespace(filepath=None, database=database_dir, severity_threshold=None)
+    AuditCommand("group", config).run(args)
+    assert [rec.message for rec in caplog.records][-1] == (
+        "Audited 1 dependencies: found 1 vulnerabilities (0 ignored)."
+    )
+
+
+def test_audit_not_a_charm(config, database_dir, tmp_path):
+    """The indicated file is not a charm."""
+    filepath = tmp_path / "something.charm"
+    filepath.write_text("not a zip")
+
+    args = Namespace(filepath=filepath, database=database_dir, severity_threshold=None)
+    with pytest.raises(CommandError) as cm:
+        AuditCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "Cannot open {!r}: it is not a charm file.".format(str(filepath))
+    )
+
+
+def test_audit_configured(caplog, config, database_dir, built_venv):
+    """Use the database, threshold and ignore list from the config."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    config.set(
+        audit=AuditConfig(
+            database="osv", severity_threshold="low", ignore=["PYSEC-2021-2"]
+        )
+    )
+
+    args = Namespace(filepath=None, database=None, severity_threshold=None)
+    with pytest.raises(CommandError) as cm:
+        AuditCommand("group", config).run(args)
+    assert str(cm.value) == "Found 1 vulnerabilities with severity 'low' or higher."
+    assert caplog.records[-1].message == (
+        "Audited 3 dependencies: found 1 vulnerabilities (1 ignored)."
+    )
+
+
+def test_audit_threshold_option(config, database_dir, built_venv):
+    """The threshold from the command line has priority over the configured one."""
+    config.set(audit=AuditConfig(severity_threshold="low"))
+
+    args = Namespace(filepath=None, database=database_dir, severity_threshold="high")
+    with pytest.raises(CommandError) as cm:
+        AuditCommand("group", config).run(args)
+    assert str(cm.value) == "Found 1 vulnerabilities with severity 'high' or higher."
+
+    args = Namespace(
+        filepath=None, database=database_dir, severity_threshold="critical"
+    )
+    AuditCommand("group", config).run(args)
+
+
+def test_audit_no_database(config, built_venv):
+    """A database must be indicated or configured."""
+    args = Namespace(filepath=None, database=None, severity_threshold=None)
+    with pytest.raises(CommandError) as cm:
+        AuditCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "No vulnerabilities database indicated: use --database or configure it in "
+        "charmcraft.yaml."
+    )
+
+
+def test_audit_not_built(config, database_dir):
+    """There is no build with dependencies to audit."""
+    args = Namespace(filepath=None, database=database_dir, severity_threshold=None)
+    with pytest.raises(CommandError) as cm:
+        AuditCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "No dependencies to audit: the project has not been built, or it has no "
+        "dependencies."
+    )
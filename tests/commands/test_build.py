@@ -19,6 +19,7 @@ import filecmp
 import logging
 import os
 import pathlib
+import shutil
 import socket
 import subprocess
 import sys
@@ -29,18 +30,38 @@ from unittest.mock import patch, call
 import pytest
 import yaml
 
+from charmcraft import cache
 from charmcraft.cmdbase import CommandError
+from charmcraft.config import (
+    Action,
+    AuditConfig,
+    Base,
+    CharmConfig,
+    CharmMetadata,
+    CharmPart,
+    ConfigOption,
+    Parts,
+    Relation,
+)
 from charmcraft.commands.build import (
     BUILD_DIRNAME,
     Builder,
-    CHARM_METADATA,
     DISPATCH_CONTENT,
     DISPATCH_FILENAME,
     VENV_DIRNAME,
+    VERSION_FILENAME,
+    PythonTarget,
     Validator,
+    get_event_hook_names,
+    get_pip_python_version,
+    get_python_target,
+    install_dependencies,
     polite_exec,
     relativise,
 )
+from charmcraft.metadata import CHARM_METADATA
+from charmcraft.utils import OSPlatform
+from tests.factory import create_distribution, create_osv_record, git
 
 
 # --- Validator tests
@@ -66,6 +87,18 @@ def test_validator_process_simple():
     assert result == dict(foo=70, bar=80)
 
 
+@pytest.mark.parametrize("staging,expected", [(None, False), (True, True)])
+def test_validator_staging(staging, expected):
+    """The staging tree is only used if requested."""
+    assert Validator().validate_staging(staging) is expected
+
+
+@pytest.mark.parametrize("no_cache,expected", [(None, False), (True, True)])
+def test_validator_no_cache(no_cache, expected):
+    """The dependencies cache is used unless requested otherwise."""
+    assert Validator().validate_no_cache(no_cache) is expected
+
+
 def test_validator_process_notpresent():
     """Process an option after not finding the value."""
 
@@ -858,6 +891,169 @@ def test_build_generics_different_filetype(tmp_path, caplog, monkeypatch, config
     assert expected in [rec.message for rec in caplog.records]
 
 
+def test_build_version_from_git(tmp_path, config):
+    """The version file is written using the git information."""
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [],
+        },
+        config,
+    )
+    with patch("charmcraft.git.is_repository", return_value=True):
+        with patch("charmcraft.git.is_dirty", return_value=False):
+            with patch("charmcraft.git.build_version") as build_version_mock:
+                build_version_mock.return_value = "v1.0-3-gabc1234"
+                builder.handle_version()
+
+    build_version_mock.assert_called_once_with(tmp_path, None)
+    assert (build_dir / VERSION_FILENAME).read_text() == "v1.0-3-gabc1234\n"
+
+
+def test_build_version_template(tmp_path, config):
+    """The configured template is used for the version."""
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+    config.set(parts=Parts(charm=CharmPart(version="{branch}-{short_commit}")))
+
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [],
+        },
+        config,
+    )
+    with patch("charmcraft.git.is_repository", return_value=True):
+        with patch("charmcraft.git.is_dirty", return_value=False):
+            with patch("charmcraft.git.build_version") as build_version_mock:
+                build_version_mock.return_value = "main-abc1234"
+                builder.handle_version()
+
+    build_version_mock.assert_called_once_with(tmp_path, "{branch}-{short_commit}")
+    assert (build_dir / VERSION_FILENAME).read_text() == "main-abc1234\n"
+
+
+def test_build_version_dirty(tmp_path, caplog, config):
+    """Warn when the version is built from a dirty tree."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [],
+        },
+        config,
+    )
+    with patch("charmcraft.git.is_repository", return_value=True):
+        with patch("charmcraft.git.is_dirty", return_value=True):
+            with patch("charmcraft.git.build_version") as build_version_mock:
+                build_version_mock.return_value = "abc1234-dirty"
+                builder.handle_version()
+
+    assert (build_dir / VERSION_FILENAME).read_text() == "abc1234-dirty\n"
+    expected = "The project has uncommitted changes, the charm version is 'abc1234-dirty'."
+    assert expected in [rec.message for rec in caplog.records]
+
+
+def test_build_version_respected(tmp_path, config):
+    """The version file provided by the project is left untouched."""
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+    version_file = build_dir / VERSION_FILENAME
+    version_file.write_text("my own version")
+
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [],
+        },
+        config,
+    )
+    with patch("charmcraft.git.build_version") as build_version_mock:
+        builder.handle_version()
+
+    build_version_mock.assert_not_called()
+    assert version_file.read_text() == "my own version"
+
+
+def test_build_version_not_a_repository(tmp_path, caplog, config):
+    """Without a git repository no version file is written."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [],
+        },
+        config,
+    )
+    with patch("charmcraft.git.is_repository", return_value=False):
+        builder.handle_version()
+
+    assert not (build_dir / VERSION_FILENAME).exists()
+    assert caplog.records == []
+
+
+def test_build_version_not_a_repository_with_template(tmp_path, caplog, config):
+    """Warn if a template is configured but the project is not in a git repository."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+    config.set(parts=Parts(charm=CharmPart(version="{describe}")))
+
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [],
+        },
+        config,
+    )
+    with patch("charmcraft.git.is_repository", return_value=False):
+        builder.handle_version()
+
+    assert not (build_dir / VERSION_FILENAME).exists()
+    expected = (
+        "Cannot build the charm version from the configured template: "
+        "the project is not in a git repository."
+    )
+    assert [expected] == [rec.message for rec in caplog.records]
+
+
+def test_build_version_repository_without_commits(tmp_path, caplog, config):
+    """Warn and skip the version file if nothing was committed in the repository."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    git(tmp_path, "init", "--quiet")
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [],
+        },
+        config,
+    )
+    builder.handle_version()
+
+    assert not (build_dir / VERSION_FILENAME).exists()
+    expected = "Cannot build the charm version: the git repository has no commits."
+    assert [expected] == [rec.message for rec in caplog.records]
+
+
 def test_build_dispatcher_modern_dispatch_created(tmp_path, config):
     """The dispatcher script is properly built."""
     build_dir = tmp_path / BUILD_DIRNAME
@@ -1001,6 +1197,98 @@ def test_build_dispatcher_classic_hooks_linking_charm_replaced(
     assert expected in [rec.message for rec in caplog.records]
 
 
+def test_event_hook_names():
+    """Get the hooks for the standard events and those of the declared items."""
+    metadata = {
+        "name": "test-charm",
+        "requires": {"db": {"interface": "pgsql"}},
+        "provides": {"website": {"interface": "http"}},
+        "peers": {"cluster": {"interface": "cluster"}},
+        "storage": {"data": {"type": "filesystem"}},
+        "containers": {"app": {"resource": "app-image"}},
+    }
+    hooknames = get_event_hook_names(metadata)
+    assert {"install", "config-changed", "update-status", "stop"} <= hooknames
+    assert {
+        "db-relation-created",
+        "db-relation-joined",
+        "db-relation-changed",
+        "db-relation-departed",
+        "db-relation-broken",
+        "website-relation-joined",
+        "cluster-relation-changed",
+        "data-storage-attached",
+        "data-storage-detaching",
+        "app-pebble-ready",
+    } <= hooknames
+    assert get_event_hook_names({"name": "test-charm"}) == get_event_hook_names(
+        {"requires": None, "storage": {}}
+    )
+
+
+def test_build_dispatcher_legacy_hooks(tmp_path, config):
+    """All the hooks and actions are created pointing to dispatch, if configured."""
+    config.set(parts=Parts(charm=CharmPart(legacy_hooks=True)))
+    (tmp_path / CHARM_METADATA).write_text(
+        "name: test-charm\nrequires:\n  db:\n    interface: pgsql\n"
+    )
+    (tmp_path / "actions.yaml").write_text("backup: {}\nrestore: {}\n")
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+
+    # the project provides one action, which is respected
+    built_actions_dir = build_dir / "actions"
+    built_actions_dir.mkdir()
+    (built_actions_dir / "restore").write_text("custom restore")
+
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [],
+        },
+        config,
+    )
+    builder.handle_dispatcher(build_dir / "somestuff.py")
+
+    included_dispatcher = build_dir / DISPATCH_FILENAME
+    hooknames = {path.name for path in (build_dir / "hooks").iterdir()}
+    assert hooknames == get_event_hook_names(
+        {"requires": {"db": {"interface": "pgsql"}}}
+    )
+    for hookname in hooknames:
+        assert (build_dir / "hooks" / hookname).resolve() == included_dispatcher
+
+    backup = built_actions_dir / "backup"
+    assert backup.is_symlink()
+    assert os.readlink(str(backup)) == os.path.join("..", DISPATCH_FILENAME)
+    assert (built_actions_dir / "restore").read_text() == "custom restore"
+
+
+def test_build_dispatcher_legacy_hooks_not_configured(tmp_path, config):
+    """Only the mandatory hooks are created by default."""
+    (tmp_path / CHARM_METADATA).write_text(
+        "name: test-charm\nrequires:\n  db:\n    interface: pgsql\n"
+    )
+    (tmp_path / "actions.yaml").write_text("backup: {}\n")
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [],
+        },
+        config,
+    )
+    builder.handle_dispatcher(build_dir / "somestuff.py")
+
+    hooknames = {path.name for path in (build_dir / "hooks").iterdir()}
+    assert hooknames == {"install", "start", "upgrade-charm"}
+    assert not (build_dir / "actions").exists()
+
+
 def test_build_dependencies_virtualenv_simple(tmp_path, config):
     """A virtualenv is created with the specified requirements file."""
     build_dir = tmp_path / BUILD_DIRNAME
@@ -1017,6 +1305,7 @@ def test_build_dependencies_virtualenv_simple(tmp_path, config):
 
     with patch("charmcraft.commands.build.subprocess.run") as mock_run:
         mock_run.return_value.returncode = 1
+        mock_run.return_value.stdout = ""
         with patch("charmcraft.commands.build.polite_exec") as mock:
             mock.return_value = 0
             builder.handle_dependencies()
@@ -1025,10 +1314,22 @@ def test_build_dependencies_virtualenv_simple(tmp_path, config):
     assert mock.mock_calls == [
         call(["pip3", "list"]),
         call(
-            ["pip3", "install", "--target={}".format(envpath), "--requirement=reqs.txt"]
+            [
+                "pip3",
+                "install",
+                "--target={}".format(envpath),
+                "--cache-dir={}".format(cache.get_pip_cache_dirpath()),
+                "--requirement=reqs.txt",
+            ]
         ),
     ]
     assert mock_run.mock_calls == [
+        call(
+            ["pip3", "--version"],
+            stdout=subprocess.PIPE,
+            stderr=subprocess.DEVNULL,
+            universal_newlines=True,
+        ),
         call(
             [
                 "python3",
@@ -1060,6 +1361,7 @@ def test_build_dependencies_needs_system(tmp_path, config):
 
     with patch("charmcraft.commands.build.subprocess.run") as mock_run:
         mock_run.return_value.returncode = 0
+        mock_run.return_value.stdout = ""
         with patch("charmcraft.commands.build.polite_exec") as mock:
             mock.return_value = 0
             builder.handle_dependencies()
@@ -1072,6 +1374,7 @@ def test_build_dependencies_needs_system(tmp_path, config):
                 "pip3",
                 "install",
                 "--target={}".format(envpath),
+                "--cache-dir={}".format(cache.get_pip_cache_dirpath()),
                 "--system",
                 "--requirement=reqs",
             ]
@@ -1095,6 +1398,7 @@ def test_build_dependencies_virtualenv_multiple(tmp_path, config):
 
     with patch("charmcraft.commands.build.subprocess.run") as mock_run:
         mock_run.return_value.returncode = 1
+        mock_run.return_value.stdout = ""
         with patch("charmcraft.commands.build.polite_exec") as mock:
             mock.return_value = 0
             builder.handle_dependencies()
@@ -1107,6 +1411,7 @@ def test_build_dependencies_virtualenv_multiple(tmp_path, config):
                 "pip3",
                 "install",
                 "--target={}".format(envpath),
+                "--cache-dir={}".format(cache.get_pip_cache_dirpath()),
                 "--requirement=reqs1.txt",
                 "--requirement=reqs2.txt",
             ]
@@ -1150,6 +1455,7 @@ def test_build_dependencies_virtualenv_error_basicpip(tmp_path, config):
 
     with patch("charmcraft.commands.build.subprocess.run") as mock_run:
         mock_run.return_value.returncode = 1
+        mock_run.return_value.stdout = ""
         with patch("charmcraft.commands.build.polite_exec") as mock:
             mock.return_value = -7
             with pytest.raises(CommandError, match="problems using pip"):
@@ -1172,12 +1478,341 @@ def test_build_dependencies_virtualenv_error_installing(tmp_path, config):
 
     with patch("charmcraft.commands.build.subprocess.run") as mock_run:
         mock_run.return_value.returncode = 1
+        mock_run.return_value.stdout = ""
         with patch("charmcraft.commands.build.polite_exec") as mock:
             mock.side_effect = [0, -7]
             with pytest.raises(CommandError, match="problems installing dependencies"):
                 builder.handle_dependencies()
 
 
+def _fake_pip_install(cmd):
+    """Fake pip installing something in the target directory."""
+    if cmd[1] == "install":
+        venvpath = pathlib.Path(cmd[2].split("=", 1)[1])
+        (venvpath / "ops").mkdir(parents=True)
+        (venvpath / "ops" / "__init__.py").write_text("# the framework")
+    return 0
+
+
+def test_build_dependencies_cached(tmp_path, config):
+    """The installed dependencies are stored in the cache and reused later."""
+    reqs = tmp_path / "reqs.txt"
+    reqs.write_text("ops==1.2.0")
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+    builder = Builder(
+        {"from": tmp_path, "entrypoint": "whatever", "requirement": [reqs]}, config
+    )
+
+    with patch("charmcraft.commands.build.get_pip_python_version", return_value="3.8"):
+        with patch("charmcraft.commands.build._pip_needs_system", return_value=False):
+            with patch("charmcraft.commands.build.polite_exec") as mock:
+                mock.side_effect = _fake_pip_install
+                builder.handle_dependencies()
+
+                # a new build with the same requirements does not install them
+                shutil.rmtree(str(build_dir))
+                builder.handle_dependencies()
+
+    assert len(mock.mock_calls) == 2
+    installed = build_dir / VENV_DIRNAME / "ops" / "__init__.py"
+    assert installed.read_text() == "# the framework"
+    (cached,) = (cache.get_cache_dirpath() / cache.VENVS_DIRNAME).iterdir()
+    assert installed.stat().st_ino == (cached / "ops" / "__init__.py").stat().st_ino
+
+
+def test_build_dependencies_cached_other_platform(tmp_path, config):
+    """The installed dependencies are not reused for other Python."""
+    reqs = tmp_path / "reqs.txt"
+    reqs.write_text("ops==1.2.0")
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+    builder = Builder(
+        {"from": tmp_path, "entrypoint": "whatever", "requirement": [reqs]}, config
+    )
+
+    with patch("charmcraft.commands.build.get_pip_python_version") as version_mock:
+        version_mock.side_effect = ["3.8", "3.10"]
+        with patch("charmcraft.commands.build._pip_needs_system", return_value=False):
+            with patch("charmcraft.commands.build.polite_exec") as mock:
+                mock.side_effect = _fake_pip_install
+                builder.handle_dependencies()
+                shutil.rmtree(str(build_dir))
+                builder.handle_dependencies()
+
+    assert len(mock.mock_calls) == 4
+    assert len(list((cache.get_cache_dirpath() / cache.VENVS_DIRNAME).iterdir())) == 2
+
+
+@pytest.mark.parametrize(
+    "requirements, no_cache",
+    [
+        ("ops\n", False),
+        ("./vendor/ops\n", False),
+        ("-e ./vendor/ops\n", False),
+        ("ops==1.2.0\n", True),
+    ],
+)
+def test_build_dependencies_not_cached(tmp_path, config, requirements, no_cache):
+    """The dependencies are always installed if not pinned, or if requested."""
+    reqs = tmp_path / "reqs.txt"
+    reqs.write_text(requirements)
+    build_dir = tmp_path / BUILD_DIRNAME
+    build_dir.mkdir()
+    builder = Builder(
+        {
+            "from": tmp_path,
+            "entrypoint": "whatever",
+            "requirement": [reqs],
+            "no_cache": no_cache,
+        },
+        config,
+    )
+
+    with patch("charmcraft.commands.build.get_pip_python_version", return_value="3.8"):
+        with patch("charmcraft.commands.build._pip_needs_system", return_value=False):
+            with patch("charmcraft.commands.build.polite_exec") as mock:
+                mock.side_effect = _fake_pip_install
+                builder.handle_dependencies()
+                shutil.rmtree(str(build_dir))
+                builder.handle_dependencies()
+
+    assert len(mock.mock_calls) == 4
+    installed = build_dir / VENV_DIRNAME / "ops" / "__init__.py"
+    assert installed.read_text() == "# the framework"
+    assert not (cache.get_cache_dirpath() / cache.VENVS_DIRNAME).exists()
+
+
+def _target_config(config, channel="20.04"):
+    """Set the base where the charm will run in the config."""
+    config.set(parts=Parts(charm=CharmPart(base=Base(name="ubuntu", channel=channel))))
+
+
+def test_python_target_no_base(config):
+    """Nothing to target if no base is configured."""
+    assert get_python_target(config) is None
+
+
+def test_python_target_for_base(config):
+    """The Python target is derived from the configured base."""
+    _target_config(config, "20.04")
+    os_platform = OSPlatform(system="ubuntu", release="22.04", machine="x86_64")
+    with patch("charmcraft.commands.build.get_os_platform", return_value=os_platform):
+        target = get_python_target(config)
+
+    assert target.version == "3.8"
+    assert target.abi == "cp38"
+    assert target.platforms[0] == "manylinux_2_31_x86_64"
+    assert target.platforms[-2:] == ["manylinux_2_18_x86_64", "manylinux2014_x86_64"]
+    assert len(target.platforms) == 15
+
+
+def test_python_target_for_base_pymalloc_abi(config):
+    """The ABI for Pythons before 3.8 includes the pymalloc flag."""
+    _target_config(config, "18.04")
+    os_platform = OSPlatform(system="ubuntu", release="20.04", machine="aarch64")
+    with patch("charmcraft.commands.build.get_os_platform", return_value=os_platform):
+        target = get_python_target(config)
+
+    assert target.version == "3.6"
+    assert target.abi == "cp36m"
+    assert target.platforms[0] == "manylinux_2_27_aarch64"
+
+
+@pytest.mark.parametrize(
+    "returncode,output,expected",
+    [
+        (0, "pip 20.0.2 from /usr/lib/python3/dist-packages/pip (python 3.8)\n", "3.8"),
+        (0, "pip 22.0.2 from /usr/lib/python3/dist-packages/pip (python 3.10)", "3.10"),
+        (0, "something weird", None),
+        (1, "", None),
+    ],
+)
+def test_pip_python_version(returncode, output, expected):
+    """Get the Python version pip runs with."""
+    with patch("charmcraft.commands.build.subprocess.run") as mock_run:
+        mock_run.return_value.returncode = returncode
+        mock_run.return_value.stdout = output
+        assert get_pip_python_version(["pip3"]) == expected
+    mock_run.assert_called_once_with(
+        ["pip3", "--version"],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        universal_newlines=True,
+    )
+
+
+def _install_for_target(tmp_path, pip_versions, interpreter=None, retcodes=(0, 0)):
+    """Install the dependencies for Python 3.8, faking the available pips."""
+    target = PythonTarget(version="3.8", abi="cp38", platforms=["p1", "p2"])
+    with patch("charmcraft.commands.build.get_pip_python_version") as version_mock:
+        version_mock.side_effect = pip_versions
+        with patch("shutil.which", return_value=interpreter):
+            with patch("charmcraft.commands.build._pip_needs_system") as system_mock:
+                system_mock.return_value = False
+                with patch("charmcraft.commands.build.polite_exec") as mock:
+                    mock.side_effect = retcodes
+                    install_dependencies(["reqs.txt"], tmp_path, target)
+    return mock
+
+
+def test_install_dependencies_target_same_python(tmp_path):
+    """The pip in the system already runs with the target Python."""
+    mock = _install_for_target(tmp_path, ["3.8"])
+    assert mock.mock_calls == [
+        call(["pip3", "list"]),
+        call(
+            [
+                "pip3",
+                "install",
+                "--target={}".format(tmp_path),
+                "--requirement=reqs.txt",
+            ]
+        ),
+    ]
+
+
+def test_install_dependencies_target_interpreter(tmp_path):
+    """A Python matching the target is available in the system, its pip is used."""
+    mock = _install_for_target(tmp_path, ["3.10", "3.8"], "/usr/bin/python3.8")
+    pip_cmd = ["/usr/bin/python3.8", "-m", "pip"]
+    assert mock.mock_calls == [
+        call(pip_cmd + ["list"]),
+        call(
+            pip_cmd
+            + [
+                "install",
+                "--target={}".format(tmp_path),
+                "--requirement=reqs.txt",
+            ]
+        ),
+    ]
+
+
+def test_install_dependencies_target_wheels(tmp_path):
+    """No Python matching the target is available, pip gets the wheels for it."""
+    mock = _install_for_target(tmp_path, ["3.10"])
+    assert mock.mock_calls == [
+        call(["pip3", "list"]),
+        call(
+            [
+                "pip3",
+                "install",
+                "--target={}".format(tmp_path),
+                "--python-version=3.8",
+                "--implementation=cp",
+                "--abi=cp38",
+                "--only-binary=:all:",
+                "--platform=p1",
+                "--platform=p2",
+                "--requirement=reqs.txt",
+            ]
+        ),
+    ]
+
+
+def test_install_dependencies_target_wheels_missing(tmp_path):
+    """Compatible wheels are not available for some dependencies."""
+    with pytest.raises(CommandError) as cm:
+        _install_for_target(tmp_path, ["3.10", None], "/usr/bin/python3.8", (0, 1))
+    assert str(cm.value) == (
+        "problems installing dependencies: Python 3.8 is not available in this "
+        "system, so all the dependencies need to be provided as wheels compatible "
+        "with it, which do not exist for some of them (see above)."
+    )
+
+
+def _setup_audit(tmp_path, config, **audit_config):
+    """Prepare a builder with an installed dependency and a configured database."""
+    build_dir = tmp_path / BUILD_DIRNAME
+    venvpath = build_dir / VENV_DIRNAME
+    create_distribution(venvpath, "PyYAML", "5.3.1")
+    create_osv_record(
+        tmp_path / "osv", "PYSEC-2021-1", "PyYAML", fixed="5.4", severity="high"
+    )
+    create_osv_record(tmp_path / "osv", "PYSEC-2021-2", "PyYAML", severity="low")
+    config.set(audit=AuditConfig(database="osv", **audit_config))
+    return Builder(
+        {"from": tmp_path, "entrypoint": "whatever", "requirement": []}, config
+    )
+
+
+def test_build_audit_warnings(tmp_path, caplog, config):
+    """The vulnerabilities found in the dependencies are reported."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    builder = _setup_audit(tmp_path, config)
+    builder.handle_audit()
+    assert [rec.message for rec in caplog.records] == [
+        "The dependency pyyaml 5.3.1 is affected by PYSEC-2021-1 (severity: high, "
+        "fixed in: 5.4).",
+        "The dependency pyyaml 5.3.1 is affected by PYSEC-2021-2 (severity: low, "
+        "fixed in: -).",
+    ]
+
+
+def test_build_audit_threshold(tmp_path, config):
+    """The build fails if a vulnerability reaches the configured threshold."""
+    builder = _setup_audit(tmp_path, config, severity_threshold="medium")
+    with pytest.raises(CommandError) as cm:
+        builder.handle_audit()
+    assert str(cm.value) == (
+        "Found 1 vulnerabilities with severity 'medium' or higher in the charm's "
+        "dependencies: PYSEC-2021-1."
+    )
+
+
+def test_build_audit_threshold_unknown_severity(tmp_path, caplog, config):
+    """A vulnerability of unknown severity fails the build, with a warning."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    builder = _setup_audit(tmp_path, config, severity_threshold="critical")
+    create_osv_record(tmp_path / "osv", "PYSEC-2021-3", "PyYAML")
+    with pytest.raises(CommandError) as cm:
+        builder.handle_audit()
+    assert str(cm.value) == (
+        "Found 1 vulnerabilities with severity 'critical' or higher in the charm's "
+        "dependencies: PYSEC-2021-3."
+    )
+    assert caplog.records[-1].message == (
+        "The severity of PYSEC-2021-3 is unknown, so it is considered to reach the "
+        "threshold; if it is not relevant for the charm, add it to the 'ignore' list "
+        "of the 'audit' configuration."
+    )
+
+
+def test_build_audit_threshold_ignored(tmp_path, caplog, config):
+    """The ignored vulnerabilities do not fail the build."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    builder = _setup_audit(
+        tmp_path, config, severity_threshold="medium", ignore=["PYSEC-2021-1"]
+    )
+    builder.handle_audit()
+    assert [rec.message for rec in caplog.records] == [
+        "The dependency pyyaml 5.3.1 is affected by PYSEC-2021-2 (severity: low, "
+        "fixed in: -).",
+    ]
+
+
+def test_build_audit_not_configured(tmp_path, config):
+    """Nothing is audited if there is no database configured."""
+    builder = _setup_audit(tmp_path, config)
+    config.set(audit=AuditConfig())
+    with patch("charmcraft.audit.audit") as audit_mock:
+        builder.handle_audit()
+    audit_mock.assert_not_called()
+
+
+def test_build_audit_no_dependencies(tmp_path, config):
+    """Nothing is audited if the charm has no dependencies."""
+    create_osv_record(tmp_path / "osv", "PYSEC-2021-1", "PyYAML", severity="high")
+    config.set(audit=AuditConfig(database="osv", severity_threshold="low"))
+    builder = Builder(
+        {"from": tmp_path, "entrypoint": "whatever", "requirement": []}, config
+    )
+    with patch("charmcraft.audit.audit") as audit_mock:
+        builder.handle_audit()
+    audit_mock.assert_not_called()
+
+
 def test_build_package_tree_structure(tmp_path, monkeypatch, config):
     """The zip file is properly built internally."""
     # the metadata
@@ -1318,6 +1953,236 @@ def test_builder_with_jujuignore(tmp_path, config):
     assert not ignore.match("myfile.c", is_dir=False)
 
 
+# --- tests for the streamed package
+
+
+def _create_project(dirpath):
+    """Create a project with files from all the sources the package may have."""
+    dirpath.mkdir()
+    (dirpath / "metadata.yaml").write_text("name: test-charm\nrequires:\n  db: {}\n")
+    (dirpath / "actions.yaml").write_text("backup: {}\nrestore: {}\n")
+    (dirpath / "requirements.txt").write_text("ops\n")
+    (dirpath / "version").write_text("1.2.3\n")
+    (dirpath / ".jujuignore").write_text("*.txt\n")
+
+    entrypoint = dirpath / "src" / "charm.py"
+    entrypoint.parent.mkdir()
+    entrypoint.write_text("all the magic")
+    entrypoint.chmod(0o755)
+
+    ops_dir = dirpath / "lib" / "ops"
+    ops_dir.mkdir(parents=True)
+    (ops_dir / "stuff.py").write_text("ops stuff")
+    (ops_dir / "notes.txt").write_text("ignored")
+    (dirpath / "linkedlib").symlink_to("lib")
+    (dirpath / "linkedfile").symlink_to("src/charm.py")
+
+    (dirpath / "hooks").mkdir()
+    (dirpath / "hooks" / "install").symlink_to("../src/charm.py")
+    (dirpath / "hooks" / "start").write_text("custom start")
+    (dirpath / "actions").mkdir()
+    (dirpath / "actions" / "backup").write_text("custom backup")
+    return entrypoint
+
+
+def _build_project(dirpath, config, entrypoint, staging):
+    """Build the project, faking the dependencies installation; return the package."""
+    builder = Builder(
+        {
+            "from": dirpath,
+            "entrypoint": entrypoint,
+            "requirement": [dirpath / "requirements.txt"],
+            "staging": staging,
+        },
+        config,
+    )
+    with patch("charmcraft.commands.build.get_pip_python_version", return_value="3.8"):
+        with patch("charmcraft.commands.build._pip_needs_system", return_value=False):
+            with patch("charmcraft.commands.build.polite_exec") as mock:
+                mock.side_effect = _fake_pip_install
+                zipname = builder.run()
+
+    with zipfile.ZipFile(zipname) as zf:
+        return {info.filename: (zf.read(info), info) for info in zf.infolist()}
+
+
+def test_build_streamed_same_as_staged(tmp_path, monkeypatch, config):
+    """The streamed package has the same content than the one built from staging."""
+    config.set(parts=Parts(charm=CharmPart(legacy_hooks=True)))
+    project_dir = tmp_path / "project"
+    entrypoint = _create_project(project_dir)
+    monkeypatch.chdir(tmp_path)
+
+    streamed = _build_project(project_dir, config, entrypoint, staging=False)
+    assert not (project_dir / BUILD_DIRNAME).exists()
+    staged = _build_project(project_dir, config, entrypoint, staging=True)
+    assert (project_dir / BUILD_DIRNAME / "dispatch").exists()
+
+    def get_contents(package):
+        return {name: content for name, (content, _) in package.items()}
+
+    contents = get_contents(streamed)
+    assert contents == get_contents(staged)
+
+    dispatch = DISPATCH_CONTENT.format(entrypoint_relative_path="src/charm.py")
+    assert contents["dispatch"] == dispatch.encode("ascii")
+    assert contents["src/charm.py"] == b"all the magic"
+    assert contents["lib/ops/stuff.py"] == b"ops stuff"
+    assert contents["linkedlib/ops/stuff.py"] == b"ops stuff"
+    assert contents["linkedfile"] == b"all the magic"
+    assert contents["version"] == b"1.2.3\n"
+    assert contents["hooks/install"] == dispatch.encode("ascii")
+    assert contents["hooks/start"] == b"custom start"
+    assert contents["hooks/db-relation-joined"] == dispatch.encode("ascii")
+    assert contents["actions/backup"] == b"custom backup"
+    assert contents["actions/restore"] == dispatch.encode("ascii")
+    assert contents["venv/ops/__init__.py"] == b"# the framework"
+    assert "lib/ops/notes.txt" not in contents
+    assert "linkedlib/ops/notes.txt" not in contents
+    manifest = yaml.safe_load(contents["manifest.yaml"])
+    assert manifest["charmcraft-started-at"] == (
+        config.project.started_at.isoformat() + "Z"
+    )
+
+
+@pytest.mark.parametrize("staging", [False, True])
+def test_build_charm_sections_in_config(tmp_path, monkeypatch, config, staging):
+    """The metadata, actions and config declared in charmcraft.yaml are generated."""
+    config.set(
+        parts=Parts(charm=CharmPart(legacy_hooks=True)),
+        metadata=CharmMetadata(
+            name="config-charm", peers={"cluster": Relation(interface="cluster")}
+        ),
+        actions={"snapshot": Action(description="Take a snapshot.")},
+        charm_config=CharmConfig(options={"debug": ConfigOption(type="boolean")}),
+    )
+    project_dir = tmp_path / "project"
+    entrypoint = _create_project(project_dir)
+    (project_dir / "metadata.yaml").unlink()
+    (project_dir / "actions.yaml").unlink()
+    monkeypatch.chdir(tmp_path)
+
+    package = _build_project(project_dir, config, entrypoint, staging=staging)
+    assert (tmp_path / "config-charm.charm").exists()
+    contents = {name: content for name, (content, _) in package.items()}
+    assert yaml.safe_load(contents["metadata.yaml"]) == {
+        "name": "config-charm",
+        "peers": {"cluster": {"interface": "cluster"}},
+    }
+    assert yaml.safe_load(contents["actions.yaml"]) == {
+        "snapshot": {"description": "Take a snapshot."}
+    }
+    assert yaml.safe_load(contents["config.yaml"]) == {
+        "options": {"debug": {"type": "boolean"}}
+    }
+
+    # the legacy hooks and actions are those declared in charmcraft.yaml
+    dispatch = DISPATCH_CONTENT.format(entrypoint_relative_path="src/charm.py")
+    assert contents["hooks/cluster-relation-joined"] == dispatch.encode("ascii")
+    assert "hooks/db-relation-joined" not in contents
+    assert contents["actions/snapshot"] == dispatch.encode("ascii")
+    assert "actions/restore" not in contents
+
+
+@pytest.mark.parametrize("staging", [False, True])
+def test_build_charm_sections_also_in_files(tmp_path, monkeypatch, config, staging):
+    """The metadata cannot be in charmcraft.yaml and in its file at the same time."""
+    config.set(metadata=CharmMetadata(name="config-charm"))
+    project_dir = tmp_path / "project"
+    entrypoint = _create_project(project_dir)
+    monkeypatch.chdir(tmp_path)
+
+    with pytest.raises(CommandError) as cm:
+        _build_project(project_dir, config, entrypoint, staging=staging)
+    assert str(cm.value) == (
+        "Cannot generate 'metadata.yaml': its content is declared in charmcraft.yaml "
+        "but the project also has the file; remove one of them."
+    )
+
+
+@pytest.mark.parametrize("staging", [False, True])
+def test_build_not_deployable(tmp_path, monkeypatch, config, staging):
+    """The built package is verified, failing if it could not be deployed."""
+    project_dir = tmp_path / "project"
+    entrypoint = _create_project(project_dir)
+    (project_dir / "dispatch").write_text("custom dispatch")
+    (project_dir / "dispatch").chmod(0o644)
+    monkeypatch.chdir(tmp_path)
+
+    with pytest.raises(CommandError) as cm:
+        _build_project(project_dir, config, entrypoint, staging=staging)
+    assert str(cm.value) == (
+        "The packed charm 'test-charm.charm' is not deployable: "
+        "The 'dispatch' file is not executable."
+    )
+
+
+def test_build_streamed_generated_modes(tmp_path, monkeypatch, config):
+    """The generated dispatch script is executable, the rest of generated files not."""
+    project_dir = tmp_path / "project"
+    entrypoint = _create_project(project_dir)
+    (project_dir / "version").unlink()
+    monkeypatch.chdir(tmp_path)
+
+    package = _build_project(project_dir, config, entrypoint, staging=False)
+    _, dispatch_info = package["dispatch"]
+    assert dispatch_info.external_attr >> 16 == 0o100755
+    _, manifest_info = package["manifest.yaml"]
+    assert manifest_info.external_attr >> 16 == 0o100644
+    assert "version" not in package
+
+
+def test_build_streamed_cached_dependencies(tmp_path, monkeypatch, config):
+    """The cached dependencies are included directly from the cache."""
+    project_dir = tmp_path / "project"
+    entrypoint = _create_project(project_dir)
+    (project_dir / "requirements.txt").write_text("ops==1.2.0\n")
+    monkeypatch.chdir(tmp_path)
+
+    _build_project(project_dir, config, entrypoint, staging=False)
+    with patch("charmcraft.cache.link_tree") as link_mock:
+        with patch("charmcraft.commands.build.install_dependencies") as install_mock:
+            package = _build_project(project_dir, config, entrypoint, staging=False)
+    link_mock.assert_not_called()
+    install_mock.assert_not_called()
+    content, _ = package["venv/ops/__init__.py"]
+    assert content == b"# the framework"
+
+
+def test_build_streamed_symlinks_not_followed(tmp_path, monkeypatch, caplog, config):
+    """The symlinks to ignored files or creating loops are not followed."""
+    caplog.set_level(logging.DEBUG, logger="charmcraft.commands")
+    project_dir = tmp_path / "project"
+    entrypoint = _create_project(project_dir)
+    (project_dir / "secret.txt").write_text("the secret")
+    (project_dir / "public").symlink_to("secret.txt")
+    (project_dir / "lib" / "ops" / "loop").symlink_to("..")
+    monkeypatch.chdir(tmp_path)
+
+    package = _build_project(project_dir, config, entrypoint, staging=False)
+    assert "public" not in package
+    assert not any("loop" in name for name in package)
+    messages = [rec.message for rec in caplog.records]
+    assert "Ignoring symlink because of its target: 'public'" in messages
+    assert "Ignoring symlink as it creates a loop: 'lib/ops/loop'" in messages
+
+
+@pytest.mark.parametrize("staging", [True, False])
+def test_build_manifest_in_project(tmp_path, monkeypatch, config, staging):
+    """The project cannot provide its own manifest, staged or streamed."""
+    project_dir = tmp_path / "project"
+    entrypoint = _create_project(project_dir)
+    (project_dir / "manifest.yaml").write_text("whatever: true")
+    monkeypatch.chdir(tmp_path)
+
+    expected_msg = (
+        "Cannot write the manifest as there is already a 'manifest.yaml' in disk."
+    )
+    with pytest.raises(CommandError) as cm:
+        _build_project(project_dir, config, entrypoint, staging=staging)
+    assert str(cm.value) == expected_msg
+
+
 # --- tests for relativise helper
 
 
@@ -0,0 +1,87 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the 'cache' command (code in commands/cache.py)."""
+
+import datetime
+import logging
+from argparse import ArgumentParser, Namespace
+from unittest.mock import patch
+
+from charmcraft import cache
+from charmcraft.commands.cache import CacheCommand
+
+
+def test_info(caplog, config):
+    """Show the location and usage of the cache."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    usage = [
+        cache.SectionUsage("pip", "Downloads and built wheels", None, 7, 12345678),
+        cache.SectionUsage("venvs", "Installed dependencies", None, 2, 2345678),
+        cache.SectionUsage("store", "Store responses", None, 0, 0),
+    ]
+
+    args = Namespace(action="info", older_than=None, all=False)
+    with patch("charmcraft.cache.get_usage", return_value=usage):
+        CacheCommand("group", config).run(args)
+
+    assert [rec.message for rec in caplog.records] == [
+        "Cache directory: {}".format(cache.get_cache_dirpath()),
+        "Content                     Directory      Entries    Size",
+        "Downloads and built wheels  pip                  7   11.8M",
+        "Installed dependencies      venvs                2    2.2M",
+        "Store responses             store                0      0B",
+        "Total size: 14.0M",
+    ]
+
+
+def test_prune_old(caplog, config):
+    """Prune the installed dependencies not used in some days."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    older_than = datetime.timedelta(days=15)
+    args = Namespace(action="prune", older_than=older_than, all=False)
+    with patch("charmcraft.cache.prune", return_value=(3, 3000000)) as prune_mock:
+        CacheCommand("group", config).run(args)
+
+    prune_mock.assert_called_once_with(older_than)
+    assert [rec.message for rec in caplog.records] == [
+        "Removed 3 installed dependencies sets not used in the last 15 days, "
+        "freeing 2.9M.",
+    ]
+
+
+def test_prune_all(caplog, config):
+    """Prune everything in the cache."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    args = Namespace(action="prune", older_than=None, all=True)
+    with patch("charmcraft.cache.prune", return_value=(2, 1024)) as prune_mock:
+        CacheCommand("group", config).run(args)
+
+    prune_mock.assert_called_once_with()
+    assert [rec.message for rec in caplog.records] == [
+        "Removed everything from the cache, freeing 1.0K.",
+    ]
+
+
+def test_parser_defaults(config):
+    """The default age to prune."""
+    parser = ArgumentParser()
+    CacheCommand("group", config).fill_parser(parser)
+    args = parser.parse_args(["prune"])
+    assert args.older_than == datetime.timedelta(days=30)
+    assert args.all is False
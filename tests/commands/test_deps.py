@@ -0,0 +1,138 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the 'deps' command (code in commands/deps.py)."""
+
+import logging
+from argparse import Namespace
+from unittest.mock import patch
+
+import pytest
+
+from charmcraft import cache
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.deps import DepsCommand
+from tests.factory import create_distribution
+
+
+def fake_install(requirement_paths, venvpath, target, cache_dirpath):
+    """Fake the dependencies installation, as pip would do it."""
+    create_distribution(venvpath, "ops", "1.2.0", requires=["PyYAML"])
+    create_distribution(venvpath, "PyYAML", "5.4.1")
+    create_distribution(venvpath, "requests", "2.25.1", requires=["urllib3", "idna"])
+    create_distribution(venvpath, "idna", "2.10")
+    create_distribution(venvpath, "urllib3", "1.26.4", requires=["idna"])
+
+
+@pytest.fixture
+def requirements(tmp_path):
+    """Provide the project's requirements file."""
+    filepath = tmp_path / "requirements.txt"
+    filepath.write_text("ops\nrequests\n")
+    return filepath
+
+
+def _run(config, requirement=None, why=None):
+    """Run the command with the installation faked."""
+    args = Namespace(requirement=requirement, why=why)
+    with patch("charmcraft.commands.deps.install_dependencies") as install_mock:
+        install_mock.side_effect = fake_install
+        DepsCommand("group", config).run(args)
+    return install_mock
+
+
+def test_tree(caplog, config, requirements):
+    """Show the tree of the dependencies from the project's requirements."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    install_mock = _run(config)
+
+    (requirement_paths, venvpath, target), kwargs = install_mock.call_args
+    assert requirement_paths == [requirements]
+    assert venvpath.name == "venv"
+    assert target is None
+    assert kwargs == {"cache_dirpath": cache.get_pip_cache_dirpath()}
+    assert [rec.message for rec in caplog.records] == [
+        "ops 1.2.0 (direct)",
+        "  pyyaml 5.4.1 (transitive)",
+        "requests 2.25.1 (direct)",
+        "  idna 2.10 (transitive)",
+        "  urllib3 1.26.4 (transitive)",
+        "    idna 2.10 (transitive)",
+    ]
+
+
+def test_tree_indicated_requirements(caplog, config, requirements, tmp_path):
+    """Use the indicated requirements files."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    other = tmp_path / "other.txt"
+    other.write_text("urllib3\n")
+
+    install_mock = _run(config, requirement=[other])
+
+    (requirement_paths, _, _), _ = install_mock.call_args
+    assert requirement_paths == [other]
+    assert [rec.message for rec in caplog.records] == [
+        "urllib3 1.26.4 (direct)",
+        "  idna 2.10 (transitive)",
+        "ops 1.2.0 (transitive)",
+        "  pyyaml 5.4.1 (transitive)",
+        "requests 2.25.1 (transitive)",
+        "  idna 2.10 (transitive)",
+        "  urllib3 1.26.4 (direct, see above)",
+    ]
+
+
+def test_why(caplog, config, requirements):
+    """Show the chains that pulled in a package."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    _run(config, why="IDNA")
+
+    assert [rec.message for rec in caplog.records] == [
+        "idna 2.10 is pulled in by:",
+        "- requirements.txt -> requests 2.25.1 -> idna 2.10",
+        "- requirements.txt -> requests 2.25.1 -> urllib3 1.26.4 -> idna 2.10",
+    ]
+
+
+def test_why_direct(caplog, config, requirements):
+    """A direct requirement is pulled in by the requirements file."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    _run(config, why="ops")
+
+    assert [rec.message for rec in caplog.records] == [
+        "ops 1.2.0 is pulled in by:",
+        "- requirements.txt -> ops 1.2.0",
+    ]
+
+
+def test_why_not_installed(config, requirements):
+    """The package is not a dependency of the charm."""
+    with pytest.raises(CommandError) as cm:
+        _run(config, why="django")
+    assert str(cm.value) == "The package 'django' is not among the charm's dependencies."
+
+
+def test_no_requirements(config):
+    """There is nothing to resolve."""
+    with pytest.raises(CommandError) as cm:
+        _run(config)
+    assert str(cm.value) == (
+        "No requirements to resolve: use --requirement or provide a "
+        "'requirements.txt' file."
+    )
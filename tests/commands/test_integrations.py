@@ -0,0 +1,125 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the 'integrations' command (code in commands/integrations.py)."""
+
+import json
+import logging
+from argparse import Namespace
+from textwrap import dedent
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.integrations import IntegrationsCommand
+
+
+@pytest.fixture
+def repo(tmp_path):
+    """Provide a directory with some charms."""
+    for name, content in [
+        (
+            "db",
+            """
+            name: db
+            provides:
+              database:
+                interface: mysql
+            """,
+        ),
+        (
+            "web",
+            """
+            name: web
+            provides:
+              website:
+                interface: http
+            requires:
+              db:
+                interface: mysql
+              logs:
+                interface: syslog
+            """,
+        ),
+    ]:
+        dirpath = tmp_path / "charms" / name
+        dirpath.mkdir(parents=True)
+        (dirpath / "metadata.yaml").write_text(dedent(content))
+    return tmp_path
+
+
+def test_table(caplog, config, repo):
+    """Show the matrix and the unmatched interfaces."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    args = Namespace(directory=repo, format="table", output=None)
+    IntegrationsCommand("group", config).run(args)
+
+    expected = [
+        "Requirer \\ Provider    db     web",
+        "db                     -      -",
+        "web                    mysql  -",
+        "Interfaces only provided (no requirer in the charms):",
+        "- http: web:website",
+        "Interfaces only required (no provider in the charms):",
+        "- syslog: web:logs",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_default_directory(caplog, config, repo):
+    """Find the charms in the project directory if not indicated."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    assert config.project.dirpath == repo
+
+    args = Namespace(directory=None, format="json", output=None)
+    IntegrationsCommand("group", config).run(args)
+
+    content = json.loads("\n".join(rec.message for rec in caplog.records))
+    assert sorted(content["charms"]) == ["db", "web"]
+
+
+def test_output_file(caplog, config, repo, tmp_path):
+    """Write the result to a file."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    output = tmp_path / "charms.dot"
+
+    args = Namespace(directory=repo, format="dot", output=output)
+    IntegrationsCommand("group", config).run(args)
+
+    content = output.read_text()
+    assert content.startswith("digraph integrations {\n")
+    assert '    "web" -> "db" [label="mysql (db -> database)"];\n' in content
+    assert [rec.message for rec in caplog.records] == [
+        "Wrote the integrations of 2 charm(s) to {!r}.".format(str(output))
+    ]
+
+
+def test_no_charms(config, tmp_path):
+    """There are no charms in the directory."""
+    args = Namespace(directory=tmp_path, format="table", output=None)
+    with pytest.raises(CommandError) as cm:
+        IntegrationsCommand("group", config).run(args)
+    assert str(cm.value) == "No charms found in {!r}.".format(str(tmp_path))
+
+
+def test_missing_directory(config, tmp_path):
+    """The indicated directory does not exist."""
+    missing = tmp_path / "missing"
+    args = Namespace(directory=missing, format="table", output=None)
+    with pytest.raises(CommandError) as cm:
+        IntegrationsCommand("group", config).run(args)
+    assert str(cm.value) == "Cannot access directory {!r}.".format(str(missing))
@@ -0,0 +1,148 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the 'interfaces' command (code in commands/interfaces.py)."""
+
+import json
+import logging
+from argparse import Namespace
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.interfaces import InterfacesCommand
+from charmcraft.config import InterfacesConfig
+
+PROVIDER_SCHEMA = {"type": "object", "required": ["host"]}
+REQUIRER_SCHEMA = {"type": "object", "required": ["database"]}
+
+
+@pytest.fixture
+def catalog_dir(tmp_path):
+    """Provide a catalog with some interfaces."""
+    basedir = tmp_path / "catalog"
+    for name, version, schemas in [
+        ("mysql", 0, {}),
+        ("mysql", 1, {"provider": PROVIDER_SCHEMA, "requirer": REQUIRER_SCHEMA}),
+        ("http", 0, {"provider": PROVIDER_SCHEMA}),
+        ("ingress", None, {}),
+    ]:
+        interface_dir = basedir / name
+        interface_dir.mkdir(parents=True, exist_ok=True)
+        if version is not None:
+            version_dir = interface_dir / "v{}".format(version)
+            version_dir.mkdir()
+            for role, schema in schemas.items():
+                (version_dir / (role + ".json")).write_text(json.dumps(schema))
+    return basedir
+
+
+@pytest.fixture
+def charm_metadata(tmp_path):
+    """Provide a charm's metadata using some interfaces."""
+    (tmp_path / "metadata.yaml").write_text(
+        """
+        name: test-charm
+        provides:
+          web:
+            interface: http
+          admin:
+            interface: http
+        requires:
+          db:
+            interface: mysql
+          queue:
+            interface: kafka
+        """
+    )
+
+
+def test_list(caplog, config, catalog_dir, charm_metadata):
+    """List the interfaces in the catalog, with those used by the charm."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    args = Namespace(catalog=catalog_dir, export=None)
+    InterfacesCommand("group", config).run(args)
+
+    expected = [
+        "Interface    Versions    Schemas             Used in",
+        "http         v0          provider            web (provides), admin (provides)",
+        "ingress      -           -                   -",
+        "mysql        v0, v1      provider, requirer  db (requires)",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_list_empty_catalog(caplog, config, tmp_path):
+    """The catalog has no interfaces."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    catalog_dir = tmp_path / "catalog"
+    catalog_dir.mkdir()
+
+    args = Namespace(catalog=catalog_dir, export=None)
+    InterfacesCommand("group", config).run(args)
+    assert ["No interfaces found in the catalog."] == [
+        rec.message for rec in caplog.records
+    ]
+
+
+def test_configured_catalog(caplog, config, catalog_dir):
+    """Use the catalog from the config if not indicated."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    config.set(interfaces=InterfacesConfig(catalog="catalog"))
+
+    args = Namespace(catalog=None, export=None)
+    InterfacesCommand("group", config).run(args)
+    assert len(caplog.records) == 4
+
+
+def test_no_catalog(config):
+    """A catalog must be indicated or configured."""
+    args = Namespace(catalog=None, export=None)
+    with pytest.raises(CommandError) as cm:
+        InterfacesCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "No interfaces catalog indicated: use --catalog or configure it in "
+        "charmcraft.yaml."
+    )
+
+
+def test_export(caplog, config, catalog_dir, charm_metadata, tmp_path):
+    """Export the schemas of the interfaces used by the charm."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    export_dir = tmp_path / "schemas"
+
+    args = Namespace(catalog=catalog_dir, export=export_dir)
+    InterfacesCommand("group", config).run(args)
+
+    exported = sorted(
+        str(path.relative_to(export_dir)) for path in export_dir.rglob("*.json")
+    )
+    assert exported == [
+        "http/v0/provider.json",
+        "mysql/v1/provider.json",
+        "mysql/v1/requirer.json",
+    ]
+    content = json.loads((export_dir / "mysql" / "v1" / "requirer.json").read_text())
+    assert content == REQUIRER_SCHEMA
+
+    messages = [rec.message for rec in caplog.records]
+    assert "Interface 'kafka' (used in relation 'queue') not found in the catalog." in (
+        messages
+    )
+    assert messages[-1] == (
+        "Exported 3 schema(s) for the charm's relations to {!r}.".format(str(export_dir))
+    )
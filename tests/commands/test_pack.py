@@ -15,6 +15,8 @@
 # For further info, check https://github.com/canonical/charmcraft
 
 import datetime
+import hashlib
+import json
 import logging
 import pathlib
 import zipfile
@@ -24,18 +26,31 @@ from unittest.mock import patch, MagicMock
 import pytest
 import yaml
 
+from charmcraft import __version__
 from charmcraft.cmdbase import CommandError
 from charmcraft.config import Project
+from charmcraft.git import run_git
 from charmcraft.commands import pack
 from charmcraft.commands.pack import (
     PackCommand,
     build_zip,
+    fetch_charm,
     get_paths_to_include,
 )
 from charmcraft.utils import useful_filepath, SingleOptionEnsurer
+from tests.factory import git
 
 # empty namespace
-noargs = Namespace(entrypoint=None, requirement=None)
+noargs = Namespace(
+    entrypoint=None,
+    requirement=None,
+    from_git=None,
+    release_mode=False,
+    allow_dirty=False,
+    report=None,
+    fetch_charms=False,
+    no_cache=False,
+)
 
 
 @pytest.fixture
@@ -74,7 +89,7 @@ def test_resolve_bundle_type(config):
 
     with patch.object(cmd, "_pack_bundle") as mock:
         cmd.run(noargs)
-    mock.assert_called_with()
+    mock.assert_called_with(fetch_charms=False)
 
 
 def test_resolve_no_config_packs_charm(config, tmp_path):
@@ -96,7 +111,16 @@ def test_resolve_no_config_packs_charm(config, tmp_path):
 def test_resolve_bundle_with_requirement(config):
     """The requirement option is not valid when packing a bundle."""
     config.set(type="bundle")
-    args = Namespace(requirement="reqs.txt", entrypoint=None)
+    args = Namespace(
+        requirement="reqs.txt",
+        entrypoint=None,
+        from_git=None,
+        release_mode=False,
+        allow_dirty=False,
+        report=None,
+        fetch_charms=False,
+        no_cache=False,
+    )
 
     with pytest.raises(CommandError) as cm:
         PackCommand("group", config).run(args)
@@ -109,13 +133,66 @@ def test_resolve_bundle_with_requirement(config):
 def test_resolve_bundle_with_entrypoint(config):
     """The entrypoint option is not valid when packing a bundle."""
     config.set(type="bundle")
-    args = Namespace(requirement=None, entrypoint="mycharm.py")
+    args = Namespace(
+        requirement=None,
+        entrypoint="mycharm.py",
+        from_git=None,
+        release_mode=False,
+        allow_dirty=False,
+        report=None,
+        fetch_charms=False,
+        no_cache=False,
+    )
 
     with pytest.raises(CommandError) as cm:
         PackCommand("group", config).run(args)
     assert str(cm.value) == "The -e/--entry option is valid only when packing a charm"
 
 
+@pytest.mark.parametrize(
+    "option, extra_args",
+    [
+        ("--from-git", {"from_git": "HEAD"}),
+        ("--release-mode", {"release_mode": True}),
+        ("--no-cache", {"no_cache": True}),
+    ],
+)
+def test_resolve_bundle_with_charm_options(config, option, extra_args):
+    """The git related and dependencies options are not valid when packing a bundle."""
+    config.set(type="bundle")
+    args = Namespace(
+        requirement=None,
+        entrypoint=None,
+        from_git=None,
+        release_mode=False,
+        allow_dirty=False,
+        report=None,
+        fetch_charms=False,
+        no_cache=False,
+    )
+    for key, value in extra_args.items():
+        setattr(args, key, value)
+
+    with pytest.raises(CommandError) as cm:
+        PackCommand("group", config).run(args)
+    assert str(cm.value) == "The {} option is valid only when packing a charm".format(
+        option
+    )
+
+
+def test_resolve_charm_with_fetch_charms(config):
+    """The option to fetch the charms is not valid when packing a charm."""
+    config.set(type="charm")
+    args = Namespace(**vars(noargs))
+    args.fetch_charms = True
+
+    with pytest.raises(CommandError) as cm:
+        PackCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "The --fetch-charms option is valid only when packing a bundle"
+    )
+
+
 # -- tests for main bundle building process
 
 
@@ -151,6 +228,94 @@ def test_bundle_simple_succesful_build(tmp_path, caplog, bundle_yaml, config):
     assert not (tmp_path / "manifest.yaml").exists()
 
 
+def test_bundle_linters_problems(tmp_path, caplog, bundle_yaml, config):
+    """The problems found by the checks are shown, but the bundle is packed anyway."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.commands")
+    bundle_yaml(name="testbundle")
+    config.set(type="bundle")
+    (tmp_path / "README.md").write_text("test readme")
+    (tmp_path / "icon.svg").write_text('<svg xmlns="http://www.w3.org/2000/svg"/>')
+
+    PackCommand("group", config).run(noargs)
+    assert (tmp_path / "testbundle.zip").exists()
+    assert [rec.message for rec in caplog.records] == [
+        "The 'icon' check found a problem: the root element must have a 'viewBox' "
+        "attribute"
+    ]
+
+
+def test_bundle_not_deployable(tmp_path, caplog, bundle_yaml, config):
+    """The packed bundle is verified, failing if it could not be deployed."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    bundle_yaml(name="testbundle")
+    config.set(type="bundle")
+    (tmp_path / "README.md").write_text("test readme")
+
+    error = CommandError("The packed bundle is not deployable: problems.")
+    with patch("charmcraft.commands.pack.ensure_deployable", side_effect=error) as mock:
+        with pytest.raises(CommandError) as cm:
+            PackCommand("group", config).run(noargs)
+    assert cm.value is error
+    mock.assert_called_once_with(tmp_path / "testbundle.zip")
+    assert [rec.message for rec in caplog.records] == []
+
+
+def test_bundle_not_matching_charms(tmp_path, config):
+    """The bundle is verified against its local charms."""
+    config.set(type="bundle")
+    (tmp_path / "README.md").write_text("test readme")
+    charm_dir = tmp_path / "charms" / "web"
+    charm_dir.mkdir(parents=True)
+    (charm_dir / "metadata.yaml").write_text("name: web\nrequires: {db: mysql}")
+    bundle = {
+        "name": "testbundle",
+        "applications": {
+            "web": {"charm": "./charms/web", "options": {"port": 80}},
+            "db": {"charm": "ch:mysql"},
+        },
+        "relations": [["web:database", "db:db"]],
+    }
+    (tmp_path / "bundle.yaml").write_text(yaml.dump(bundle))
+
+    with pytest.raises(CommandError) as cm:
+        PackCommand("group", config).run(noargs)
+    assert str(cm.value) == (
+        "The bundle does not match its charms:\n"
+        "- The option 'port' of application 'web' does not exist in its charm."
+    )
+    assert not (tmp_path / "testbundle.zip").exists()
+
+
+def test_bundle_fetch_charms(tmp_path, bundle_yaml, config):
+    """The charms are fetched from Charmhub to verify the bundle if requested."""
+    bundle_yaml(name="testbundle")
+    config.set(type="bundle")
+    (tmp_path / "README.md").write_text("test readme")
+    args = Namespace(**vars(noargs))
+    args.fetch_charms = True
+
+    with patch("charmcraft.commands.pack.Store") as store_mock:
+        with patch("charmcraft.bundle.check_bundle", return_value=[]) as check_mock:
+            PackCommand("group", config).run(args)
+
+    store_mock.assert_called_once_with(config.charmhub)
+    (bundle_config, dirpath), kwargs = check_mock.call_args
+    assert bundle_config == {"name": "testbundle"}
+    assert dirpath == tmp_path
+    assert kwargs["fetch"].func is fetch_charm
+    assert kwargs["fetch"].args == (store_mock.return_value,)
+
+
+def test_fetch_charm(tmp_path):
+    """Download the charm released in a channel."""
+    store = MagicMock()
+    filepath = tmp_path / "mysql.charm"
+    with patch("charmcraft.commands.pack.download_charm") as download_mock:
+        fetch_charm(store, "mysql", "edge", filepath)
+
+    download_mock.assert_called_once_with(store, "mysql", "edge", filepath)
+
+
 def test_bundle_missing_bundle_file(tmp_path, config):
     """Can not build a bundle without bundle.yaml."""
     # build without a bundle.yaml!
@@ -428,7 +593,16 @@ def test_charm_parameters_entrypoint(config):
 
 def test_charm_parameters_validator(config, tmp_path):
     """Check that build.Builder is properly called."""
-    args = Namespace(requirement="test-reqs", entrypoint="test-epoint")
+    args = Namespace(
+        requirement="test-reqs",
+        entrypoint="test-epoint",
+        from_git=None,
+        release_mode=False,
+        allow_dirty=False,
+        report=None,
+        fetch_charms=False,
+        no_cache=False,
+    )
     config.set(
         type="charm",
         project=Project(dirpath=tmp_path, started_at=datetime.datetime.utcnow()),
@@ -445,6 +619,7 @@ def test_charm_parameters_validator(config, tmp_path):
                 "from": tmp_path,
                 "requirement": "test-reqs",
                 "entrypoint": "test-epoint",
+                "no_cache": False,
             }
         )
     )
@@ -460,3 +635,419 @@ def test_charm_builder_infrastructure_called(config):
             PackCommand("group", config).run(noargs)
     builder_class_mock.assert_called_with("processed args", config)
     builder_instance_mock.run.assert_called_with()
+
+
+# -- tests for packing the charm from git and in release mode
+
+
+def _charm_args(**kwargs):
+    """Build the parsed arguments for the charm packing."""
+    args = Namespace(
+        entrypoint=None,
+        requirement=None,
+        from_git=None,
+        release_mode=False,
+        allow_dirty=False,
+        report=None,
+        fetch_charms=False,
+        no_cache=False,
+    )
+    for key, value in kwargs.items():
+        setattr(args, key, value)
+    return args
+
+
+def test_charm_parameters_from_git(config):
+    """The --from-git option defaults to HEAD if a ref is not given."""
+    parser = ArgumentParser()
+    PackCommand("group", config).fill_parser(parser)
+    assert parser.parse_args([]).from_git is None
+    assert parser.parse_args(["--from-git"]).from_git == "HEAD"
+    assert parser.parse_args(["--from-git", "v1.0"]).from_git == "v1.0"
+
+
+@pytest.fixture
+def charm_repo(config, git_repo):
+    """Provide a charm project committed in a git repository."""
+    (git_repo / "metadata.yaml").write_text("name: test-charm")
+    src = git_repo / "src"
+    src.mkdir()
+    (src / "charm.py").write_text("committed code")
+    (src / "charm.py").chmod(0o755)
+    git(git_repo, "add", "metadata.yaml", "src")
+    git(git_repo, "commit", "--quiet", "-m", "The charm")
+    config.set(
+        type="charm",
+        project=Project(dirpath=git_repo, started_at=datetime.datetime.utcnow()),
+    )
+    return git_repo
+
+
+def _pack_capturing_tree(config, args):
+    """Pack the charm, returning the arguments for the builder and the tree it got."""
+    captured = {}
+
+    def fake_builder(builder_args, config):
+        captured["args"] = builder_args
+        captured["files"] = {
+            str(path.relative_to(builder_args["from"])): path.read_text()
+            for path in builder_args["from"].rglob("*")
+            if path.is_file()
+        }
+        return MagicMock()
+
+    with patch("charmcraft.commands.build.Builder", side_effect=fake_builder):
+        PackCommand("group", config).run(args)
+    return captured["args"], captured["files"]
+
+
+def test_charm_from_git_committed_tree(caplog, config, charm_repo):
+    """The charm is built from what is committed, with its version."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    (charm_repo / "src" / "charm.py").write_text("uncommitted code")
+    (charm_repo / "untracked").write_text("stuff")
+    commit = run_git(charm_repo, "rev-parse", "--short", "HEAD")
+
+    builder_args, files = _pack_capturing_tree(config, _charm_args(from_git="HEAD"))
+    assert builder_args["from"] != charm_repo
+    assert builder_args["entrypoint"] == builder_args["from"] / "src" / "charm.py"
+    assert files == {
+        "somefile": "content",
+        "metadata.yaml": "name: test-charm",
+        "src/charm.py": "committed code",
+        "version": commit + "\n",
+    }
+    expected = "Packing the charm from git ref 'HEAD' (commit {}).".format(commit)
+    assert expected in [rec.message for rec in caplog.records]
+
+    # the temporary tree was removed after building
+    assert not builder_args["from"].exists()
+
+
+def test_charm_from_git_version_template(config, charm_repo):
+    """The version is built using the template configured in the ref."""
+    (charm_repo / "charmcraft.yaml").write_text(
+        "type: charm\nparts:\n  charm:\n    version: '{branch}-{short_commit}'\n"
+    )
+    git(charm_repo, "add", "charmcraft.yaml")
+    git(charm_repo, "commit", "--quiet", "-m", "Version template")
+    git(charm_repo, "tag", "v1.0")
+    commit = run_git(charm_repo, "rev-parse", "--short", "HEAD")
+
+    _, files = _pack_capturing_tree(config, _charm_args(from_git="v1.0"))
+    assert files["version"] == "v1.0-{}\n".format(commit)
+
+
+def test_charm_from_git_version_file_provided(config, charm_repo):
+    """The version file committed in the project is respected."""
+    (charm_repo / "version").write_text("1.2.3\n")
+    git(charm_repo, "add", "version")
+    git(charm_repo, "commit", "--quiet", "-m", "Fixed version")
+
+    _, files = _pack_capturing_tree(config, _charm_args(from_git="HEAD"))
+    assert files["version"] == "1.2.3\n"
+
+
+def test_charm_from_git_committed_config(config, charm_repo):
+    """The charm is built with the configuration committed in the ref."""
+    (charm_repo / "charmcraft.yaml").write_text(
+        "type: charm\nparts:\n  charm:\n    legacy-hooks: true\n"
+    )
+    git(charm_repo, "add", "charmcraft.yaml")
+    git(charm_repo, "commit", "--quiet", "-m", "Config")
+    (charm_repo / "charmcraft.yaml").write_text(
+        "type: charm\nparts:\n  charm:\n    legacy-hooks: false\n"
+    )
+
+    with patch("charmcraft.commands.build.Validator") as validator_class_mock:
+        with patch("charmcraft.commands.build.Builder") as builder_class_mock:
+            PackCommand("group", config).run(_charm_args(from_git="HEAD"))
+    (validator_args,) = validator_class_mock().process.call_args[0]
+    export_dirpath = getattr(validator_args, "from")
+    (_, builder_config) = builder_class_mock.call_args[0]
+    assert builder_config is not config
+    assert builder_config.project.dirpath == export_dirpath.resolve()
+    assert builder_config.project.config_provided
+    assert builder_config.parts.charm.legacy_hooks
+
+    # the same run, so the same timestamp (e.g. for the manifest and the report)
+    assert builder_config.project.started_at == config.project.started_at
+
+
+def test_charm_from_git_relocated_paths(config, charm_repo, tmp_path):
+    """The entrypoint and requirements inside the project are used from the export."""
+    (charm_repo / "reqs.txt").write_text("ops")
+    git(charm_repo, "add", "reqs.txt")
+    git(charm_repo, "commit", "--quiet", "-m", "Requirements")
+    outside_reqs = tmp_path.parent / "outside-reqs.txt"
+
+    args = _charm_args(
+        from_git="HEAD",
+        entrypoint=charm_repo / "src" / "charm.py",
+        requirement=[charm_repo / "reqs.txt", outside_reqs],
+    )
+    with patch("charmcraft.commands.build.Validator") as validator_class_mock:
+        with patch("charmcraft.commands.build.Builder"):
+            PackCommand("group", config).run(args)
+    (validator_args,) = validator_class_mock().process.call_args[0]
+    export_dirpath = getattr(validator_args, "from")
+    assert validator_args.entrypoint == export_dirpath / "src" / "charm.py"
+    assert validator_args.requirement == [export_dirpath / "reqs.txt", outside_reqs]
+
+
+def test_charm_from_git_not_repository(config, tmp_path):
+    """Cannot pack from git if the project is not in a repository."""
+    config.set(
+        type="charm",
+        project=Project(dirpath=tmp_path, started_at=datetime.datetime.utcnow()),
+    )
+    with pytest.raises(CommandError) as cm:
+        PackCommand("group", config).run(_charm_args(from_git="HEAD"))
+    assert str(cm.value) == (
+        "Cannot pack from git: the project is not in a git repository."
+    )
+
+
+def test_charm_release_mode_clean(config):
+    """In release mode a clean project is packed normally."""
+    config.set(type="charm")
+    with patch("charmcraft.git.is_repository", return_value=True):
+        with patch("charmcraft.git.is_dirty", return_value=False) as is_dirty_mock:
+            with patch.object(PackCommand, "_build_charm") as build_mock:
+                PackCommand("group", config).run(_charm_args(release_mode=True))
+    is_dirty_mock.assert_called_with(config.project.dirpath, untracked=True)
+    build_mock.assert_called_with(config.project.dirpath, None, None, False, config)
+
+
+@pytest.mark.parametrize(
+    "is_repository, problem",
+    [
+        (True, "the project has uncommitted changes or untracked files"),
+        (False, "the project is not in a git repository, so it cannot be verified"),
+    ],
+)
+def test_charm_release_mode_dirty(config, is_repository, problem):
+    """In release mode a project that cannot be verified as clean is refused."""
+    config.set(type="charm")
+    with patch("charmcraft.git.is_repository", return_value=is_repository):
+        with patch("charmcraft.git.is_dirty", return_value=True):
+            with patch.object(PackCommand, "_build_charm") as build_mock:
+                with pytest.raises(CommandError) as cm:
+                    PackCommand("group", config).run(_charm_args(release_mode=True))
+    assert str(cm.value) == (
+        "Cannot pack in release mode: {}; commit everything, use --from-git, or use "
+        "--allow-dirty to pack anyway.".format(problem)
+    )
+    build_mock.assert_not_called()
+
+
+def test_charm_release_mode_dirty_allowed(caplog, config):
+    """In release mode a dirty project can be packed if explicitly allowed."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.commands")
+    config.set(type="charm")
+    args = _charm_args(release_mode=True, allow_dirty=True)
+    with patch("charmcraft.git.is_repository", return_value=True):
+        with patch("charmcraft.git.is_dirty", return_value=True):
+            with patch.object(PackCommand, "_build_charm") as build_mock:
+                PackCommand("group", config).run(args)
+    build_mock.assert_called_with(config.project.dirpath, None, None, False, config)
+    assert [rec.message for rec in caplog.records] == [
+        "Packing in release mode although the project has uncommitted changes or "
+        "untracked files."
+    ]
+
+
+def test_charm_release_mode_from_git(config, charm_repo):
+    """Packing from git in release mode does not care about the working tree."""
+    (charm_repo / "src" / "charm.py").write_text("uncommitted code")
+    args = _charm_args(release_mode=True, from_git="HEAD")
+    _, files = _pack_capturing_tree(config, args)
+    assert files["src/charm.py"] == "committed code"
+
+
+def test_charm_linters_problems(caplog, config, tmp_path):
+    """The problems found by the checks are shown before building the charm."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.commands")
+    config.set(
+        type="charm",
+        project=Project(dirpath=tmp_path, started_at=datetime.datetime.utcnow()),
+    )
+    (tmp_path / "icon.svg").write_text("not an svg")
+
+    with patch("charmcraft.commands.build.Validator"):
+        with patch("charmcraft.commands.build.Builder") as builder_class_mock:
+            PackCommand("group", config).run(noargs)
+    builder_class_mock().run.assert_called_with()
+    assert [rec.message for rec in caplog.records] == [
+        "The 'icon' check found a problem: it is not well-formed XML (syntax error: "
+        "line 1, column 0)"
+    ]
+
+
+# -- tests for the pack report
+
+
+def _create_charm(filepath, version=None):
+    """Create a charm file with its metadata, manifest and (optionally) version."""
+    manifest = {
+        "charmcraft-version": "1.0.0",
+        "bases": [
+            {"name": "ubuntu", "channel": "20.04", "architectures": ["amd64"]},
+            {"name": "ubuntu", "channel": "18.04", "architectures": ["arm64", "amd64"]},
+        ],
+    }
+    with zipfile.ZipFile(str(filepath), "w") as zf:
+        zf.writestr("metadata.yaml", "name: test-charm")
+        zf.writestr("manifest.yaml", yaml.dump(manifest))
+        if version is not None:
+            zf.writestr("version", version + "\n")
+    return filepath
+
+
+def test_report_charm_info(tmp_path):
+    """Get the information of a packed charm."""
+    filepath = _create_charm(tmp_path / "test-charm.charm", version="1.2.3")
+    content = filepath.read_bytes()
+
+    name, artifact_type, version, details = pack.get_artifact_info(filepath)
+    assert name == "test-charm"
+    assert artifact_type == "charm"
+    assert version == "1.2.3"
+    assert details == {
+        "path": str(filepath),
+        "size": len(content),
+        "sha256": hashlib.sha256(content).hexdigest(),
+        "sha384": hashlib.sha384(content).hexdigest(),
+        "bases": [
+            {"name": "ubuntu", "channel": "20.04", "architectures": ["amd64"]},
+            {"name": "ubuntu", "channel": "18.04", "architectures": ["arm64", "amd64"]},
+        ],
+        "architectures": ["amd64", "arm64"],
+    }
+
+
+def test_report_charm_without_version(tmp_path):
+    """The charm may not have a version."""
+    filepath = _create_charm(tmp_path / "test-charm.charm")
+    _, _, version, _ = pack.get_artifact_info(filepath)
+    assert version is None
+
+
+def test_report_charm(caplog, config, tmp_path):
+    """Write the report of a packed charm."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    started_at = datetime.datetime(2021, 6, 10, 12, 0, 0)
+    config.set(
+        type="charm",
+        project=Project(dirpath=tmp_path, started_at=started_at),
+    )
+    charm_path = _create_charm(tmp_path / "test-charm.charm", version="1.2.3")
+    report_path = tmp_path / "report.json"
+
+    with patch("charmcraft.commands.build.Validator"):
+        with patch("charmcraft.commands.build.Builder") as builder_class_mock:
+            builder_class_mock().run.return_value = str(charm_path)
+            with patch("charmcraft.commands.pack.datetime") as datetime_mock:
+                datetime_mock.datetime.utcnow.return_value = datetime.datetime(
+                    2021, 6, 10, 12, 1, 30, 250000
+                )
+                PackCommand("group", config).run(_charm_args(report=report_path))
+
+    report = json.loads(report_path.read_text())
+    (artifact,) = report.pop("artifacts")
+    assert report == {
+        "charmcraft-version": __version__,
+        "name": "test-charm",
+        "type": "charm",
+        "version": "1.2.3",
+        "timings": {
+            "started-at": "2021-06-10T12:00:00Z",
+            "finished-at": "2021-06-10T12:01:30.250000Z",
+            "duration": 90.25,
+        },
+    }
+    assert artifact["path"] == str(charm_path)
+    assert artifact["sha256"] == hashlib.sha256(charm_path.read_bytes()).hexdigest()
+    assert "Report written to '{}'.".format(report_path) in [
+        rec.message for rec in caplog.records
+    ]
+
+
+def test_report_bundle(tmp_path, bundle_yaml, config):
+    """Write the report of a packed bundle."""
+    bundle_yaml(name="testbundle")
+    config.set(type="bundle")
+    (tmp_path / "README.md").write_text("test readme")
+    report_path = tmp_path / "report.json"
+
+    args = Namespace(**vars(noargs))
+    args.report = report_path
+    PackCommand("group", config).run(args)
+
+    report = json.loads(report_path.read_text())
+    assert report["name"] == "testbundle"
+    assert report["type"] == "bundle"
+    assert report["version"] is None
+    (artifact,) = report["artifacts"]
+    zippath = tmp_path / "testbundle.zip"
+    assert artifact["path"] == str(zippath)
+    assert artifact["size"] == zippath.stat().st_size
+    assert artifact["sha384"] == hashlib.sha384(zippath.read_bytes()).hexdigest()
+    (base,) = artifact["bases"]
+    assert artifact["architectures"] == base["architectures"]
+
+
+def test_report_per_artifact(tmp_path):
+    """Each artifact has its own name, type and version."""
+    started_at = datetime.datetime(2021, 6, 10, 12, 0, 0)
+    charm_paths = [
+        _create_charm(tmp_path / "test-charm-1.charm", version="1.2.3"),
+        _create_charm(tmp_path / "test-charm-2.charm", version="1.2.4"),
+    ]
+    report_path = tmp_path / "report.json"
+
+    pack.write_report(report_path, charm_paths, started_at)
+
+    report = json.loads(report_path.read_text())
+    assert report["name"] == "test-charm"
+    assert report["type"] == "charm"
+    assert report["version"] is None
+    assert [
+        (artifact["path"], artifact["name"], artifact["type"], artifact["version"])
+        for artifact in report["artifacts"]
+    ] == [
+        (str(charm_paths[0]), "test-charm", "charm", "1.2.3"),
+        (str(charm_paths[1]), "test-charm", "charm", "1.2.4"),
+    ]
+
+
+def test_report_no_artifacts(tmp_path):
+    """The report can be written even if nothing was packed."""
+    started_at = datetime.datetime(2021, 6, 10, 12, 0, 0)
+    report_path = tmp_path / "report.json"
+
+    pack.write_report(report_path, [], started_at)
+
+    report = json.loads(report_path.read_text())
+    assert report["name"] is None
+    assert report["type"] is None
+    assert report["version"] is None
+    assert report["artifacts"] == []
+
+
+def test_report_cannot_write(tmp_path, bundle_yaml, config):
+    """The report cannot be written."""
+    bundle_yaml(name="testbundle")
+    config.set(type="bundle")
+    (tmp_path / "README.md").write_text("test readme")
+    report_path = tmp_path / "missing-dir" / "report.json"
+
+    args = Namespace(**vars(noargs))
+    args.report = report_path
+    with pytest.raises(CommandError) as cm:
+        PackCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "Cannot write the report to {!r}: No such file or directory.".format(
+            str(report_path)
+        )
+    )
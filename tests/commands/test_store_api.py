@@ -23,7 +23,7 @@ import pytest
 from dateutil import parser
 
 from charmcraft.utils import ResourceOption
-from charmcraft.commands.store.store import Store, Library
+from charmcraft.commands.store.store import Library, Platform, Store
 
 
 @pytest.fixture
@@ -31,7 +31,8 @@ def client_mock():
     """Fixture to provide a mocked client."""
     client_mock = MagicMock()
     with patch(
-        "charmcraft.commands.store.store.Client", lambda api, storage: client_mock
+        "charmcraft.commands.store.store.Client",
+        lambda api, storage, offline: client_mock,
     ):
         yield client_mock
 
@@ -44,10 +45,26 @@ def test_client_init(config):
     with patch("charmcraft.commands.store.store.Client") as client_mock:
         Store(config.charmhub)
     assert client_mock.mock_calls == [
-        call(config.charmhub.api_url, config.charmhub.storage_url),
+        call(config.charmhub.api_url, config.charmhub.storage_url, offline=False),
     ]
 
 
+def test_client_init_offline(config):
+    """The client is told to work offline."""
+    with patch("charmcraft.commands.store.store.Client") as client_mock:
+        Store(config.charmhub, offline=True)
+    assert client_mock.mock_calls == [
+        call(config.charmhub.api_url, config.charmhub.storage_url, offline=True),
+    ]
+
+
+def test_get_cache_timestamp(client_mock, config):
+    """The timestamp of the cached data comes from the client."""
+    client_mock.cache_timestamp = "some timestamp"
+    store = Store(config.charmhub, offline=True)
+    assert store.get_cache_timestamp() == "some timestamp"
+
+
 # -- tests for auth
 
 
@@ -180,7 +197,7 @@ def test_upload_straightforward(client_mock, caplog, config):
     assert client_mock.mock_calls == [
         call.push(test_filepath),
         call.post(test_endpoint, {"upload-id": test_upload_id}),
-        call.get(test_status_url),
+        call.get(test_status_url, cache=False),
     ]
 
     # check result (build after patched ending struct)
@@ -237,9 +254,9 @@ def test_upload_polls_status(client_mock, caplog, config):
 
     # check the status-checking client calls (kept going until third one)
     assert client_mock.mock_calls[2:] == [
-        call.get(test_status_url),
-        call.get(test_status_url),
-        call.get(test_status_url),
+        call.get(test_status_url, cache=False),
+        call.get(test_status_url, cache=False),
+        call.get(test_status_url, cache=False),
     ]
 
     # check result which must have values from final result
@@ -373,7 +390,7 @@ def test_upload_including_extra_parameters(client_mock, caplog, config):
         call.post(
             test_endpoint, {"upload-id": test_upload_id, "extra-key": "1", "more": "2"}
         ),
-        call.get(test_status_url),
+        call.get(test_status_url, cache=False),
     ]
 
 
@@ -569,7 +586,11 @@ def test_status_ok(client_mock, config):
             {
                 "channel": "latest/edge/mybranch",
                 "expiration-date": "2020-08-16T18:46:02Z",
-                "platform": {"architecture": "all", "os": "all", "series": "all"},
+                "platform": {
+                    "architecture": "amd64",
+                    "os": "ubuntu",
+                    "series": "focal",
+                },
                 "progressive": {"paused": None, "percentage": None},
                 "revision": 10,
                 "when": "2020-07-16T18:46:02Z",
@@ -626,10 +647,16 @@ def test_status_ok(client_mock, config):
     assert cmap1.channel == "latest/beta"
     assert cmap1.expires_at is None
     assert cmap1.resources == []
+    assert cmap1.released_at == parser.parse("2020-07-16T18:45:24Z")
+    assert cmap1.platform == Platform(architecture="all", os="all", series="all")
     assert cmap2.revision == 10
     assert cmap2.channel == "latest/edge/mybranch"
     assert cmap2.expires_at == parser.parse("2020-08-16T18:46:02Z")
     assert cmap2.resources == []
+    assert cmap2.released_at == parser.parse("2020-07-16T18:46:02Z")
+    assert cmap2.platform == Platform(
+        architecture="amd64", os="ubuntu", series="focal"
+    )
 
     channel1, channel2 = channels
     assert channel1.name == "latest/stable"
@@ -883,7 +910,7 @@ def test_get_tips_simple(client_mock, config):
         {"library-id": test_lib_id},
     ]
     assert client_mock.mock_calls == [
-        call.post("/v1/charm/libraries/bulk", payload),
+        call.post("/v1/charm/libraries/bulk", payload, cache=True),
     ]
     expected = {
         (test_lib_id, test_api): Library(
@@ -915,7 +942,7 @@ def test_get_tips_empty(client_mock, config):
         {"library-id": test_lib_id},
     ]
     assert client_mock.mock_calls == [
-        call.post("/v1/charm/libraries/bulk", payload),
+        call.post("/v1/charm/libraries/bulk", payload, cache=True),
     ]
     assert result == {}
 
@@ -973,7 +1000,7 @@ def test_get_tips_several(client_mock, config):
         {"library-id": test_lib_id_2},
     ]
     assert client_mock.mock_calls == [
-        call.post("/v1/charm/libraries/bulk", payload),
+        call.post("/v1/charm/libraries/bulk", payload, cache=True),
     ]
     expected = {
         (test_lib_id_1, test_api_1): Library(
@@ -1026,7 +1053,7 @@ def test_get_tips_query_combinations(client_mock, config):
         },
     ]
     assert client_mock.mock_calls == [
-        call.post("/v1/charm/libraries/bulk", payload),
+        call.post("/v1/charm/libraries/bulk", payload, cache=True),
     ]
 
 
@@ -1195,3 +1222,87 @@ def test_list_resource_revisions_several(client_mock, config):
     assert item2.revision == 2
     assert item2.created_at == parser.parse("2021-02-11T14:23:55.659148")
     assert item2.size == 420
+
+
+# -- tests for downloads
+
+
+def test_get_release_downloads_ok(client_mock, config):
+    """Get the charm and resources download info for a channel."""
+    client_mock.get.return_value = {
+        "default-release": {
+            "revision": {
+                "revision": 7,
+                "download": {
+                    "url": "https://api.charmhub.io/api/v1/charms/download/abc_7.charm",
+                    "hash-sha-256": "charm-hash",
+                    "size": 1234,
+                },
+            },
+            "resources": [
+                {
+                    "name": "someresource",
+                    "type": "file",
+                    "revision": 3,
+                    "download": {
+                        "url": "https://api.charmhub.io/api/v1/resources/download/r3",
+                        "hash-sha-256": "resource-hash",
+                        "size": 42,
+                    },
+                },
+            ],
+        },
+    }
+
+    store = Store(config.charmhub)
+    result = store.get_release_downloads("charm-name", "latest/stable", "amd64")
+
+    assert client_mock.mock_calls == [
+        call.get(
+            "/v2/charms/info/charm-name?channel=latest/stable&architecture=amd64&"
+            "fields=default-release.revision.revision,"
+            "default-release.revision.download,default-release.resources"
+        )
+    ]
+    assert result.revision == 7
+    assert result.download.url == (
+        "https://api.charmhub.io/api/v1/charms/download/abc_7.charm"
+    )
+    assert result.download.hash_sha256 == "charm-hash"
+    assert result.download.size == 1234
+    (resource,) = result.resources
+    assert resource.name == "someresource"
+    assert resource.resource_type == "file"
+    assert resource.revision == 3
+    assert resource.download.url == (
+        "https://api.charmhub.io/api/v1/resources/download/r3"
+    )
+    assert resource.download.hash_sha256 == "resource-hash"
+    assert resource.download.size == 42
+
+
+def test_get_release_downloads_no_resources(client_mock, config):
+    """Get the download info for a charm without resources."""
+    client_mock.get.return_value = {
+        "default-release": {
+            "revision": {
+                "revision": 1,
+                "download": {"url": "https://url", "hash-sha-256": "h", "size": 1},
+            },
+            "resources": [],
+        },
+    }
+
+    store = Store(config.charmhub)
+    result = store.get_release_downloads("charm-name", "latest/edge", "arm64")
+    assert result.revision == 1
+    assert result.resources == []
+
+
+def test_download(client_mock, config, tmp_path):
+    """Download delegates to the client."""
+    store = Store(config.charmhub)
+    filepath = tmp_path / "stuff"
+    store.download("https://some.url/blob", filepath)
+
+    assert client_mock.mock_calls == [call.download("https://some.url/blob", filepath)]
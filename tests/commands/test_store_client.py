@@ -20,7 +20,7 @@ import json
 import logging
 import os
 from http.cookiejar import MozillaCookieJar, Cookie
-from unittest.mock import patch
+from unittest.mock import MagicMock, patch
 
 import pytest
 from macaroonbakery import httpbakery
@@ -31,7 +31,9 @@ from requests_toolbelt import MultipartEncoder, MultipartEncoderMonitor
 from charmcraft.cmdbase import CommandError
 from charmcraft.commands.store.client import (
     Client,
+    ResponseCache,
     _AuthHolder,
+    _storage_pull,
     _storage_push,
     build_user_agent,
     visit_page_with_browser,
@@ -252,12 +254,34 @@ def test_authholder_credentials_save_reallysave(auth_holder):
         new_file_content = fh.read()
     assert new_file_content != prv_file_content
 
-    # call the tested method again, to verify that it was calling save on the cookiejar (and
-    # not that the file changed as other side effect)
+    # change the credentials again and call the tested method, to verify that it was calling
+    # save on the cookiejar (and not that the file changed as other side effect)
+    auth_holder._cookiejar.set_cookie(get_cookie(value="yet another"))
     with patch.object(auth_holder._cookiejar, "save") as mock:
         auth_holder._save_credentials_if_changed()
     assert mock.call_count == 1
 
+    # nothing changed since last time, nothing to save
+    with patch.object(auth_holder._cookiejar, "save") as mock:
+        auth_holder._save_credentials_if_changed()
+    assert mock.call_count == 0
+
+
+def test_authholder_credentials_save_notifies(tmp_path):
+    """The new credentials are notified when saved, only if changed."""
+    on_new_credentials = MagicMock()
+    auth_holder = _AuthHolder(on_new_credentials=on_new_credentials)
+    auth_holder._cookiejar_filepath = str(tmp_path / "test.credentials")
+    auth_holder._load_credentials()
+
+    with patch.object(auth_holder._cookiejar, "save"):
+        auth_holder._save_credentials_if_changed()
+        on_new_credentials.assert_not_called()
+
+        auth_holder._cookiejar.set_cookie(get_cookie(value="different"))
+        auth_holder._save_credentials_if_changed()
+        on_new_credentials.assert_called_once_with()
+
 
 def test_authholder_credentials_save_createsdir(auth_holder, tmp_path):
     """Save creates the directory if not there."""
@@ -345,13 +369,14 @@ def test_authholder_request_interaction_error(auth_holder):
 
 
 class FakeResponse:
-    def __init__(self, content, status_code):
+    def __init__(self, content, status_code, headers=None):
         self.content = content
         self.status_code = status_code
+        self.headers = headers or {}
 
     @property
     def ok(self):
-        return self.status_code == 200
+        return self.status_code in (200, 304)
 
     def json(self):
         return json.loads(self.content)
@@ -363,7 +388,9 @@ def test_client_get():
         client = Client("http://api.test", "http://storage.test")
     client.get("/somepath")
 
-    mock_auth().request.assert_called_once_with("GET", "http://api.test/somepath", None)
+    mock_auth().request.assert_called_once_with(
+        "GET", "http://api.test/somepath", None, headers={}
+    )
 
 
 def test_client_post():
@@ -373,7 +400,7 @@ def test_client_post():
     client.post("/somepath", "somebody")
 
     mock_auth().request.assert_called_once_with(
-        "POST", "http://api.test/somepath", "somebody"
+        "POST", "http://api.test/somepath", "somebody", headers=None
     )
 
 
@@ -388,7 +415,9 @@ def test_client_hit_success_simple(caplog):
         client = Client("http://api.test", "http://storage.test")
     result = client._hit("GET", "/somepath")
 
-    mock_auth().request.assert_called_once_with("GET", "http://api.test/somepath", None)
+    mock_auth().request.assert_called_once_with(
+        "GET", "http://api.test/somepath", None, headers=None
+    )
     assert result == response_value
     expected = [
         "Hitting the store: GET http://api.test/somepath None",
@@ -403,7 +432,7 @@ def test_client_hit_url_extra_slash():
         client = Client("https://local.test:1234/", "http://storage.test")
     client._hit("GET", "/somepath")
     mock_auth().request.assert_called_once_with(
-        "GET", "https://local.test:1234/somepath", None
+        "GET", "https://local.test:1234/somepath", None, headers=None
     )
 
 
@@ -419,7 +448,7 @@ def test_client_hit_success_withbody(caplog):
     result = client._hit("POST", "/somepath", "somebody")
 
     mock_auth().request.assert_called_once_with(
-        "POST", "http://api.test/somepath", "somebody"
+        "POST", "http://api.test/somepath", "somebody", headers=None
     )
     assert result == response_value
     expected = [
@@ -442,12 +471,180 @@ def test_client_hit_failure():
         client._hit("GET", "/somepath")
 
 
+def test_client_new_credentials_clear_cache(tmp_path):
+    """The cached responses are removed if the user authenticates again."""
+    client = Client("http://api.test", "http://storage.test")
+    client._cache.set("GET", "/somepath", None, {"foo": "bar"}, None, None)
+    auth_holder = client._auth_client
+    auth_holder._cookiejar_filepath = str(tmp_path / "test.credentials")
+    auth_holder._load_credentials()
+
+    def fake_request(self, method, url, **kwargs):
+        # the user authenticated while doing the request
+        auth_holder._cookiejar.set_cookie(get_cookie(value="other account"))
+        return FakeResponse(json.dumps({"foo": "new"}), 200)
+
+    with patch("macaroonbakery.httpbakery.Client.request", fake_request):
+        with patch.object(auth_holder._cookiejar, "save"):
+            assert client.get("/otherpath") == {"foo": "new"}
+
+    assert client._cache.get("GET", "/somepath") is None
+    assert client._cache.get("GET", "/otherpath").data == {"foo": "new"}
+
+
 def test_client_clear_credentials():
     with patch("charmcraft.commands.store.client._AuthHolder") as mock_auth:
         client = Client("http://api.test", "http://storage.test")
+    client._cache.set("GET", "/somepath", None, {"foo": "bar"}, None, None)
     client.clear_credentials()
 
     mock_auth().clear_credentials.assert_called_once_with()
+    assert client._cache.get("GET", "/somepath") is None
+
+
+# --- Client cache tests
+
+
+def test_responsecache_dirpath(user_cache_dir):
+    """The responses are cached per Store."""
+    cache = ResponseCache("https://api.charmhub.io")
+    assert cache.dirpath == user_cache_dir / "charmcraft" / "store" / "api.charmhub.io"
+
+
+def test_responsecache_roundtrip():
+    """Store and retrieve responses, by method, path and body."""
+    cache = ResponseCache("http://api.test")
+    cache.set("GET", "/somepath", None, {"foo": "bar"}, '"tag"', None)
+    cache.set("POST", "/somepath", ["query"], {"foo": "other"}, None, "a date")
+
+    cached = cache.get("GET", "/somepath")
+    assert cached.data == {"foo": "bar"}
+    assert cached.etag == '"tag"'
+    assert cached.last_modified is None
+    cached = cache.get("POST", "/somepath", ["query"])
+    assert cached.data == {"foo": "other"}
+    assert cached.etag is None
+    assert cached.last_modified == "a date"
+    assert cache.get("GET", "/otherpath") is None
+    assert cache.get("POST", "/somepath", ["other query"]) is None
+
+
+def test_responsecache_corrupted():
+    """A corrupted cached response is ignored."""
+    cache = ResponseCache("http://api.test")
+    cache.set("GET", "/somepath", None, {"foo": "bar"}, None, None)
+    for filepath in cache.dirpath.iterdir():
+        filepath.write_text("{broken")
+    assert cache.get("GET", "/somepath") is None
+
+
+def test_client_get_cached_first_time():
+    """The response is cached with its validators."""
+    headers = {"ETag": '"tag"', "Last-Modified": "Tue, 01 Jun 2021 10:00:00 GMT"}
+    fake_response = FakeResponse(json.dumps({"foo": "bar"}), 200, headers)
+    with patch("charmcraft.commands.store.client._AuthHolder") as mock_auth:
+        mock_auth().request.return_value = fake_response
+        client = Client("http://api.test", "http://storage.test")
+    assert client.get("/somepath") == {"foo": "bar"}
+
+    mock_auth().request.assert_called_once_with(
+        "GET", "http://api.test/somepath", None, headers={}
+    )
+    cached = client._cache.get("GET", "/somepath")
+    assert cached.data == {"foo": "bar"}
+    assert cached.etag == '"tag"'
+    assert cached.last_modified == "Tue, 01 Jun 2021 10:00:00 GMT"
+
+
+def test_client_get_cached_not_modified():
+    """The cached response is revalidated, and used if not modified."""
+    with patch("charmcraft.commands.store.client._AuthHolder") as mock_auth:
+        mock_auth().request.return_value = FakeResponse("", 304)
+        client = Client("http://api.test", "http://storage.test")
+    client._cache.set("GET", "/somepath", None, {"foo": "bar"}, '"tag"', "a date")
+
+    assert client.get("/somepath") == {"foo": "bar"}
+    mock_auth().request.assert_called_once_with(
+        "GET",
+        "http://api.test/somepath",
+        None,
+        headers={"If-None-Match": '"tag"', "If-Modified-Since": "a date"},
+    )
+    assert client._cache.get("GET", "/somepath").etag == '"tag"'
+
+
+def test_client_get_cached_modified():
+    """The cached response is replaced if modified."""
+    fake_response = FakeResponse(json.dumps({"foo": "new"}), 200, {"ETag": '"new"'})
+    with patch("charmcraft.commands.store.client._AuthHolder") as mock_auth:
+        mock_auth().request.return_value = fake_response
+        client = Client("http://api.test", "http://storage.test")
+    client._cache.set("GET", "/somepath", None, {"foo": "bar"}, '"tag"', None)
+
+    assert client.get("/somepath") == {"foo": "new"}
+    mock_auth().request.assert_called_once_with(
+        "GET", "http://api.test/somepath", None, headers={"If-None-Match": '"tag"'}
+    )
+    cached = client._cache.get("GET", "/somepath")
+    assert cached.data == {"foo": "new"}
+    assert cached.etag == '"new"'
+
+
+def test_client_get_not_cached():
+    """The cache can be avoided."""
+    fake_response = FakeResponse(json.dumps({"foo": "bar"}), 200)
+    with patch("charmcraft.commands.store.client._AuthHolder") as mock_auth:
+        mock_auth().request.return_value = fake_response
+        client = Client("http://api.test", "http://storage.test")
+    client.get("/somepath", cache=False)
+    assert client._cache.get("GET", "/somepath") is None
+
+
+def test_client_post_cached():
+    """POST responses are cached only if indicated."""
+    fake_response = FakeResponse(json.dumps({"foo": "bar"}), 200)
+    with patch("charmcraft.commands.store.client._AuthHolder") as mock_auth:
+        mock_auth().request.return_value = fake_response
+        client = Client("http://api.test", "http://storage.test")
+    client.post("/somepath", "body1")
+    client.post("/somepath", "body2", cache=True)
+    assert client._cache.get("POST", "/somepath", "body1") is None
+    assert client._cache.get("POST", "/somepath", "body2").data == {"foo": "bar"}
+
+
+def test_client_offline():
+    """Working offline the cached responses are used, with their timestamp."""
+    with patch("charmcraft.commands.store.client._AuthHolder") as mock_auth:
+        client = Client("http://api.test", "http://storage.test", offline=True)
+    client._cache.set("GET", "/path1", None, {"foo": "bar"}, None, None)
+    client._cache.set("GET", "/path2", None, {"foo": "baz"}, None, None)
+
+    assert client.cache_timestamp is None
+    assert client.get("/path1") == {"foo": "bar"}
+    assert client.get("/path2") == {"foo": "baz"}
+    assert client.cache_timestamp == client._cache.get("GET", "/path1").timestamp
+    mock_auth().request.assert_not_called()
+
+
+def test_client_offline_not_cached():
+    """Cannot work offline without the response cached."""
+    with patch("charmcraft.commands.store.client._AuthHolder"):
+        client = Client("http://api.test", "http://storage.test", offline=True)
+    with pytest.raises(CommandError) as cm:
+        client.get("/somepath")
+    assert str(cm.value) == (
+        "Cannot work offline: there is no cached data from the Store for /somepath; "
+        "run the command again with network access."
+    )
+
+
+def test_client_offline_not_cacheable():
+    """Cannot work offline if the request needs to hit the Store."""
+    with patch("charmcraft.commands.store.client._AuthHolder"):
+        client = Client("http://api.test", "http://storage.test", offline=True)
+    with pytest.raises(CommandError) as cm:
+        client.post("/somepath", "somebody")
+    assert str(cm.value) == "Cannot work offline: POST /somepath needs to hit the Store."
 
 
 def test_client_errorparsing_complete():
@@ -688,3 +885,83 @@ def test_storage_push_network_error():
             _storage_push(test_monitor, "http://test.url:0000")
         expected = "Network error when pushing file: RequestException('naughty error')"
         assert str(cm.value) == expected
+
+
+def test_client_download_ok(tmp_path, caplog):
+    """Download content into the indicated file."""
+    caplog.set_level(logging.DEBUG, logger="charmcraft.commands")
+    test_filepath = tmp_path / "downloaded.bin"
+    with patch("charmcraft.commands.store.client._storage_pull") as mock:
+        mock.return_value = FakeResponse(content="", status_code=200)
+        Client("http://api.test", "http://storage.test").download(
+            "https://storage.test/blob", test_filepath
+        )
+    mock.assert_called_once_with("https://storage.test/blob", test_filepath)
+
+    expected = [
+        "Starting to download https://storage.test/blob into {}".format(test_filepath),
+        "Downloading bytes ended, saved in {}".format(test_filepath),
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_client_download_response_not_ok(tmp_path):
+    """Didn't get a 200 from the Storage when downloading."""
+    test_filepath = tmp_path / "downloaded.bin"
+    with patch("charmcraft.commands.store.client._storage_pull") as mock:
+        mock.return_value = FakeResponse(content="not there", status_code=404)
+        with pytest.raises(CommandError) as cm:
+            Client("http://api.test", "http://storage.test").download(
+                "https://storage.test/blob", test_filepath
+            )
+        assert str(cm.value) == "Failure while downloading file: [404] 'not there'"
+
+
+def test_storage_pull_succesful(tmp_path):
+    """Bytes are properly pulled from the Storage into the file."""
+    test_filepath = tmp_path / "downloaded.bin"
+    with patch("requests.Session") as mock:
+        cm_session_mock = mock().__enter__()
+        response = cm_session_mock.get.return_value
+        response.ok = True
+        response.iter_content.return_value = [b"abc", b"def"]
+        _storage_pull("https://test.url/blob", test_filepath)
+
+    # check request was properly called and the content saved
+    headers = {"User-Agent": build_user_agent()}
+    cm_session_mock.get.assert_called_once_with(
+        "https://test.url/blob", headers=headers, stream=True
+    )
+    assert test_filepath.read_bytes() == b"abcdef"
+
+    # check the retries were properly setup
+    (protocol, adapter), _ = cm_session_mock.mount.call_args
+    assert protocol == "https://"
+    assert isinstance(adapter, HTTPAdapter)
+    assert adapter.max_retries.backoff_factor == 2
+    assert adapter.max_retries.total == 5
+    assert adapter.max_retries.status_forcelist == [500, 502, 503, 504]
+
+
+def test_storage_pull_not_ok(tmp_path):
+    """Nothing is written if the response is not ok."""
+    test_filepath = tmp_path / "downloaded.bin"
+    with patch("requests.Session") as mock:
+        cm_session_mock = mock().__enter__()
+        cm_session_mock.get.return_value.ok = False
+        response = _storage_pull("https://test.url/blob", test_filepath)
+
+    assert response.ok is False
+    assert not test_filepath.exists()
+
+
+def test_storage_pull_network_error(tmp_path):
+    """A generic network error happened when downloading."""
+    with patch("requests.Session.get") as mock:
+        mock.side_effect = RequestException("naughty error")
+        with pytest.raises(CommandError) as cm:
+            _storage_pull("https://test.url/blob", tmp_path / "downloaded.bin")
+        expected = (
+            "Network error when downloading file: RequestException('naughty error')"
+        )
+        assert str(cm.value) == expected
@@ -21,6 +21,8 @@ import hashlib
 import json
 import logging
 import pathlib
+import subprocess
+import sys
 import zipfile
 from argparse import Namespace, ArgumentParser
 from unittest.mock import patch, call, MagicMock
@@ -29,11 +31,19 @@ import dateutil.parser
 import pytest
 import yaml
 
-from charmcraft.config import CharmhubConfig
+from charmcraft import git
+from charmcraft.config import (
+    CharmMetadata,
+    CharmhubConfig,
+    GitTagConfig,
+    ReleasePolicyConfig,
+)
 from charmcraft.cmdbase import CommandError
 from charmcraft.commands.store import (
+    ChangelogCommand,
     CreateLibCommand,
     EntityType,
+    ExportCommand,
     FetchLibCommand,
     ListLibCommand,
     ListNamesCommand,
@@ -48,20 +58,25 @@ from charmcraft.commands.store import (
     RegisterCharmNameCommand,
     ReleaseCommand,
     StatusCommand,
+    TuiCommand,
     UploadCommand,
     UploadResourceCommand,
+    VerifyExportCommand,
     WhoamiCommand,
     _get_lib_info,
+    diff_artifacts,
     get_name_from_metadata,
     get_name_from_zip,
     oci_image_spec,
 )
 from charmcraft.commands.store.store import (
     Channel,
+    Download,
     Entity,
     Error,
     Library,
     Release,
+    ReleaseDownloads,
     Resource,
     ResourceRevision,
     Revision,
@@ -85,9 +100,10 @@ def store_mock():
     """The fixture to fake the store layer in all the tests."""
     store_mock = MagicMock()
 
-    def validate_config(config):
+    def validate_config(config, offline=False):
         """Check that the store received the Charmhub configuration."""
         assert config == CharmhubConfig()
+        store_mock.offline = offline
         return store_mock
 
     with patch("charmcraft.commands.store.Store", validate_config):
@@ -125,6 +141,25 @@ def test_get_name_from_metadata_ok(tmp_path, monkeypatch):
     assert result == "test-name"
 
 
+def test_get_name_from_metadata_config(tmp_path, monkeypatch, config):
+    """The name is taken from the metadata declared in charmcraft.yaml."""
+    monkeypatch.chdir(tmp_path)
+    (tmp_path / "metadata.yaml").write_text("name: other-name")
+    config.set(metadata=CharmMetadata(name="test-name"))
+
+    result = get_name_from_metadata(config)
+    assert result == "test-name"
+
+
+def test_get_name_from_metadata_config_without_metadata(tmp_path, monkeypatch, config):
+    """The name is taken from metadata.yaml if not declared in charmcraft.yaml."""
+    monkeypatch.chdir(tmp_path)
+    (tmp_path / "metadata.yaml").write_text("name: test-name")
+
+    result = get_name_from_metadata(config)
+    assert result == "test-name"
+
+
 def test_get_name_from_metadata_no_file(tmp_path, monkeypatch):
     """No metadata file to get info."""
     monkeypatch.chdir(tmp_path)
@@ -446,7 +481,7 @@ def test_upload_call_ok(caplog, store_mock, config, tmp_path):
 
     test_charm = tmp_path / "mystuff.charm"
     _build_zip_with_yaml(test_charm, "metadata.yaml", content={"name": "mycharm"})
-    args = Namespace(filepath=test_charm, release=[])
+    args = Namespace(filepath=test_charm, release=[], override_policy=False)
     UploadCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [call.upload("mycharm", test_charm)]
@@ -467,7 +502,7 @@ def test_upload_call_error(caplog, store_mock, config, tmp_path):
 
     test_charm = tmp_path / "mystuff.charm"
     _build_zip_with_yaml(test_charm, "metadata.yaml", content={"name": "mycharm"})
-    args = Namespace(filepath=test_charm, release=[])
+    args = Namespace(filepath=test_charm, release=[], override_policy=False)
     UploadCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [call.upload("mycharm", test_charm)]
@@ -488,7 +523,7 @@ def test_upload_call_ok_including_release(caplog, store_mock, config, tmp_path):
 
     test_charm = tmp_path / "mystuff.charm"
     _build_zip_with_yaml(test_charm, "metadata.yaml", content={"name": "mycharm"})
-    args = Namespace(filepath=test_charm, release=["edge"])
+    args = Namespace(filepath=test_charm, release=["edge"], override_policy=False)
     UploadCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -513,7 +548,9 @@ def test_upload_call_ok_including_release_multiple(
 
     test_charm = tmp_path / "mystuff.charm"
     _build_zip_with_yaml(test_charm, "metadata.yaml", content={"name": "mycharm"})
-    args = Namespace(filepath=test_charm, release=["edge", "stable"])
+    args = Namespace(
+        filepath=test_charm, release=["edge", "stable"], override_policy=False
+    )
     UploadCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -527,6 +564,81 @@ def test_upload_call_ok_including_release_multiple(
     assert expected == [rec.message for rec in caplog.records]
 
 
+def test_upload_notifications(store_mock, config, tmp_path):
+    """Notify the upload (without channels), and the release if included."""
+    store_mock.upload.return_value = Uploaded(ok=True, status=200, revision=7, errors=[])
+
+    test_charm = tmp_path / "mystuff.charm"
+    _build_zip_with_yaml(test_charm, "metadata.yaml", content={"name": "mycharm"})
+    args = Namespace(filepath=test_charm, release=["edge"], override_policy=False)
+    with patch("charmcraft.commands.store.notify") as notify_mock:
+        UploadCommand("group", config).run(args)
+
+    notified = [
+        (payload["event"], payload["revision"], payload["channels"])
+        for _, payload in (c.args for c in notify_mock.call_args_list)
+    ]
+    assert notified == [("upload", 7, []), ("release", 7, ["edge"])]
+
+
+def test_upload_release_not_allowed_notifications(store_mock, config, tmp_path):
+    """Only the upload is notified if the release is not allowed by the policy."""
+    config.set(release_policy=ReleasePolicyConfig(require_lower_risk=True))
+    store_mock.upload.return_value = Uploaded(ok=True, status=200, revision=7, errors=[])
+    store_mock.list_releases.return_value = ([], [], [])
+
+    test_charm = tmp_path / "mystuff.charm"
+    _build_zip_with_yaml(test_charm, "metadata.yaml", content={"name": "mycharm"})
+    args = Namespace(filepath=test_charm, release=["stable"], override_policy=False)
+    with patch("charmcraft.commands.store.notify") as notify_mock:
+        with pytest.raises(CommandError):
+            UploadCommand("group", config).run(args)
+
+    ((_, payload),) = (c.args for c in notify_mock.call_args_list)
+    assert payload["event"] == "upload"
+    assert payload["channels"] == []
+
+
+def test_upload_error_not_notified(store_mock, config, tmp_path):
+    """Nothing is notified if the upload fails."""
+    errors = [Error(message="text", code="problem")]
+    store_mock.upload.return_value = Uploaded(
+        ok=False, status=400, revision=None, errors=errors
+    )
+
+    test_charm = tmp_path / "mystuff.charm"
+    _build_zip_with_yaml(test_charm, "metadata.yaml", content={"name": "mycharm"})
+    args = Namespace(filepath=test_charm, release=["edge"], override_policy=False)
+    with patch("charmcraft.commands.store.notify") as notify_mock:
+        UploadCommand("group", config).run(args)
+
+    notify_mock.assert_not_called()
+
+
+def test_upload_call_ok_including_release_not_allowed(store_mock, config, tmp_path):
+    """Upload with a release not allowed by the release policy."""
+    config.set(release_policy=ReleasePolicyConfig(require_lower_risk=True))
+    store_mock.upload.return_value = Uploaded(ok=True, status=200, revision=7, errors=[])
+    store_mock.list_releases.return_value = ([], [], [])
+
+    test_charm = tmp_path / "mystuff.charm"
+    _build_zip_with_yaml(test_charm, "metadata.yaml", content={"name": "mycharm"})
+    args = Namespace(filepath=test_charm, release=["stable"], override_policy=False)
+    with pytest.raises(CommandError) as cm:
+        UploadCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "The release is not allowed by the release policy: Revision 7 needs to be "
+        "released to 'latest/candidate' before going to 'stable'. Use "
+        "--override-policy to release anyway."
+    )
+
+    # the revision was uploaded, but not released
+    assert store_mock.mock_calls == [
+        call.upload("mycharm", test_charm),
+        call.list_releases("mycharm"),
+    ]
+
+
 def test_upload_call_error_including_release(caplog, store_mock, config, tmp_path):
     """Upload with a realsea but the upload went wrong, so no release."""
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
@@ -537,7 +649,7 @@ def test_upload_call_error_including_release(caplog, store_mock, config, tmp_pat
 
     test_charm = tmp_path / "mystuff.charm"
     _build_zip_with_yaml(test_charm, "metadata.yaml", content={"name": "mycharm"})
-    args = Namespace(filepath=test_charm, release=["edge"])
+    args = Namespace(filepath=test_charm, release=["edge"], override_policy=False)
     UploadCommand("group", config).run(args)
 
     # check the upload was attempted, but not the release!
@@ -555,7 +667,7 @@ def test_upload_charm_with_init_template_todo_token(tmp_path, config):
         zf.writestr("file_ok.cfg", b"This is fine :).")
         zf.writestr("othertainted.txt", b"# TEMPLATE-TODO: need to fix.")
 
-    args = Namespace(filepath=test_charm, release=[])
+    args = Namespace(filepath=test_charm, release=[], override_policy=False)
     expected_msg = (
         "Cannot upload the charm as it include the following files with a leftover "
         "TEMPLATE-TODO token from when the project was created using the 'init' "
@@ -583,7 +695,7 @@ def test_revisions_simple(caplog, store_mock, config):
     ]
     store_mock.list_revisions.return_value = store_response
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     ListRevisionsCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -603,10 +715,29 @@ def test_revisions_empty(caplog, store_mock, config):
     store_response = []
     store_mock.list_revisions.return_value = store_response
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
+    ListRevisionsCommand("group", config).run(args)
+
+    expected = [
+        "No revisions found.",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_revisions_offline(caplog, store_mock, config):
+    """Show the cached revisions, with their age."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    store_mock.list_revisions.return_value = []
+    cached_at = datetime.datetime.utcnow() - datetime.timedelta(hours=3, minutes=5)
+    store_mock.get_cache_timestamp.return_value = cached_at
+
+    args = Namespace(name="testcharm", offline=True)
     ListRevisionsCommand("group", config).run(args)
 
+    assert store_mock.offline is True
     expected = [
+        "Working offline, showing the data cached 3 hours ago.",
         "No revisions found.",
     ]
     assert expected == [rec.message for rec in caplog.records]
@@ -632,7 +763,7 @@ def test_revisions_ordered_by_revision(caplog, store_mock, config):
     ]
     store_mock.list_revisions.return_value = store_response
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     ListRevisionsCommand("group", config).run(args)
 
     expected = [
@@ -659,7 +790,7 @@ def test_revisions_version_null(caplog, store_mock, config):
     ]
     store_mock.list_revisions.return_value = store_response
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     ListRevisionsCommand("group", config).run(args)
 
     expected = [
@@ -669,6 +800,44 @@ def test_revisions_version_null(caplog, store_mock, config):
     assert expected == [rec.message for rec in caplog.records]
 
 
+def test_revisions_commit_from_version(caplog, store_mock, config):
+    """Show the commit if the versions were built from git."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    tstamp = datetime.datetime(2020, 7, 3, 20, 30, 40)
+    store_response = [
+        Revision(
+            revision=1, version="v1", created_at=tstamp, status="accepted", errors=[]
+        ),
+        Revision(
+            revision=2,
+            version="v1.0-3-gabc1234",
+            created_at=tstamp,
+            status="accepted",
+            errors=[],
+        ),
+        Revision(
+            revision=3,
+            version="v1.0-4-gdef5678-dirty",
+            created_at=tstamp,
+            status="accepted",
+            errors=[],
+        ),
+    ]
+    store_mock.list_revisions.return_value = store_response
+
+    args = Namespace(name="testcharm", offline=False)
+    ListRevisionsCommand("group", config).run(args)
+
+    expected = [
+        "Revision    Version                Commit           Created at    Status",
+        "3           v1.0-4-gdef5678-dirty  def5678 (dirty)  2020-07-03    accepted",
+        "2           v1.0-3-gabc1234        abc1234          2020-07-03    accepted",
+        "1           v1                                      2020-07-03    accepted",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
 def test_revisions_errors_simple(caplog, store_mock, config):
     """Support having one case with a simple error."""
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
@@ -684,7 +853,7 @@ def test_revisions_errors_simple(caplog, store_mock, config):
     ]
     store_mock.list_revisions.return_value = store_response
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     ListRevisionsCommand("group", config).run(args)
 
     expected = [
@@ -712,7 +881,7 @@ def test_revisions_errors_multiple(caplog, store_mock, config):
     ]
     store_mock.list_revisions.return_value = store_response
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     ListRevisionsCommand("group", config).run(args)
 
     expected = [
@@ -729,11 +898,23 @@ def test_release_simple_ok(caplog, store_mock, config):
     """Simple case of releasing a revision ok."""
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
+    store_mock.list_resources.return_value = []
+
     channels = ["somechannel"]
-    args = Namespace(name="testcharm", revision=7, channel=channels, resource=[])
+    args = Namespace(
+        name="testcharm",
+        revision=7,
+        channel=channels,
+        resource=[],
+        override_policy=False,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
+    )
     ReleaseCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
+        call.list_resources("testcharm"),
         call.release("testcharm", 7, channels, []),
     ]
 
@@ -741,6 +922,35 @@ def test_release_simple_ok(caplog, store_mock, config):
     assert [expected] == [rec.message for rec in caplog.records]
 
 
+def test_release_notifications(store_mock, config):
+    """Notify the release, with its channels and resources."""
+    store_mock.list_resources.return_value = []
+
+    args = Namespace(
+        name="testcharm",
+        revision=7,
+        channel=["edge", "beta"],
+        resource=[ResourceOption(name="db", revision=3)],
+        override_policy=False,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
+    )
+    with patch("charmcraft.commands.store.notify") as notify_mock:
+        ReleaseCommand("group", config).run(args)
+
+    notify_mock.assert_called_once_with(
+        config,
+        {
+            "event": "release",
+            "name": "testcharm",
+            "revision": 7,
+            "channels": ["edge", "beta"],
+            "resources": [{"name": "db", "revision": 3}],
+        },
+    )
+
+
 def test_release_simple_multiple_channels(caplog, store_mock, config):
     """Releasing to multiple channels."""
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
@@ -750,6 +960,10 @@ def test_release_simple_multiple_channels(caplog, store_mock, config):
         revision=7,
         channel=["channel1", "channel2", "channel3"],
         resource=[],
+        override_policy=False,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
     )
     ReleaseCommand("group", config).run(args)
 
@@ -763,14 +977,24 @@ def test_release_including_resources(caplog, store_mock, config):
     """Releasing with resources."""
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
+    store_mock.list_resources.return_value = []
+
     r1 = ResourceOption(name="foo", revision=3)
     r2 = ResourceOption(name="bar", revision=17)
     args = Namespace(
-        name="testcharm", revision=7, channel=["testchannel"], resource=[r1, r2]
+        name="testcharm",
+        revision=7,
+        channel=["testchannel"],
+        resource=[r1, r2],
+        override_policy=False,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
     )
     ReleaseCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
+        call.list_resources("testcharm"),
         call.release("testcharm", 7, ["testchannel"], [r1, r2]),
     ]
 
@@ -781,6 +1005,378 @@ def test_release_including_resources(caplog, store_mock, config):
     assert [expected] == [rec.message for rec in caplog.records]
 
 
+def test_release_policy_not_allowed(store_mock, config):
+    """The release is not allowed by the release policy."""
+    config.set(release_policy=ReleasePolicyConfig(require_lower_risk=True))
+    store_mock.list_releases.return_value = ([], [], [])
+
+    args = Namespace(
+        name="testcharm",
+        revision=7,
+        channel=["beta"],
+        resource=[],
+        override_policy=False,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
+    )
+    with pytest.raises(CommandError) as cm:
+        ReleaseCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "The release is not allowed by the release policy: Revision 7 needs to be "
+        "released to 'latest/edge' before going to 'beta'. Use --override-policy "
+        "to release anyway."
+    )
+    assert call.release("testcharm", 7, ["beta"], []) not in store_mock.mock_calls
+
+
+def test_release_policy_overridden(caplog, store_mock, config):
+    """The release policy is overridden, and the release done."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    config.set(release_policy=ReleasePolicyConfig(restricted_tracks=["legacy"]))
+    store_mock.list_resources.return_value = []
+
+    args = Namespace(
+        name="testcharm",
+        revision=7,
+        channel=["legacy/edge"],
+        resource=[],
+        override_policy=True,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
+    )
+    ReleaseCommand("group", config).run(args)
+
+    assert call.release("testcharm", 7, ["legacy/edge"], []) in store_mock.mock_calls
+    assert [rec.message for rec in caplog.records] == [
+        "Overriding the release policy to release revision 7 of 'testcharm' to "
+        "legacy/edge.",
+        "- The track 'legacy' is restricted (releasing to 'legacy/edge').",
+        "Revision 7 of charm 'testcharm' released to legacy/edge",
+    ]
+
+
+def _oci_resources_store(store_mock, attached_resources):
+    """Set up the store with OCI image resources, some attached in the channel map."""
+    store_mock.list_resources.return_value = [
+        Resource(name=name, optional=False, revision=1, resource_type=resource_type)
+        for name, resource_type in [
+            ("app-image", "oci-image"),
+            ("db-image", "oci-image"),
+            ("config", "file"),
+        ]
+    ]
+    channel_map = [
+        Release(
+            revision=6,
+            channel="latest/edge",
+            expires_at=None,
+            resources=attached_resources,
+            released_at=None,
+            platform=None,
+        ),
+    ]
+    store_mock.list_releases.return_value = (channel_map, [], [])
+
+
+def test_release_container_resources_missing(caplog, store_mock, config):
+    """Warn about the OCI image resources without a revision to attach."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.commands")
+    attached = [
+        Resource(name="db-image", optional=False, revision=2, resource_type="oci-image")
+    ]
+    _oci_resources_store(store_mock, attached)
+
+    args = Namespace(
+        name="testcharm",
+        revision=7,
+        channel=["edge", "beta"],
+        resource=[],
+        override_policy=False,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
+    )
+    ReleaseCommand("group", config).run(args)
+
+    assert store_mock.mock_calls == [
+        call.list_resources("testcharm"),
+        call.list_releases("testcharm"),
+        call.release("testcharm", 7, ["edge", "beta"], []),
+    ]
+    assert [rec.message for rec in caplog.records] == [
+        "The OCI image resource 'app-image' has no revision attached in channel "
+        "'edge'; use --resource to indicate one.",
+        "The OCI image resource 'app-image' has no revision attached in channel "
+        "'beta'; use --resource to indicate one.",
+        "The OCI image resource 'db-image' has no revision attached in channel "
+        "'beta'; use --resource to indicate one.",
+    ]
+
+
+def test_release_container_resources_indicated(caplog, store_mock, config):
+    """No warnings if all the OCI image resources are indicated."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.commands")
+    _oci_resources_store(store_mock, [])
+
+    resources = [
+        ResourceOption(name="app-image", revision=3),
+        ResourceOption(name="db-image", revision=4),
+    ]
+    args = Namespace(
+        name="testcharm",
+        revision=7,
+        channel=["edge"],
+        resource=resources,
+        override_policy=False,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
+    )
+    ReleaseCommand("group", config).run(args)
+
+    assert store_mock.mock_calls == [
+        call.list_resources("testcharm"),
+        call.release("testcharm", 7, ["edge"], resources),
+    ]
+    assert caplog.records == []
+
+
+def test_release_container_resources_check_failed(caplog, store_mock, config):
+    """The release is done even if the resources could not be verified."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.commands")
+    store_mock.list_resources.side_effect = CommandError("not found")
+
+    args = Namespace(
+        name="testbundle",
+        revision=7,
+        channel=["edge"],
+        resource=[],
+        override_policy=False,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
+    )
+    ReleaseCommand("group", config).run(args)
+
+    assert store_mock.mock_calls == [
+        call.list_resources("testbundle"),
+        call.release("testbundle", 7, ["edge"], []),
+    ]
+    assert caplog.records == []
+
+
+def _tagging_args(channels=("stable",), **kwargs):
+    """Helper to build the release arguments for the git tagging tests."""
+    args = Namespace(
+        name="testcharm",
+        revision=42,
+        channel=list(channels),
+        resource=[],
+        override_policy=False,
+        git_tag=True,
+        tag_template=None,
+        push_tag=False,
+    )
+    for key, value in kwargs.items():
+        setattr(args, key, value)
+    return args
+
+
+@pytest.fixture
+def released_commit(git_repo, store_mock):
+    """Provide the commit included in the version of the released revision."""
+    commit = git.run_git(git_repo, "rev-parse", "HEAD")
+    store_mock.list_revisions.return_value = [
+        _build_revision(41, "v1.0"),
+        _build_revision(42, "v1.0-1-g" + commit[:7]),
+    ]
+    store_mock.list_resources.return_value = []
+    return commit
+
+
+def test_release_git_tag(caplog, store_mock, config, released_commit):
+    """Tag the released commit, once per channel."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    dirpath = config.project.dirpath
+
+    ReleaseCommand("group", config).run(_tagging_args(["stable", "2.0/edge"]))
+
+    store_mock.release.assert_called_once_with(
+        "testcharm", 42, ["stable", "2.0/edge"], []
+    )
+    for tag in ("testcharm/stable/r42", "testcharm/2.0/edge/r42"):
+        assert git.run_git(dirpath, "cat-file", "-t", tag) == "tag"
+        assert git.run_git(dirpath, "rev-parse", tag + "^{commit}") == released_commit
+    message = git.run_git(dirpath, "tag", "-l", "--format=%(contents)", "*/stable/*")
+    assert message == "Revision 42 of 'testcharm' released to stable, 2.0/edge"
+    records = [
+        rec for rec in caplog.records if rec.name.startswith("charmcraft.commands")
+    ]
+    assert [rec.message for rec in records] == [
+        "Revision 42 of charm 'testcharm' released to stable, 2.0/edge",
+        "Tagged commit {} as testcharm/stable/r42, testcharm/2.0/edge/r42.".format(
+            released_commit[:7]
+        ),
+    ]
+
+
+def test_release_git_tag_from_config(store_mock, config, released_commit):
+    """The tagging and its template can be configured."""
+    config.set(
+        git_tag=GitTagConfig(
+            enabled=True, template="release-{track}-{risk}-r{revision}"
+        )
+    )
+    args = _tagging_args(git_tag=False)
+
+    ReleaseCommand("group", config).run(args)
+
+    assert git.has_tag(config.project.dirpath, "release-latest-stable-r42")
+
+
+def test_release_git_tag_template_option(store_mock, config, released_commit):
+    """The template in the command line implies the tagging."""
+    config.set(git_tag=GitTagConfig(template="from-config-{revision}"))
+    args = _tagging_args(git_tag=False, tag_template="{name}-{version}")
+
+    ReleaseCommand("group", config).run(args)
+
+    dirpath = config.project.dirpath
+    assert git.has_tag(dirpath, "testcharm-v1.0-1-g" + released_commit[:7])
+    assert not git.has_tag(dirpath, "from-config-42")
+
+
+def test_release_git_tag_not_repository(store_mock, config):
+    """Nothing is released if the project is not in a git repository."""
+    with pytest.raises(CommandError) as cm:
+        ReleaseCommand("group", config).run(_tagging_args())
+
+    assert str(cm.value) == (
+        "Cannot tag the release: the project is not in a git repository."
+    )
+    store_mock.release.assert_not_called()
+
+
+@pytest.mark.parametrize(
+    "version, problem",
+    [
+        ("1.2.3", "version '1.2.3' does not include a commit."),
+        ("abc1234-dirty", "version 'abc1234-dirty' was built from a dirty tree."),
+        ("abcdef0", "commit 'abcdef0' not found in the repository."),
+    ],
+)
+def test_release_git_tag_no_commit(git_repo, store_mock, config, version, problem):
+    """Nothing is released if the revision cannot be mapped to a clean commit."""
+    store_mock.list_revisions.return_value = [_build_revision(42, version)]
+
+    with pytest.raises(CommandError) as cm:
+        ReleaseCommand("group", config).run(_tagging_args())
+
+    assert str(cm.value) == "Cannot tag the release: " + problem
+    store_mock.release.assert_not_called()
+
+
+def test_release_git_tag_missing_revision(git_repo, store_mock, config):
+    """Nothing is released if the revision is not found."""
+    store_mock.list_revisions.return_value = [_build_revision(41, "abc1234")]
+
+    with pytest.raises(CommandError) as cm:
+        ReleaseCommand("group", config).run(_tagging_args())
+
+    assert str(cm.value) == "Cannot tag the release: revision 42 not found."
+    store_mock.release.assert_not_called()
+
+
+def test_release_git_tag_existing(store_mock, config, released_commit):
+    """Nothing is released if a tag already exists (tags are never moved)."""
+    factory.git(config.project.dirpath, "tag", "testcharm/edge/r42")
+
+    with pytest.raises(CommandError) as cm:
+        ReleaseCommand("group", config).run(_tagging_args(["stable", "edge"]))
+
+    assert str(cm.value) == (
+        "Cannot tag the release: tag 'testcharm/edge/r42' already exists."
+    )
+    store_mock.release.assert_not_called()
+    assert not git.has_tag(config.project.dirpath, "testcharm/stable/r42")
+
+
+def test_release_git_tag_invalid_name(store_mock, config, released_commit):
+    """Nothing is released if the template produces an invalid tag name."""
+    args = _tagging_args(tag_template="{name} {revision}")
+
+    with pytest.raises(CommandError) as cm:
+        ReleaseCommand("group", config).run(args)
+
+    assert str(cm.value) == (
+        "Cannot tag the release: 'testcharm 42' is not a valid tag name."
+    )
+    store_mock.release.assert_not_called()
+
+
+def test_release_git_tag_invalid_template(store_mock, config, released_commit):
+    """Nothing is released if the template in the command line is invalid."""
+    args = _tagging_args(tag_template="{name}-{commit}")
+
+    with pytest.raises(CommandError) as cm:
+        ReleaseCommand("group", config).run(args)
+
+    assert str(cm.value).startswith(
+        "Cannot tag the release: unknown field(s) in template: commit"
+    )
+    store_mock.release.assert_not_called()
+
+
+def test_release_git_tag_creation_failed(store_mock, config, released_commit):
+    """The release is done and notified even if the tag cannot be created."""
+    with patch("charmcraft.commands.store.git.create_tag") as create_mock:
+        create_mock.side_effect = CommandError("boom")
+        with patch("charmcraft.commands.store.notify") as notify_mock:
+            with pytest.raises(CommandError) as cm:
+                ReleaseCommand("group", config).run(_tagging_args())
+
+    assert str(cm.value) == (
+        "Revision 42 was released, but the git tag 'testcharm/stable/r42' could "
+        "not be created: boom"
+    )
+    store_mock.release.assert_called_once_with("testcharm", 42, ["stable"], [])
+    notify_mock.assert_called_once()
+
+
+def test_release_git_tag_push(caplog, store_mock, config, released_commit):
+    """Push the tags to the configured remote."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    dirpath = config.project.dirpath
+    remote = dirpath / "remote.git"
+    factory.git(dirpath, "init", "--quiet", "--bare", str(remote))
+    factory.git(dirpath, "remote", "add", "upstream", str(remote))
+    config.set(git_tag=GitTagConfig(remote="upstream"))
+
+    ReleaseCommand("group", config).run(_tagging_args(push_tag=True))
+
+    assert git.has_tag(remote, "testcharm/stable/r42")
+    records = [
+        rec for rec in caplog.records if rec.name.startswith("charmcraft.commands")
+    ]
+    assert records[-1].message == "Pushed the git tags to 'upstream'."
+
+
+def test_release_git_tag_push_failed(caplog, store_mock, config, released_commit):
+    """A failed push is only a warning, the tags are already created."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.commands")
+    config.set(git_tag=GitTagConfig(push=True))
+
+    ReleaseCommand("group", config).run(_tagging_args())
+
+    assert git.has_tag(config.project.dirpath, "testcharm/stable/r42")
+    (record,) = [
+        rec for rec in caplog.records if rec.name.startswith("charmcraft.commands")
+    ]
+    assert record.message.startswith("Cannot push the git tags to 'origin': ")
+
+
 def test_release_options_resource(config):
     """The --resource-file option implies a set of validations."""
     cmd = ReleaseCommand("group", config)
@@ -838,7 +1434,45 @@ def test_release_parameters_ok(config, sysargs, expected_parsed):
     except SystemExit:
         pytest.fail("Parsing of {} was not ok.".format(sysargs))
     attribs = ["name", "revision", "channel", "resource"]
-    assert args == Namespace(**dict(zip(attribs, expected_parsed)))
+    expected = Namespace(
+        override_policy=False,
+        git_tag=False,
+        tag_template=None,
+        push_tag=False,
+        **dict(zip(attribs, expected_parsed))
+    )
+    assert args == expected
+
+
+def test_release_parameters_git_tag(config):
+    """The git tagging options."""
+    cmd = ReleaseCommand("group", config)
+    parser = ArgumentParser()
+    cmd.fill_parser(parser)
+    args = parser.parse_args(
+        ["somename", "-c=stable", "-r=3", "--git-tag", "--tag-template=t-{revision}"]
+    )
+    assert args.git_tag is True
+    assert args.tag_template == "t-{revision}"
+    assert args.push_tag is False
+
+
+def test_release_parameters_push_tag(config):
+    """The tags can be pushed."""
+    cmd = ReleaseCommand("group", config)
+    parser = ArgumentParser()
+    cmd.fill_parser(parser)
+    args = parser.parse_args(["somename", "-c=stable", "-r=3", "--push-tag"])
+    assert args.push_tag is True
+
+
+def test_release_parameters_override_policy(config):
+    """The release policy can be overridden."""
+    cmd = ReleaseCommand("group", config)
+    parser = ArgumentParser()
+    cmd.fill_parser(parser)
+    args = parser.parse_args(["somename", "-c=stable", "-r=3", "--override-policy"])
+    assert args.override_policy is True
 
 
 @pytest.mark.parametrize(
@@ -898,10 +1532,38 @@ def test_status_simple_ok(caplog, store_mock, config):
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
     channel_map = [
-        Release(revision=7, channel="latest/stable", expires_at=None, resources=[]),
-        Release(revision=7, channel="latest/candidate", expires_at=None, resources=[]),
-        Release(revision=80, channel="latest/beta", expires_at=None, resources=[]),
-        Release(revision=156, channel="latest/edge", expires_at=None, resources=[]),
+        Release(
+            revision=7,
+            channel="latest/stable",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=7,
+            channel="latest/candidate",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=80,
+            channel="latest/beta",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=156,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
     ]
     channels = _build_channels()
     revisions = [
@@ -911,7 +1573,7 @@ def test_status_simple_ok(caplog, store_mock, config):
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -933,20 +1595,53 @@ def test_status_empty(caplog, store_mock, config):
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
     store_mock.list_releases.return_value = [], [], []
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     expected = "Nothing has been released yet."
     assert [expected] == [rec.message for rec in caplog.records]
 
 
+def test_status_offline(caplog, store_mock, config):
+    """Show the cached channel map, with its age."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    store_mock.list_releases.return_value = [], [], []
+    cached_at = datetime.datetime.utcnow() - datetime.timedelta(days=2, hours=1)
+    store_mock.get_cache_timestamp.return_value = cached_at
+
+    args = Namespace(name="testcharm", offline=True)
+    StatusCommand("group", config).run(args)
+
+    assert store_mock.offline is True
+    expected = [
+        "Working offline, showing the data cached 2 days ago.",
+        "Nothing has been released yet.",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
 def test_status_channels_not_released_with_fallback(caplog, store_mock, config):
     """Support gaps in channel releases, having fallbacks."""
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
     channel_map = [
-        Release(revision=7, channel="latest/stable", expires_at=None, resources=[]),
-        Release(revision=80, channel="latest/edge", expires_at=None, resources=[]),
+        Release(
+            revision=7,
+            channel="latest/stable",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=80,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
     ]
     channels = _build_channels()
     revisions = [
@@ -955,7 +1650,7 @@ def test_status_channels_not_released_with_fallback(caplog, store_mock, config):
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -977,8 +1672,22 @@ def test_status_channels_not_released_without_fallback(caplog, store_mock, confi
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
     channel_map = [
-        Release(revision=5, channel="latest/beta", expires_at=None, resources=[]),
-        Release(revision=12, channel="latest/edge", expires_at=None, resources=[]),
+        Release(
+            revision=5,
+            channel="latest/beta",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=12,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
     ]
     channels = _build_channels()
     revisions = [
@@ -987,7 +1696,7 @@ def test_status_channels_not_released_without_fallback(caplog, store_mock, confi
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -1009,8 +1718,22 @@ def test_status_multiple_tracks(caplog, store_mock, config):
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
     channel_map = [
-        Release(revision=503, channel="latest/stable", expires_at=None, resources=[]),
-        Release(revision=1, channel="2.0/edge", expires_at=None, resources=[]),
+        Release(
+            revision=503,
+            channel="latest/stable",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=1,
+            channel="2.0/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
     ]
     channels_latest = _build_channels()
     channels_track = _build_channels(track="2.0")
@@ -1021,7 +1744,7 @@ def test_status_multiple_tracks(caplog, store_mock, config):
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -1047,10 +1770,38 @@ def test_status_tracks_order(caplog, store_mock, config):
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
     channel_map = [
-        Release(revision=1, channel="latest/edge", expires_at=None, resources=[]),
-        Release(revision=2, channel="aaa/edge", expires_at=None, resources=[]),
-        Release(revision=3, channel="2.0/edge", expires_at=None, resources=[]),
-        Release(revision=4, channel="zzz/edge", expires_at=None, resources=[]),
+        Release(
+            revision=1,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=2,
+            channel="aaa/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=3,
+            channel="2.0/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=4,
+            channel="zzz/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
     ]
     channels_latest = _build_channels()
     channels_track_1 = _build_channels(track="zzz")
@@ -1065,7 +1816,7 @@ def test_status_tracks_order(caplog, store_mock, config):
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -1100,12 +1851,21 @@ def test_status_with_one_branch(caplog, store_mock, config):
 
     tstamp_with_timezone = dateutil.parser.parse("2020-07-03T20:30:40Z")
     channel_map = [
-        Release(revision=5, channel="latest/beta", expires_at=None, resources=[]),
+        Release(
+            revision=5,
+            channel="latest/beta",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
         Release(
             revision=12,
             channel="latest/beta/mybranch",
             expires_at=tstamp_with_timezone,
             resources=[],
+            released_at=None,
+            platform=None,
         ),
     ]
     channels = _build_channels()
@@ -1124,7 +1884,7 @@ def test_status_with_one_branch(caplog, store_mock, config):
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -1148,12 +1908,29 @@ def test_status_with_multiple_branches(caplog, store_mock, config):
 
     tstamp = dateutil.parser.parse("2020-07-03T20:30:40Z")
     channel_map = [
-        Release(revision=5, channel="latest/beta", expires_at=None, resources=[]),
         Release(
-            revision=12, channel="latest/beta/branch-1", expires_at=tstamp, resources=[]
+            revision=5,
+            channel="latest/beta",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
         ),
         Release(
-            revision=15, channel="latest/beta/branch-2", expires_at=tstamp, resources=[]
+            revision=12,
+            channel="latest/beta/branch-1",
+            expires_at=tstamp,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=15,
+            channel="latest/beta/branch-2",
+            expires_at=tstamp,
+            resources=[],
+            released_at=None,
+            platform=None,
         ),
     ]
     channels = _build_channels()
@@ -1182,7 +1959,7 @@ def test_status_with_multiple_branches(caplog, store_mock, config):
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -1213,8 +1990,17 @@ def test_status_with_resources(caplog, store_mock, config):
             channel="latest/candidate",
             expires_at=None,
             resources=[res1, res2],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=5,
+            channel="latest/beta",
+            expires_at=None,
+            resources=[res1],
+            released_at=None,
+            platform=None,
         ),
-        Release(revision=5, channel="latest/beta", expires_at=None, resources=[res1]),
     ]
     channels = _build_channels()
     revisions = [
@@ -1222,7 +2008,7 @@ def test_status_with_resources(caplog, store_mock, config):
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     expected = [
@@ -1244,11 +2030,28 @@ def test_status_with_resources_missing_after_closed_channel(caplog, store_mock,
     )
     channel_map = [
         Release(
-            revision=5, channel="latest/stable", expires_at=None, resources=[resource]
+            revision=5,
+            channel="latest/stable",
+            expires_at=None,
+            resources=[resource],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=5,
+            channel="latest/beta",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
         ),
-        Release(revision=5, channel="latest/beta", expires_at=None, resources=[]),
         Release(
-            revision=5, channel="latest/edge", expires_at=None, resources=[resource]
+            revision=5,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[resource],
+            released_at=None,
+            platform=None,
         ),
     ]
     channels = _build_channels()
@@ -1257,7 +2060,7 @@ def test_status_with_resources_missing_after_closed_channel(caplog, store_mock,
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     expected = [
@@ -1278,12 +2081,21 @@ def test_status_with_resources_and_branches(caplog, store_mock, config):
     res1 = Resource(name="testres", optional=True, revision=1, resource_type="file")
     res2 = Resource(name="testres", optional=True, revision=14, resource_type="file")
     channel_map = [
-        Release(revision=23, channel="latest/beta", expires_at=None, resources=[res2]),
+        Release(
+            revision=23,
+            channel="latest/beta",
+            expires_at=None,
+            resources=[res2],
+            released_at=None,
+            platform=None,
+        ),
         Release(
             revision=5,
             channel="latest/edge/mybranch",
             expires_at=tstamp,
             resources=[res1],
+            released_at=None,
+            platform=None,
         ),
     ]
     channels = _build_channels()
@@ -1302,7 +2114,7 @@ def test_status_with_resources_and_branches(caplog, store_mock, config):
     ]
     store_mock.list_releases.return_value = (channel_map, channels, revisions)
 
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     StatusCommand("group", config).run(args)
 
     expected = [
@@ -1316,6 +2128,163 @@ def test_status_with_resources_and_branches(caplog, store_mock, config):
     assert expected == [rec.message for rec in caplog.records]
 
 
+# -- tests for the tui command
+
+
+@pytest.fixture
+def tty_mock():
+    """Make the command believe it runs in an interactive terminal."""
+    with patch("sys.stdin") as stdin_mock, patch("sys.stdout") as stdout_mock:
+        stdin_mock.isatty.return_value = True
+        stdout_mock.isatty.return_value = True
+        yield
+
+
+def _run_tui(config, keys, override=False):
+    """Run the tui command, with the grid handling the indicated keys."""
+
+    def _fake_wrapper(func):
+        grid = func.__self__
+        for key in keys:
+            grid.handle_key(ord(key))
+        assert grid.finished
+        return grid.confirmed
+
+    args = Namespace(name="testcharm", override_policy=override)
+    with patch("curses.wrapper", _fake_wrapper):
+        TuiCommand("group", config).run(args)
+
+
+def _setup_tui_store(store_mock):
+    """Prepare the store with a simple channel map."""
+    channel_map = [
+        Release(
+            revision=7,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+    ]
+    revisions = [
+        _build_revision(revno=5, version="v5"),
+        _build_revision(revno=7, version="v7"),
+    ]
+    store_mock.list_releases.return_value = (channel_map, _build_channels(), revisions)
+    store_mock.list_resources.return_value = []
+
+
+def test_tui_release(caplog, store_mock, config, tty_mock):
+    """Release the planned revisions when confirmed."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    _setup_tui_store(store_mock)
+
+    # select the revision in edge, and release it in beta and candidate
+    keys = ["j", "j", "j", "s", "k", "\n", "k", "\n", "c", "y"]
+    _run_tui(config, keys)
+
+    assert store_mock.mock_calls == [
+        call.list_releases("testcharm"),
+        call.list_resources("testcharm"),
+        call.release("testcharm", 7, ["latest/beta", "latest/candidate"], ()),
+    ]
+    assert [rec.message for rec in caplog.records] == [
+        "Revision 7 of 'testcharm' released to latest/beta, latest/candidate",
+    ]
+
+
+def test_tui_release_error(caplog, store_mock, config, tty_mock):
+    """The releases already done are reported when one fails."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    _setup_tui_store(store_mock)
+    store_mock.release.side_effect = [None, CommandError("Store failure.")]
+
+    # release revision 7 (from edge) to beta, and revision 5 to stable
+    keys = ["j", "j", "j", "s", "k", "\n", "\t", "j", "\n", "k", "k", "\n", "c", "y"]
+    with pytest.raises(CommandError) as cm:
+        _run_tui(config, keys)
+    assert str(cm.value) == "Store failure."
+
+    assert store_mock.release.mock_calls == [
+        call("testcharm", 7, ["latest/beta"], ()),
+        call("testcharm", 5, ["latest/stable"], ()),
+    ]
+    assert [rec.message for rec in caplog.records] == [
+        "Revision 7 of 'testcharm' released to latest/beta",
+    ]
+
+
+def test_tui_quit(caplog, store_mock, config, tty_mock):
+    """Nothing is released if the user leaves without confirming."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    _setup_tui_store(store_mock)
+
+    _run_tui(config, ["j", "s", "\n", "q"])
+
+    store_mock.release.assert_not_called()
+    assert [rec.message for rec in caplog.records] == ["Nothing released."]
+
+
+def test_tui_policy_overridden(caplog, store_mock, config, tty_mock):
+    """The violations of the release policy are logged when overridden."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    config.set(release_policy=ReleasePolicyConfig(require_lower_risk=True))
+    _setup_tui_store(store_mock)
+
+    # release revision 5 to stable, which was not in candidate
+    keys = ["\t", "j", "\n", "\n", "c", "y"]
+    _run_tui(config, keys, override=True)
+
+    assert store_mock.release.mock_calls == [
+        call("testcharm", 5, ["latest/stable"], ())
+    ]
+    assert [rec.message for rec in caplog.records] == [
+        "Overriding the release policy to release revisions of 'testcharm'.",
+        "- Revision 5 needs to be released to 'latest/candidate' before going to "
+        "'latest/stable'.",
+        "Revision 5 of 'testcharm' released to latest/stable",
+    ]
+
+
+def test_tui_policy_not_allowed(caplog, store_mock, config, tty_mock):
+    """The planned releases cannot be confirmed if the policy does not allow them."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    config.set(release_policy=ReleasePolicyConfig(require_lower_risk=True))
+    _setup_tui_store(store_mock)
+
+    # the confirmation is refused, so go back to the grid and leave
+    keys = ["\t", "j", "\n", "\n", "c", "y", "n", "q"]
+    _run_tui(config, keys)
+
+    store_mock.release.assert_not_called()
+    assert [rec.message for rec in caplog.records] == ["Nothing released."]
+
+
+def test_tui_not_interactive(store_mock, config):
+    """The command needs an interactive terminal."""
+    with patch("sys.stdin") as stdin_mock:
+        stdin_mock.isatty.return_value = False
+        with pytest.raises(CommandError) as cm:
+            TuiCommand("group", config).run(
+                Namespace(name="testcharm", override_policy=False)
+            )
+    assert str(cm.value) == (
+        "The 'tui' command needs an interactive terminal; use the 'status' and "
+        "'release' commands instead."
+    )
+    store_mock.list_releases.assert_not_called()
+
+
+def test_tui_curses_not_needed_to_import():
+    """The commands can be imported where curses is not available."""
+    code = (
+        "import sys; sys.modules['curses'] = None; import charmcraft.commands.store"
+    )
+    proc = subprocess.run([sys.executable, "-c", code], stderr=subprocess.PIPE)
+    assert proc.returncode == 0, proc.stderr.decode()
+
+
 # -- tests for create library command
 
 
@@ -1357,8 +2326,9 @@ def test_createlib_name_from_metadata_problem(store_mock, config):
         with pytest.raises(CommandError) as cm:
             CreateLibCommand("group", config).run(args)
         assert str(cm.value) == (
-            "Cannot find a valid charm name in metadata.yaml. Check you are in a charm "
-            "directory with metadata.yaml."
+            "Cannot find a valid charm name in metadata.yaml nor in the 'metadata' "
+            "section of charmcraft.yaml. Check you are in a charm directory with any "
+            "of them."
         )
 
 
@@ -1487,6 +2457,31 @@ def test_publishlib_simple(caplog, store_mock, tmp_path, monkeypatch, config):
     assert [expected] == [rec.message for rec in caplog.records]
 
 
+def test_publishlib_notifications(store_mock, tmp_path, monkeypatch, config):
+    """Notify each published library."""
+    monkeypatch.chdir(tmp_path)
+    factory.create_lib_filepath("testcharm", "testlib", api=0, patch=1)
+
+    store_mock.get_libraries_tips.return_value = {}
+    args = Namespace(library="charms.testcharm.v0.testlib")
+    with patch("charmcraft.commands.store.get_name_from_metadata") as mock:
+        mock.return_value = "testcharm"
+        with patch("charmcraft.commands.store.notify") as notify_mock:
+            PublishLibCommand("group", config).run(args)
+
+    notify_mock.assert_called_once_with(
+        config,
+        {
+            "event": "publish-lib",
+            "name": "testcharm",
+            "revision": None,
+            "channels": [],
+            "resources": [],
+            "library": {"name": "charms.testcharm.v0.testlib", "version": "0.1"},
+        },
+    )
+
+
 def test_publishlib_contains_dash(caplog, store_mock, tmp_path, monkeypatch, config):
     """Happy path publishing because no revision at all in the Store."""
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
@@ -1606,8 +2601,9 @@ def test_publishlib_name_from_metadata_problem(store_mock, config):
             PublishLibCommand("group", config).run(args)
 
         assert str(cm.value) == (
-            "Can't access name in 'metadata.yaml' file. The 'publish-lib' command needs to "
-            "be executed in a valid project's directory."
+            "Can't access name in 'metadata.yaml' file nor in the 'metadata' section of "
+            "charmcraft.yaml. The 'publish-lib' command needs to be executed in a valid "
+            "project's directory."
         )
 
 
@@ -2538,7 +3534,7 @@ def test_listlib_simple(caplog, store_mock, config):
             charm_name="testcharm",
         ),
     }
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     ListLibCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -2556,7 +3552,7 @@ def test_listlib_charm_from_metadata(caplog, store_mock, config):
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
     store_mock.get_libraries_tips.return_value = {}
-    args = Namespace(name=None)
+    args = Namespace(name=None, offline=False)
     with patch("charmcraft.commands.store.get_name_from_metadata") as mock:
         mock.return_value = "testcharm"
         ListLibCommand("group", config).run(args)
@@ -2568,16 +3564,17 @@ def test_listlib_charm_from_metadata(caplog, store_mock, config):
 
 def test_listlib_name_from_metadata_problem(store_mock, config):
     """The metadata wasn't there to get the name."""
-    args = Namespace(name=None)
+    args = Namespace(name=None, offline=False)
     with patch("charmcraft.commands.store.get_name_from_metadata") as mock:
         mock.return_value = None
         with pytest.raises(CommandError) as cm:
             ListLibCommand("group", config).run(args)
 
         assert str(cm.value) == (
-            "Can't access name in 'metadata.yaml' file. The 'list-lib' command must either be "
-            "executed from a valid project directory, or specify a charm name using "
-            "the --charm-name option."
+            "Can't access name in 'metadata.yaml' file nor in the 'metadata' section "
+            "of charmcraft.yaml. The 'list-lib' command must either be executed from "
+            "a valid project directory, or specify a charm name using the "
+            "--charm-name option."
         )
 
 
@@ -2586,13 +3583,32 @@ def test_listlib_empty(caplog, store_mock, config):
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
 
     store_mock.get_libraries_tips.return_value = {}
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     ListLibCommand("group", config).run(args)
 
     expected = "No libraries found for charm testcharm."
     assert [expected] == [rec.message for rec in caplog.records]
 
 
+def test_listlib_offline(caplog, store_mock, config):
+    """Show the cached libraries, with their age."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    store_mock.get_libraries_tips.return_value = {}
+    cached_at = datetime.datetime.utcnow() - datetime.timedelta(minutes=10, seconds=3)
+    store_mock.get_cache_timestamp.return_value = cached_at
+
+    args = Namespace(name="testcharm", offline=True)
+    ListLibCommand("group", config).run(args)
+
+    assert store_mock.offline is True
+    expected = [
+        "Working offline, showing the data cached 10 minutes ago.",
+        "No libraries found for charm testcharm.",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
 def test_listlib_properly_sorted(caplog, store_mock, config):
     """Check the sorting of the list."""
     caplog.set_level(logging.INFO, logger="charmcraft.commands")
@@ -2626,7 +3642,7 @@ def test_listlib_properly_sorted(caplog, store_mock, config):
             charm_name="testcharm",
         ),
     }
-    args = Namespace(name="testcharm")
+    args = Namespace(name="testcharm", offline=False)
     ListLibCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -2653,7 +3669,7 @@ def test_resources_simple(caplog, store_mock, config):
     ]
     store_mock.list_resources.return_value = store_response
 
-    args = Namespace(charm_name="testcharm")
+    args = Namespace(charm_name="testcharm", offline=False)
     ListResourcesCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -2673,10 +3689,29 @@ def test_resources_empty(caplog, store_mock, config):
     store_response = []
     store_mock.list_resources.return_value = store_response
 
-    args = Namespace(charm_name="testcharm")
+    args = Namespace(charm_name="testcharm", offline=False)
+    ListResourcesCommand("group", config).run(args)
+
+    expected = [
+        "No resources associated to testcharm.",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_resources_offline(caplog, store_mock, config):
+    """Show the cached resources, with their age."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    store_mock.list_resources.return_value = []
+    cached_at = datetime.datetime.utcnow() - datetime.timedelta(hours=5, minutes=1)
+    store_mock.get_cache_timestamp.return_value = cached_at
+
+    args = Namespace(charm_name="testcharm", offline=True)
     ListResourcesCommand("group", config).run(args)
 
+    assert store_mock.offline is True
     expected = [
+        "Working offline, showing the data cached 5 hours ago.",
         "No resources associated to testcharm.",
     ]
     assert expected == [rec.message for rec in caplog.records]
@@ -2695,7 +3730,7 @@ def test_resources_ordered_and_grouped(caplog, store_mock, config):
     ]
     store_mock.list_resources.return_value = store_response
 
-    args = Namespace(charm_name="testcharm")
+    args = Namespace(charm_name="testcharm", offline=False)
     ListResourcesCommand("group", config).run(args)
 
     expected = [
@@ -2946,7 +3981,9 @@ def test_resourcerevisions_simple(caplog, store_mock, config):
     ]
     store_mock.list_resource_revisions.return_value = store_response
 
-    args = Namespace(charm_name="testcharm", resource_name="testresource")
+    args = Namespace(
+        charm_name="testcharm", resource_name="testresource", offline=False
+    )
     ListResourceRevisionsCommand("group", config).run(args)
 
     assert store_mock.mock_calls == [
@@ -2966,7 +4003,9 @@ def test_resourcerevisions_empty(caplog, store_mock, config):
     store_response = []
     store_mock.list_resource_revisions.return_value = store_response
 
-    args = Namespace(charm_name="testcharm", resource_name="testresource")
+    args = Namespace(
+        charm_name="testcharm", resource_name="testresource", offline=False
+    )
     ListResourceRevisionsCommand("group", config).run(args)
 
     expected = [
@@ -2990,7 +4029,9 @@ def test_resourcerevisions_ordered_by_revision(caplog, store_mock, config):
     ]
     store_mock.list_resource_revisions.return_value = store_response
 
-    args = Namespace(charm_name="testcharm", resource_name="testresource")
+    args = Namespace(
+        charm_name="testcharm", resource_name="testresource", offline=False
+    )
     ListResourceRevisionsCommand("group", config).run(args)
 
     expected = [
@@ -3001,3 +4042,386 @@ def test_resourcerevisions_ordered_by_revision(caplog, store_mock, config):
         "1           2020-07-03    4.9K",
     ]
     assert expected == [rec.message for rec in caplog.records]
+
+
+def test_resourcerevisions_offline(caplog, store_mock, config):
+    """Show the cached resource revisions, with their age."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    store_mock.list_resource_revisions.return_value = []
+    cached_at = datetime.datetime.utcnow() - datetime.timedelta(hours=3, minutes=5)
+    store_mock.get_cache_timestamp.return_value = cached_at
+
+    args = Namespace(charm_name="testcharm", resource_name="testresource", offline=True)
+    ListResourceRevisionsCommand("group", config).run(args)
+
+    assert store_mock.offline is True
+    expected = [
+        "Working offline, showing the data cached 3 hours ago.",
+        "No revisions found.",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+# -- tests for export and verify export commands
+
+
+def test_export_simple(caplog, store_mock, config, tmp_path):
+    """Export a charm to the indicated file."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    output = tmp_path / "export.tar"
+    manifest = {
+        "channel": "latest/stable",
+        "revision": 7,
+        "resources": [{"name": "someresource", "type": "file", "revision": 3}],
+    }
+    args = Namespace(
+        name="testcharm",
+        channel="stable",
+        output=output,
+        architecture=None,
+        series=None,
+    )
+    with patch("charmcraft.commands.store.build_export") as build_mock:
+        build_mock.return_value = manifest
+        ExportCommand("group", config).run(args)
+
+    build_mock.assert_called_once_with(
+        store_mock, "testcharm", "stable", output, architecture=None, series=None
+    )
+    expected = [
+        "Exported revision 7 of 'testcharm' from channel 'latest/stable' "
+        "to {!r}.".format(str(output)),
+        "- resource 'someresource' (file) revision 3",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_export_default_output(store_mock, config):
+    """The archive is named after the charm if not indicated."""
+    manifest = {"channel": "latest/edge", "revision": 1, "resources": []}
+    args = Namespace(
+        name="testcharm",
+        channel="edge",
+        output=None,
+        architecture="arm64",
+        series="focal",
+    )
+    with patch("charmcraft.commands.store.build_export") as build_mock:
+        build_mock.return_value = manifest
+        ExportCommand("group", config).run(args)
+
+    build_mock.assert_called_once_with(
+        store_mock,
+        "testcharm",
+        "edge",
+        pathlib.Path("testcharm.tar"),
+        architecture="arm64",
+        series="focal",
+    )
+
+
+def test_export_parser_defaults(config):
+    """Check the default values for the export command."""
+    parser = ArgumentParser()
+    ExportCommand("group", config).fill_parser(parser)
+    args = parser.parse_args(["testcharm"])
+    assert args.name == "testcharm"
+    assert args.channel == "stable"
+    assert args.output is None
+    assert args.architecture is None
+    assert args.series is None
+
+
+def test_verifyexport_ok(caplog, config, tmp_path):
+    """The archive is fine."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    filepath = tmp_path / "export.tar"
+    manifest = {
+        "name": "testcharm",
+        "channel": "latest/stable",
+        "revision": 7,
+        "files": [{"path": "a"}, {"path": "b"}],
+    }
+    args = Namespace(filepath=filepath)
+    with patch("charmcraft.commands.store.verify_export") as verify_mock:
+        verify_mock.return_value = (manifest, [])
+        VerifyExportCommand("group", config).run(args)
+
+    verify_mock.assert_called_once_with(filepath)
+    expected = [
+        "Archive {!r} verified: revision 7 of 'testcharm' from channel "
+        "'latest/stable', 2 files.".format(str(filepath)),
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_verifyexport_problems(caplog, config, tmp_path):
+    """The archive has problems."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    filepath = tmp_path / "export.tar"
+    problems = ["Missing file: 'a'.", "Wrong hash for file 'b'."]
+    args = Namespace(filepath=filepath)
+    with patch("charmcraft.commands.store.verify_export") as verify_mock:
+        verify_mock.return_value = ({}, problems)
+        with pytest.raises(CommandError) as cm:
+            VerifyExportCommand("group", config).run(args)
+
+    assert str(cm.value) == (
+        "The archive {!r} is corrupted (2 problem(s) found).".format(str(filepath))
+    )
+    expected = [
+        "- Missing file: 'a'.",
+        "- Wrong hash for file 'b'.",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+# -- tests for the changelog command
+
+
+def _build_changelog_releases(old_version="v1.0-0-gabc1234", new_version="def5678"):
+    """Build what list_releases returns, with revision 5 in stable and 7 in edge."""
+    tstamp = datetime.datetime(2020, 7, 3, 20, 30, 40)
+    channel_map = [
+        Release(
+            revision=5,
+            channel="latest/stable",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+        Release(
+            revision=7,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[],
+            released_at=None,
+            platform=None,
+        ),
+    ]
+    revisions = [
+        Revision(
+            revision=5,
+            version=old_version,
+            created_at=tstamp,
+            status="released",
+            errors=[],
+        ),
+        Revision(
+            revision=7,
+            version=new_version,
+            created_at=tstamp,
+            status="released",
+            errors=[],
+        ),
+    ]
+    return channel_map, [], revisions
+
+
+def _create_charm_zip(filepath, files):
+    """Create a charm artifact with the given files."""
+    with zipfile.ZipFile(str(filepath), "w") as zf:
+        for name, content in files.items():
+            zf.writestr(name, content)
+
+
+def test_diff_artifacts(tmp_path):
+    """Compare the content of two charm artifacts."""
+    old_filepath = tmp_path / "old.charm"
+    _create_charm_zip(
+        old_filepath, {"same": b"content", "modified": b"old", "removed": b"bye"}
+    )
+    new_filepath = tmp_path / "new.charm"
+    _create_charm_zip(
+        new_filepath, {"same": b"content", "modified": b"new", "added": b"hi"}
+    )
+
+    assert diff_artifacts(old_filepath, new_filepath) == [
+        ("A", "added"),
+        ("M", "modified"),
+        ("D", "removed"),
+    ]
+
+
+def test_changelog_from_git(caplog, store_mock, config):
+    """Show the log and changed files from the git repository."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    store_mock.list_releases.return_value = _build_changelog_releases()
+
+    args = Namespace(name="testcharm", from_channel="stable", to_channel="edge")
+    with patch("charmcraft.git.is_repository", return_value=True):
+        with patch("charmcraft.git.has_commit", return_value=True):
+            with patch("charmcraft.git.get_log") as get_log_mock:
+                get_log_mock.return_value = ["def5678 Fix stuff", "bcd3456 Add stuff"]
+                with patch("charmcraft.git.get_changed_files") as changed_mock:
+                    changed_mock.return_value = [("M", "src/charm.py")]
+                    ChangelogCommand("group", config).run(args)
+
+    assert store_mock.mock_calls == [call.list_releases("testcharm")]
+    dirpath = config.project.dirpath
+    get_log_mock.assert_called_once_with(dirpath, "abc1234", "def5678")
+    changed_mock.assert_called_once_with(dirpath, "abc1234", "def5678")
+    expected = [
+        "From revision 5 (latest/stable, version v1.0-0-gabc1234)",
+        "  to revision 7 (latest/edge, version def5678)",
+        "Commits:",
+        "def5678 Fix stuff",
+        "bcd3456 Add stuff",
+        "Changed files:",
+        "M  src/charm.py",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_changelog_same_revision(caplog, store_mock, config):
+    """Nothing to show if both channels have the same revision."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    store_mock.list_releases.return_value = _build_changelog_releases()
+
+    args = Namespace(name="testcharm", from_channel="edge", to_channel="latest/edge")
+    ChangelogCommand("group", config).run(args)
+
+    expected = [
+        "From revision 7 (latest/edge, version def5678)",
+        "  to revision 7 (latest/edge, version def5678)",
+        "Both channels have the same revision, nothing changed.",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_changelog_channel_not_released(store_mock, config):
+    """One of the channels has nothing released."""
+    store_mock.list_releases.return_value = _build_changelog_releases()
+
+    args = Namespace(name="testcharm", from_channel="stable", to_channel="beta")
+    with pytest.raises(CommandError) as cm:
+        ChangelogCommand("group", config).run(args)
+    assert str(cm.value) == "Nothing is released in channel 'latest/beta'."
+
+
+@pytest.mark.parametrize(
+    "is_repository, has_commit, old_version, problem",
+    [
+        (False, True, "abc1234", "the project is not in a git repository"),
+        (True, True, "1", "version '1' does not include a commit"),
+        (True, False, "abc1234", "commit 'abc1234' not found in the repository"),
+    ],
+)
+def test_changelog_artifacts_fallback(
+    caplog,
+    store_mock,
+    config,
+    tmp_path,
+    is_repository,
+    has_commit,
+    old_version,
+    problem,
+):
+    """Compare the artifacts when the git history is not available."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    store_mock.list_releases.return_value = _build_changelog_releases(
+        old_version=old_version
+    )
+
+    # prepare both artifacts, the fake download url is where to get them locally
+    artifacts = {}
+    for revision, content in ((5, b"old"), (7, b"new")):
+        filepath = tmp_path / "source_r{}.charm".format(revision)
+        _create_charm_zip(filepath, {"src/charm.py": content})
+        download = Download(
+            url=str(filepath),
+            hash_sha256=hashlib.sha256(filepath.read_bytes()).hexdigest(),
+            size=filepath.stat().st_size,
+        )
+        artifacts[revision] = ReleaseDownloads(
+            revision=revision, download=download, resources=[]
+        )
+    store_mock.get_release_downloads.side_effect = [artifacts[5], artifacts[7]]
+    store_mock.download.side_effect = lambda url, filepath: filepath.write_bytes(
+        pathlib.Path(url).read_bytes()
+    )
+
+    args = Namespace(name="testcharm", from_channel="stable", to_channel="edge")
+    with patch("charmcraft.git.is_repository", return_value=is_repository):
+        with patch("charmcraft.git.has_commit", return_value=has_commit):
+            ChangelogCommand("group", config).run(args)
+
+    assert store_mock.get_release_downloads.mock_calls == [
+        call("testcharm", "latest/stable"),
+        call("testcharm", "latest/edge"),
+    ]
+    expected = [
+        "From revision 5 (latest/stable, version {})".format(old_version),
+        "  to revision 7 (latest/edge, version def5678)",
+        "Git history not available ({}), comparing the charm artifacts.".format(
+            problem
+        ),
+        "Changed files:",
+        "M  src/charm.py",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_changelog_artifacts_revision_moved(store_mock, config, tmp_path):
+    """The revision released in a channel changed while comparing."""
+    store_mock.list_releases.return_value = _build_changelog_releases(
+        old_version=None, new_version=None
+    )
+
+    filepath = tmp_path / "source.charm"
+    _create_charm_zip(filepath, {"src/charm.py": b"same"})
+    download = Download(
+        url=str(filepath),
+        hash_sha256=hashlib.sha256(filepath.read_bytes()).hexdigest(),
+        size=filepath.stat().st_size,
+    )
+    store_mock.get_release_downloads.side_effect = [
+        ReleaseDownloads(revision=5, download=download, resources=[]),
+        ReleaseDownloads(revision=8, download=download, resources=[]),
+    ]
+    store_mock.download.side_effect = lambda url, filepath: filepath.write_bytes(
+        pathlib.Path(url).read_bytes()
+    )
+
+    args = Namespace(name="testcharm", from_channel="stable", to_channel="edge")
+    with patch("charmcraft.git.is_repository", return_value=False):
+        with pytest.raises(CommandError) as cm:
+            ChangelogCommand("group", config).run(args)
+    assert str(cm.value) == (
+        "Charmhub informed revision 8 to download, but revision 7 is the one "
+        "expected in channel 'latest/edge'."
+    )
+
+
+def test_changelog_artifacts_no_changes(caplog, store_mock, config, tmp_path):
+    """The artifacts have the same content."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    store_mock.list_releases.return_value = _build_changelog_releases(
+        old_version=None, new_version=None
+    )
+
+    filepath = tmp_path / "source.charm"
+    _create_charm_zip(filepath, {"src/charm.py": b"same"})
+    download = Download(
+        url=str(filepath),
+        hash_sha256=hashlib.sha256(filepath.read_bytes()).hexdigest(),
+        size=filepath.stat().st_size,
+    )
+    store_mock.get_release_downloads.side_effect = [
+        ReleaseDownloads(revision=5, download=download, resources=[]),
+        ReleaseDownloads(revision=7, download=download, resources=[]),
+    ]
+    store_mock.download.side_effect = lambda url, filepath: filepath.write_bytes(
+        pathlib.Path(url).read_bytes()
+    )
+
+    args = Namespace(name="testcharm", from_channel="stable", to_channel="edge")
+    with patch("charmcraft.git.is_repository", return_value=True):
+        ChangelogCommand("group", config).run(args)
+
+    assert [rec.message for rec in caplog.records][-2:] == ["Changed files:", "(none)"]
@@ -0,0 +1,688 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the export functionality (code in store/export.py)."""
+
+import hashlib
+import io
+import json
+import tarfile
+from unittest.mock import MagicMock, call, patch
+
+import pytest
+import yaml
+
+from charmcraft import __version__
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.store.export import (
+    EXPORT_MANIFEST,
+    build_export,
+    download_charm,
+    get_file_hash,
+    normalize_channel,
+    verify_export,
+)
+from charmcraft.commands.store.store import (
+    Download,
+    Platform,
+    Release,
+    ReleaseDownloads,
+    Resource,
+    ResourceDownload,
+)
+
+
+def _build_download(content):
+    """Create a Download for the given content, its url is the content itself."""
+    return Download(
+        url=content.decode("utf8"),
+        hash_sha256=hashlib.sha256(content).hexdigest(),
+        size=len(content),
+    )
+
+
+@pytest.fixture
+def store_mock():
+    """A fake store that "downloads" the content indicated in the URL."""
+    store_mock = MagicMock()
+
+    def fake_download(url, filepath):
+        filepath.write_bytes(url.encode("utf8"))
+
+    store_mock.download.side_effect = fake_download
+    return store_mock
+
+
+def _add_to_tar(tar, name, content):
+    """Add a file with the given content to the tar."""
+    info = tarfile.TarInfo(name)
+    info.size = len(content)
+    tar.addfile(info, io.BytesIO(content))
+
+
+def _create_export(filepath, files, manifest_files=None):
+    """Create a tar with the given files, and a manifest listing them."""
+    if manifest_files is None:
+        manifest_files = [
+            {
+                "path": name,
+                "sha256": hashlib.sha256(content).hexdigest(),
+                "size": len(content),
+            }
+            for name, content in files.items()
+        ]
+    manifest = {
+        "name": "testcharm",
+        "channel": "latest/stable",
+        "revision": 7,
+        "files": manifest_files,
+    }
+    with tarfile.open(str(filepath), "w") as tar:
+        _add_to_tar(tar, EXPORT_MANIFEST, yaml.dump(manifest).encode("utf8"))
+        for name, content in files.items():
+            _add_to_tar(tar, name, content)
+
+
+# -- tests for helpers
+
+
+def test_get_file_hash(tmp_path):
+    """Hash the file content."""
+    filepath = tmp_path / "somefile"
+    filepath.write_bytes(b"some content")
+    assert get_file_hash(filepath) == hashlib.sha256(b"some content").hexdigest()
+
+
+@pytest.mark.parametrize(
+    "channel, expected",
+    [
+        ("stable", "latest/stable"),
+        ("edge/fix-123", "latest/edge/fix-123"),
+        ("2.0/stable", "2.0/stable"),
+        ("latest/beta", "latest/beta"),
+    ],
+)
+def test_normalize_channel(channel, expected):
+    """Include the default track if not given."""
+    assert normalize_channel(channel) == expected
+
+
+# -- tests for building the export
+
+
+def test_build_export_charm_only(store_mock, tmp_path):
+    """Export a charm without resources."""
+    charm_download = _build_download(b"charm content")
+    store_mock.list_releases.return_value = (
+        [
+            Release(
+                revision=5,
+                channel="latest/edge",
+                expires_at=None,
+                resources=[],
+                released_at=None,
+                platform=None,
+            ),
+            Release(
+                revision=7,
+                channel="latest/stable",
+                expires_at=None,
+                resources=[],
+                released_at=None,
+                platform=None,
+            ),
+        ],
+        [],
+        [],
+    )
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=7, download=charm_download, resources=[]
+    )
+
+    output = tmp_path / "export.tar"
+    manifest = build_export(
+        store_mock, "testcharm", "stable", output, architecture="amd64"
+    )
+
+    assert store_mock.mock_calls == [
+        call.list_releases("testcharm"),
+        call.get_release_downloads("testcharm", "latest/stable", "amd64"),
+        call.download("charm content", store_mock.download.call_args[0][1]),
+    ]
+    assert manifest["charmcraft-version"] == __version__
+    assert manifest["name"] == "testcharm"
+    assert manifest["channel"] == "latest/stable"
+    assert manifest["revision"] == 7
+    assert manifest["architecture"] == "amd64"
+    assert manifest["charm"] == "testcharm_r7.charm"
+    assert manifest["resources"] == []
+    assert manifest["files"] == [
+        {
+            "path": "testcharm_r7.charm",
+            "sha256": charm_download.hash_sha256,
+            "size": charm_download.size,
+        },
+    ]
+
+    with tarfile.open(str(output)) as tar:
+        assert sorted(tar.getnames()) == [EXPORT_MANIFEST, "testcharm_r7.charm"]
+        assert tar.extractfile("testcharm_r7.charm").read() == b"charm content"
+        assert yaml.safe_load(tar.extractfile(EXPORT_MANIFEST)) == manifest
+
+
+def test_build_export_with_resources(store_mock, tmp_path):
+    """Export a charm with a file and an image resources."""
+    image_info = {
+        "ImageName": "fakereg.com/orga/stuff@sha256:abc",
+        "Username": "user",
+        "Password": "pass",
+    }
+    file_download = _build_download(b"resource content")
+    image_download = _build_download(json.dumps(image_info).encode("utf8"))
+    store_mock.list_releases.return_value = (
+        [
+            Release(
+                revision=7,
+                channel="latest/stable",
+                expires_at=None,
+                resources=[
+                    Resource(
+                        name="somefile",
+                        optional=None,
+                        revision=3,
+                        resource_type="file",
+                    ),
+                    Resource(
+                        name="someimage",
+                        optional=None,
+                        revision=1,
+                        resource_type="oci-image",
+                    ),
+                ],
+                released_at=None,
+                platform=None,
+            ),
+        ],
+        [],
+        [],
+    )
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=7,
+        download=_build_download(b"charm content"),
+        resources=[
+            ResourceDownload(
+                name="somefile",
+                resource_type="file",
+                revision=3,
+                download=file_download,
+            ),
+            ResourceDownload(
+                name="someimage",
+                resource_type="oci-image",
+                revision=1,
+                download=image_download,
+            ),
+        ],
+    )
+
+    def fake_save(layout_dir, architecture):
+        layout_dir.mkdir(parents=True)
+        (layout_dir / "index.json").write_text("{}")
+        return "sha256:abc"
+
+    output = tmp_path / "export.tar"
+    with patch("charmcraft.commands.store.export.ImageExporter") as exporter_mock:
+        exporter_mock().save.side_effect = fake_save
+        manifest = build_export(
+            store_mock, "testcharm", "latest/stable", output, architecture="arm64"
+        )
+
+    exporter_mock.assert_called_with(
+        "fakereg.com/orga/stuff@sha256:abc", "user", "pass"
+    )
+    assert manifest["resources"] == [
+        {
+            "name": "somefile",
+            "type": "file",
+            "revision": 3,
+            "path": "resources/somefile_r3",
+        },
+        {
+            "name": "someimage",
+            "type": "oci-image",
+            "revision": 1,
+            "image": "fakereg.com/orga/stuff@sha256:abc",
+            "digest": "sha256:abc",
+            "path": "images/someimage_r1",
+        },
+    ]
+    assert [item["path"] for item in manifest["files"]] == [
+        "images/someimage_r1/index.json",
+        "resources/somefile_r3",
+        "testcharm_r7.charm",
+    ]
+
+    with tarfile.open(str(output)) as tar:
+        assert tar.extractfile("resources/somefile_r3").read() == b"resource content"
+
+
+def test_build_export_default_architecture(store_mock, tmp_path):
+    """The architecture defaults to the local one."""
+    store_mock.list_releases.return_value = (
+        [
+            Release(
+                revision=7,
+                channel="latest/stable",
+                expires_at=None,
+                resources=[],
+                released_at=None,
+                platform=None,
+            )
+        ],
+        [],
+        [],
+    )
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=7, download=_build_download(b"charm content"), resources=[]
+    )
+
+    with patch("charmcraft.commands.store.export.get_os_platform") as platform_mock:
+        platform_mock.return_value.machine = "x86_64"
+        manifest = build_export(store_mock, "testcharm", "stable", tmp_path / "e.tar")
+    assert manifest["architecture"] == "amd64"
+
+
+def test_build_export_nothing_released(store_mock, tmp_path):
+    """Nothing is released in the requested channel."""
+    store_mock.list_releases.return_value = (
+        [
+            Release(
+                revision=5,
+                channel="latest/edge",
+                expires_at=None,
+                resources=[],
+                released_at=None,
+                platform=None,
+            )
+        ],
+        [],
+        [],
+    )
+
+    with pytest.raises(CommandError) as cm:
+        build_export(store_mock, "testcharm", "stable", tmp_path / "export.tar")
+    assert str(cm.value) == (
+        "Nothing is released in channel 'latest/stable' for 'testcharm'."
+    )
+
+
+def _platform_release(revision, architecture, series="focal", channel="latest/stable"):
+    """Build a release in the channel for the platform."""
+    return Release(
+        revision=revision,
+        channel=channel,
+        expires_at=None,
+        resources=[],
+        released_at=None,
+        platform=Platform(architecture=architecture, os="ubuntu", series=series),
+    )
+
+
+@pytest.mark.parametrize(
+    "architecture, series, expected_revision",
+    [
+        ("amd64", None, 7),
+        ("arm64", None, 8),
+        ("arm64", "focal", 8),
+        ("s390x", "focal", 9),
+    ],
+)
+def test_build_export_platform(
+    store_mock, tmp_path, architecture, series, expected_revision
+):
+    """The exported release is the one for the architecture (and series)."""
+    store_mock.list_releases.return_value = (
+        [
+            _platform_release(6, "amd64", channel="latest/edge"),
+            _platform_release(7, "amd64"),
+            _platform_release(8, "arm64"),
+            _platform_release(9, "all"),
+        ],
+        [],
+        [],
+    )
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=expected_revision,
+        download=_build_download(b"charm content"),
+        resources=[],
+    )
+
+    manifest = build_export(
+        store_mock,
+        "testcharm",
+        "stable",
+        tmp_path / "export.tar",
+        architecture=architecture,
+        series=series,
+    )
+    store_mock.get_release_downloads.assert_called_once_with(
+        "testcharm", "latest/stable", architecture
+    )
+    assert manifest["revision"] == expected_revision
+    assert manifest["architecture"] == architecture
+
+
+def test_build_export_platform_not_released(store_mock, tmp_path):
+    """Nothing is released in the channel for the architecture and series."""
+    store_mock.list_releases.return_value = (
+        [_platform_release(7, "amd64"), _platform_release(8, "arm64", series="jammy")],
+        [],
+        [],
+    )
+
+    with pytest.raises(CommandError) as cm:
+        build_export(
+            store_mock,
+            "testcharm",
+            "stable",
+            tmp_path / "export.tar",
+            architecture="arm64",
+            series="focal",
+        )
+    assert str(cm.value) == (
+        "Nothing is released in channel 'latest/stable' for 'testcharm' for "
+        "architecture 'arm64' and series 'focal'."
+    )
+    store_mock.get_release_downloads.assert_not_called()
+
+
+def test_build_export_platform_several_series(store_mock, tmp_path):
+    """The series is needed if there are releases for several ones."""
+    store_mock.list_releases.return_value = (
+        [_platform_release(7, "amd64"), _platform_release(8, "amd64", series="jammy")],
+        [],
+        [],
+    )
+
+    with pytest.raises(CommandError) as cm:
+        build_export(
+            store_mock,
+            "testcharm",
+            "stable",
+            tmp_path / "export.tar",
+            architecture="amd64",
+        )
+    assert str(cm.value) == (
+        "Several revisions of 'testcharm' are released in channel 'latest/stable' "
+        "for architecture 'amd64' (for series 'focal', 'jammy'); indicate which one "
+        "to export with --series."
+    )
+    store_mock.get_release_downloads.assert_not_called()
+
+
+def test_build_export_revision_mismatch(store_mock, tmp_path):
+    """The revision to download is not the one released in the channel."""
+    store_mock.list_releases.return_value = (
+        [
+            Release(
+                revision=7,
+                channel="latest/stable",
+                expires_at=None,
+                resources=[],
+                released_at=None,
+                platform=None,
+            )
+        ],
+        [],
+        [],
+    )
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=8, download=_build_download(b"charm content"), resources=[]
+    )
+
+    with pytest.raises(CommandError) as cm:
+        build_export(store_mock, "testcharm", "stable", tmp_path / "export.tar")
+    assert str(cm.value) == (
+        "Charmhub informed revision 8 to download, but revision 7 is the one "
+        "released in channel 'latest/stable'."
+    )
+
+
+def test_build_export_resource_revision_mismatch(store_mock, tmp_path):
+    """The resource revision to download is not the one attached in the channel."""
+    store_mock.list_releases.return_value = (
+        [
+            Release(
+                revision=7,
+                channel="latest/stable",
+                expires_at=None,
+                resources=[
+                    Resource(
+                        name="somefile",
+                        optional=None,
+                        revision=3,
+                        resource_type="file",
+                    ),
+                ],
+                released_at=None,
+                platform=None,
+            )
+        ],
+        [],
+        [],
+    )
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=7,
+        download=_build_download(b"charm content"),
+        resources=[
+            ResourceDownload(
+                name="somefile",
+                resource_type="file",
+                revision=4,
+                download=_build_download(b"resource content"),
+            ),
+        ],
+    )
+
+    with pytest.raises(CommandError) as cm:
+        build_export(store_mock, "testcharm", "stable", tmp_path / "export.tar")
+    assert str(cm.value) == (
+        "Charmhub informed revision 4 to download for resource 'somefile', but "
+        "revision 3 is the one attached in channel 'latest/stable'."
+    )
+
+
+def test_build_export_corrupted_download(store_mock, tmp_path):
+    """The downloaded content does not match the informed hash."""
+    store_mock.list_releases.return_value = (
+        [
+            Release(
+                revision=7,
+                channel="latest/stable",
+                expires_at=None,
+                resources=[],
+                released_at=None,
+                platform=None,
+            )
+        ],
+        [],
+        [],
+    )
+    download = Download(url="charm content", hash_sha256="bad-hash", size=13)
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=7, download=download, resources=[]
+    )
+
+    with pytest.raises(CommandError) as cm:
+        build_export(store_mock, "testcharm", "stable", tmp_path / "export.tar")
+    assert str(cm.value) == (
+        "Downloaded file 'testcharm_r7.charm' is corrupted (expected hash 'bad-hash', "
+        "got {!r}).".format(hashlib.sha256(b"charm content").hexdigest())
+    )
+
+
+# -- tests for downloading a charm
+
+
+def test_download_charm(store_mock, tmp_path):
+    """Download the charm released in the channel."""
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=7, download=_build_download(b"charm content"), resources=[]
+    )
+    filepath = tmp_path / "testcharm.charm"
+
+    revision = download_charm(store_mock, "testcharm", "latest/edge", filepath)
+
+    assert revision == 7
+    assert filepath.read_bytes() == b"charm content"
+    store_mock.get_release_downloads.assert_called_once_with(
+        "testcharm", "latest/edge"
+    )
+
+
+def test_download_charm_revision_expected(store_mock, tmp_path):
+    """The released revision is verified, if indicated."""
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=7, download=_build_download(b"charm content"), resources=[]
+    )
+    filepath = tmp_path / "testcharm.charm"
+
+    revision = download_charm(
+        store_mock, "testcharm", "latest/edge", filepath, revision=7
+    )
+    assert revision == 7
+
+
+def test_download_charm_revision_changed(store_mock, tmp_path):
+    """The channel has other revision than the expected one."""
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=8, download=_build_download(b"charm content"), resources=[]
+    )
+    filepath = tmp_path / "testcharm.charm"
+
+    with pytest.raises(CommandError) as cm:
+        download_charm(store_mock, "testcharm", "latest/edge", filepath, revision=7)
+    assert str(cm.value) == (
+        "Charmhub informed revision 8 to download, but revision 7 is the one "
+        "expected in channel 'latest/edge'."
+    )
+    assert not filepath.exists()
+
+
+# -- tests for verifying the export
+
+
+def test_verify_export_ok(tmp_path):
+    """All the files are fine."""
+    filepath = tmp_path / "export.tar"
+    _create_export(filepath, {"charm.charm": b"charm", "resources/res_r1": b"res"})
+
+    manifest, problems = verify_export(filepath)
+    assert manifest["name"] == "testcharm"
+    assert problems == []
+
+
+def test_verify_export_roundtrip(store_mock, tmp_path):
+    """Verify what was created by build_export."""
+    store_mock.list_releases.return_value = (
+        [
+            Release(
+                revision=7,
+                channel="latest/stable",
+                expires_at=None,
+                resources=[],
+                released_at=None,
+                platform=None,
+            )
+        ],
+        [],
+        [],
+    )
+    store_mock.get_release_downloads.return_value = ReleaseDownloads(
+        revision=7, download=_build_download(b"charm content"), resources=[]
+    )
+    output = tmp_path / "export.tar"
+    built_manifest = build_export(store_mock, "testcharm", "stable", output)
+
+    manifest, problems = verify_export(output)
+    assert manifest == built_manifest
+    assert problems == []
+
+
+def test_verify_export_problems(tmp_path):
+    """Report missing, extra, and corrupted files."""
+    filepath = tmp_path / "export.tar"
+    manifest_files = [
+        {"path": "missing", "sha256": "whatever", "size": 3},
+        {"path": "badsize", "sha256": "whatever", "size": 3},
+        {"path": "badhash", "sha256": "whatever", "size": 4},
+    ]
+    files = {"badsize": b"12345", "badhash": b"1234", "extra": b"boo"}
+    _create_export(filepath, files, manifest_files=manifest_files)
+
+    _, problems = verify_export(filepath)
+    assert problems == [
+        "Missing file: 'missing'.",
+        "Wrong size for file 'badsize': expected 3, got 5.",
+        "Wrong hash for file 'badhash'.",
+        "File not listed in the manifest: 'extra'.",
+    ]
+
+
+def test_verify_export_unsafe_path(tmp_path):
+    """The archive includes paths outside the destination."""
+    filepath = tmp_path / "export.tar"
+    _create_export(filepath, {"../evil": b"evil"})
+
+    with pytest.raises(CommandError) as cm:
+        verify_export(filepath)
+    assert str(cm.value) == "Invalid path in the archive: '../evil'."
+
+
+def test_verify_export_no_manifest(tmp_path):
+    """The archive is not an export."""
+    filepath = tmp_path / "export.tar"
+    with tarfile.open(str(filepath), "w") as tar:
+        _add_to_tar(tar, "somefile", b"content")
+
+    with pytest.raises(CommandError) as cm:
+        verify_export(filepath)
+    assert str(cm.value) == (
+        "The archive {!r} is not a charmcraft export ({!r} not found).".format(
+            str(filepath), EXPORT_MANIFEST
+        )
+    )
+
+
+def test_verify_export_bad_manifest(tmp_path):
+    """The manifest in the archive is not valid."""
+    filepath = tmp_path / "export.tar"
+    with tarfile.open(str(filepath), "w") as tar:
+        _add_to_tar(tar, EXPORT_MANIFEST, b"- just a list")
+
+    with pytest.raises(CommandError) as cm:
+        verify_export(filepath)
+    assert str(cm.value) == "Bad {!r} file in the archive.".format(EXPORT_MANIFEST)
+
+
+def test_verify_export_not_a_tar(tmp_path):
+    """The file is not a tar."""
+    filepath = tmp_path / "export.tar"
+    filepath.write_bytes(b"not really a tar file")
+
+    with pytest.raises(CommandError) as cm:
+        verify_export(filepath)
+    assert str(cm.value).startswith("Cannot open {!r}: ".format(str(filepath)))
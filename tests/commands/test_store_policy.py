@@ -0,0 +1,226 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the release policy enforcement (code in store/policy.py)."""
+
+import datetime
+import logging
+from unittest.mock import MagicMock, call, patch
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.store.policy import (
+    enforce,
+    get_violations,
+    needs_confirmation,
+    split_channel,
+)
+from charmcraft.commands.store.store import Release
+from charmcraft.config import ReleasePolicyConfig
+
+NOW = datetime.datetime(2021, 6, 10, 12, 0, tzinfo=datetime.timezone.utc)
+
+
+def _release(revision, channel, hours_ago=100):
+    """Build a release done some hours before NOW."""
+    return Release(
+        revision=revision,
+        channel=channel,
+        expires_at=None,
+        resources=[],
+        released_at=NOW - datetime.timedelta(hours=hours_ago),
+        platform=None,
+    )
+
+
+@pytest.mark.parametrize(
+    "channel,expected",
+    [
+        ("stable", ("latest", "stable", None)),
+        ("beta/hotfix", ("latest", "beta", "hotfix")),
+        ("2.0/candidate", ("2.0", "candidate", None)),
+        ("2.0/edge/feature", ("2.0", "edge", "feature")),
+    ],
+)
+def test_split_channel(channel, expected):
+    """Get the parts of the channel, including the default track."""
+    assert split_channel(channel) == expected
+
+
+def test_violations_no_policy():
+    """Everything is allowed without a policy."""
+    policy = ReleasePolicyConfig()
+    assert get_violations(policy, [], 7, ["stable", "2.0/candidate"], NOW) == []
+
+
+def test_violations_restricted_track():
+    """Nothing can be released to a restricted track."""
+    policy = ReleasePolicyConfig(restricted_tracks=["legacy"])
+    violations = get_violations(policy, [], 7, ["legacy/edge", "edge"], NOW)
+    assert violations == [
+        "The track 'legacy' is restricted (releasing to 'legacy/edge')."
+    ]
+
+
+def test_violations_lower_risk():
+    """The revision needs to be released to the next lower risk of the track."""
+    policy = ReleasePolicyConfig(require_lower_risk=True)
+    channel_map = [
+        _release(7, "latest/edge"),
+        _release(7, "2.0/beta"),
+        _release(5, "latest/beta"),
+    ]
+    channels = ["edge", "beta", "candidate", "2.0/candidate"]
+    violations = get_violations(policy, channel_map, 7, channels, NOW)
+    assert violations == [
+        "Revision 7 needs to be released to 'latest/beta' before going to "
+        "'candidate'."
+    ]
+
+
+def test_violations_lower_risk_branches():
+    """The branches do not need the revision released to lower risks."""
+    policy = ReleasePolicyConfig(require_lower_risk=True)
+    assert get_violations(policy, [], 7, ["stable/hotfix", "2.0/beta/fix"], NOW) == []
+
+
+def test_violations_soak_time():
+    """The revision needs to be in the next lower risk for a minimum time."""
+    policy = ReleasePolicyConfig(soak_hours=24)
+    channel_map = [
+        _release(7, "latest/beta", hours_ago=30),
+        _release(7, "latest/candidate", hours_ago=3),
+    ]
+    violations = get_violations(policy, channel_map, 7, ["candidate", "stable"], NOW)
+    assert violations == [
+        "Revision 7 has been in 'latest/candidate' for 3 hours, but it needs 24 hours "
+        "there before going to 'stable'."
+    ]
+
+
+def test_violations_soak_time_several_bases():
+    """The channel map has one release per base, the first one starts the soak."""
+    policy = ReleasePolicyConfig(soak_hours=24)
+    channel_map = [
+        _release(7, "latest/candidate", hours_ago=30),
+        _release(7, "latest/candidate", hours_ago=3),
+    ]
+    assert get_violations(policy, channel_map, 7, ["stable"], NOW) == []
+
+
+def test_violations_soak_time_not_released():
+    """The soak time implies the revision is released to the next lower risk."""
+    policy = ReleasePolicyConfig(soak_hours=24)
+    violations = get_violations(policy, [], 7, ["beta"], NOW)
+    assert violations == [
+        "Revision 7 needs to be released to 'latest/edge' before going to 'beta'."
+    ]
+
+
+@pytest.mark.parametrize(
+    "confirm_stable,channels,expected",
+    [
+        (False, ["stable"], False),
+        (True, ["stable"], True),
+        (True, ["edge", "2.0/stable"], True),
+        (True, ["candidate", "stable/hotfix"], False),
+    ],
+)
+def test_needs_confirmation(confirm_stable, channels, expected):
+    """Confirmation is needed only for stable channels, if configured."""
+    policy = ReleasePolicyConfig(confirm_stable=confirm_stable)
+    assert needs_confirmation(policy, channels) is expected
+
+
+def test_enforce_no_releases_needed():
+    """The current releases are not retrieved if the policy does not need them."""
+    store = MagicMock()
+    policy = ReleasePolicyConfig(restricted_tracks=["legacy"])
+    enforce(policy, store, "testcharm", 7, ["stable"])
+    assert store.mock_calls == []
+
+
+def test_enforce_violations():
+    """The release is not allowed."""
+    store = MagicMock()
+    store.list_releases.return_value = ([_release(7, "latest/edge")], [], [])
+    policy = ReleasePolicyConfig(require_lower_risk=True, restricted_tracks=["old"])
+
+    with pytest.raises(CommandError) as cm:
+        enforce(policy, store, "testcharm", 7, ["beta", "candidate", "old/edge"])
+    assert str(cm.value) == (
+        "The release is not allowed by the release policy: Revision 7 needs to be "
+        "released to 'latest/beta' before going to 'candidate'. The track 'old' is "
+        "restricted (releasing to 'old/edge'). Use --override-policy to release "
+        "anyway."
+    )
+    assert store.mock_calls == [call.list_releases("testcharm")]
+
+
+def test_enforce_override(caplog):
+    """The policy is overridden, which is logged."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.commands")
+    store = MagicMock()
+    store.list_releases.return_value = ([], [], [])
+    policy = ReleasePolicyConfig(require_lower_risk=True, confirm_stable=True)
+
+    with patch("builtins.input") as input_mock:
+        enforce(policy, store, "testcharm", 7, ["stable"], override=True)
+    input_mock.assert_not_called()
+    assert [rec.message for rec in caplog.records] == [
+        "Overriding the release policy to release revision 7 of 'testcharm' to stable.",
+        "- Revision 7 needs to be released to 'latest/candidate' before going "
+        "to 'stable'.",
+    ]
+
+
+@pytest.mark.parametrize("answer", ["y", "Yes\n"])
+def test_enforce_confirmed(answer):
+    """The user confirms the release to stable."""
+    policy = ReleasePolicyConfig(confirm_stable=True)
+    with patch("sys.stdin") as stdin_mock:
+        stdin_mock.isatty.return_value = True
+        with patch("builtins.input", return_value=answer) as input_mock:
+            enforce(policy, MagicMock(), "testcharm", 7, ["stable"])
+    input_mock.assert_called_once_with(
+        "Release revision 7 of 'testcharm' to stable? [y/N] "
+    )
+
+
+@pytest.mark.parametrize("answer", ["", "n", "whatever"])
+def test_enforce_not_confirmed(answer):
+    """The user does not confirm the release to stable."""
+    policy = ReleasePolicyConfig(confirm_stable=True)
+    with patch("sys.stdin") as stdin_mock:
+        stdin_mock.isatty.return_value = True
+        with patch("builtins.input", return_value=answer):
+            with pytest.raises(CommandError) as cm:
+                enforce(policy, MagicMock(), "testcharm", 7, ["stable"])
+    assert str(cm.value) == "Release cancelled."
+
+
+def test_enforce_confirmation_not_interactive():
+    """The release cannot be confirmed if the terminal is not interactive."""
+    policy = ReleasePolicyConfig(confirm_stable=True)
+    with patch("sys.stdin") as stdin_mock:
+        stdin_mock.isatty.return_value = False
+        with pytest.raises(CommandError) as cm:
+            enforce(policy, MagicMock(), "testcharm", 7, ["stable"])
+    assert str(cm.value) == (
+        "The release policy requires confirmation to release to a stable channel, "
+        "but the terminal is not interactive. Use --override-policy to release anyway."
+    )
@@ -16,21 +16,26 @@
 
 """Tests for the OCI Registry related functionality (code in store/registry.py)."""
 
+import base64
+import hashlib
 import io
 import json
 import logging
-from unittest.mock import patch
+from unittest.mock import call, patch
 
 import pytest
 import requests
 
 from charmcraft.cmdbase import CommandError
 from charmcraft.commands.store.registry import (
+    ImageExporter,
     ImageHandler,
     MANIFEST_LISTS,
     MANIFEST_V2_MIMETYPE,
+    OCI_INDEX_MIMETYPE,
     OCIRegistry,
     assert_response_ok,
+    parse_image_name,
 )
 
 
@@ -525,3 +530,173 @@ def test_imagehandler_getdestinationurl_missing(mocked_imagehandler):
     )
     with pytest.raises(CommandError, match=expected_error):
         mocked_imagehandler.get_destination_url("test-reference")
+
+
+# -- tests for the blobs downloading
+
+
+def test_get_blob_ok(responses, tmp_path):
+    """Download a blob and verify its hash."""
+    ocireg = OCIRegistry("fakereg.com", "test-orga", "test-image")
+    content = b"some blob content"
+    digest = "sha256:" + hashlib.sha256(content).hexdigest()
+    url = "https://fakereg.com/v2/test-orga/test-image/blobs/" + digest
+    responses.add(responses.GET, url, status=200, body=content)
+
+    filepath = tmp_path / "blob"
+    ocireg.get_blob(digest, filepath)
+    assert filepath.read_bytes() == content
+
+
+def test_get_blob_corrupted(responses, tmp_path):
+    """The downloaded blob does not match the digest."""
+    ocireg = OCIRegistry("fakereg.com", "test-orga", "test-image")
+    digest = "sha256:" + hashlib.sha256(b"original content").hexdigest()
+    url = "https://fakereg.com/v2/test-orga/test-image/blobs/" + digest
+    responses.add(responses.GET, url, status=200, body=b"other content")
+
+    with pytest.raises(CommandError) as cm:
+        ocireg.get_blob(digest, tmp_path / "blob")
+    expected_hash = hashlib.sha256(b"other content").hexdigest()
+    assert str(cm.value) == (
+        "Downloaded blob {!r} is corrupted (got hash {!r}).".format(
+            digest, expected_hash
+        )
+    )
+
+
+def test_get_blob_bad_response(responses, tmp_path):
+    """The registry answered with an error."""
+    ocireg = OCIRegistry("fakereg.com", "test-orga", "test-image")
+    url = "https://fakereg.com/v2/test-orga/test-image/blobs/sha256:123"
+    responses.add(responses.GET, url, status=404)
+
+    with pytest.raises(CommandError) as cm:
+        ocireg.get_blob("sha256:123", tmp_path / "blob")
+    assert str(cm.value).startswith(
+        "Wrong status code from server (expected=200, got=404)"
+    )
+
+
+# -- tests for the image exporter
+
+
+@pytest.mark.parametrize(
+    "image_name, expected",
+    [
+        (
+            "registry.jujucharms.com/charm/abc/stuff@sha256:123",
+            ("registry.jujucharms.com", "charm/abc", "stuff", "sha256:123"),
+        ),
+        (
+            "fakereg.com/orga/stuff:v1.2",
+            ("fakereg.com", "orga", "stuff", "v1.2"),
+        ),
+        (
+            "fakereg.com:5000/orga/stuff",
+            ("fakereg.com:5000", "orga", "stuff", "latest"),
+        ),
+    ],
+)
+def test_parse_image_name(image_name, expected):
+    """Split the different parts of a fully qualified image name."""
+    assert parse_image_name(image_name) == expected
+
+
+def test_imageexporter_credentials():
+    """The credentials are properly set in the registry."""
+    exporter = ImageExporter("fakereg.com/orga/stuff@sha256:123", "user", "pass")
+    assert exporter.reference == "sha256:123"
+    assert exporter.registry.server == "fakereg.com"
+    expected = base64.b64encode(b"user:pass").decode("ascii")
+    assert exporter.registry.auth_encoded_credentials == expected
+
+
+def test_imageexporter_no_credentials():
+    """No credentials are set if not given."""
+    exporter = ImageExporter("fakereg.com/orga/stuff@sha256:123")
+    assert exporter.registry.auth_encoded_credentials is None
+
+
+def _fake_get_blob(digest, filepath):
+    """Write something in the blob file for the given digest."""
+    filepath.write_text("content for " + digest)
+
+
+def test_imageexporter_save_simple(tmp_path):
+    """Save an image with a v2 manifest."""
+    manifest = {
+        "schemaVersion": 2,
+        "mediaType": MANIFEST_V2_MIMETYPE,
+        "config": {"digest": "sha256:conf"},
+        "layers": [{"digest": "sha256:layer1"}, {"digest": "sha256:layer2"}],
+    }
+    raw_manifest = json.dumps(manifest)
+    exporter = ImageExporter("fakereg.com/orga/stuff@sha256:mnfst")
+
+    with patch.object(exporter.registry, "get_manifest") as get_manifest_mock:
+        get_manifest_mock.return_value = (None, "sha256:mnfst", raw_manifest)
+        with patch.object(exporter.registry, "get_blob") as get_blob_mock:
+            get_blob_mock.side_effect = _fake_get_blob
+            digest = exporter.save(tmp_path / "layout", "amd64")
+
+    assert digest == "sha256:mnfst"
+    blobs_dir = tmp_path / "layout" / "blobs" / "sha256"
+    assert get_blob_mock.mock_calls == [
+        call("sha256:conf", blobs_dir / "conf"),
+        call("sha256:layer1", blobs_dir / "layer1"),
+        call("sha256:layer2", blobs_dir / "layer2"),
+    ]
+    assert (blobs_dir / "mnfst").read_text() == raw_manifest
+
+    index = json.loads((tmp_path / "layout" / "index.json").read_text())
+    assert index == {
+        "schemaVersion": 2,
+        "mediaType": OCI_INDEX_MIMETYPE,
+        "manifests": [
+            {
+                "mediaType": MANIFEST_V2_MIMETYPE,
+                "digest": "sha256:mnfst",
+                "size": len(raw_manifest),
+                "annotations": {"org.opencontainers.image.ref.name": "sha256:mnfst"},
+            },
+        ],
+    }
+    oci_layout = json.loads((tmp_path / "layout" / "oci-layout").read_text())
+    assert oci_layout == {"imageLayoutVersion": "1.0.0"}
+
+
+def test_imageexporter_save_multiplatform(tmp_path):
+    """Save only the manifest for the indicated architecture from a list."""
+    manifests_list = [
+        {"digest": "sha256:arm", "platform": {"architecture": "arm64"}},
+        {"digest": "sha256:intel", "platform": {"architecture": "amd64"}},
+    ]
+    manifest = {"schemaVersion": 2, "config": {"digest": "sha256:conf"}, "layers": []}
+    exporter = ImageExporter("fakereg.com/orga/stuff:latest")
+
+    with patch.object(exporter.registry, "get_manifest") as get_manifest_mock:
+        get_manifest_mock.side_effect = [
+            (manifests_list, "sha256:list", "raw list"),
+            (None, "sha256:intel", json.dumps(manifest)),
+        ]
+        with patch.object(exporter.registry, "get_blob") as get_blob_mock:
+            get_blob_mock.side_effect = _fake_get_blob
+            digest = exporter.save(tmp_path / "layout", "amd64")
+
+    assert digest == "sha256:intel"
+    assert get_manifest_mock.mock_calls == [call("latest"), call("sha256:intel")]
+
+
+def test_imageexporter_save_missing_architecture(tmp_path):
+    """The manifests list does not include the needed architecture."""
+    manifests_list = [{"digest": "sha256:arm", "platform": {"architecture": "arm64"}}]
+    exporter = ImageExporter("fakereg.com/orga/stuff:latest")
+
+    with patch.object(exporter.registry, "get_manifest") as get_manifest_mock:
+        get_manifest_mock.return_value = (manifests_list, "sha256:list", "raw list")
+        with pytest.raises(CommandError) as cm:
+            exporter.save(tmp_path / "layout", "amd64")
+    assert str(cm.value) == (
+        "The image 'latest' is not available for architecture 'amd64'."
+    )
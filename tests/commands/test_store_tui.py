@@ -0,0 +1,539 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the interactive releases grid (code in store/tui.py)."""
+
+import curses
+import datetime
+from unittest.mock import MagicMock, call
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.store.store import Channel, Release, Resource, Revision
+from charmcraft.commands.store.tui import ReleasePlan, ReleasesGrid
+from charmcraft.config import ReleasePolicyConfig
+from charmcraft.utils import ResourceOption
+
+NOW = datetime.datetime(2021, 7, 1, tzinfo=datetime.timezone.utc)
+
+
+def _build_channels():
+    """Build the channels of the 'latest' track, in the order they are shown."""
+    risks = ["stable", "candidate", "beta", "edge"]
+    return [
+        Channel(
+            name="latest/" + risk,
+            fallback=None if fallback is None else "latest/" + fallback,
+            track="latest",
+            risk=risk,
+            branch=None,
+        )
+        for risk, fallback in zip(risks, [None] + risks)
+    ]
+
+
+def _build_revision(revno):
+    """Build a revision."""
+    return Revision(
+        revision=revno,
+        version="v{}".format(revno),
+        created_at=datetime.datetime(2021, 6, revno, 12, 0),
+        status="approved",
+        errors=[],
+    )
+
+
+@pytest.fixture
+def plan():
+    """Provide a plan for a charm with revisions 5 and 7 released."""
+    resource = Resource(name="db", optional=False, revision=2, resource_type="file")
+    channel_map = [
+        Release(
+            revision=5,
+            channel="latest/candidate",
+            expires_at=None,
+            resources=[resource],
+            released_at=NOW - datetime.timedelta(days=3),
+            platform=None,
+        ),
+        Release(
+            revision=7,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[],
+            released_at=NOW - datetime.timedelta(hours=1),
+            platform=None,
+        ),
+    ]
+    revisions = [_build_revision(revno) for revno in (5, 6, 7)]
+    resources = [
+        Resource(name="db", optional=False, revision=None, resource_type="file")
+    ]
+    return ReleasePlan(
+        "testcharm", _build_channels(), channel_map, revisions, resources
+    )
+
+
+# -- tests for the plan
+
+
+def test_plan_get_releases(plan):
+    """Get what is released in a channel, including what was planned."""
+    assert plan.get_releases("latest/edge") == (((7, ()),), False)
+    assert plan.get_releases("latest/stable") == ((), False)
+
+    plan.release(6, "latest/stable")
+    assert plan.get_releases("latest/stable") == (((6, ()),), True)
+
+
+def test_plan_get_releases_several_bases(plan):
+    """All the releases in the channel are returned, one for each base."""
+    plan.channel_map.append(
+        Release(
+            revision=6,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[],
+            released_at=NOW,
+            platform=None,
+        )
+    )
+    assert plan.get_releases("latest/edge") == (((7, ()), (6, ())), False)
+
+
+def test_plan_revisions_ordered(plan):
+    """The revisions are shown from the newest."""
+    assert [revision.revision for revision in plan.revisions] == [7, 6, 5]
+    assert plan.get_version(6) == "v6"
+    assert plan.get_version(99) == "-"
+
+
+def test_plan_release_unknown_revision(plan):
+    """Only the revisions of the package can be released."""
+    with pytest.raises(CommandError) as cm:
+        plan.release(99, "latest/beta")
+    assert str(cm.value) == "Unknown revision 99."
+
+
+def test_plan_release_replaces(plan):
+    """Planning again in a channel replaces what was planned, at the end."""
+    plan.release(6, "latest/beta")
+    plan.release(7, "latest/stable")
+    plan.release(5, "latest/beta")
+    assert list(plan.planned) == ["latest/stable", "latest/beta"]
+    assert plan.get_releases("latest/beta") == (((5, ()),), True)
+
+
+def test_plan_release_many(plan):
+    """Plan to release several revisions in a channel, replacing what is there."""
+    plan.release_many("latest/edge", [(5, ()), (6, [ResourceOption("db", 3)])])
+    assert plan.get_releases("latest/edge") == (
+        ((5, ()), (6, (ResourceOption("db", 3),))),
+        True,
+    )
+
+    with pytest.raises(CommandError) as cm:
+        plan.release_many("latest/beta", [(5, ()), (99, ())])
+    assert str(cm.value) == "Unknown revision 99."
+    assert "latest/beta" not in plan.planned
+
+
+def test_plan_attach(plan):
+    """Attach a resource, replacing the revision of the same resource if there."""
+    plan.attach("latest/candidate", ResourceOption("db", 3))
+    plan.attach("latest/edge", ResourceOption("db", 4))
+
+    assert plan.get_releases("latest/candidate") == (
+        ((5, (ResourceOption("db", 3),)),),
+        True,
+    )
+    assert plan.get_releases("latest/edge") == (
+        ((7, (ResourceOption("db", 4),)),),
+        True,
+    )
+
+
+def test_plan_attach_several_bases(plan):
+    """The resource is attached to all the releases in the channel."""
+    plan.release_many("latest/beta", [(5, ()), (6, [ResourceOption("db", 1)])])
+    plan.attach("latest/beta", ResourceOption("db", 3))
+    resources = (ResourceOption("db", 3),)
+    assert plan.get_releases("latest/beta") == (((5, resources), (6, resources)), True)
+
+
+def test_plan_attach_nothing_released(plan):
+    """Resources can only be attached to a release."""
+    with pytest.raises(CommandError) as cm:
+        plan.attach("latest/stable", ResourceOption("db", 3))
+    assert str(cm.value) == (
+        "Nothing is released in 'latest/stable' to attach the resource to."
+    )
+
+
+def test_plan_attach_unknown_resource(plan):
+    """Only the resources of the package can be attached."""
+    with pytest.raises(CommandError) as cm:
+        plan.attach("latest/edge", ResourceOption("other", 3))
+    assert str(cm.value) == "Unknown resource 'other' (the package has: db)."
+
+
+def test_plan_cancel(plan):
+    """Forget what was planned in a channel."""
+    plan.release(6, "latest/beta")
+    plan.cancel("latest/beta")
+    plan.cancel("latest/stable")
+    assert plan.planned == {}
+
+
+def test_plan_batches(plan):
+    """Group the releases of the same revision and resources."""
+    plan.release(7, "latest/beta")
+    plan.release(6, "latest/stable")
+    plan.release(7, "latest/candidate")
+    plan.attach("latest/edge", ResourceOption("db", 4))
+
+    assert plan.get_batches() == [
+        (7, ["latest/beta", "latest/candidate"], ()),
+        (6, ["latest/stable"], ()),
+        (7, ["latest/edge"], (ResourceOption("db", 4),)),
+    ]
+
+
+def test_plan_apply(plan):
+    """Release all that was planned, in batches."""
+    store = MagicMock()
+    plan.release(7, "latest/beta")
+    plan.release(7, "latest/candidate")
+
+    batches = list(plan.apply(store))
+    assert batches == [(7, ["latest/beta", "latest/candidate"], ())]
+    assert store.mock_calls == [
+        call.release("testcharm", 7, ["latest/beta", "latest/candidate"], ())
+    ]
+    assert plan.planned == {}
+
+
+def test_plan_apply_error(plan):
+    """The batches done before a release fails are yielded, then it's raised."""
+    store = MagicMock()
+    store.release.side_effect = [None, CommandError("Store failure.")]
+    plan.release(7, "latest/beta")
+    plan.release(6, "latest/stable")
+
+    done = []
+    with pytest.raises(CommandError) as cm:
+        for batch in plan.apply(store):
+            done.append(batch)
+    assert str(cm.value) == "Store failure."
+    assert done == [(7, ["latest/beta"], ())]
+
+
+def test_plan_violations_in_sequence(plan):
+    """Each planned release is verified as if the previous ones were done."""
+    policy = ReleasePolicyConfig(require_lower_risk=True)
+
+    # edge to beta is fine, and then beta to candidate
+    plan.release(7, "latest/beta")
+    plan.release(7, "latest/candidate")
+    assert plan.get_violations(policy, now=NOW) == []
+
+    # but revision 6 is nowhere
+    plan.release(6, "latest/stable")
+    assert plan.get_violations(policy, now=NOW) == [
+        "Revision 6 needs to be released to 'latest/candidate' before going to "
+        "'latest/stable'."
+    ]
+
+
+def test_plan_violations_several_bases(plan):
+    """All the releases planned in a channel are verified, then done together."""
+    policy = ReleasePolicyConfig(require_lower_risk=True)
+    plan.release_many("latest/beta", [(7, ()), (6, ())])
+    plan.release_many("latest/candidate", [(7, ()), (6, ())])
+    assert plan.get_violations(policy, now=NOW) == [
+        "Revision 6 needs to be released to 'latest/edge' before going to "
+        "'latest/beta'."
+    ]
+
+
+def test_plan_violations_soak_time(plan):
+    """The planned releases are considered just done when checking the soak time."""
+    policy = ReleasePolicyConfig(soak_hours=1)
+    plan.release(7, "latest/beta")
+    plan.release(7, "latest/candidate")
+
+    violations = plan.get_violations(policy, now=NOW)
+    assert len(violations) == 1
+    assert violations[0].startswith("Revision 7 has been in 'latest/beta' for ")
+
+
+# -- tests for the grid
+
+
+@pytest.fixture
+def grid(plan):
+    """Provide a grid for the plan, without release policy."""
+    return ReleasesGrid(plan, ReleasePolicyConfig())
+
+
+def _press(grid, *keys):
+    """Make the grid handle the keys."""
+    for key in keys:
+        grid.handle_key(ord(key) if isinstance(key, str) else key)
+
+
+def test_grid_move(grid):
+    """Move the cursors, not going beyond the lists."""
+    _press(grid, curses.KEY_UP, "j", curses.KEY_DOWN, "j", "j", "j")
+    assert grid.channel_cursor == 3
+    assert grid.current_channel == "latest/edge"
+
+    _press(grid, "\t", "j", "j", "j", "k")
+    assert grid.revision_cursor == 1
+    assert grid.channel_cursor == 3
+
+
+def test_grid_release_selected_revision(grid, plan):
+    """Select a revision in the list and release it in some channels."""
+    _press(grid, "\t", "j", "\n")
+    assert grid.selected == ((6, ()),)
+    assert grid.focus == "channels"
+
+    _press(grid, "j", "j", "\n")
+    assert grid.message == "Planned to release revision 6 in 'latest/beta'."
+    assert plan.get_releases("latest/beta") == (((6, ()),), True)
+
+
+def test_grid_move_channel_revision(grid, plan):
+    """Select the revision of a channel, with its resources, to release elsewhere."""
+    releases, _ = plan.get_releases("latest/candidate")
+    _press(grid, "j", "s", "k", "\n")
+    assert plan.get_releases("latest/stable") == (releases, True)
+
+
+def test_grid_move_channel_several_bases(grid, plan):
+    """All the revisions in the channel (for the different bases) are selected."""
+    plan.channel_map.append(
+        Release(
+            revision=6,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[],
+            released_at=NOW,
+            platform=None,
+        )
+    )
+    _press(grid, "j", "j", "j", "s")
+    assert grid.selected == ((7, ()), (6, ()))
+    assert grid.message == (
+        "Selected revisions 7, 6: press enter on the channels to release."
+    )
+
+    _press(grid, "k", "\n")
+    assert grid.message == "Planned to release revisions 7, 6 in 'latest/beta'."
+    assert plan.get_releases("latest/beta") == (((7, ()), (6, ())), True)
+
+
+def test_grid_select_from_empty_channel(grid):
+    """Nothing to select if the channel has no release."""
+    _press(grid, "s")
+    assert grid.selected is None
+    assert grid.message == "Nothing is released in 'latest/stable'."
+
+
+def test_grid_release_without_selection(grid, plan):
+    """A revision must be selected before releasing."""
+    _press(grid, "\n")
+    assert grid.message == "Select a revision first (from the revisions or a channel)."
+    assert plan.planned == {}
+
+
+def test_grid_cancel(grid, plan):
+    """Undo what was planned in the channel."""
+    _press(grid, "\t", "\n", "\n", "x")
+    assert plan.planned == {}
+
+
+def test_grid_attach_resource(grid, plan):
+    """Attach a resource to the release in the current channel."""
+    _press(grid, "j", "j", "j")
+    grid.attach_resource("db:8")
+    assert grid.message == "Planned to attach db (r8) in 'latest/edge'."
+    assert plan.get_releases("latest/edge") == (
+        ((7, (ResourceOption("db", 8),)),),
+        True,
+    )
+
+
+@pytest.mark.parametrize(
+    "value, message",
+    [
+        (
+            "db",
+            "Cannot attach the resource: the resource format must be "
+            "<name>:<revision> (revision being a positive integer)",
+        ),
+        (
+            "other:3",
+            "Cannot attach the resource: Unknown resource 'other' (the package "
+            "has: db).",
+        ),
+    ],
+)
+def test_grid_attach_resource_error(grid, plan, value, message):
+    """The problems when attaching a resource are shown."""
+    _press(grid, "j")
+    grid.attach_resource(value)
+    assert grid.message == message
+    assert plan.planned == {}
+
+
+def test_grid_confirm(grid):
+    """Confirm the planned releases."""
+    _press(grid, "c")
+    assert grid.message == "Nothing planned to release."
+    assert not grid.confirming
+
+    _press(grid, "\t", "\n", "\n", "c")
+    assert grid.confirming
+    _press(grid, "y")
+    assert grid.finished
+    assert grid.confirmed
+
+
+def test_grid_confirm_go_back(grid):
+    """Go back to the grid from the confirmation."""
+    _press(grid, "\t", "\n", "\n", "c", "n")
+    assert not grid.confirming
+    assert not grid.finished
+
+
+def test_grid_confirm_policy_violations(plan):
+    """The planned releases cannot be confirmed if the policy does not allow them."""
+    grid = ReleasesGrid(plan, ReleasePolicyConfig(restricted_tracks=["latest"]))
+    _press(grid, "\t", "\n", "\n", "c", "y")
+    assert not grid.finished
+    assert grid.message == (
+        "The release policy does not allow these releases; use --override-policy "
+        "to release anyway."
+    )
+
+
+def test_grid_confirm_policy_overridden(plan):
+    """The planned releases are confirmed anyway if the policy is overridden."""
+    policy = ReleasePolicyConfig(restricted_tracks=["latest"])
+    grid = ReleasesGrid(plan, policy, override=True)
+    _press(grid, "\t", "\n", "\n", "c", "y")
+    assert grid.confirmed
+
+
+def test_grid_quit(grid):
+    """Leave the grid without confirming."""
+    _press(grid, "q")
+    assert grid.finished
+    assert not grid.confirmed
+
+
+def _get_lines(screen):
+    """Return the lines written in the fake screen."""
+    return [args[2] for _, args, _ in screen.addnstr.mock_calls]
+
+
+@pytest.fixture
+def screen():
+    """Provide a fake curses screen."""
+    screen = MagicMock()
+    screen.getmaxyx.return_value = (30, 100)
+    return screen
+
+
+def test_grid_draw(grid, screen):
+    """Draw the channels, the revisions, and the help."""
+    _press(grid, "\t", "\n", "j", "\n")
+    grid.draw(screen)
+
+    assert _get_lines(screen) == [
+        "Releases of 'testcharm' (selected revision 7)",
+        "Channels",
+        "     Channel           Revision  Version  Resources",
+        "     latest/stable     -         -        -",
+        "> *  latest/candidate  7         v7       -",
+        "     latest/beta       -         -        -",
+        "     latest/edge       7         v7       -",
+        "Revisions",
+        "  Revision  Version  Created at        Status",
+        "  7         v7       2021-06-07 12:00  approved",
+        "  6         v6       2021-06-06 12:00  approved",
+        "  5         v5       2021-06-05 12:00  approved",
+        "Planned to release revision 7 in 'latest/candidate'.",
+        "[tab] switch list  [enter] select/release  [s] select channel's revisions  "
+        "[a] attach resource  [x] undo  [c] confirm  [q] quit",
+    ]
+
+
+def test_grid_draw_several_bases(grid, plan, screen):
+    """The channels show all their revisions, for the different bases."""
+    resource = Resource(name="db", optional=False, revision=3, resource_type="file")
+    plan.channel_map.append(
+        Release(
+            revision=6,
+            channel="latest/edge",
+            expires_at=None,
+            resources=[resource],
+            released_at=NOW,
+            platform=None,
+        )
+    )
+    grid.draw(screen)
+
+    lines = _get_lines(screen)
+    assert lines[2] == "     Channel           Revision  Version  Resources"
+    assert lines[5] == "     latest/beta       -         -        -"
+    assert lines[6] == "     latest/edge       7, 6      v7, v6   -; db (r3)"
+
+
+def test_grid_draw_confirmation(plan, screen):
+    """Draw the planned releases and the policy violations."""
+    grid = ReleasesGrid(plan, ReleasePolicyConfig(restricted_tracks=["latest"]))
+    plan.release(6, "latest/beta")
+    plan.attach("latest/edge", ResourceOption("db", 4))
+    _press(grid, "c")
+    grid.draw(screen)
+
+    assert _get_lines(screen) == [
+        "Releases of 'testcharm'",
+        "Planned releases",
+        "- revision 6 to latest/beta",
+        "- revision 7 to latest/edge (attaching resources: db (r4))",
+        "Release policy violations",
+        "- The track 'latest' is restricted (releasing to 'latest/beta').",
+        "- The track 'latest' is restricted (releasing to 'latest/edge').",
+        "",
+        "[y] do the releases  [n] go back",
+    ]
+
+
+def test_grid_draw_clipped(grid, screen):
+    """The lines are clipped to the screen, and the lists scroll to the cursor."""
+    screen.getmaxyx.return_value = (12, 30)
+    _press(grid, "j", "j", "j")
+    grid.draw(screen)
+
+    lines = [args[0] for _, args, _ in screen.addnstr.mock_calls]
+    assert max(lines) == 11
+    assert all(args[3] == 29 for _, args, _ in screen.addnstr.mock_calls)
+    assert "> " + " " * 3 + "latest/edge" in " ".join(_get_lines(screen))
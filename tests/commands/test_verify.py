@@ -0,0 +1,71 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the 'verify-artifact' command (code in commands/verify.py)."""
+
+import logging
+from argparse import ArgumentParser, Namespace
+from unittest.mock import patch
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.verify import VerifyArtifactCommand
+
+
+def test_verify_ok(caplog, config, tmp_path):
+    """The artifact is fine."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    filepath = tmp_path / "test.charm"
+    args = Namespace(filepath=filepath)
+    with patch("charmcraft.commands.verify.verify_artifact") as verify_mock:
+        verify_mock.return_value = ("charm", [])
+        VerifyArtifactCommand("group", config).run(args)
+
+    verify_mock.assert_called_once_with(filepath)
+    expected = ["The charm {!r} is deployable.".format(str(filepath))]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_verify_problems(caplog, config, tmp_path):
+    """The artifact has problems."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+
+    filepath = tmp_path / "bundle.zip"
+    problems = ["The 'README.md' file is missing.", "The path '/etc' is outside."]
+    args = Namespace(filepath=filepath)
+    with patch("charmcraft.commands.verify.verify_artifact") as verify_mock:
+        verify_mock.return_value = ("bundle", problems)
+        with pytest.raises(CommandError) as cm:
+            VerifyArtifactCommand("group", config).run(args)
+
+    assert str(cm.value) == (
+        "The bundle {!r} is not deployable (2 problem(s) found).".format(str(filepath))
+    )
+    expected = [
+        "- The 'README.md' file is missing.",
+        "- The path '/etc' is outside.",
+    ]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_verify_parser_missing_file(config, tmp_path):
+    """The file to verify must exist."""
+    parser = ArgumentParser()
+    VerifyArtifactCommand("group", config).fill_parser(parser)
+    with pytest.raises(CommandError):
+        parser.parse_args([str(tmp_path / "missing.charm")])
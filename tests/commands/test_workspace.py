@@ -0,0 +1,397 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the workspace command (code in commands/workspace.py)."""
+
+import logging
+import sys
+import threading
+from argparse import Namespace
+from textwrap import dedent
+from unittest.mock import patch
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.commands.workspace import (
+    RESULT_FAILED,
+    RESULT_OK,
+    RESULT_SKIPPED,
+    WORKSPACE_FILENAME,
+    WorkspaceCommand,
+    find_workspace_file,
+    load_workspace,
+    run_charmcraft,
+    run_projects,
+    select_projects,
+)
+
+
+def _create_charm(basedir, relpath, name):
+    """Create a minimal charm project."""
+    dirpath = basedir / relpath
+    dirpath.mkdir(parents=True)
+    (dirpath / "metadata.yaml").write_text("name: {}".format(name))
+    return dirpath
+
+
+def _create_bundle(basedir, relpath, name, charms):
+    """Create a minimal bundle project using the indicated charms."""
+    dirpath = basedir / relpath
+    dirpath.mkdir(parents=True)
+    (dirpath / "charmcraft.yaml").write_text("type: bundle")
+    applications = "".join(
+        "\n  app{}:\n    charm: {}".format(idx, charm)
+        for idx, charm in enumerate(charms)
+    )
+    (dirpath / "bundle.yaml").write_text(
+        "name: {}\napplications:{}".format(name, applications)
+    )
+    return dirpath
+
+
+@pytest.fixture
+def workspace(tmp_path):
+    """Provide a workspace with two charms and a bundle using both."""
+    _create_charm(tmp_path, "charms/db", "db-charm")
+    _create_charm(tmp_path, "charms/web", "web-charm")
+    _create_bundle(
+        tmp_path, "bundles/main", "main-bundle", ["ch:db-charm", "../../charms/web"]
+    )
+    (tmp_path / WORKSPACE_FILENAME).write_text(
+        dedent(
+            """
+            projects:
+              - bundles/main
+              - charms/db
+              - charms/web
+            """
+        )
+    )
+    return tmp_path
+
+
+# -- tests for the workspace loading
+
+
+def test_find_workspace_file_in_parent(workspace):
+    """The workspace file is found from any project."""
+    filepath = find_workspace_file(workspace / "charms" / "db")
+    assert filepath == workspace.resolve() / WORKSPACE_FILENAME
+
+
+def test_find_workspace_file_missing(tmp_path):
+    """There is no workspace file."""
+    with pytest.raises(CommandError) as cm:
+        find_workspace_file(tmp_path)
+    assert str(cm.value) == (
+        "Cannot find the workspace file {!r} in {!r} or any of its parents.".format(
+            WORKSPACE_FILENAME, str(tmp_path.resolve())
+        )
+    )
+
+
+def test_load_workspace_ok(workspace):
+    """Load all the projects and their dependencies."""
+    bundle, db, web = load_workspace(workspace / WORKSPACE_FILENAME)
+
+    assert (bundle.name, bundle.type, bundle.relpath) == (
+        "main-bundle",
+        "bundle",
+        "bundles/main",
+    )
+    assert (db.name, db.type, db.relpath) == ("db-charm", "charm", "charms/db")
+    assert (web.name, web.type, web.relpath) == ("web-charm", "charm", "charms/web")
+    assert bundle.depends_on == [db, web]
+    assert db.depends_on == []
+    assert web.depends_on == []
+    basedir = workspace.resolve()
+    assert bundle.artifact == basedir / "bundles" / "main" / "main-bundle.zip"
+    assert db.artifact == basedir / "charms" / "db" / "db-charm.charm"
+
+
+def test_load_workspace_metadata_in_config(tmp_path):
+    """The name of a charm is taken from its charmcraft.yaml if declared there."""
+    dirpath = tmp_path / "charm"
+    dirpath.mkdir()
+    (dirpath / "charmcraft.yaml").write_text("type: charm\nmetadata:\n  name: foo")
+    (tmp_path / WORKSPACE_FILENAME).write_text("projects: [charm]")
+
+    (charm,) = load_workspace(tmp_path / WORKSPACE_FILENAME)
+    assert (charm.name, charm.type) == ("foo", "charm")
+
+
+def test_load_workspace_bundle_external_charms(tmp_path):
+    """Charms not in the workspace are not dependencies."""
+    _create_bundle(tmp_path, "bundle", "some-bundle", ["cs:postgresql-7"])
+    (tmp_path / WORKSPACE_FILENAME).write_text("projects: [bundle]")
+
+    (bundle,) = load_workspace(tmp_path / WORKSPACE_FILENAME)
+    assert bundle.depends_on == []
+
+
+@pytest.mark.parametrize(
+    "content, problem",
+    [
+        ("foo: bar", "it must include a 'projects' list."),
+        ("projects: foo", "it must include a 'projects' list."),
+        ("- foo", "it must include a 'projects' list."),
+        ("projects: ['/abs/path']", "'/abs/path' must be a relative path."),
+        ("projects: [33]", "33 must be a relative path."),
+        ("projects: [missing]", "'missing' is not a directory."),
+    ],
+)
+def test_load_workspace_bad_file(tmp_path, content, problem):
+    """Different problems in the workspace file."""
+    filepath = tmp_path / WORKSPACE_FILENAME
+    filepath.write_text(content)
+
+    with pytest.raises(CommandError) as cm:
+        load_workspace(filepath)
+    assert str(cm.value) == "Bad workspace file {!r}: {}".format(str(filepath), problem)
+
+
+def test_load_workspace_project_without_name(tmp_path):
+    """The project's name cannot be found."""
+    (tmp_path / "charm").mkdir()
+    (tmp_path / WORKSPACE_FILENAME).write_text("projects: [charm]")
+
+    with pytest.raises(CommandError) as cm:
+        load_workspace(tmp_path / WORKSPACE_FILENAME)
+    assert str(cm.value) == (
+        "Cannot find the name of the charm in the workspace project 'charm'."
+    )
+
+
+def test_select_projects(workspace):
+    """Select projects by directory or name."""
+    projects = load_workspace(workspace / WORKSPACE_FILENAME)
+    bundle, db, web = projects
+
+    assert select_projects(projects, None) == projects
+    assert select_projects(projects, ["web-charm", "charms/db/"]) == [web, db]
+    assert select_projects(projects, ["db-charm", "charms/db"]) == [db]
+
+
+def test_select_projects_missing(workspace):
+    """The selected project is not in the workspace."""
+    projects = load_workspace(workspace / WORKSPACE_FILENAME)
+    with pytest.raises(CommandError) as cm:
+        select_projects(projects, ["other"])
+    assert str(cm.value) == "Project 'other' not found in the workspace."
+
+
+# -- tests for running in the projects
+
+
+def test_run_charmcraft(workspace):
+    """Run charmcraft in the project's directory."""
+    _, db, _ = load_workspace(workspace / WORKSPACE_FILENAME)
+    with patch("subprocess.run") as run_mock:
+        run_mock.return_value.returncode = 0
+        run_mock.return_value.stdout = "the output"
+        result = run_charmcraft(db, "pack", ["--foo"])
+
+    assert result == (0, "the output")
+    (cmd,), kwargs = run_mock.call_args
+    assert cmd == [sys.executable, "-m", "charmcraft", "pack", "--foo"]
+    assert kwargs["cwd"] == str(db.dirpath)
+
+
+@pytest.mark.parametrize("command", ["analyze", "upload"])
+def test_run_charmcraft_artifact(workspace, command):
+    """The artifact is passed to the commands that work on it."""
+    _, db, _ = load_workspace(workspace / WORKSPACE_FILENAME)
+    with patch("subprocess.run") as run_mock:
+        run_mock.return_value.returncode = 0
+        run_mock.return_value.stdout = ""
+        run_charmcraft(db, command, ["--foo", "bar"])
+
+    (cmd,), _ = run_mock.call_args
+    assert cmd == [
+        sys.executable,
+        "-m",
+        "charmcraft",
+        command,
+        str(db.artifact),
+        "--foo",
+        "bar",
+    ]
+
+
+def test_run_projects_dependencies_order(workspace):
+    """Bundles are run after the charms they contain."""
+    projects = load_workspace(workspace / WORKSPACE_FILENAME)
+    bundle, db, web = projects
+    finished = []
+    lock = threading.Lock()
+
+    def runner(project):
+        if project is bundle:
+            assert db in finished and web in finished
+        with lock:
+            finished.append(project)
+        return 0, ""
+
+    results = run_projects(projects, runner, jobs=3)
+    assert finished[-1] is bundle
+    assert {p: r for p, (r, _) in results.items()} == {
+        bundle: RESULT_OK,
+        db: RESULT_OK,
+        web: RESULT_OK,
+    }
+
+
+def test_run_projects_failure_skips_dependents(workspace, caplog):
+    """If a charm fails, the bundle that contains it is skipped."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    projects = load_workspace(workspace / WORKSPACE_FILENAME)
+    bundle, db, web = projects
+
+    def runner(project):
+        if project is db:
+            return 1, "line 1\nline 2"
+        return 0, ""
+
+    results = run_projects(projects, runner, jobs=1)
+    assert {p: r for p, (r, _) in results.items()} == {
+        bundle: RESULT_SKIPPED,
+        db: RESULT_FAILED,
+        web: RESULT_OK,
+    }
+    messages = [rec.message for rec in caplog.records]
+    assert "db-charm failed:" in messages
+    assert "    line 1" in messages
+    assert "web-charm finished ok." in messages
+    assert (
+        "Skipping main-bundle: some of its dependencies did not finish ok." in messages
+    )
+
+
+def test_run_projects_runner_crash(workspace):
+    """A crash in the runner is a failure for that project."""
+    projects = load_workspace(workspace / WORKSPACE_FILENAME)
+
+    def runner(project):
+        raise ValueError("boom")
+
+    results = run_projects(projects[1:], runner, jobs=2)
+    assert [r for r, _ in results.values()] == [RESULT_FAILED, RESULT_FAILED]
+
+
+def test_run_projects_selected_without_dependencies(workspace):
+    """Dependencies not selected are not waited for."""
+    projects = load_workspace(workspace / WORKSPACE_FILENAME)
+    bundle, _, _ = projects
+
+    results = run_projects([bundle], lambda project: (0, ""), jobs=1)
+    assert results[bundle][0] == RESULT_OK
+
+
+# -- tests for the command
+
+
+def test_command_all_ok(workspace, caplog, config):
+    """Run a command in all the projects it applies to."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    config.set(project=config.project.copy(update={"dirpath": workspace / "charms"}))
+
+    args = Namespace(command="publish-lib", projects=None, jobs=1, extra_args=[])
+    with patch("charmcraft.commands.workspace.run_charmcraft") as run_mock:
+        run_mock.return_value = (0, "")
+        with patch("time.monotonic", side_effect=[0, 1.5, 0, 1.5]):
+            WorkspaceCommand("group", config).run(args)
+
+    # bundles do not publish libraries
+    called = sorted(call_args[0][0].name for call_args in run_mock.call_args_list)
+    assert called == ["db-charm", "web-charm"]
+    messages = [rec.message for rec in caplog.records]
+    assert messages[0] == "Running 'publish-lib' in 2 project(s) (1 in parallel)."
+    assert messages[-3:] == [
+        "Project     Type    Result    Time",
+        "charms/db   charm   ok        1.5s",
+        "charms/web  charm   ok        1.5s",
+    ]
+
+
+def test_command_extra_args_and_failure(workspace, caplog, config):
+    """Pass the extra arguments, and fail if any project failed."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    config.set(project=config.project.copy(update={"dirpath": workspace}))
+
+    def fake_run(project, command, extra_args):
+        assert command == "fetch-lib"
+        assert extra_args == ["charms.other.v0.lib"]
+        return (1, "broken") if project.name == "db-charm" else (0, "")
+
+    args = Namespace(
+        command="fetch-lib",
+        projects=["db-charm", "web-charm"],
+        jobs=1,
+        extra_args=["--", "charms.other.v0.lib"],
+    )
+    with patch("charmcraft.commands.workspace.run_charmcraft", fake_run):
+        with pytest.raises(CommandError) as cm:
+            WorkspaceCommand("group", config).run(args)
+
+    assert str(cm.value) == (
+        "Command 'fetch-lib' did not finish ok in 1 of 2 project(s)."
+    )
+
+
+def test_command_upload_missing_artifact(workspace, caplog, config):
+    """Uploading fails for the projects that were not packed."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    config.set(project=config.project.copy(update={"dirpath": workspace}))
+    (workspace / "charms" / "web" / "web-charm.charm").write_bytes(b"charm")
+
+    args = Namespace(
+        command="upload", projects=["charms/db", "charms/web"], jobs=1, extra_args=[]
+    )
+    with patch("charmcraft.commands.workspace.run_charmcraft") as run_mock:
+        run_mock.return_value = (0, "")
+        with pytest.raises(CommandError):
+            WorkspaceCommand("group", config).run(args)
+
+    assert [call_args[0][0].name for call_args in run_mock.call_args_list] == [
+        "web-charm"
+    ]
+    messages = [rec.message for rec in caplog.records]
+    assert "    Cannot find 'db-charm.charm', pack the project first." in messages
+
+
+def test_command_no_projects(workspace, caplog, config):
+    """Nothing to do if the command does not apply to the selected projects."""
+    caplog.set_level(logging.INFO, logger="charmcraft.commands")
+    config.set(project=config.project.copy(update={"dirpath": workspace}))
+
+    args = Namespace(
+        command="publish-lib", projects=["bundles/main"], jobs=1, extra_args=[]
+    )
+    with patch("charmcraft.commands.workspace.run_charmcraft") as run_mock:
+        WorkspaceCommand("group", config).run(args)
+
+    run_mock.assert_not_called()
+    expected = ["No projects in the workspace to run 'publish-lib' in."]
+    assert expected == [rec.message for rec in caplog.records]
+
+
+def test_command_bad_jobs(config):
+    """The number of jobs must be positive."""
+    args = Namespace(command="pack", projects=None, jobs=0, extra_args=[])
+    with pytest.raises(CommandError) as cm:
+        WorkspaceCommand("group", config).run(args)
+    assert str(cm.value) == "The number of jobs must be at least 1."
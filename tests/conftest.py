@@ -16,11 +16,13 @@
 
 import datetime
 import tempfile
+from unittest.mock import patch
 
 import pytest
 import responses as responses_module
 
 from charmcraft import config as config_module
+from tests.factory import git
 
 
 @pytest.fixture(autouse=True, scope="session")
@@ -28,6 +30,22 @@ def tmpdir_under_tmpdir(tmpdir_factory):
     tempfile.tempdir = str(tmpdir_factory.getbasetemp())
 
 
+@pytest.fixture(autouse=True)
+def user_cache_dir(tmp_path):
+    """Isolate the user's cache directory, so the tests don't use the real one."""
+    cache_dir = tmp_path / "user-cache"
+    with patch("appdirs.user_cache_dir", lambda appname: str(cache_dir / appname)):
+        yield cache_dir
+
+
+@pytest.fixture(autouse=True)
+def user_config_dir(tmp_path):
+    """Isolate the user's config directory, so the tests don't use the real one."""
+    config_dir = tmp_path / "user-config"
+    with patch("appdirs.user_config_dir", lambda appname: str(config_dir / appname)):
+        yield config_dir
+
+
 @pytest.fixture
 def monkeypatch(monkeypatch):
     """Adapt pytest's monkeypatch to support stdlib's pathlib."""
@@ -78,6 +96,21 @@ def config(tmp_path):
     return TestConfig(type="bundle", project=project)
 
 
+@pytest.fixture
+def git_repo(tmp_path, monkeypatch):
+    """Provide a git repository with one commit."""
+    monkeypatch.setenv("GIT_AUTHOR_NAME", "Test Author")
+    monkeypatch.setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+    monkeypatch.setenv("GIT_COMMITTER_NAME", "Test Committer")
+    monkeypatch.setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+
+    git(tmp_path, "init", "--quiet", "--initial-branch=main")
+    (tmp_path / "somefile").write_text("content")
+    git(tmp_path, "add", "somefile")
+    git(tmp_path, "commit", "--quiet", "-m", "Initial commit")
+    return tmp_path
+
+
 @pytest.fixture
 def responses():
     """Simple helper to use responses module as a fixture, for easier integration in tests."""
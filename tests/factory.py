@@ -16,7 +16,9 @@
 
 """Collection of creation functions for normally used objects for testing."""
 
+import json
 import pathlib
+import subprocess
 import textwrap
 
 from charmcraft.cmdbase import BaseCommand
@@ -69,3 +71,42 @@ def create_lib_filepath(charm_name, lib_name, api=0, patch=1, lib_id="test-lib-i
     # files (no point in duplicating that logic here)
     libdata = _get_lib_info(lib_path=lib_file)
     return content, libdata.content_hash
+
+
+def git(dirpath, *args):
+    """Run a git command in the indicated directory, for the tests setup."""
+    subprocess.run(["git", "-C", str(dirpath)] + list(args), check=True)
+
+
+def create_distribution(venvpath, name, version, requires=()):
+    """Helper to create the metadata of an installed distribution."""
+    distinfo = venvpath / "{}-{}.dist-info".format(name.replace("-", "_"), version)
+    distinfo.mkdir(parents=True)
+    lines = ["Metadata-Version: 2.1", "Name: " + name, "Version: " + version]
+    lines.extend("Requires-Dist: " + requirement for requirement in requires)
+    lines.extend(["", "The description."])
+    (distinfo / "METADATA").write_text("\n".join(lines) + "\n")
+
+
+def create_osv_record(
+    dbpath, vuln_id, package, introduced="0", fixed=None, severity=None, aliases=()
+):
+    """Helper to create a vulnerability record, in the OSV format, in the database."""
+    events = [{"introduced": introduced}]
+    if fixed is not None:
+        events.append({"fixed": fixed})
+    record = {
+        "id": vuln_id,
+        "aliases": list(aliases),
+        "summary": "Something bad in {}".format(package),
+        "affected": [
+            {
+                "package": {"ecosystem": "PyPI", "name": package},
+                "ranges": [{"type": "ECOSYSTEM", "events": events}],
+            }
+        ],
+    }
+    if severity is not None:
+        record["database_specific"] = {"severity": severity}
+    dbpath.mkdir(parents=True, exist_ok=True)
+    (dbpath / (vuln_id + ".json")).write_text(json.dumps(record))
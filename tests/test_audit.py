@@ -0,0 +1,314 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the dependencies vulnerabilities audit (code in audit.py)."""
+
+import json
+import logging
+
+import pytest
+
+from charmcraft.audit import (
+    Distribution,
+    VulnerabilityDatabase,
+    audit,
+    cvss3_base_score,
+    get_blocking,
+    get_installed_distributions,
+    load_database,
+    normalize_name,
+    version_key,
+)
+from charmcraft.cmdbase import CommandError
+from charmcraft.config import AuditConfig
+from tests.factory import create_distribution, create_osv_record
+
+
+@pytest.fixture
+def database_dir(tmp_path):
+    """Provide a database with some vulnerabilities."""
+    dbpath = tmp_path / "osv"
+    create_osv_record(
+        dbpath, "PYSEC-2021-1", "PyYAML", fixed="5.4", severity="HIGH", aliases=["CVE-1"]
+    )
+    create_osv_record(dbpath, "PYSEC-2021-2", "ops", introduced="1.0", fixed="1.2.1")
+    create_osv_record(dbpath, "GHSA-xxxx", "requests", severity="moderate")
+    return dbpath
+
+
+@pytest.mark.parametrize(
+    "name, normalized",
+    [
+        ("PyYAML", "pyyaml"),
+        ("zope.interface", "zope-interface"),
+        ("some__weird-._name", "some-weird-name"),
+    ],
+)
+def test_normalize_name(name, normalized):
+    """Names are normalized as PyPI does."""
+    assert normalize_name(name) == normalized
+
+
+def test_version_key_ordering():
+    """Versions are sorted as PEP 440 indicates."""
+    versions = [
+        "0.9",
+        "1.0.dev1",
+        "1.0a1",
+        "1.0a2.dev1",
+        "1.0b1",
+        "1.0rc1",
+        "1.0",
+        "1.0.post1",
+        "1.0-2",
+        "1.1",
+        "1.10",
+        "2.0",
+        "1!0.5",
+    ]
+    assert sorted(reversed(versions), key=version_key) == versions
+
+
+def test_version_key_equivalences():
+    """Different ways of writing the same version."""
+    assert version_key("1.0") == version_key("1.0.0")
+    assert version_key("v1.0") == version_key("1.0")
+    assert version_key("1.0-rc.1") == version_key("1.0rc1")
+    assert version_key("1.0+local.7") == version_key("1.0")
+
+
+def test_get_installed_distributions(tmp_path):
+    """Get the distributions from their metadata in the venv."""
+    create_distribution(tmp_path, "PyYAML", "5.3.1")
+    create_distribution(tmp_path, "ops", "1.2.0")
+    egginfo = tmp_path / "old_thing.egg-info"
+    egginfo.mkdir()
+    (egginfo / "PKG-INFO").write_text("Name: Old_Thing\nVersion: 0.1\n")
+    (tmp_path / "yaml").mkdir()
+
+    assert get_installed_distributions(tmp_path) == [
+        Distribution("old-thing", "0.1"),
+        Distribution("ops", "1.2.0"),
+        Distribution("pyyaml", "5.3.1"),
+    ]
+
+
+def test_database_find(database_dir):
+    """Find the vulnerabilities affecting an installed distribution."""
+    database = VulnerabilityDatabase(database_dir)
+    (vuln,) = database.find(Distribution("pyyaml", "5.3.1"))
+    assert vuln.id == "PYSEC-2021-1"
+    assert vuln.aliases == ["CVE-1"]
+    assert vuln.package == "pyyaml"
+    assert vuln.version == "5.3.1"
+    assert vuln.severity == "high"
+    assert vuln.summary == "Something bad in PyYAML"
+    assert vuln.fixed_versions == ["5.4"]
+
+
+@pytest.mark.parametrize(
+    "version, affected",
+    [
+        ("0.9", False),
+        ("1.0", True),
+        ("1.2", True),
+        ("1.2.1rc1", True),
+        ("1.2.1", False),
+        ("1.3", False),
+    ],
+)
+def test_database_find_ranges(database_dir, version, affected):
+    """The versions are checked against the affected ranges."""
+    database = VulnerabilityDatabase(database_dir)
+    assert bool(database.find(Distribution("ops", version))) == affected
+
+
+def test_database_find_severities(tmp_path):
+    """The severity is taken from the different places it may be."""
+    create_osv_record(tmp_path, "V-1", "pkg", severity="moderate")
+    create_osv_record(tmp_path, "V-2", "pkg")
+    record = json.loads((tmp_path / "V-2.json").read_text())
+    record["severity"] = [{"type": "CVSS_V3", "score": "9.8"}]
+    (tmp_path / "V-2.json").write_text(json.dumps(record))
+    create_osv_record(tmp_path, "V-3", "pkg")
+
+    database = VulnerabilityDatabase(tmp_path)
+    vulns = database.find(Distribution("pkg", "1.0"))
+    assert [(v.id, v.severity) for v in vulns] == [
+        ("V-1", "medium"),
+        ("V-2", "critical"),
+        ("V-3", "unknown"),
+    ]
+
+
+@pytest.mark.parametrize(
+    "vector, score",
+    [
+        ("CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8),
+        ("CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1),
+        ("CVSS:3.0/AV:N/AC:L/PR:L/UI:N/S:C/C:H/I:H/A:H", 9.9),
+        ("CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N", 5.5),
+        ("CVSS:3.1/AV:P/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N", 1.6),
+        ("CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N", 0.0),
+        ("CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H", None),
+        ("CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", None),
+        ("AV:N/AC:L/Au:N/C:P/I:P/A:P", None),
+    ],
+)
+def test_cvss3_base_score(vector, score):
+    """The base score is calculated from the CVSS v3 vector."""
+    assert cvss3_base_score(vector) == score
+
+
+def test_database_find_severities_cvss_vectors(tmp_path):
+    """The severity is calculated from the CVSS vectors in the records."""
+    vectors = {
+        "V-1": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+        "V-2": "CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N",
+        "V-3": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N",
+        "V-4": "AV:N/AC:L/Au:N/C:P/I:P/A:P",
+    }
+    for vuln_id, vector in vectors.items():
+        create_osv_record(tmp_path, vuln_id, "pkg")
+        record = json.loads((tmp_path / (vuln_id + ".json")).read_text())
+        record["severity"] = [{"type": "CVSS_V3", "score": vector}]
+        (tmp_path / (vuln_id + ".json")).write_text(json.dumps(record))
+
+    database = VulnerabilityDatabase(tmp_path)
+    vulns = database.find(Distribution("pkg", "1.0"))
+    assert [(v.id, v.severity) for v in vulns] == [
+        ("V-1", "critical"),
+        ("V-2", "medium"),
+        ("V-3", "unknown"),
+        ("V-4", "unknown"),
+    ]
+
+
+def test_database_listed_versions(tmp_path):
+    """The affected versions may be just listed."""
+    record = {
+        "id": "V-1",
+        "affected": [
+            {"package": {"ecosystem": "PyPI", "name": "pkg"}, "versions": ["1.0", "1.1"]},
+            {"package": {"ecosystem": "npm", "name": "other"}, "versions": ["1.0"]},
+        ],
+    }
+    (tmp_path / "V-1.json").write_text(json.dumps(record))
+
+    database = VulnerabilityDatabase(tmp_path)
+    assert len(database.find(Distribution("pkg", "1.1"))) == 1
+    assert database.find(Distribution("pkg", "1.2")) == []
+    assert database.find(Distribution("other", "1.0")) == []
+
+
+def test_database_missing_directory(tmp_path):
+    """The database directory does not exist."""
+    with pytest.raises(CommandError) as cm:
+        VulnerabilityDatabase(tmp_path / "missing")
+    assert str(cm.value) == (
+        "Cannot use the vulnerabilities database: {!r} is not a directory.".format(
+            str(tmp_path / "missing")
+        )
+    )
+
+
+def test_database_invalid_record(tmp_path):
+    """A record in the database is not valid JSON."""
+    (tmp_path / "bad.json").write_text("{not json")
+    with pytest.raises(CommandError) as cm:
+        VulnerabilityDatabase(tmp_path)
+    assert str(cm.value).startswith(
+        "Invalid record in the vulnerabilities database {!r}: ".format(
+            str(tmp_path / "bad.json")
+        )
+    )
+
+
+def test_database_malformed_records(caplog, tmp_path):
+    """The records without an id are ignored."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.audit")
+    create_osv_record(tmp_path, "V-1", "pkg")
+    record = json.loads((tmp_path / "V-1.json").read_text())
+    del record["id"]
+    (tmp_path / "no-id.json").write_text(json.dumps(record))
+    (tmp_path / "not-a-dict.json").write_text(json.dumps(["V-2"]))
+
+    database = VulnerabilityDatabase(tmp_path)
+    assert [v.id for v in database.find(Distribution("pkg", "1.0"))] == ["V-1"]
+    assert [rec.message for rec in caplog.records] == [
+        "Ignoring malformed record in the vulnerabilities database {!r}.".format(
+            str(tmp_path / name)
+        )
+        for name in ("no-id.json", "not-a-dict.json")
+    ]
+
+
+def test_audit_ignore(database_dir):
+    """The vulnerabilities can be ignored by their ids or aliases."""
+    database = VulnerabilityDatabase(database_dir)
+    distributions = [
+        Distribution("pyyaml", "5.3.1"),
+        Distribution("ops", "1.2.0"),
+        Distribution("requests", "2.25.1"),
+    ]
+    found, ignored = audit(distributions, database, ignore=["CVE-1", "GHSA-xxxx"])
+    assert [v.id for v in found] == ["PYSEC-2021-2"]
+    assert [v.id for v in ignored] == ["PYSEC-2021-1", "GHSA-xxxx"]
+
+
+def test_get_blocking(database_dir):
+    """Only the vulnerabilities at or above the threshold are blocking."""
+    database = VulnerabilityDatabase(database_dir)
+    distributions = [Distribution("pyyaml", "5.3.1"), Distribution("requests", "1")]
+    found, _ = audit(distributions, database)
+    assert [v.id for v in get_blocking(found, "high")] == ["PYSEC-2021-1"]
+    assert [v.id for v in get_blocking(found, "critical")] == []
+    assert len(get_blocking(found, "unknown")) == 2
+
+
+def test_get_blocking_unknown_severity(database_dir):
+    """The vulnerabilities of unknown severity are blocking for any threshold."""
+    database = VulnerabilityDatabase(database_dir)
+    distributions = [Distribution("pyyaml", "5.3.1"), Distribution("ops", "1.2")]
+    found, _ = audit(distributions, database)
+    assert [(v.id, v.severity) for v in found] == [
+        ("PYSEC-2021-1", "high"),
+        ("PYSEC-2021-2", "unknown"),
+    ]
+    assert [v.id for v in get_blocking(found, "critical")] == ["PYSEC-2021-2"]
+    assert [v.id for v in get_blocking(found, "high")] == [
+        "PYSEC-2021-1",
+        "PYSEC-2021-2",
+    ]
+
+
+def test_load_database_none(config):
+    """No database indicated nor configured."""
+    assert load_database(config) is None
+
+
+def test_load_database_from_config(config, database_dir):
+    """Use the database configured relative to the project."""
+    config.set(audit=AuditConfig(database="osv"))
+    database = load_database(config)
+    assert database.dirpath == config.project.dirpath / "osv"
+
+
+def test_load_database_indicated(config, database_dir):
+    """The indicated database has priority over the configured one."""
+    config.set(audit=AuditConfig(database="other"))
+    database = load_database(config, database_dir)
+    assert database.dirpath == database_dir
@@ -0,0 +1,409 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the bundle verification against its charms (code in bundle.py)."""
+
+import logging
+import zipfile
+from textwrap import dedent
+from unittest.mock import MagicMock
+
+import pytest
+from requests.exceptions import ConnectionError
+
+from charmcraft.bundle import check_bundle, load_charm_directory, load_charm_package
+from charmcraft.cmdbase import CommandError
+
+WORDPRESS_METADATA = """
+    name: wordpress
+    requires:
+        db:
+            interface: mysql
+    provides:
+        website: http
+    peers:
+        cluster:
+            interface: wordpress-cluster
+"""
+
+WORDPRESS_CONFIG = """
+    options:
+        port:
+            type: int
+        title:
+            type: string
+        ratio:
+            type: float
+        debug:
+            type: boolean
+"""
+
+MYSQL_METADATA = """
+    name: mysql
+    provides:
+        db:
+            interface: mysql
+        admin:
+            interface: mysql-root
+"""
+
+
+def _create_charm(dirpath, metadata, config=None):
+    """Create a charm project with the indicated metadata and config."""
+    dirpath.mkdir(parents=True)
+    (dirpath / "metadata.yaml").write_text(dedent(metadata))
+    if config is not None:
+        (dirpath / "config.yaml").write_text(dedent(config))
+    return dirpath
+
+
+def _create_package(filepath, metadata, config=None):
+    """Create a packed charm with the indicated metadata and config."""
+    with zipfile.ZipFile(str(filepath), "w") as zf:
+        zf.writestr("metadata.yaml", dedent(metadata))
+        if config is not None:
+            zf.writestr("config.yaml", dedent(config))
+    return filepath
+
+
+@pytest.fixture
+def charms(tmp_path):
+    """Provide a directory with a wordpress charm project and a packed mysql charm."""
+    wp_dirpath = tmp_path / "charms" / "wordpress"
+    _create_charm(wp_dirpath, WORDPRESS_METADATA, WORDPRESS_CONFIG)
+    _create_package(tmp_path / "mysql.charm", MYSQL_METADATA)
+    return tmp_path
+
+
+def _bundle(relations, options=None):
+    """Build a bundle relating the wordpress and mysql local charms."""
+    return {
+        "applications": {
+            "wp": {"charm": "./charms/wordpress", "options": options or {}},
+            "db": {"charm": "./mysql.charm"},
+        },
+        "relations": relations,
+    }
+
+
+# -- tests for loading the charms
+
+
+def test_load_charm_directory(tmp_path):
+    """The endpoints and options of a charm project."""
+    dirpath = _create_charm(tmp_path / "wp", WORDPRESS_METADATA, WORDPRESS_CONFIG)
+    info = load_charm_directory(dirpath)
+    assert {name: tuple(endpoint) for name, endpoint in info.endpoints.items()} == {
+        "juju-info": ("provides", "juju-info"),
+        "db": ("requires", "mysql"),
+        "website": ("provides", "http"),
+        "cluster": ("peers", "wordpress-cluster"),
+    }
+    assert sorted(info.options) == ["debug", "port", "ratio", "title"]
+
+
+def test_load_charm_directory_config_sections(tmp_path):
+    """The metadata and config of a charm project can be in its charmcraft.yaml."""
+    dirpath = tmp_path / "wp"
+    dirpath.mkdir()
+    (dirpath / "charmcraft.yaml").write_text(
+        dedent(
+            """
+            type: charm
+            metadata:
+                name: wordpress
+                requires:
+                    db: mysql
+            config:
+                options:
+                    port:
+                        type: int
+            """
+        )
+    )
+    info = load_charm_directory(dirpath)
+    assert tuple(info.endpoints["db"]) == ("requires", "mysql")
+    assert info.options == {"port": {"type": "int"}}
+
+
+def test_load_charm_package(tmp_path):
+    """The endpoints and options of a packed charm."""
+    filepath = _create_package(tmp_path / "db.charm", MYSQL_METADATA)
+    info = load_charm_package(filepath)
+    assert sorted(info.endpoints) == ["admin", "db", "juju-info"]
+    assert info.options == {}
+
+
+def test_load_charm_package_not_a_zip(tmp_path):
+    """The packed charm is not a zip file."""
+    filepath = tmp_path / "db.charm"
+    filepath.write_text("whatever")
+    with pytest.raises(CommandError) as cm:
+        load_charm_package(filepath)
+    assert str(cm.value) == (
+        "Cannot open {!r}: it is not a charm file.".format(str(filepath))
+    )
+
+
+# -- tests for the relations
+
+
+@pytest.mark.parametrize(
+    "relation",
+    [
+        ["wp:db", "db:db"],
+        ["wp", "db:db"],
+        ["wp:db", "db"],
+        ["wp", "db"],
+    ],
+)
+def test_relation_ok(charms, relation):
+    """The relation connects compatible endpoints (maybe chosen by Juju)."""
+    assert check_bundle(_bundle([relation]), charms) == []
+
+
+def test_relation_juju_info(charms, tmp_path):
+    """All the charms provide the 'juju-info' endpoint implicitly."""
+    metadata = "name: monitor\nsubordinate: true\nrequires: {host: juju-info}"
+    _create_package(tmp_path / "monitor.charm", metadata)
+    bundle = _bundle([["monitor:host", "db:juju-info"]])
+    bundle["applications"]["monitor"] = {"charm": "./monitor.charm"}
+    assert check_bundle(bundle, charms) == []
+
+
+def test_relation_unknown_endpoint(charms):
+    """The endpoint does not exist in the charm."""
+    problems = check_bundle(_bundle([["wp:database", "db:db"]]), charms)
+    assert problems == [
+        "The relation between 'wp:database' and 'db:db' uses the endpoint "
+        "'database', which does not exist in the charm of application 'wp'."
+    ]
+
+
+def test_relation_different_interfaces(charms):
+    """The endpoints have different interfaces."""
+    problems = check_bundle(_bundle([["wp:db", "db:admin"]]), charms)
+    assert problems == [
+        "The relation between 'wp:db' and 'db:admin' connects different interfaces "
+        "('mysql' and 'mysql-root')."
+    ]
+
+
+def test_relation_same_role(charms, tmp_path):
+    """The endpoints need to be a provider and a requirer."""
+    _create_package(tmp_path / "other.charm", MYSQL_METADATA)
+    bundle = _bundle([["db:db", "other:db"]])
+    bundle["applications"]["other"] = {"charm": "./other.charm"}
+    problems = check_bundle(bundle, charms)
+    assert problems == [
+        "The relation between 'db:db' and 'other:db' must connect a provided "
+        "endpoint with a required one."
+    ]
+
+
+def test_relation_no_compatible_endpoints(charms, tmp_path):
+    """Juju would not find compatible endpoints to choose."""
+    _create_package(tmp_path / "other.charm", "name: other\nprovides: {web: http}")
+    bundle = _bundle([["db", "other"]])
+    bundle["applications"]["other"] = {"charm": "./other.charm"}
+    problems = check_bundle(bundle, charms)
+    assert problems == [
+        "The relation between 'db' and 'other' has no compatible endpoints."
+    ]
+
+
+def test_relation_unknown_application(charms):
+    """The relation refers to an application not in the bundle."""
+    problems = check_bundle(_bundle([["wp:db", "mysql:db"]]), charms)
+    assert problems == [
+        "The relation between 'wp:db' and 'mysql:db' refers to the unknown "
+        "application 'mysql'."
+    ]
+
+
+def test_relation_nested(charms):
+    """The legacy form relating an endpoint with several ones is expanded in pairs."""
+    bundle = _bundle([["db:db", ["wp:db", "wp2:website"]]])
+    bundle["applications"]["wp2"] = {"charm": "./charms/wordpress"}
+    problems = check_bundle(bundle, charms)
+    assert problems == [
+        "The relation between 'db:db' and 'wp2:website' connects different "
+        "interfaces ('mysql' and 'http')."
+    ]
+
+
+@pytest.mark.parametrize("relation", [["wp:db"], "wp:db db:db", ["wp:db", 3]])
+def test_relation_bad_format(charms, relation):
+    """The relation must be a pair of endpoints."""
+    problems = check_bundle(_bundle([relation]), charms)
+    assert problems == [
+        "The relation {!r} must be a pair of application endpoints.".format(relation)
+    ]
+
+
+def test_relation_nested_bad_format(charms):
+    """The pairs expanded from the legacy form are also verified."""
+    problems = check_bundle(_bundle([["wp:db", ["db:db", 3]]]), charms)
+    assert problems == [
+        "The relation ['wp:db', 3] must be a pair of application endpoints."
+    ]
+
+
+def test_relation_charm_not_local(charms):
+    """The endpoints cannot be verified for charms not available."""
+    bundle = _bundle([["wp:whatever", "db:db"]])
+    bundle["applications"]["wp"]["charm"] = "ch:wordpress"
+    assert check_bundle(bundle, charms) == []
+
+
+# -- tests for the options
+
+
+def test_options_ok(charms):
+    """The options exist in the charm with values of their types."""
+    options = {
+        "port": 8080,
+        "title": "My blog",
+        "ratio": 2,
+        "debug": True,
+    }
+    assert check_bundle(_bundle([], options), charms) == []
+
+
+def test_options_include_file(charms):
+    """The values included from files are not verified."""
+    options = {"port": "include-file://port.txt"}
+    assert check_bundle(_bundle([], options), charms) == []
+
+
+def test_options_unknown(charms):
+    """The option does not exist in the charm."""
+    problems = check_bundle(_bundle([], {"colour": "blue"}), charms)
+    assert problems == [
+        "The option 'colour' of application 'wp' does not exist in its charm."
+    ]
+
+
+@pytest.mark.parametrize(
+    "key, value, option_type",
+    [
+        ("port", "8080", "int"),
+        ("port", True, "int"),
+        ("title", 42, "string"),
+        ("ratio", "fast", "float"),
+        ("debug", 1, "boolean"),
+    ],
+)
+def test_options_bad_type(charms, key, value, option_type):
+    """The values must be of the option's type."""
+    problems = check_bundle(_bundle([], {key: value}), charms)
+    assert problems == [
+        "The option {!r} of application 'wp' must be of type {!r}.".format(
+            key, option_type
+        )
+    ]
+
+
+def test_missing_local_charm(charms):
+    """The local charm of an application does not exist."""
+    bundle = _bundle([["wp:db", "db:db"]])
+    bundle["applications"]["db"]["charm"] = "./missing"
+    assert check_bundle(bundle, charms) == [
+        "The charm './missing' of application 'db' does not exist."
+    ]
+
+
+# -- tests for the charms fetched from Charmhub
+
+
+def test_fetch_charms(charms):
+    """The charms not local are fetched, once per name and channel."""
+    bundle = _bundle([["wp:db", "db:db"], ["wp2:db", "db:db"]], {"port": 80})
+    bundle["applications"]["db"] = {"charm": "ch:mysql", "channel": "edge"}
+    bundle["applications"]["wp2"] = {"charm": "wordpress", "options": {"port": "80"}}
+
+    def fake_fetch(name, channel, filepath):
+        metadata = MYSQL_METADATA if name == "mysql" else WORDPRESS_METADATA
+        config = WORDPRESS_CONFIG if name == "wordpress" else None
+        _create_package(filepath, metadata, config)
+
+    fetch = MagicMock(side_effect=fake_fetch)
+    problems = check_bundle(bundle, charms, fetch=fetch)
+    assert problems == [
+        "The option 'port' of application 'wp2' must be of type 'int'.",
+    ]
+    assert [call[0][:2] for call in fetch.call_args_list] == [
+        ("mysql", "edge"),
+        ("wordpress", "stable"),
+    ]
+
+
+def test_fetch_charms_error(charms, caplog):
+    """The charms that cannot be fetched are not verified."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    bundle = _bundle([["wp:db", "db:whatever"]])
+    bundle["applications"]["db"] = {"charm": "mysql"}
+    fetch = MagicMock(side_effect=CommandError("Not found."))
+
+    assert check_bundle(bundle, charms, fetch=fetch) == []
+    assert [rec.message for rec in caplog.records] == [
+        "Cannot fetch charm 'mysql' to verify the bundle: Not found."
+    ]
+
+
+def test_fetch_charms_network_error(charms, caplog):
+    """The charms that cannot be fetched because of the network are not verified."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    bundle = _bundle([["wp:db", "db:whatever"]])
+    bundle["applications"]["db"] = {"charm": "mysql"}
+    fetch = MagicMock(side_effect=ConnectionError("Connection refused."))
+
+    assert check_bundle(bundle, charms, fetch=fetch) == []
+    assert [rec.message for rec in caplog.records] == [
+        "Cannot fetch charm 'mysql' to verify the bundle: Connection refused."
+    ]
+
+
+@pytest.mark.parametrize(
+    "content, error",
+    [
+        (b"not a zip", "Cannot open {!r}: it is not a charm file."),
+        (None, "Bad magic number for file header"),
+    ],
+)
+def test_fetch_charms_bad_package(charms, caplog, content, error):
+    """The fetched charms that cannot be loaded are not verified."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    bundle = _bundle([["wp:db", "db:whatever"]])
+    bundle["applications"]["db"] = {"charm": "mysql"}
+    paths = []
+
+    def fake_fetch(name, channel, filepath):
+        paths.append(filepath)
+        if content is None:
+            # a damaged package, which still looks like a zip
+            _create_package(filepath, MYSQL_METADATA)
+            damaged = filepath.read_bytes().replace(b"PK\x03\x04", b"PK\x00\x00")
+            filepath.write_bytes(damaged)
+        else:
+            filepath.write_bytes(content)
+
+    assert check_bundle(bundle, charms, fetch=MagicMock(side_effect=fake_fetch)) == []
+    assert [rec.message for rec in caplog.records] == [
+        "Cannot load charm 'mysql' to verify the bundle: {}".format(
+            error.format(str(paths[0]))
+        )
+    ]
@@ -0,0 +1,296 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the shared cache (code in cache.py)."""
+
+import datetime
+import os
+import time
+from unittest.mock import patch
+
+import pytest
+
+from charmcraft import cache
+
+
+def _create_venv(dirpath, content="# the framework"):
+    """Create a fake installed dependencies directory."""
+    (dirpath / "ops").mkdir(parents=True)
+    (dirpath / "ops" / "__init__.py").write_text(content)
+    (dirpath / "bin").mkdir()
+    (dirpath / "bin" / "tool").symlink_to("../ops/__init__.py")
+    return dirpath
+
+
+def _age(dirpath, days):
+    """Make the directory look as not used for the indicated days."""
+    timestamp = time.time() - days * 86400
+    os.utime(str(dirpath), (timestamp, timestamp))
+
+
+def test_cache_dirpath(user_cache_dir):
+    """The cache is in the user's cache directory."""
+    assert cache.get_cache_dirpath() == user_cache_dir / "charmcraft"
+    assert cache.get_pip_cache_dirpath() == user_cache_dir / "charmcraft" / "pip"
+
+
+def test_venv_key_content(tmp_path):
+    """The key depends on the content of the requirements and the platform."""
+    reqs = tmp_path / "requirements.txt"
+    reqs.write_text("ops==1.2.0\n")
+    key = cache.get_venv_key([reqs], "ubuntu-20.04-x86_64-python3.8")
+    assert key == cache.get_venv_key([reqs], "ubuntu-20.04-x86_64-python3.8")
+    assert key != cache.get_venv_key([reqs], "ubuntu-22.04-x86_64-python3.10")
+
+    reqs.write_text("ops==1.3.0\n")
+    assert key != cache.get_venv_key([reqs], "ubuntu-20.04-x86_64-python3.8")
+
+
+def test_venv_key_included_files(tmp_path):
+    """The key depends on the content of the included requirements and constraints."""
+    reqs = tmp_path / "requirements.txt"
+    reqs.write_text("-r base.txt\n--constraint=constraints.txt\nops\n")
+    (tmp_path / "base.txt").write_text("requests\n")
+    constraints = tmp_path / "constraints.txt"
+    constraints.write_text("requests==2.25.1\n")
+    key = cache.get_venv_key([reqs], "platform")
+
+    constraints.write_text("requests==2.26.0\n")
+    assert key != cache.get_venv_key([reqs], "platform")
+
+
+def test_venv_key_missing_file(tmp_path):
+    """A missing requirements file does not break the key (pip will complain)."""
+    reqs = tmp_path / "requirements.txt"
+    reqs.write_text("-r missing.txt\n")
+    assert cache.get_venv_key([reqs], "platform")
+
+
+def test_venv_key_shared_across_projects(tmp_path):
+    """The same requirements in different projects use the same installed venv."""
+    requirement_paths = []
+    for project in ("project-1", "project-2"):
+        project_dir = tmp_path / project
+        project_dir.mkdir()
+        reqs = project_dir / "requirements.txt"
+        reqs.write_text("-r base.txt\nops==1.2.0\n")
+        (project_dir / "base.txt").write_text("requests==2.25.1\n")
+        requirement_paths.append(reqs)
+    key_1 = cache.get_venv_key([requirement_paths[0]], "platform")
+    key_2 = cache.get_venv_key([requirement_paths[1]], "platform")
+    assert key_1 == key_2
+
+    cache.store_venv(key_1, _create_venv(tmp_path / "venv"))
+    newvenv = tmp_path / "project-2" / "build" / "venv"
+    assert cache.restore_venv(key_2, newvenv)
+    assert (newvenv / "ops" / "__init__.py").read_text() == "# the framework"
+
+    # but not if what the projects include is different
+    (tmp_path / "project-2" / "base.txt").write_text("requests==2.26.0\n")
+    assert key_1 != cache.get_venv_key([requirement_paths[1]], "platform")
+
+
+@pytest.mark.parametrize(
+    "content",
+    [
+        "ops==1.2.0\n",
+        "ops===1.2.0\n",
+        "ops[extra] == 1.2.0 ; python_version >= '3.8'\n",
+        "ops==1.2.0 \\\n    --hash=sha256:1234\n",
+        "# the framework\nops==1.2.0  # pinned\n\n--index-url https://pypi.org/simple\n",
+        "-c constraints.txt\nOps\n",
+    ],
+)
+def test_unpinned_requirement_none(tmp_path, content):
+    """All the requirements are pinned to a version, by themselves or constraints."""
+    (tmp_path / "constraints.txt").write_text("ops==1.2.0\n")
+    reqs = tmp_path / "requirements.txt"
+    reqs.write_text(content)
+    assert cache.get_unpinned_requirement([reqs]) is None
+
+
+@pytest.mark.parametrize(
+    "content, unpinned",
+    [
+        ("ops\n", "ops"),
+        ("ops>=1.2\n", "ops>=1.2"),
+        ("ops==1.*\n", "ops==1.*"),
+        (".\n", "."),
+        ("./vendor/ops\n", "./vendor/ops"),
+        ("/vendor/ops.tar.gz\n", "/vendor/ops.tar.gz"),
+        ("-e ./vendor/ops\n", "-e ./vendor/ops"),
+        ("--editable=./vendor/ops\n", "--editable=./vendor/ops"),
+        ("file:///vendor/ops\n", "file:///vendor/ops"),
+        ("ops @ https://example.com/ops.whl\n", "ops @ https://example.com/ops.whl"),
+        ("git+https://example.com/ops.git\n", "git+https://example.com/ops.git"),
+        ("ops==1.2.0\n-r base.txt\n", "requests"),
+        ("-c constraints.txt\nrequests\n", "requests"),
+    ],
+)
+def test_unpinned_requirement_found(tmp_path, content, unpinned):
+    """The first requirement not pinned to a version is returned."""
+    (tmp_path / "base.txt").write_text("requests\n")
+    (tmp_path / "constraints.txt").write_text("ops==1.2.0\n")
+    reqs = tmp_path / "requirements.txt"
+    reqs.write_text(content)
+    assert cache.get_unpinned_requirement([reqs]) == unpinned
+
+
+def test_link_tree(tmp_path):
+    """The directory is replicated with hard links, keeping the symlinks."""
+    srcdir = _create_venv(tmp_path / "src")
+    destdir = tmp_path / "dest"
+    cache.link_tree(srcdir, destdir)
+
+    srcfile = srcdir / "ops" / "__init__.py"
+    destfile = destdir / "ops" / "__init__.py"
+    assert destfile.stat().st_ino == srcfile.stat().st_ino
+    assert os.readlink(str(destdir / "bin" / "tool")) == "../ops/__init__.py"
+
+
+def test_link_tree_cannot_link(tmp_path):
+    """The files are copied if they cannot be hard linked."""
+    srcdir = _create_venv(tmp_path / "src")
+    destdir = tmp_path / "dest"
+    with patch("os.link", side_effect=OSError("Invalid cross-device link")):
+        cache.link_tree(srcdir, destdir)
+
+    srcfile = srcdir / "ops" / "__init__.py"
+    destfile = destdir / "ops" / "__init__.py"
+    assert destfile.read_text() == "# the framework"
+    assert destfile.stat().st_ino != srcfile.stat().st_ino
+
+
+def test_venv_store_and_restore(tmp_path):
+    """The installed dependencies are stored and restored later."""
+    venvpath = _create_venv(tmp_path / "venv")
+    cache.store_venv("somekey", venvpath)
+
+    newvenv = tmp_path / "build" / "venv"
+    assert cache.restore_venv("somekey", newvenv)
+    assert (newvenv / "ops" / "__init__.py").read_text() == "# the framework"
+
+    # no temporary directories are left behind
+    cached = list((cache.get_cache_dirpath() / cache.VENVS_DIRNAME).iterdir())
+    assert [path.name for path in cached] == ["somekey"]
+
+
+def test_venv_restore_missing(tmp_path):
+    """Nothing is restored if it is not in the cache."""
+    venvpath = tmp_path / "venv"
+    assert not cache.restore_venv("somekey", venvpath)
+    assert not venvpath.exists()
+
+
+def test_venv_restore_marks_used(tmp_path):
+    """Restoring the dependencies marks them as used."""
+    cache.store_venv("somekey", _create_venv(tmp_path / "venv"))
+    cached = cache.get_cache_dirpath() / cache.VENVS_DIRNAME / "somekey"
+    _age(cached, 10)
+
+    cache.restore_venv("somekey", tmp_path / "build")
+    assert cached.stat().st_mtime > time.time() - 60
+
+
+def test_venv_store_already_there(tmp_path):
+    """The stored dependencies are not replaced."""
+    cache.store_venv("somekey", _create_venv(tmp_path / "venv1"))
+    cache.store_venv("somekey", _create_venv(tmp_path / "venv2", "# other"))
+
+    cache.restore_venv("somekey", tmp_path / "build")
+    assert (tmp_path / "build" / "ops" / "__init__.py").read_text() == "# the framework"
+
+
+def test_venv_store_nothing_installed(tmp_path):
+    """Nothing is stored if nothing was installed."""
+    cache.store_venv("somekey", tmp_path / "venv")
+    assert not (cache.get_cache_dirpath() / cache.VENVS_DIRNAME / "somekey").exists()
+
+
+def test_usage_empty():
+    """The usage of an empty cache."""
+    usage = cache.get_usage()
+    assert [(section.name, section.entries, section.size) for section in usage] == [
+        ("pip", 0, 0),
+        ("venvs", 0, 0),
+        ("store", 0, 0),
+    ]
+
+
+def test_usage(tmp_path):
+    """The usage of each part of the cache, counting hard links once."""
+    cache.store_venv("key1", _create_venv(tmp_path / "venv1"))
+    cache.store_venv("key2", _create_venv(tmp_path / "venv2", "# other framework"))
+    pip_dirpath = cache.get_pip_cache_dirpath() / "wheels"
+    pip_dirpath.mkdir(parents=True)
+    (pip_dirpath / "ops.whl").write_bytes(b"x" * 1000)
+
+    pip, venvs, store = cache.get_usage()
+    assert (pip.entries, store.entries, venvs.entries) == (1, 0, 2)
+    assert pip.size > 1000
+    venv_files_size = len("# the framework") + len("# other framework")
+    assert venvs.size > venv_files_size
+
+    # a venv restored from the cache does not use more space in it
+    cache.restore_venv("key1", tmp_path / "build")
+    _, venvs_after, _ = cache.get_usage()
+    assert venvs_after.size == venvs.size
+
+
+def test_prune_old(tmp_path):
+    """Only the installed dependencies not used recently are removed."""
+    cache.store_venv("old", _create_venv(tmp_path / "venv1"))
+    cache.store_venv("recent", _create_venv(tmp_path / "venv2"))
+    venvs_dirpath = cache.get_cache_dirpath() / cache.VENVS_DIRNAME
+    _age(venvs_dirpath / "old", 40)
+    _age(venvs_dirpath / "recent", 5)
+    cache.get_pip_cache_dirpath().mkdir()
+
+    removed, freed = cache.prune(datetime.timedelta(days=30))
+    assert removed == 1
+    assert freed > 0
+    assert [path.name for path in venvs_dirpath.iterdir()] == ["recent"]
+    assert cache.get_pip_cache_dirpath().exists()
+
+
+def test_prune_old_empty():
+    """Nothing to prune in an empty cache."""
+    assert cache.prune(datetime.timedelta(days=30)) == (0, 0)
+
+
+def test_prune_all(tmp_path):
+    """Everything is removed from the cache."""
+    cache.store_venv("recent", _create_venv(tmp_path / "venv"))
+    store_dirpath = cache.get_cache_dirpath() / cache.STORE_DIRNAME
+    store_dirpath.mkdir()
+    (store_dirpath / "response").write_text("{}")
+
+    removed, freed = cache.prune()
+    assert removed == 2
+    assert freed > 0
+    assert list(cache.get_cache_dirpath().iterdir()) == []
+
+
+@pytest.mark.parametrize("value", ["-1", "foo"])
+def test_days_bad(value):
+    """The number of days must be a non-negative integer."""
+    with pytest.raises(ValueError):
+        cache.days(value)
+
+
+def test_days():
+    """Convert a number of days."""
+    assert cache.days("7") == datetime.timedelta(days=7)
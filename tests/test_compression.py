@@ -0,0 +1,262 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the parallel compression of zip files (code in compression.py)."""
+
+import os
+import pathlib
+import zipfile
+from unittest.mock import patch
+
+import pytest
+
+from charmcraft.compression import ParallelZipFile
+
+
+def _create_files(dirpath, count=50):
+    """Create some files with compressible content, all with the same timestamp."""
+    dirpath.mkdir()
+    filepaths = []
+    for idx in range(count):
+        filepath = dirpath / "file{:03d}.py".format(idx)
+        filepath.write_text("print('some code {}')\n".format(idx) * (idx + 1) * 20)
+        os.utime(str(filepath), (1600000000, 1600000000))
+        filepaths.append(filepath)
+    return filepaths
+
+
+def _write_zip(zippath, filepaths, basedir, **kwargs):
+    """Write the files in a zip with the parallel writer."""
+    with ParallelZipFile(zippath, **kwargs) as zipfh:
+        for filepath in filepaths:
+            zipfh.write(filepath, filepath.relative_to(basedir))
+
+
+def test_content_and_order(tmp_path):
+    """The entries are written in the same order they were added, with their content."""
+    filepaths = _create_files(tmp_path / "src")
+    zippath = tmp_path / "result.zip"
+    _write_zip(zippath, reversed(filepaths), tmp_path, workers=4)
+
+    with zipfile.ZipFile(str(zippath)) as zf:
+        assert zf.testzip() is None
+        assert zf.namelist() == [
+            str(path.relative_to(tmp_path)) for path in reversed(filepaths)
+        ]
+        for filepath in filepaths:
+            name = str(filepath.relative_to(tmp_path))
+            assert zf.read(name) == filepath.read_bytes()
+            info = zf.getinfo(name)
+            assert info.compress_type == zipfile.ZIP_DEFLATED
+            assert info.compress_size < info.file_size
+
+
+def test_same_as_zipfile(tmp_path):
+    """The result is what zipfile produces compressing each file after the other."""
+    filepaths = _create_files(tmp_path / "src")
+    parallel_zippath = tmp_path / "parallel.zip"
+    _write_zip(parallel_zippath, filepaths, tmp_path, workers=8)
+
+    serial_zippath = tmp_path / "serial.zip"
+    with zipfile.ZipFile(str(serial_zippath), "w", zipfile.ZIP_DEFLATED) as zf:
+        for filepath in filepaths:
+            zf.write(str(filepath), str(filepath.relative_to(tmp_path)))
+
+    assert parallel_zippath.read_bytes() == serial_zippath.read_bytes()
+
+
+def _write_serial_zip(zippath, filepaths, basedir):
+    """Write the files in a zip with ZipFile, one after the other."""
+    with zipfile.ZipFile(str(zippath), "w", zipfile.ZIP_DEFLATED) as zf:
+        for filepath in filepaths:
+            zf.write(str(filepath), str(filepath.relative_to(basedir)))
+
+
+def test_one_worker_uses_zipfile(tmp_path):
+    """With only one worker the entries are written by ZipFile, without threads."""
+    filepaths = _create_files(tmp_path / "src")
+    zippath = tmp_path / "result.zip"
+    with patch("charmcraft.compression.ThreadPoolExecutor") as mock_executor:
+        _write_zip(zippath, filepaths, tmp_path, workers=1)
+    mock_executor.assert_not_called()
+
+    serial_zippath = tmp_path / "serial.zip"
+    _write_serial_zip(serial_zippath, filepaths, tmp_path)
+    assert zippath.read_bytes() == serial_zippath.read_bytes()
+
+
+def test_unsupported_python_uses_zipfile(tmp_path):
+    """Where ZipFile's internals are not supported, it writes all the entries."""
+    filepaths = _create_files(tmp_path / "src")
+    zippath = tmp_path / "result.zip"
+    with patch("charmcraft.compression.PARALLEL_SUPPORTED", False):
+        with patch("charmcraft.compression.ThreadPoolExecutor") as mock_executor:
+            _write_zip(zippath, filepaths, tmp_path, workers=4)
+    mock_executor.assert_not_called()
+
+    serial_zippath = tmp_path / "serial.zip"
+    _write_serial_zip(serial_zippath, filepaths, tmp_path)
+    assert zippath.read_bytes() == serial_zippath.read_bytes()
+
+
+def test_compresslevel_not_supported(tmp_path):
+    """Before Python 3.7 ZipFile does not receive the compression level."""
+    filepaths = _create_files(tmp_path / "src", count=3)
+    original_write = zipfile.ZipFile.write
+    original_writestr = zipfile.ZipFile.writestr
+
+    # the signatures of those methods in Python 3.6
+    def fake_write(self, filename, arcname=None, compress_type=None):
+        return original_write(self, filename, arcname, compress_type)
+
+    def fake_writestr(self, zinfo_or_arcname, data, compress_type=None):
+        return original_writestr(self, zinfo_or_arcname, data, compress_type)
+
+    zippath = tmp_path / "result.zip"
+    with patch("charmcraft.compression.COMPRESSLEVEL_SUPPORTED", False):
+        with patch.object(zipfile.ZipFile, "write", fake_write):
+            with patch.object(zipfile.ZipFile, "writestr", fake_writestr):
+                with ParallelZipFile(zippath, workers=1) as zipfh:
+                    for filepath in filepaths:
+                        zipfh.write(filepath, filepath.relative_to(tmp_path))
+                    zipfh.writestr(zipfile.ZipInfo("generated"), b"content")
+
+    with zipfile.ZipFile(str(zippath)) as zf:
+        assert zf.namelist() == [
+            "src/file000.py",
+            "src/file001.py",
+            "src/file002.py",
+            "generated",
+        ]
+        assert zf.read("generated") == b"content"
+
+
+def test_big_files_streamed(tmp_path):
+    """The files bigger than the threshold are not loaded in memory, order is kept."""
+    filepaths = _create_files(tmp_path / "src", count=10)
+    threshold = filepaths[4].stat().st_size
+    read_paths = []
+    original_read_bytes = pathlib.Path.read_bytes
+
+    def fake_read_bytes(path):
+        read_paths.append(path)
+        return original_read_bytes(path)
+
+    zippath = tmp_path / "result.zip"
+    with patch("charmcraft.compression.STREAM_THRESHOLD", threshold):
+        with patch.object(pathlib.Path, "read_bytes", fake_read_bytes):
+            _write_zip(zippath, filepaths, tmp_path, workers=4)
+    assert read_paths == filepaths[:5]
+
+    serial_zippath = tmp_path / "serial.zip"
+    _write_serial_zip(serial_zippath, filepaths, tmp_path)
+    assert zippath.read_bytes() == serial_zippath.read_bytes()
+
+
+def test_deterministic(tmp_path):
+    """The result does not depend on the number of workers."""
+    filepaths = _create_files(tmp_path / "src")
+    results = []
+    for workers in (1, 2, 16):
+        zippath = tmp_path / "result-{}.zip".format(workers)
+        _write_zip(zippath, filepaths, tmp_path, workers=workers)
+        results.append(zippath.read_bytes())
+    assert results[0] == results[1] == results[2]
+
+
+@pytest.mark.parametrize("level", [1, 9])
+def test_level(tmp_path, level):
+    """The indicated compression level is used."""
+    filepaths = _create_files(tmp_path / "src")
+    zippath = tmp_path / "result.zip"
+    _write_zip(zippath, filepaths, tmp_path, level=level)
+
+    serial_zippath = tmp_path / "serial.zip"
+    with zipfile.ZipFile(
+        str(serial_zippath), "w", zipfile.ZIP_DEFLATED, compresslevel=level
+    ) as zf:
+        for filepath in filepaths:
+            zf.write(str(filepath), str(filepath.relative_to(tmp_path)))
+
+    with zipfile.ZipFile(str(zippath)) as zf1, zipfile.ZipFile(serial_zippath) as zf2:
+        sizes = [info.compress_size for info in zf1.infolist()]
+        assert sizes == [info.compress_size for info in zf2.infolist()]
+
+
+def test_level_zero_stored(tmp_path):
+    """The entries are not compressed with level 0."""
+    filepaths = _create_files(tmp_path / "src", count=3)
+    zippath = tmp_path / "result.zip"
+    _write_zip(zippath, filepaths, tmp_path, level=0)
+
+    with zipfile.ZipFile(str(zippath)) as zf:
+        assert zf.testzip() is None
+        for info in zf.infolist():
+            assert info.compress_type == zipfile.ZIP_STORED
+            assert info.compress_size == info.file_size
+
+
+def test_writestr(tmp_path):
+    """Entries with generated content keep the attributes of their info."""
+    zippath = tmp_path / "result.zip"
+    with ParallelZipFile(zippath) as zipfh:
+        info = zipfile.ZipInfo("dispatch", date_time=(2021, 6, 10, 12, 0, 0))
+        info.external_attr = 0o100755 << 16
+        zipfh.writestr(info, b"#!/bin/sh\n")
+        zipfh.writestr(zipfile.ZipInfo("other"), b"")
+
+    with zipfile.ZipFile(str(zippath)) as zf:
+        assert zf.testzip() is None
+        assert zf.read("dispatch") == b"#!/bin/sh\n"
+        assert zf.getinfo("dispatch").external_attr >> 16 == 0o100755
+        assert zf.getinfo("dispatch").date_time == (2021, 6, 10, 12, 0, 0)
+        assert zf.getinfo("other").external_attr >> 16 == 0o600
+
+
+def test_directory(tmp_path):
+    """Directories are stored as such."""
+    (tmp_path / "somedir").mkdir()
+    zippath = tmp_path / "result.zip"
+    with ParallelZipFile(zippath) as zipfh:
+        zipfh.write(tmp_path / "somedir", "somedir")
+
+    with zipfile.ZipFile(str(zippath)) as zf:
+        (info,) = zf.infolist()
+        assert info.filename == "somedir/"
+        assert info.is_dir()
+
+
+def test_error_in_worker(tmp_path):
+    """An error reading a file is raised when writing it, and the zip is closed."""
+    filepaths = _create_files(tmp_path / "src", count=3)
+    original_read_bytes = pathlib.Path.read_bytes
+
+    def fake_read_bytes(path):
+        if path == filepaths[1]:
+            raise PermissionError("Permission denied")
+        return original_read_bytes(path)
+
+    zippath = tmp_path / "result.zip"
+    with patch.object(pathlib.Path, "read_bytes", fake_read_bytes):
+        zipfh = ParallelZipFile(zippath, workers=2)
+        zipfh.write(filepaths[0], "first")
+        zipfh.write(filepaths[1], "second")
+        zipfh.write(filepaths[2], "third")
+        with pytest.raises(PermissionError):
+            zipfh.close()
+
+    with zipfile.ZipFile(str(zippath)) as zf:
+        assert zf.namelist() == ["first"]
@@ -456,3 +456,899 @@ def test_basicprime_empty():
     """Building with an empty list."""
     config = Part(prime=[])
     assert config.prime == []
+
+
+# -- tests for the charm part config
+
+
+def test_charmpart_version_default(create_config):
+    """The version template is not mandatory."""
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.parts.charm.version is None
+    assert config.parts.get("charm") == config.parts.charm
+
+
+def test_charmpart_version_template_ok(create_config):
+    """A template using valid fields."""
+    tmp_path = create_config(
+        """
+        type: charm
+        parts:
+            charm:
+                version: "{branch}-{short_commit}{dirty}"
+    """
+    )
+    config = load(tmp_path)
+    assert config.parts.charm.version == "{branch}-{short_commit}{dirty}"
+
+
+def test_schema_charmpart_version_unknown_field(create_config, check_schema_error):
+    """Schema validation, the version template uses an unknown field."""
+    create_config(
+        """
+        type: charm
+        parts:
+            charm:
+                version: "{tag}-{commit}"
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- unknown field(s) in template: tag (valid ones are: branch, commit, "
+            "describe, dirty, short_commit) in field 'parts.charm.version'"
+        )
+    )
+
+
+def test_schema_charmpart_version_bad_template(create_config, check_schema_error):
+    """Schema validation, the version template is broken."""
+    create_config(
+        """
+        type: charm
+        parts:
+            charm:
+                version: "{commit"
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- invalid template (expected '}' before end of string) "
+            "in field 'parts.charm.version'"
+        )
+    )
+
+
+def test_charmpart_legacy_hooks_default(create_config):
+    """The legacy hooks are not generated by default."""
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.parts.charm.legacy_hooks is False
+
+
+def test_charmpart_legacy_hooks_ok(create_config):
+    """The legacy hooks generation is configured."""
+    tmp_path = create_config(
+        """
+        type: charm
+        parts:
+            charm:
+                legacy-hooks: true
+    """
+    )
+    config = load(tmp_path)
+    assert config.parts.charm.legacy_hooks is True
+
+
+def test_charmpart_base_ok(create_config):
+    """The base where the charm will run is configured."""
+    tmp_path = create_config(
+        """
+        type: charm
+        parts:
+            charm:
+                base:
+                    name: ubuntu
+                    channel: "18.04"
+    """
+    )
+    config = load(tmp_path)
+    assert config.parts.charm.base.name == "ubuntu"
+    assert config.parts.charm.base.channel == "18.04"
+
+
+def test_schema_charmpart_base_unsupported(create_config, check_schema_error):
+    """Schema validation, the base must be a supported one."""
+    create_config(
+        """
+        type: charm
+        parts:
+            charm:
+                base:
+                    name: centos
+                    channel: "7"
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- unsupported base centos 7 (supported ones are: ubuntu 18.04, "
+            "ubuntu 20.04, ubuntu 22.04) in field 'parts.charm.base.channel'"
+        )
+    )
+
+
+# -- tests for the interfaces config
+
+
+def test_interfaces_catalog_default(create_config):
+    """The interfaces catalog is not mandatory."""
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.interfaces.catalog is None
+
+
+def test_interfaces_catalog_ok(create_config):
+    """The interfaces catalog is configured."""
+    tmp_path = create_config(
+        """
+        type: charm
+        interfaces:
+            catalog: ../interfaces
+    """
+    )
+    config = load(tmp_path)
+    assert config.interfaces.catalog == "../interfaces"
+
+
+def test_schema_interfaces_catalog_bad_type(create_config, check_schema_error):
+    """Schema validation, the interfaces catalog must be a string."""
+    create_config(
+        """
+        type: charm
+        interfaces:
+            catalog: 33
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- string type expected in field 'interfaces.catalog'"
+        )
+    )
+
+
+# -- tests for the audit config
+
+
+def test_audit_default(create_config):
+    """The audit is not configured by default."""
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.audit.database is None
+    assert config.audit.severity_threshold is None
+    assert config.audit.ignore == []
+
+
+def test_audit_ok(create_config):
+    """The audit is fully configured."""
+    tmp_path = create_config(
+        """
+        type: charm
+        audit:
+            database: osv/pypi
+            severity-threshold: high
+            ignore:
+                - GHSA-1234-abcd-5678
+                - CVE-2021-12345
+    """
+    )
+    config = load(tmp_path)
+    assert config.audit.database == "osv/pypi"
+    assert config.audit.severity_threshold == "high"
+    assert config.audit.ignore == ["GHSA-1234-abcd-5678", "CVE-2021-12345"]
+
+
+def test_schema_audit_bad_severity(create_config, check_schema_error):
+    """Schema validation, the severity threshold must be a known one."""
+    create_config(
+        """
+        type: charm
+        audit:
+            severity-threshold: terrible
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- must be one of unknown, low, medium, high, critical "
+            "in field 'audit.severity-threshold'"
+        )
+    )
+
+
+# -- tests for the release policy config
+
+
+def test_release_policy_default(create_config):
+    """No release policy is enforced by default."""
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.release_policy.require_lower_risk is False
+    assert config.release_policy.soak_hours is None
+    assert config.release_policy.confirm_stable is False
+    assert config.release_policy.restricted_tracks == []
+
+
+def test_release_policy_ok(create_config):
+    """The release policy is fully configured."""
+    tmp_path = create_config(
+        """
+        type: charm
+        release-policy:
+            require-lower-risk: true
+            soak-hours: 48
+            confirm-stable: true
+            restricted-tracks:
+                - "1.0"
+                - legacy
+    """
+    )
+    config = load(tmp_path)
+    assert config.release_policy.require_lower_risk is True
+    assert config.release_policy.soak_hours == 48
+    assert config.release_policy.confirm_stable is True
+    assert config.release_policy.restricted_tracks == ["1.0", "legacy"]
+
+
+def test_schema_release_policy_bad_soak_hours(create_config, check_schema_error):
+    """Schema validation, the soak time must be positive."""
+    create_config(
+        """
+        type: charm
+        release-policy:
+            soak-hours: 0
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- must be a positive number of hours "
+            "in field 'release-policy.soak-hours'"
+        )
+    )
+
+
+@pytest.fixture
+def create_user_config(user_config_dir):
+    """Helper to create the user's config."""
+
+    def create_user_config(text):
+        filepath = user_config_dir / "charmcraft" / "config.yaml"
+        filepath.parent.mkdir(parents=True, exist_ok=True)
+        filepath.write_text(dedent(text))
+        return filepath
+
+    return create_user_config
+
+
+def test_release_policy_from_user_config(create_config, create_user_config):
+    """The release policy is taken from the user's config."""
+    create_user_config(
+        """
+        release-policy:
+            soak-hours: 24
+            confirm-stable: true
+    """
+    )
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.release_policy.soak_hours == 24
+    assert config.release_policy.confirm_stable is True
+
+
+def test_release_policy_from_user_config_no_project_config(
+    tmp_path, create_user_config
+):
+    """The user's release policy is used even without charmcraft.yaml."""
+    create_user_config(
+        """
+        release-policy:
+            restricted-tracks: [legacy]
+    """
+    )
+    config = load(tmp_path)
+    assert config.release_policy.restricted_tracks == ["legacy"]
+
+
+def test_release_policy_project_precedence(create_config, create_user_config):
+    """The release policy in charmcraft.yaml takes precedence over the user's."""
+    create_user_config(
+        """
+        release-policy:
+            soak-hours: 24
+    """
+    )
+    tmp_path = create_config(
+        """
+        type: charm
+        release-policy:
+            confirm-stable: true
+    """
+    )
+    config = load(tmp_path)
+    assert config.release_policy.soak_hours is None
+    assert config.release_policy.confirm_stable is True
+
+
+def test_user_config_bad_content(tmp_path, create_user_config):
+    """The user's config is validated."""
+    filepath = create_user_config(
+        """
+        release-policy:
+            soak-hours: -3
+        other: stuff
+    """
+    )
+    with pytest.raises(CommandError) as cm:
+        load(tmp_path)
+    assert str(cm.value) == (
+        "Bad {} content:\n"
+        "- must be a positive number of hours in field 'release-policy.soak-hours'\n"
+        "- extra fields not permitted in field 'other'".format(filepath)
+    )
+
+
+def test_user_config_not_a_mapping(tmp_path, create_user_config):
+    """The user's config must be a mapping."""
+    filepath = create_user_config("- release-policy")
+    with pytest.raises(CommandError) as cm:
+        load(tmp_path)
+    assert str(cm.value) == "Bad {} content: it must be a mapping.".format(filepath)
+
+
+# -- tests for the git tagging config
+
+
+def test_git_tag_default(create_config):
+    """Nothing is tagged by default."""
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.git_tag.enabled is False
+    assert config.git_tag.template == "{name}/{channel}/r{revision}"
+    assert config.git_tag.push is False
+    assert config.git_tag.remote == "origin"
+
+
+def test_git_tag_ok(create_config):
+    """Configure the tagging when releasing."""
+    tmp_path = create_config(
+        """
+        type: charm
+        git-tag:
+            enabled: true
+            template: "{track}-{risk}-{version}"
+            push: true
+            remote: upstream
+    """
+    )
+    config = load(tmp_path)
+    assert config.git_tag.enabled is True
+    assert config.git_tag.template == "{track}-{risk}-{version}"
+    assert config.git_tag.push is True
+    assert config.git_tag.remote == "upstream"
+
+
+def test_schema_git_tag_bad_template(create_config, check_schema_error):
+    """Schema validation, the tag template can only use the known fields."""
+    create_config(
+        """
+        type: charm
+        git-tag:
+            template: "{name}-{commit}"
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- unknown field(s) in template: commit (valid ones are: channel, name, "
+            "revision, risk, track, version) in field 'git-tag.template'"
+        )
+    )
+
+
+# -- tests for the notifications config
+
+
+def test_notifications_default(create_config):
+    """Nothing is notified by default."""
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.notifications == []
+
+
+def test_notifications_ok(create_config):
+    """Notify with a webhook and a command."""
+    tmp_path = create_config(
+        """
+        type: charm
+        notifications:
+            - url: http://localhost:8000/hook
+            - command: ./deploy.sh --quick
+              events: [release]
+              timeout: 300
+    """
+    )
+    config = load(tmp_path)
+    webhook, command = config.notifications
+    assert webhook.url == "http://localhost:8000/hook"
+    assert webhook.command is None
+    assert webhook.events == ["release", "upload", "publish-lib"]
+    assert webhook.timeout == 30
+    assert command.url is None
+    assert command.command == "./deploy.sh --quick"
+    assert command.events == ["release"]
+    assert command.timeout == 300
+
+
+@pytest.mark.parametrize(
+    "notification",
+    [
+        "{events: [release]}",
+        "{url: 'https://example.com/hook', command: ./deploy.sh}",
+    ],
+)
+def test_schema_notifications_target(create_config, check_schema_error, notification):
+    """Schema validation, the notification needs either a URL or a command."""
+    create_config(
+        """
+        type: charm
+        notifications:
+            - {}
+    """.format(
+            notification
+        )
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- either this or 'url' must be indicated, but not both "
+            "in field 'notifications[0].command'"
+        )
+    )
+
+
+def test_schema_notifications_bad_url(create_config, check_schema_error):
+    """Schema validation, only the URL is reported if it's not valid."""
+    create_config(
+        """
+        type: charm
+        notifications:
+            - url: example.com/hook
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- invalid or missing URL scheme in field 'notifications[0].url'"
+        )
+    )
+
+
+@pytest.mark.parametrize(
+    "command, error",
+    [
+        (
+            "\"'./deploy.sh --all\"",
+            "cannot be parsed as a command: No closing quotation",
+        ),
+        ("' '", "must not be empty"),
+    ],
+)
+def test_schema_notifications_bad_command(
+    create_config, check_schema_error, command, error
+):
+    """Schema validation, the command must be split in its arguments."""
+    create_config(
+        """
+        type: charm
+        notifications:
+            - command: {}
+    """.format(
+            command
+        )
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- {} in field 'notifications[0].command'".format(error)
+        )
+    )
+
+
+def test_schema_notifications_bad_event(create_config, check_schema_error):
+    """Schema validation, the events must be known."""
+    create_config(
+        """
+        type: charm
+        notifications:
+            - url: https://example.com/hook
+              events: [release, deploy]
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- unknown event 'deploy' (must be one of: release, upload, publish-lib) "
+            "in field 'notifications[0].events'"
+        )
+    )
+
+
+def test_schema_notifications_bad_timeout(create_config, check_schema_error):
+    """Schema validation, the timeout must be positive."""
+    create_config(
+        """
+        type: charm
+        notifications:
+            - url: https://example.com/hook
+              timeout: 0
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- must be a positive number of seconds "
+            "in field 'notifications[0].timeout'"
+        )
+    )
+
+
+def test_compression_default(create_config):
+    """The packages are compressed with the default level, using all processors."""
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.compression.level == 6
+    assert config.compression.workers is None
+
+
+def test_compression_ok(create_config):
+    """The compression is configured."""
+    tmp_path = create_config(
+        """
+        type: charm
+        compression:
+            level: 9
+            workers: 2
+    """
+    )
+    config = load(tmp_path)
+    assert config.compression.level == 9
+    assert config.compression.workers == 2
+
+
+def test_schema_compression_bad_level(create_config, check_schema_error):
+    """Schema validation, the level must be one supported by zlib."""
+    create_config(
+        """
+        type: charm
+        compression:
+            level: 10
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- must be between 0 and 9 in field 'compression.level'"
+        )
+    )
+
+
+def test_schema_compression_bad_workers(create_config, check_schema_error):
+    """Schema validation, there must be at least one worker."""
+    create_config(
+        """
+        type: charm
+        compression:
+            workers: 0
+    """
+    )
+    check_schema_error(
+        (
+            "Bad charmcraft.yaml content:\n"
+            "- must be at least 1 in field 'compression.workers'"
+        )
+    )
+
+
+def test_charm_sections_default(create_config):
+    """The charm's metadata, actions and config are not declared by default."""
+    tmp_path = create_config(
+        """
+        type: charm
+    """
+    )
+    config = load(tmp_path)
+    assert config.metadata is None
+    assert config.actions is None
+    assert config.charm_config is None
+
+
+def test_charm_sections_ok(create_config):
+    """The charm's metadata, actions and config are declared in charmcraft.yaml."""
+    tmp_path = create_config(
+        """
+        type: charm
+        metadata:
+            name: test-charm
+            summary: A charm for tests.
+            requires:
+                db:
+                    interface: mysql
+                    limit: 1
+            provides:
+                website: http
+            storage:
+                data:
+                    type: filesystem
+        actions:
+            backup:
+                description: Back up the database.
+                params:
+                    target:
+                        type: string
+                required: [target]
+                additionalProperties: false
+        config:
+            options:
+                port:
+                    type: int
+                    description: The port to listen on.
+                    default: 8080
+                ratio:
+                    type: float
+                    default: 1
+    """
+    )
+    config = load(tmp_path)
+    metadata = config.metadata
+    assert metadata.name == "test-charm"
+    assert metadata.summary == "A charm for tests."
+    assert metadata.requires["db"].interface == "mysql"
+    assert metadata.requires["db"].limit == 1
+    assert metadata.provides["website"].interface == "http"
+    assert metadata.dict(by_alias=True, exclude_none=True)["storage"] == {
+        "data": {"type": "filesystem"}
+    }
+    backup = config.actions["backup"]
+    assert backup.description == "Back up the database."
+    assert backup.params == {"target": {"type": "string"}}
+    assert backup.required == ["target"]
+    assert backup.additional_properties is False
+    port = config.charm_config.options["port"]
+    assert (port.type, port.description, port.default) == (
+        "int",
+        "The port to listen on.",
+        8080,
+    )
+    assert config.charm_config.options["ratio"].default == 1
+
+
+def test_schema_metadata_missing_name(create_config, check_schema_error):
+    """Schema validation, the metadata must include the charm's name."""
+    create_config(
+        """
+        type: charm
+        metadata:
+            summary: A charm for tests.
+    """
+    )
+    check_schema_error(
+        "Bad charmcraft.yaml content:\n- field required in field 'metadata.name'"
+    )
+
+
+@pytest.mark.parametrize("name", ["TestCharm", "1charm", "test_charm", "charm-"])
+def test_schema_metadata_bad_name(create_config, check_schema_error, name):
+    """Schema validation, the charm's name must be valid for Juju."""
+    create_config(
+        """
+        type: charm
+        metadata:
+            name: {}
+    """.format(
+            name
+        )
+    )
+    check_schema_error(
+        "Bad charmcraft.yaml content:\n"
+        "- must use only lowercase letters, digits and hyphens, starting with a "
+        "letter in field 'metadata.name'"
+    )
+
+
+def test_schema_metadata_relation_missing_interface(
+    create_config, check_schema_error
+):
+    """Schema validation, the relations must indicate their interface."""
+    create_config(
+        """
+        type: charm
+        metadata:
+            name: test-charm
+            requires:
+                db:
+                    limit: 1
+    """
+    )
+    check_schema_error(
+        "Bad charmcraft.yaml content:\n"
+        "- field required in field 'metadata.requires.db.interface'"
+    )
+
+
+def test_schema_metadata_relation_bad_scope(create_config, check_schema_error):
+    """Schema validation, the relation's scope must be one supported by Juju."""
+    create_config(
+        """
+        type: charm
+        metadata:
+            name: test-charm
+            peers:
+                cluster:
+                    interface: cluster
+                    scope: local
+    """
+    )
+    check_schema_error(
+        "Bad charmcraft.yaml content:\n"
+        "- must be either 'global' or 'container' in field "
+        "'metadata.peers.cluster.scope'"
+    )
+
+
+@pytest.mark.parametrize("name", ["Backup", "back_up", "backup-", "2backup"])
+def test_schema_actions_bad_name(create_config, check_schema_error, name):
+    """Schema validation, the action names must be valid for Juju."""
+    create_config(
+        """
+        type: charm
+        actions:
+            {}:
+                description: Back up the database.
+    """.format(
+            name
+        )
+    )
+    check_schema_error(
+        "Bad charmcraft.yaml content:\n"
+        "- invalid action name {!r} (must use only lowercase letters and hyphens, "
+        "starting and ending with a letter) in field 'actions'".format(name)
+    )
+
+
+def test_schema_actions_extra_field(create_config, check_schema_error):
+    """Schema validation, the actions cannot have undefined properties."""
+    create_config(
+        """
+        type: charm
+        actions:
+            backup:
+                whatever: new-stuff
+    """
+    )
+    check_schema_error(
+        "Bad charmcraft.yaml content:\n"
+        "- extra fields not permitted in field 'actions.backup.whatever'"
+    )
+
+
+def test_schema_config_bad_type(create_config, check_schema_error):
+    """Schema validation, the option's type must be one supported by Juju."""
+    create_config(
+        """
+        type: charm
+        config:
+            options:
+                port:
+                    type: integer
+    """
+    )
+    check_schema_error(
+        "Bad charmcraft.yaml content:\n"
+        "- must be one of string, int, float, boolean, secret in field "
+        "'config.options.port.type'"
+    )
+
+
+@pytest.mark.parametrize(
+    "option_type, default",
+    [
+        ("int", "8080"),
+        ("int", True),
+        ("float", "0.5"),
+        ("boolean", 1),
+        ("string", 42),
+    ],
+)
+def test_schema_config_bad_default(
+    create_config, check_schema_error, option_type, default
+):
+    """Schema validation, the option's default must be of its type."""
+    create_config(
+        """
+        type: charm
+        config:
+            options:
+                port:
+                    type: {}
+                    default: {!r}
+    """.format(
+            option_type, default
+        )
+    )
+    check_schema_error(
+        "Bad charmcraft.yaml content:\n"
+        "- must be a value of type {!r} in field "
+        "'config.options.port.default'".format(option_type)
+    )
+
+
+@pytest.mark.parametrize(
+    "section, content",
+    [("metadata", "{name: test-charm}"), ("actions", "{}"), ("config", "{}")],
+)
+def test_schema_charm_sections_in_bundle(
+    create_config, check_schema_error, section, content
+):
+    """Schema validation, the charm's sections are not valid for bundles."""
+    create_config(
+        """
+        type: bundle
+        {}: {}
+    """.format(
+            section, content
+        )
+    )
+    check_schema_error(
+        "Bad charmcraft.yaml content:\n"
+        "- only valid for charms in field {!r}".format(section)
+    )
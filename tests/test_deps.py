@@ -0,0 +1,160 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the dependencies explanation (code in deps.py)."""
+
+from textwrap import dedent
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.deps import (
+    Package,
+    find_chains,
+    get_installed_packages,
+    get_tree,
+    parse_requirements,
+)
+from tests.factory import create_distribution
+
+
+@pytest.fixture
+def packages():
+    """Provide some installed packages, with their requirements."""
+    return {
+        "ops": Package("ops", "1.2.0", ["pyyaml"]),
+        "pyyaml": Package("pyyaml", "5.4.1", []),
+        "requests": Package("requests", "2.25.1", ["idna", "urllib3"]),
+        "idna": Package("idna", "2.10", []),
+        "urllib3": Package("urllib3", "1.26.4", ["idna"]),
+        "stray": Package("stray", "0.1", []),
+    }
+
+
+def test_parse_requirements(tmp_path):
+    """Get the project names from the different kinds of requirements."""
+    (tmp_path / "other.txt").write_text("Jinja2>=2.11\n")
+    requirements = tmp_path / "requirements.txt"
+    requirements.write_text(
+        dedent(
+            """\
+            # the framework
+            ops >= 1.2  # the comment
+            PyYAML==5.4.1; python_version >= "3.6"
+            requests[security] \\
+                ==2.25.1
+            -r other.txt
+            --requirement=other.txt
+            -c constraints.txt
+            --index-url https://pypi.example.com/simple
+            git+https://github.com/canonical/charm-lib.git#egg=charm_lib
+            https://example.com/package.tar.gz
+            -e git+https://github.com/canonical/other.git#egg=Other.Lib
+            mylib @ https://example.com/mylib-1.0.tar.gz
+            ./local/path
+            """
+        )
+    )
+    assert parse_requirements(requirements) == [
+        "ops",
+        "pyyaml",
+        "requests",
+        "jinja2",
+        "jinja2",
+        "charm-lib",
+        "other-lib",
+        "mylib",
+    ]
+
+
+def test_parse_requirements_missing_include(tmp_path):
+    """An included requirements file is missing."""
+    requirements = tmp_path / "requirements.txt"
+    requirements.write_text("-r missing.txt\n")
+    with pytest.raises(CommandError) as cm:
+        parse_requirements(requirements)
+    assert str(cm.value) == (
+        "Cannot read the requirements file {!r}: No such file or directory".format(
+            str(tmp_path / "missing.txt")
+        )
+    )
+
+
+def test_get_installed_packages(tmp_path):
+    """Get the installed packages with their installed requirements."""
+    create_distribution(tmp_path, "ops", "1.2.0", requires=["PyYAML"])
+    create_distribution(tmp_path, "PyYAML", "5.4.1")
+    create_distribution(
+        tmp_path,
+        "requests",
+        "2.25.1",
+        requires=[
+            "urllib3 (<1.27,>=1.21.1)",
+            "idna (<3,>=2.5)",
+            'PySocks (!=1.5.7,>=1.5.6) ; extra == "socks"',
+        ],
+    )
+    create_distribution(tmp_path, "idna", "2.10")
+    create_distribution(tmp_path, "urllib3", "1.26.4", requires=["idna ; extra == 'x'"])
+
+    assert get_installed_packages(tmp_path) == {
+        "ops": Package("ops", "1.2.0", ["pyyaml"]),
+        "pyyaml": Package("pyyaml", "5.4.1", []),
+        "requests": Package("requests", "2.25.1", ["idna", "urllib3"]),
+        "idna": Package("idna", "2.10", []),
+        "urllib3": Package("urllib3", "1.26.4", ["idna"]),
+    }
+
+
+def test_get_tree(packages):
+    """Build the tree from the direct requirements."""
+    tree = [
+        (depth, package.name, is_direct, repeated)
+        for depth, package, is_direct, repeated in get_tree(
+            {"requests", "ops", "pyyaml", "missing"}, packages
+        )
+    ]
+    assert tree == [
+        (0, "ops", True, False),
+        (1, "pyyaml", True, False),
+        (0, "pyyaml", True, True),
+        (0, "requests", True, False),
+        (1, "idna", False, False),
+        (1, "urllib3", False, False),
+        (2, "idna", False, True),
+        (0, "stray", False, False),
+    ]
+
+
+def test_get_tree_cycle():
+    """Cycles in the requirements are cut."""
+    packages = {
+        "one": Package("one", "1", ["two"]),
+        "two": Package("two", "2", ["one"]),
+    }
+    tree = [(depth, package.name) for depth, package, _, _ in get_tree({"one"}, packages)]
+    assert tree == [(0, "one"), (1, "two"), (2, "one")]
+
+
+def test_find_chains(packages):
+    """Find all the chains from the direct requirements to a package."""
+    direct = {"ops", "pyyaml", "requests"}
+    assert find_chains("idna", direct, packages) == [
+        ["requests", "idna"],
+        ["requests", "urllib3", "idna"],
+    ]
+    assert find_chains("pyyaml", direct, packages) == [["ops", "pyyaml"], ["pyyaml"]]
+    assert find_chains("stray", direct, packages) == []
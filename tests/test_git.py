@@ -0,0 +1,395 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the git helpers (code in git.py)."""
+
+from unittest.mock import patch
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.git import (
+    build_version,
+    create_tag,
+    export_tree,
+    get_changed_files,
+    get_commit_from_version,
+    get_log,
+    get_version_info,
+    has_commit,
+    has_head,
+    has_tag,
+    is_dirty,
+    is_repository,
+    is_valid_tag_name,
+    push_tags,
+    run_git,
+    validate_tag_template,
+    validate_version_template,
+)
+from tests.factory import git
+
+
+# -- tests for running git
+
+
+def test_run_git_ok(git_repo):
+    """Get the output of the git command."""
+    assert run_git(git_repo, "log", "--format=%s") == "Initial commit"
+
+
+def test_run_git_failure(git_repo):
+    """The git command failed."""
+    with pytest.raises(CommandError) as cm:
+        run_git(git_repo, "rev-parse", "not-a-reference")
+    assert str(cm.value).startswith(
+        "Git command ['rev-parse', 'not-a-reference'] failed: "
+    )
+
+
+def test_run_git_missing_executable(tmp_path):
+    """The git executable is not installed."""
+    with patch("subprocess.run", side_effect=FileNotFoundError()):
+        with pytest.raises(CommandError) as cm:
+            run_git(tmp_path, "status")
+    assert str(cm.value) == "Cannot run git: the 'git' executable was not found."
+
+
+def test_is_repository_yes(git_repo):
+    """The directory is in a git repository."""
+    subdir = git_repo / "subdir"
+    subdir.mkdir()
+    assert is_repository(git_repo)
+    assert is_repository(subdir)
+
+
+def test_is_repository_no(tmp_path):
+    """The directory is not in a git repository."""
+    assert not is_repository(tmp_path)
+
+
+def test_has_head(git_repo):
+    """The repository has a current commit, unless nothing was committed yet."""
+    assert has_head(git_repo)
+    empty = git_repo / "empty"
+    git(git_repo, "init", "--quiet", str(empty))
+    assert not has_head(empty)
+
+
+def test_is_dirty(git_repo):
+    """Only changes in tracked files make the tree dirty."""
+    assert not is_dirty(git_repo)
+    (git_repo / "untracked").write_text("stuff")
+    assert not is_dirty(git_repo)
+    (git_repo / "somefile").write_text("changed content")
+    assert is_dirty(git_repo)
+
+
+def test_is_dirty_untracked(git_repo):
+    """Untracked files make the tree dirty only if indicated, but never ignored ones."""
+    (git_repo / ".gitignore").write_text("*.log\n")
+    git(git_repo, "add", ".gitignore")
+    git(git_repo, "commit", "--quiet", "-m", "Ignore logs")
+    (git_repo / "debug.log").write_text("stuff")
+    assert not is_dirty(git_repo, untracked=True)
+    (git_repo / "untracked").write_text("stuff")
+    assert not is_dirty(git_repo)
+    assert is_dirty(git_repo, untracked=True)
+
+
+def test_is_dirty_only_in_directory(git_repo):
+    """Changes outside the indicated directory are not considered."""
+    subdir = git_repo / "subdir"
+    subdir.mkdir()
+    (subdir / "otherfile").write_text("content")
+    git(git_repo, "add", "subdir")
+    git(git_repo, "commit", "--quiet", "-m", "Add subdir")
+    (git_repo / "somefile").write_text("changed content")
+    assert not is_dirty(subdir)
+    (subdir / "otherfile").write_text("changed content")
+    assert is_dirty(subdir)
+
+
+def test_get_version_info(git_repo):
+    """Collect all the information from the repository."""
+    commit = run_git(git_repo, "rev-parse", "HEAD")
+    info = get_version_info(git_repo)
+    assert info.commit == commit
+    assert info.short_commit == commit[:7]
+    assert info.describe == run_git(git_repo, "rev-parse", "--short", "HEAD")
+    assert info.branch == "main"
+    assert info.dirty is False
+
+
+def test_get_version_info_ref(git_repo):
+    """Collect the information for a ref, which is never dirty."""
+    git(git_repo, "tag", "v1.0")
+    commit = run_git(git_repo, "rev-parse", "HEAD")
+    (git_repo / "somefile").write_text("changed content")
+    git(git_repo, "commit", "--quiet", "-am", "Second commit")
+    (git_repo / "somefile").write_text("even more changes")
+
+    info = get_version_info(git_repo, "v1.0")
+    assert info.commit == commit
+    assert info.describe == "v1.0-0-g{}".format(commit[:7])
+    assert info.branch == "v1.0"
+    assert info.dirty is False
+
+
+def test_get_version_info_ref_commit(git_repo):
+    """A commit hash has no short name, it is used as the branch."""
+    commit = run_git(git_repo, "rev-parse", "HEAD")
+    info = get_version_info(git_repo, commit[:10])
+    assert info.commit == commit
+    assert info.branch == commit[:10]
+
+
+# -- tests for the version building
+
+
+def test_build_version_describe_tag(git_repo):
+    """Without a template the git description is used."""
+    git(git_repo, "tag", "v1.0")
+    commit = run_git(git_repo, "rev-parse", "--short", "HEAD")
+    assert build_version(git_repo) == "v1.0-0-g{}".format(commit)
+
+
+def test_build_version_describe_dirty(git_repo):
+    """The dirty marker is included if the tree has changes."""
+    (git_repo / "somefile").write_text("changed content")
+    commit = run_git(git_repo, "rev-parse", "--short", "HEAD")
+    assert build_version(git_repo) == "{}-dirty".format(commit)
+
+
+def test_build_version_template(git_repo):
+    """Use the template to build the version."""
+    commit = run_git(git_repo, "rev-parse", "HEAD")
+    version = build_version(git_repo, "{branch}+{short_commit}{dirty}")
+    assert version == "main+{}".format(commit[:7])
+
+
+def test_build_version_template_dirty_included(git_repo):
+    """The template decides where the dirty marker goes."""
+    (git_repo / "somefile").write_text("changed content")
+    version = build_version(git_repo, "{branch}{dirty}/{commit}")
+    assert version == "main-dirty/{}".format(run_git(git_repo, "rev-parse", "HEAD"))
+
+
+def test_build_version_template_dirty_forced(git_repo):
+    """The dirty marker is added even if the template does not include it."""
+    (git_repo / "somefile").write_text("changed content")
+    assert build_version(git_repo, "{branch}") == "main-dirty"
+
+
+def test_build_version_ref(git_repo):
+    """The version for a ref does not depend on the working tree."""
+    (git_repo / "somefile").write_text("changed content")
+    commit = run_git(git_repo, "rev-parse", "--short", "HEAD")
+    assert build_version(git_repo, ref="HEAD") == commit
+    assert build_version(git_repo, "{branch}", ref="main") == "main"
+
+
+@pytest.mark.parametrize(
+    "template, problem",
+    [
+        ("{describe}", None),
+        ("{branch}-{commit}-{short_commit}{dirty}", None),
+        ("fixed", None),
+        (
+            "{foo}-{bar}",
+            "unknown field(s) in template: bar, foo (valid ones are: branch, commit, "
+            "describe, dirty, short_commit)",
+        ),
+        ("{broken", "invalid template (expected '}' before end of string)"),
+    ],
+)
+def test_validate_version_template(template, problem):
+    """Check the templates are validated."""
+    assert validate_version_template(template) == problem
+
+
+@pytest.mark.parametrize(
+    "template, problem",
+    [
+        ("{name}/{channel}/r{revision}", None),
+        ("release-{track}-{risk}-{version}", None),
+        (
+            "{name}-{commit}",
+            "unknown field(s) in template: commit (valid ones are: channel, name, "
+            "revision, risk, track, version)",
+        ),
+        ("{broken", "invalid template (expected '}' before end of string)"),
+    ],
+)
+def test_validate_tag_template(template, problem):
+    """Check the tag templates are validated."""
+    assert validate_tag_template(template) == problem
+
+
+@pytest.mark.parametrize(
+    "version, expected",
+    [
+        ("v1.0-3-gabc1234", ("abc1234", False)),
+        ("v1.0-3-gabc1234-dirty", ("abc1234", True)),
+        ("abc1234", ("abc1234", False)),
+        ("abc1234-dirty", ("abc1234", True)),
+        ("v1.0-0-g1234567", ("1234567", False)),
+        ("20210401", (None, False)),
+        ("1.2.3", (None, False)),
+        ("", (None, False)),
+        (None, (None, False)),
+    ],
+)
+def test_get_commit_from_version(version, expected):
+    """Extract the commit from different versions."""
+    assert get_commit_from_version(version) == expected
+
+
+# -- tests for the history helpers
+
+
+def test_has_commit(git_repo):
+    """Check if commits are present in the repository."""
+    commit = run_git(git_repo, "rev-parse", "HEAD")
+    assert has_commit(git_repo, commit)
+    assert has_commit(git_repo, commit[:7])
+    assert not has_commit(git_repo, "1234567")
+
+
+# -- tests for the tags helpers
+
+
+def test_is_valid_tag_name(git_repo):
+    """Check the names that can be used for tags."""
+    assert is_valid_tag_name(git_repo, "mycharm/stable/r42")
+    assert is_valid_tag_name(git_repo, "mycharm/2.0/edge/r3")
+    assert not is_valid_tag_name(git_repo, "my charm/stable")
+    assert not is_valid_tag_name(git_repo, "mycharm//r42")
+    assert not is_valid_tag_name(git_repo, "mycharm..r42")
+
+
+def test_create_tag(git_repo):
+    """Create an annotated tag on the commit."""
+    commit = run_git(git_repo, "rev-parse", "HEAD")
+    assert not has_tag(git_repo, "mycharm/stable/r42")
+
+    create_tag(git_repo, "mycharm/stable/r42", commit[:7], "The message.")
+
+    assert has_tag(git_repo, "mycharm/stable/r42")
+    assert run_git(git_repo, "cat-file", "-t", "mycharm/stable/r42") == "tag"
+    assert run_git(git_repo, "rev-parse", "mycharm/stable/r42^{commit}") == commit
+    message = run_git(git_repo, "tag", "-l", "--format=%(contents)", "mycharm/*")
+    assert message == "The message."
+
+
+def test_create_tag_existing(git_repo):
+    """The tags are never moved."""
+    commit = run_git(git_repo, "rev-parse", "HEAD")
+    create_tag(git_repo, "mycharm/stable/r42", commit, "The message.")
+    with pytest.raises(CommandError) as cm:
+        create_tag(git_repo, "mycharm/stable/r42", commit, "Other message.")
+    assert "already exists" in str(cm.value)
+
+
+def test_push_tags(git_repo):
+    """Push the tags to the remote."""
+    remote = git_repo / "remote.git"
+    git(git_repo, "init", "--quiet", "--bare", str(remote))
+    git(git_repo, "remote", "add", "origin", str(remote))
+    commit = run_git(git_repo, "rev-parse", "HEAD")
+    create_tag(git_repo, "mycharm/stable/r42", commit, "The message.")
+    create_tag(git_repo, "mycharm/edge/r42", commit, "The message.")
+
+    push_tags(git_repo, "origin", ["mycharm/stable/r42", "mycharm/edge/r42"])
+
+    assert has_tag(remote, "mycharm/stable/r42")
+    assert has_tag(remote, "mycharm/edge/r42")
+
+
+def test_push_tags_bad_remote(git_repo):
+    """The push problems are raised."""
+    with pytest.raises(CommandError):
+        push_tags(git_repo, "missing", ["mycharm/stable/r42"])
+
+
+def test_get_log_and_changed_files(git_repo):
+    """Get the commits and changed files between two commits."""
+    start = run_git(git_repo, "rev-parse", "HEAD")
+    (git_repo / "somefile").write_text("changed content")
+    (git_repo / "newfile").write_text("new content")
+    git(git_repo, "add", "somefile", "newfile")
+    git(git_repo, "commit", "--quiet", "-m", "Second commit")
+    git(git_repo, "rm", "--quiet", "somefile")
+    git(git_repo, "commit", "--quiet", "-m", "Third commit")
+    end = run_git(git_repo, "rev-parse", "HEAD")
+
+    log = get_log(git_repo, start, end)
+    assert [line.split(" ", 1)[1] for line in log] == ["Third commit", "Second commit"]
+    assert get_changed_files(git_repo, start, end) == [
+        ("A", "newfile"),
+        ("D", "somefile"),
+    ]
+
+
+def test_get_changed_files_renamed(git_repo):
+    """Renamed files show both paths."""
+    start = run_git(git_repo, "rev-parse", "HEAD")
+    git(git_repo, "mv", "somefile", "otherfile")
+    git(git_repo, "commit", "--quiet", "-m", "Rename")
+
+    changed = get_changed_files(git_repo, start, "HEAD")
+    assert changed == [("R", "somefile -> otherfile")]
+
+
+# -- tests for the tree exporting
+
+
+def test_export_tree_committed_only(git_repo, tmp_path):
+    """Only what is committed in the ref is exported."""
+    (git_repo / "somefile").write_text("changed content")
+    (git_repo / "untracked").write_text("stuff")
+    destdir = tmp_path / "export"
+    destdir.mkdir()
+
+    commit = export_tree(git_repo, "HEAD", destdir)
+    assert commit == run_git(git_repo, "rev-parse", "HEAD")
+    assert sorted(p.name for p in destdir.iterdir()) == ["somefile"]
+    assert (destdir / "somefile").read_text() == "content"
+
+
+def test_export_tree_subdirectory(git_repo, tmp_path):
+    """Only the indicated directory is exported, at the root of the destination."""
+    subdir = git_repo / "charms" / "mycharm"
+    subdir.mkdir(parents=True)
+    (subdir / "charm.py").write_text("code")
+    (subdir / "charm.py").chmod(0o755)
+    git(git_repo, "add", "charms")
+    git(git_repo, "commit", "--quiet", "-m", "Add charm")
+    destdir = tmp_path / "export"
+    destdir.mkdir()
+
+    export_tree(subdir, "main", destdir)
+    assert sorted(p.name for p in destdir.iterdir()) == ["charm.py"]
+    assert (destdir / "charm.py").stat().st_mode & 0o111
+
+
+def test_export_tree_bad_ref(git_repo, tmp_path):
+    """The ref does not exist in the repository."""
+    destdir = tmp_path / "export"
+    destdir.mkdir()
+    with pytest.raises(CommandError) as cm:
+        export_tree(git_repo, "not-a-ref", destdir)
+    assert "not-a-ref" in str(cm.value)
@@ -0,0 +1,212 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the integrations between charms (code in integrations.py)."""
+
+import json
+from textwrap import dedent
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.integrations import (
+    Charm,
+    Integration,
+    find_charms,
+    find_integrations,
+    find_unmatched,
+    get_matrix,
+    render_dot,
+    render_json,
+)
+
+
+def _create_charm(basedir, relpath, content, filename="metadata.yaml"):
+    """Create a charm project with the indicated metadata."""
+    dirpath = basedir / relpath
+    dirpath.mkdir(parents=True, exist_ok=True)
+    (dirpath / filename).write_text(dedent(content))
+    return dirpath
+
+
+@pytest.fixture
+def charms():
+    """Provide some charms that can be related in different ways."""
+    return [
+        Charm("db", "charms/db", {"database": "mysql", "info": "http"}, {}),
+        Charm("proxy", "charms/proxy", {"web": "http"}, {"backend": "http"}),
+        Charm("web", "charms/web", {}, {"db": "mysql", "logs": "syslog"}),
+    ]
+
+
+# -- tests for finding the charms
+
+
+def test_find_charms(tmp_path):
+    """Find the charms in the tree, with their endpoints."""
+    _create_charm(
+        tmp_path,
+        "charms/web",
+        """
+        name: web
+        provides:
+          website:
+            interface: http
+        requires:
+          db: mysql
+          broken: {}
+        """,
+    )
+    _create_charm(
+        tmp_path,
+        "db",
+        """
+        type: charm
+        metadata:
+          name: db
+          provides:
+            database:
+              interface: mysql
+        """,
+        filename="charmcraft.yaml",
+    )
+
+    charms = find_charms(tmp_path)
+    assert charms == [
+        Charm("db", "db", {"database": "mysql"}, {}),
+        Charm("web", "charms/web", {"website": "http"}, {"db": "mysql"}),
+    ]
+
+
+def test_find_charms_skipped(tmp_path):
+    """Bundles, hidden and build directories, and charms inside charms are ignored."""
+    _create_charm(tmp_path, "charm", "name: main")
+    _create_charm(tmp_path, "charm/tests/charm", "name: inner")
+    _create_charm(tmp_path, ".tox/charm", "name: hidden")
+    _create_charm(tmp_path, "build/charm", "name: built")
+    _create_charm(tmp_path, "bundle", "type: bundle", filename="charmcraft.yaml")
+    (tmp_path / "bundle" / "metadata.yaml").write_text("name: strange")
+
+    charms = find_charms(tmp_path)
+    assert [charm.name for charm in charms] == ["main"]
+
+
+def test_find_charms_none(tmp_path):
+    """No charms in the tree."""
+    assert find_charms(tmp_path) == []
+
+
+def test_find_charms_without_name(tmp_path):
+    """A charm must have a name."""
+    _create_charm(tmp_path, "charms/web", "summary: no name")
+    with pytest.raises(CommandError) as cm:
+        find_charms(tmp_path)
+    assert str(cm.value) == "Cannot find the name of the charm in 'charms/web'."
+
+
+def test_find_charms_repeated_name(tmp_path):
+    """Two charms cannot have the same name."""
+    _create_charm(tmp_path, "one", "name: web")
+    _create_charm(tmp_path, "two", "name: web")
+    with pytest.raises(CommandError) as cm:
+        find_charms(tmp_path)
+    assert str(cm.value) == (
+        "The charm name 'web' is used in more than one directory: 'one' and 'two'."
+    )
+
+
+# -- tests for matching the charms
+
+
+def test_find_integrations(charms):
+    """Relate the required endpoints with the provided ones of the same interface."""
+    assert find_integrations(charms) == [
+        Integration("proxy", "backend", "db", "info", "http"),
+        Integration("proxy", "backend", "proxy", "web", "http"),
+        Integration("web", "db", "db", "database", "mysql"),
+    ]
+
+
+def test_find_unmatched(charms):
+    """Find the interfaces without a counterpart."""
+    charms.append(Charm("cache", "cache", {"cache": "redis"}, {"logs": "syslog"}))
+    only_provided, only_required = find_unmatched(charms)
+    assert only_provided == {"redis": ["cache:cache"]}
+    assert only_required == {"syslog": ["cache:logs", "web:logs"]}
+
+
+def test_find_unmatched_none(charms):
+    """All the interfaces are matched."""
+    charms.append(Charm("syslog", "syslog", {"logs": "syslog"}, {}))
+    assert find_unmatched(charms) == ({}, {})
+
+
+def test_matrix(charms):
+    """Build the matrix of requirers and providers."""
+    matrix = get_matrix(charms, find_integrations(charms))
+    assert matrix == [
+        [[], [], []],
+        [["http"], ["http"], []],
+        [["mysql"], [], []],
+    ]
+
+
+# -- tests for rendering the result
+
+
+def test_render_json(charms):
+    """Render the charms and their integrations as JSON."""
+    only_provided, only_required = find_unmatched(charms)
+    content = json.loads(
+        render_json(charms, find_integrations(charms), only_provided, only_required)
+    )
+    assert content["charms"]["web"] == {
+        "path": "charms/web",
+        "provides": {},
+        "requires": {"db": "mysql", "logs": "syslog"},
+    }
+    assert content["integrations"][-1] == {
+        "requirer": "web:db",
+        "provider": "db:database",
+        "interface": "mysql",
+    }
+    assert content["only-provided"] == {}
+    assert content["only-required"] == {"syslog": ["web:logs"]}
+
+
+def test_render_dot(charms):
+    """Render the charms and their integrations as a DOT graph."""
+    charms.append(Charm("cache", "cache", {"cache": "redis"}, {}))
+    only_provided, only_required = find_unmatched(charms)
+    content = render_dot(
+        charms, find_integrations(charms), only_provided, only_required
+    )
+    assert content.splitlines() == [
+        "digraph integrations {",
+        "    rankdir=LR;",
+        '    "db";',
+        '    "proxy";',
+        '    "web";',
+        '    "cache";',
+        '    "proxy" -> "db" [label="http (backend -> info)"];',
+        '    "proxy" -> "proxy" [label="http (backend -> web)"];',
+        '    "web" -> "db" [label="mysql (db -> database)"];',
+        '    "interface:redis" [label="redis", shape=box, style=dashed, color=red];',
+        '    "interface:redis" -> "cache" [label="cache", style=dashed, color=red];',
+        '    "interface:syslog" [label="syslog", shape=box, style=dashed, color=red];',
+        '    "web" -> "interface:syslog" [label="logs", style=dashed, color=red];',
+        "}",
+    ]
@@ -0,0 +1,250 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the relation interfaces catalog (code in interfaces.py)."""
+
+import json
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.config import CharmMetadata, InterfacesConfig
+from charmcraft.interfaces import (
+    InterfacesCatalog,
+    Relation,
+    check_interfaces,
+    get_relations,
+    load_catalog,
+)
+
+MYSQL_PROVIDER_SCHEMA = {
+    "type": "object",
+    "properties": {"host": {"type": "string"}, "port": {"type": "integer"}},
+    "required": ["host"],
+}
+
+
+@pytest.fixture
+def catalog_dir(tmp_path):
+    """Provide a catalog with some interfaces."""
+    basedir = tmp_path / "catalog"
+
+    def add(name, version=None, **schemas):
+        interface_dir = basedir / "interfaces" / name
+        interface_dir.mkdir(parents=True, exist_ok=True)
+        if version is not None:
+            version_dir = interface_dir / "v{}".format(version)
+            version_dir.mkdir()
+            for role, schema in schemas.items():
+                (version_dir / (role + ".json")).write_text(json.dumps(schema))
+
+    add("mysql", 0, provider={"type": "object"})
+    add("mysql", 1, provider=MYSQL_PROVIDER_SCHEMA, requirer={"type": "object"})
+    add("http", 0)
+    add("ingress")
+    return basedir
+
+
+def test_catalog_load(catalog_dir):
+    """Load all the interfaces, with their versions."""
+    catalog = InterfacesCatalog(catalog_dir)
+    assert catalog.names == ["http", "ingress", "mysql"]
+    assert "mysql" in catalog
+    assert "postgresql" not in catalog
+    assert catalog.get_versions("mysql") == [0, 1]
+    assert catalog.get_versions("http") == [0]
+    assert catalog.get_versions("ingress") == []
+
+
+def test_catalog_load_without_interfaces_dir(catalog_dir):
+    """The interfaces may be directly in the catalog's directory."""
+    catalog = InterfacesCatalog(catalog_dir / "interfaces")
+    assert catalog.names == ["http", "ingress", "mysql"]
+
+
+def test_catalog_ignores_other_files(catalog_dir):
+    """Only directories are interfaces and versions."""
+    interfaces_dir = catalog_dir / "interfaces"
+    (interfaces_dir / "README.md").write_text("the catalog")
+    (interfaces_dir / ".git").mkdir()
+    (interfaces_dir / "http" / "README.md").write_text("the interface")
+    (interfaces_dir / "http" / "docs").mkdir()
+
+    catalog = InterfacesCatalog(catalog_dir)
+    assert catalog.names == ["http", "ingress", "mysql"]
+    assert catalog.get_versions("http") == [0]
+
+
+def test_catalog_missing_directory(tmp_path):
+    """The catalog directory does not exist."""
+    with pytest.raises(CommandError) as cm:
+        InterfacesCatalog(tmp_path / "missing")
+    assert str(cm.value) == (
+        "Cannot use the interfaces catalog: {!r} is not a directory.".format(
+            str(tmp_path / "missing")
+        )
+    )
+
+
+def test_catalog_invalid_json(catalog_dir):
+    """A schema in the catalog is not valid JSON."""
+    schema_path = catalog_dir / "interfaces" / "http" / "v0" / "provider.json"
+    schema_path.write_text("{not json")
+    with pytest.raises(CommandError) as cm:
+        InterfacesCatalog(catalog_dir)
+    assert str(cm.value).startswith(
+        "Invalid schema in the interfaces catalog {!r}: ".format(str(schema_path))
+    )
+
+
+def test_catalog_invalid_schema(catalog_dir):
+    """A schema in the catalog is not a valid JSON schema."""
+    schema_path = catalog_dir / "interfaces" / "http" / "v0" / "provider.json"
+    schema_path.write_text("[]")
+    with pytest.raises(CommandError) as cm:
+        InterfacesCatalog(catalog_dir)
+    assert str(cm.value).startswith(
+        "Invalid schema in the interfaces catalog {!r}: ".format(str(schema_path))
+    )
+
+
+def test_catalog_get_schema(catalog_dir):
+    """Get the schemas, by default from the latest version."""
+    catalog = InterfacesCatalog(catalog_dir)
+    assert catalog.get_schema("mysql", "provider") == MYSQL_PROVIDER_SCHEMA
+    assert catalog.get_schema("mysql", "provider", 0) == {"type": "object"}
+    assert catalog.get_schema("mysql", "requirer", 0) is None
+    assert catalog.get_schema("http", "provider") is None
+    assert catalog.get_schema("ingress", "provider") is None
+
+
+def test_catalog_get_schema_unknown(catalog_dir):
+    """The interface or version are not in the catalog."""
+    catalog = InterfacesCatalog(catalog_dir)
+    with pytest.raises(CommandError) as cm:
+        catalog.get_schema("postgresql", "provider")
+    assert str(cm.value) == "Interface 'postgresql' not found in the catalog."
+
+    with pytest.raises(CommandError) as cm:
+        catalog.get_schema("mysql", "provider", 7)
+    assert str(cm.value) == "Version 7 of interface 'mysql' not found in the catalog."
+
+
+def test_catalog_get_validator(catalog_dir):
+    """Get a validator for the relation data."""
+    catalog = InterfacesCatalog(catalog_dir)
+    validator = catalog.get_validator("mysql", "provider")
+    assert validator.is_valid({"host": "10.0.0.1", "port": 3306})
+    assert not validator.is_valid({"port": 3306})
+    assert not validator.is_valid({"host": "10.0.0.1", "port": "3306"})
+    assert catalog.get_validator("http", "provider") is None
+
+
+def test_catalog_suggest(catalog_dir):
+    """Suggest the closest interface name."""
+    catalog = InterfacesCatalog(catalog_dir)
+    assert catalog.suggest("mysq") == "mysql"
+    assert catalog.suggest("ingres") == "ingress"
+    assert catalog.suggest("kafka") is None
+
+
+# -- tests for the charm's relations
+
+
+def test_get_relations(tmp_path, config):
+    """Get the relations from the metadata."""
+    (tmp_path / "metadata.yaml").write_text(
+        """
+        name: test-charm
+        provides:
+          website:
+            interface: http
+        requires:
+          db:
+            interface: mysql
+          broken: {}
+        peers:
+          cluster:
+            interface: test-cluster
+        """
+    )
+    assert get_relations(config, tmp_path) == [
+        Relation("provides", "website", "http"),
+        Relation("requires", "db", "mysql"),
+        Relation("requires", "broken", None),
+    ]
+
+
+def test_get_relations_from_config(tmp_path, config):
+    """Get the relations from the metadata in charmcraft.yaml, if there."""
+    (tmp_path / "metadata.yaml").write_text(
+        "name: test-charm\nrequires:\n  db:\n    interface: mysql\n"
+    )
+    metadata = CharmMetadata(
+        name="test-charm", provides={"website": {"interface": "http"}}
+    )
+    config.set(metadata=metadata)
+    assert get_relations(config, tmp_path) == [Relation("provides", "website", "http")]
+
+
+def test_get_relations_no_metadata(tmp_path, config):
+    """There are no relations without metadata (e.g. a bundle)."""
+    assert get_relations(config, tmp_path) == []
+
+
+def test_check_interfaces(tmp_path, catalog_dir, config):
+    """Report the interfaces not in the catalog."""
+    (tmp_path / "metadata.yaml").write_text(
+        """
+        name: test-charm
+        provides:
+          website:
+            interface: http
+        requires:
+          db:
+            interface: mysq
+          queue:
+            interface: kafka
+          broken: {}
+        """
+    )
+    catalog = InterfacesCatalog(catalog_dir)
+    assert check_interfaces(config, tmp_path, catalog) == [
+        "unknown interface 'mysq' in the requires relation 'db' (did you mean "
+        "'mysql'?)",
+        "unknown interface 'kafka' in the requires relation 'queue'",
+        "the requires relation 'broken' does not declare its interface",
+    ]
+
+
+def test_load_catalog_none(config):
+    """No catalog indicated nor configured."""
+    assert load_catalog(config) is None
+
+
+def test_load_catalog_from_config(config, catalog_dir):
+    """Use the catalog configured relative to the project."""
+    config.set(interfaces=InterfacesConfig(catalog="catalog"))
+    catalog = load_catalog(config)
+    assert catalog.dirpath == config.project.dirpath / "catalog"
+    assert catalog.names == ["http", "ingress", "mysql"]
+
+
+def test_load_catalog_indicated(config, catalog_dir):
+    """The indicated catalog has priority over the configured one."""
+    config.set(interfaces=InterfacesConfig(catalog="other"))
+    catalog = load_catalog(config, catalog_dir / "interfaces")
+    assert catalog.dirpath == catalog_dir / "interfaces"
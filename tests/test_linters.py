@@ -0,0 +1,411 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the checks on charms and bundles (code in linters.py)."""
+
+import subprocess
+from textwrap import dedent
+from unittest.mock import patch
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.config import CharmMetadata
+from charmcraft.interfaces import InterfacesCatalog
+from charmcraft.linters import (
+    ICON_MAX_SIZE,
+    CheckResult,
+    analyze,
+    check_containers,
+    check_icon,
+    render_icon,
+    validate_icon,
+)
+
+GOOD_ICON = """<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"
+     width="100" height="100" viewBox="0 0 100 100">
+  <defs>
+    <linearGradient id="grad"><stop offset="0" stop-color="#e95420"/></linearGradient>
+  </defs>
+  <circle cx="50" cy="50" r="45" fill="url(#grad)"/>
+  <use xlink:href="#grad"/>
+  <image href="data:image/png;base64,iVBORw0KGgo="/>
+</svg>
+"""
+
+
+def _svg(content, viewbox="0 0 100 100"):
+    """Build an icon with the given content."""
+    return (
+        '<svg xmlns="http://www.w3.org/2000/svg" '
+        'xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="{}">{}</svg>'.format(
+            viewbox, content
+        )
+    )
+
+
+def test_validate_icon_ok(tmp_path):
+    """A good icon has no problems."""
+    icon = tmp_path / "icon.svg"
+    icon.write_text(GOOD_ICON)
+    assert validate_icon(icon) == []
+
+
+@pytest.mark.parametrize(
+    "content, problem",
+    [
+        (
+            "<svg",
+            "it is not well-formed XML (unclosed token: line 1, column 0)",
+        ),
+        (
+            '<html xmlns="http://www.w3.org/1999/xhtml"/>',
+            "the root element must be 'svg' in the 'http://www.w3.org/2000/svg' "
+            "namespace (got '{http://www.w3.org/1999/xhtml}html')",
+        ),
+        (
+            '<svg viewBox="0 0 100 100"/>',
+            "the root element must be 'svg' in the 'http://www.w3.org/2000/svg' "
+            "namespace (got 'svg')",
+        ),
+        (
+            '<?xml version="1.0"?><!DOCTYPE svg [<!ENTITY x SYSTEM "file:///etc/passwd">]>'
+            '<svg xmlns="http://www.w3.org/2000/svg">&x;</svg>',
+            "it must not declare XML entities",
+        ),
+        (
+            '<svg xmlns="http://www.w3.org/2000/svg"/>',
+            "the root element must have a 'viewBox' attribute",
+        ),
+        (
+            _svg("", viewbox="0 0 100"),
+            "the 'viewBox' attribute is invalid: '0 0 100'",
+        ),
+        (
+            _svg("", viewbox="0 0 512 512"),
+            "the 'viewBox' must define a 100x100 canvas (got 512x512)",
+        ),
+        (
+            _svg("<script>alert(1)</script>"),
+            "it must not include 'script' elements",
+        ),
+        (
+            _svg("<foreignObject><div/></foreignObject>"),
+            "it must not include 'foreignObject' elements",
+        ),
+        (
+            _svg('<rect onclick="alert(1)"/>'),
+            "it must not include event handlers ('onclick' in 'rect')",
+        ),
+        (
+            _svg('<image href="https://example.com/logo.png"/>'),
+            "it must not reference external resources "
+            "('https://example.com/logo.png' in 'image')",
+        ),
+        (
+            _svg('<use xlink:href="other.svg#shape"/>'),
+            "it must not reference external resources ('other.svg#shape' in 'use')",
+        ),
+        (
+            _svg('<rect fill="url(https://example.com/pattern.svg#p)"/>'),
+            "it must not reference external resources ('fill' in 'rect')",
+        ),
+        (
+            _svg("<style>@import url(https://example.com/style.css);</style>"),
+            "its styles must not reference external resources",
+        ),
+    ],
+)
+def test_validate_icon_problems(tmp_path, content, problem):
+    """Different problems in the icon."""
+    icon = tmp_path / "icon.svg"
+    icon.write_text(content)
+    assert validate_icon(icon) == [problem]
+
+
+def test_validate_icon_several_problems(tmp_path):
+    """All the problems are reported, but only once each."""
+    icon = tmp_path / "icon.svg"
+    icon.write_text(_svg("<script/><script/><rect onload='x()'/>", viewbox="0 0 1 1"))
+    assert validate_icon(icon) == [
+        "the 'viewBox' must define a 100x100 canvas (got 1x1)",
+        "it must not include 'script' elements",
+        "it must not include event handlers ('onload' in 'rect')",
+    ]
+
+
+def test_validate_icon_too_big(tmp_path):
+    """The icon is too big."""
+    icon = tmp_path / "icon.svg"
+    icon.write_text(_svg("<!--" + "x" * ICON_MAX_SIZE + "-->"))
+    (problem,) = validate_icon(icon)
+    assert problem.startswith("the file is too big (")
+
+
+def test_check_icon_missing(tmp_path):
+    """The icon is optional."""
+    assert check_icon(tmp_path) == []
+
+
+def test_check_icon_present(tmp_path):
+    """The icon is validated if present."""
+    (tmp_path / "icon.svg").write_text(_svg("<script/>"))
+    assert check_icon(tmp_path) == ["it must not include 'script' elements"]
+
+
+def test_analyze(tmp_path, config):
+    """All the checks are run."""
+    (tmp_path / "icon.svg").write_text(_svg("<script/>"))
+    assert analyze(config, tmp_path) == [
+        CheckResult("icon", ["it must not include 'script' elements"]),
+        CheckResult("containers", []),
+    ]
+
+
+def test_analyze_with_catalog(tmp_path, config):
+    """The interfaces are also checked if a catalog is given."""
+    catalog_dir = tmp_path / "catalog"
+    (catalog_dir / "mysql").mkdir(parents=True)
+    (tmp_path / "metadata.yaml").write_text(
+        "name: test-charm\nrequires:\n  db:\n    interface: mysq\n"
+    )
+    assert analyze(config, tmp_path, InterfacesCatalog(catalog_dir)) == [
+        CheckResult("icon", []),
+        CheckResult("containers", []),
+        CheckResult(
+            "interfaces",
+            [
+                "unknown interface 'mysq' in the requires relation 'db' (did you "
+                "mean 'mysql'?)"
+            ],
+        ),
+    ]
+
+
+# -- tests for the sidecar containers checks
+
+
+def _write_metadata(basedir, content):
+    """Write the charm's metadata."""
+    (basedir / "metadata.yaml").write_text(dedent(content))
+
+
+def test_check_containers_ok(tmp_path, config):
+    """A consistent sidecar charm."""
+    _write_metadata(
+        tmp_path,
+        """
+        name: test-charm
+        containers:
+          app:
+            resource: app-image
+            mounts:
+              - storage: data
+                location: /var/lib/app
+              - storage: logs
+          other:
+            bases:
+              - name: ubuntu
+                channel: "20.04"
+        resources:
+          app-image:
+            type: oci-image
+          config-file:
+            type: file
+            filename: config.yaml
+        storage:
+          data:
+            type: filesystem
+          logs:
+            type: filesystem
+            location: /var/log/app
+        """,
+    )
+    assert check_containers(config, tmp_path) == []
+
+
+def test_check_containers_not_sidecar(tmp_path, config):
+    """Nothing to check if the charm has no containers (e.g. a pod-spec charm)."""
+    _write_metadata(
+        tmp_path,
+        """
+        name: test-charm
+        resources:
+          app-image:
+            type: oci-image
+        """,
+    )
+    assert check_containers(config, tmp_path) == []
+
+
+def test_check_containers_no_metadata(tmp_path, config):
+    """Nothing to check if there is no metadata (e.g. a bundle)."""
+    assert check_containers(config, tmp_path) == []
+
+
+def test_check_containers_resources_problems(tmp_path, config):
+    """Problems with the resources used by the containers."""
+    _write_metadata(
+        tmp_path,
+        """
+        name: test-charm
+        containers:
+          no-resource: {}
+          undeclared:
+            resource: missing-image
+          bad-type:
+            resource: config-file
+        resources:
+          config-file:
+            type: file
+          unused-image:
+            type: oci-image
+        """,
+    )
+    assert check_containers(config, tmp_path) == [
+        "container 'no-resource' must indicate its image 'resource' (or its 'bases')",
+        "container 'undeclared' uses the undeclared resource 'missing-image'",
+        "container 'bad-type' uses resource 'config-file' which is of type 'file' "
+        "(it must be 'oci-image')",
+        "the 'oci-image' resource 'unused-image' is not used by any container",
+    ]
+
+
+def test_check_containers_from_config(tmp_path, config):
+    """The containers are taken from the metadata in charmcraft.yaml, if there."""
+    _write_metadata(tmp_path, "name: test-charm\n")
+    metadata = CharmMetadata(
+        name="test-charm",
+        containers={"app": {"resource": "missing-image"}},
+    )
+    config.set(metadata=metadata)
+    assert check_containers(config, tmp_path) == [
+        "container 'app' uses the undeclared resource 'missing-image'",
+    ]
+
+
+def test_check_containers_mounts_problems(tmp_path, config):
+    """Problems with the storage mounted in the containers."""
+    _write_metadata(
+        tmp_path,
+        """
+        name: test-charm
+        containers:
+          app:
+            resource: app-image
+            mounts:
+              - location: /nothing
+              - storage: missing
+                location: /missing
+              - storage: block
+                location: /block
+              - storage: data
+                location: relative/path
+              - storage: data
+                location: /block
+        resources:
+          app-image:
+            type: oci-image
+        storage:
+          block:
+            type: block
+          data:
+            type: filesystem
+        """,
+    )
+    assert check_containers(config, tmp_path) == [
+        "a mount in container 'app' does not indicate its storage",
+        "container 'app' mounts the undeclared storage 'missing'",
+        "container 'app' mounts storage 'block' which is of type 'block' (it must "
+        "be 'filesystem')",
+        "the mount location 'relative/path' in container 'app' must be an absolute "
+        "path",
+        "the mount location '/block' is used more than once in container 'app'",
+    ]
+
+
+def test_check_containers_mounts_not_strings(tmp_path, config):
+    """The storage and location of the mounts must be strings (not lists or maps)."""
+    _write_metadata(
+        tmp_path,
+        """
+        name: test-charm
+        containers:
+          app:
+            resource: app-image
+            mounts:
+              - storage: [data]
+              - storage: data
+                location: [/data]
+              - storage: data
+                location: {path: /data}
+        resources:
+          app-image:
+            type: oci-image
+        storage:
+          data:
+            type: filesystem
+        """,
+    )
+    assert check_containers(config, tmp_path) == [
+        "the mount storage ['data'] in container 'app' must be a string",
+        "the mount location ['/data'] in container 'app' must be a string",
+        "the mount location {'path': '/data'} in container 'app' must be a string",
+    ]
+
+
+# -- tests for the icon rendering
+
+
+def test_render_icon_ok(tmp_path):
+    """The renderer is called with the proper parameters."""
+    with patch("subprocess.run") as run_mock:
+        run_mock.return_value = subprocess.CompletedProcess([], 0, "", "")
+        render_icon(tmp_path / "icon.svg", tmp_path / "icon.png", size=200)
+    run_mock.assert_called_with(
+        [
+            "rsvg-convert",
+            "--format=png",
+            "--width=200",
+            "--height=200",
+            "--output={}".format(tmp_path / "icon.png"),
+            str(tmp_path / "icon.svg"),
+        ],
+        stdout=subprocess.PIPE,
+        stderr=subprocess.PIPE,
+        universal_newlines=True,
+    )
+
+
+def test_render_icon_failure(tmp_path):
+    """The renderer failed."""
+    with patch("subprocess.run") as run_mock:
+        run_mock.return_value = subprocess.CompletedProcess([], 1, "", "bad icon\n")
+        with pytest.raises(CommandError) as cm:
+            render_icon(tmp_path / "icon.svg", tmp_path / "icon.png")
+    assert str(cm.value) == "Cannot render the icon: bad icon"
+
+
+def test_render_icon_missing_renderer(tmp_path):
+    """The renderer is not installed."""
+    with patch("subprocess.run", side_effect=FileNotFoundError()):
+        with pytest.raises(CommandError) as cm:
+            render_icon(tmp_path / "icon.svg", tmp_path / "icon.png")
+    assert str(cm.value) == (
+        "Cannot render the icon: the 'rsvg-convert' executable was not found (it is "
+        "usually provided by the 'librsvg2-bin' package)."
+    )
@@ -0,0 +1,122 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the charm's metadata, actions and config (code in metadata.py)."""
+
+import pytest
+import yaml
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.config import (
+    Action,
+    CharmConfig,
+    CharmMetadata,
+    ConfigOption,
+    Relation,
+)
+from charmcraft.metadata import get_actions, get_legacy_files, get_metadata
+
+
+def test_get_metadata_from_config(tmp_path, config):
+    """The metadata declared in charmcraft.yaml is used."""
+    (tmp_path / "metadata.yaml").write_text("name: other-name")
+    metadata = CharmMetadata(
+        name="test-charm", requires={"db": Relation(interface="mysql")}
+    )
+    config.set(metadata=metadata)
+    assert get_metadata(config, tmp_path) == {
+        "name": "test-charm",
+        "requires": {"db": {"interface": "mysql"}},
+    }
+
+
+def test_get_metadata_from_file(tmp_path, config):
+    """The metadata is read from its file if not in charmcraft.yaml."""
+    (tmp_path / "metadata.yaml").write_text("name: test-charm")
+    assert get_metadata(config, tmp_path) == {"name": "test-charm"}
+
+
+def test_get_metadata_without_config(tmp_path):
+    """Only the file is used if no config is given (e.g. for a packed charm)."""
+    (tmp_path / "metadata.yaml").write_text("name: test-charm")
+    assert get_metadata(None, tmp_path) == {"name": "test-charm"}
+
+
+def test_get_metadata_missing(tmp_path, config):
+    """The metadata is not declared at all."""
+    assert get_metadata(config, tmp_path) == {}
+
+
+def test_get_actions_from_config(tmp_path, config):
+    """The actions declared in charmcraft.yaml are used."""
+    config.set(actions={"backup": Action(description="Back it up.")})
+    assert get_actions(config, tmp_path) == {"backup": {"description": "Back it up."}}
+
+
+def test_get_actions_from_file(tmp_path, config):
+    """The actions are read from their file if not in charmcraft.yaml."""
+    (tmp_path / "actions.yaml").write_text("backup: {}\nrestore: {}\n")
+    assert list(get_actions(config, tmp_path)) == ["backup", "restore"]
+
+
+def test_legacy_files_none(tmp_path, config):
+    """Nothing to generate if the sections are not in charmcraft.yaml."""
+    (tmp_path / "metadata.yaml").write_text("name: test-charm")
+    assert get_legacy_files(config, tmp_path) == {}
+
+
+def test_legacy_files_all(tmp_path, config):
+    """The files are generated for all the sections in charmcraft.yaml."""
+    config.set(
+        metadata=CharmMetadata(name="test-charm", summary="Tests.", subordinate=False),
+        actions={
+            "backup": Action(description="Back it up.", additional_properties=False)
+        },
+        charm_config=CharmConfig(
+            options={"port": ConfigOption(type="int", default=8080)}
+        ),
+    )
+    legacy_files = get_legacy_files(config, tmp_path)
+    assert {
+        filename: yaml.safe_load(content) for filename, content in legacy_files.items()
+    } == {
+        "metadata.yaml": {
+            "name": "test-charm",
+            "summary": "Tests.",
+            "subordinate": False,
+        },
+        "actions.yaml": {
+            "backup": {"description": "Back it up.", "additionalProperties": False}
+        },
+        "config.yaml": {"options": {"port": {"type": "int", "default": 8080}}},
+    }
+
+
+@pytest.mark.parametrize("filename", ["metadata.yaml", "actions.yaml", "config.yaml"])
+def test_legacy_files_also_in_project(tmp_path, config, filename):
+    """A section cannot be in charmcraft.yaml and in its file at the same time."""
+    config.set(
+        metadata=CharmMetadata(name="test-charm"),
+        actions={},
+        charm_config=CharmConfig(),
+    )
+    (tmp_path / filename).write_text("{}")
+    with pytest.raises(CommandError) as cm:
+        get_legacy_files(config, tmp_path)
+    assert str(cm.value) == (
+        "Cannot generate {!r}: its content is declared in charmcraft.yaml but the "
+        "project also has the file; remove one of them.".format(filename)
+    )
@@ -0,0 +1,244 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the notifications after Charmhub operations (code in notifications.py)."""
+
+import json
+import logging
+import sys
+from textwrap import dedent
+from unittest.mock import patch
+
+import pytest
+
+from charmcraft.config import NotificationConfig
+from charmcraft.notifications import build_payload, notify
+from charmcraft.utils import ResourceOption
+
+WEBHOOK_URL = "http://localhost:8000/hooks/s3cr3t"
+
+
+@pytest.fixture
+def payload():
+    """Provide the payload of a release."""
+    return build_payload(
+        "release", "testcharm", 7, ["latest/edge"], [ResourceOption("db", 3)]
+    )
+
+
+@pytest.fixture
+def script(tmp_path):
+    """Provide a command that saves what it receives in the project directory."""
+    filepath = tmp_path / "notify.py"
+    filepath.write_text(
+        dedent(
+            """
+            import json, os, sys
+            with open("received.json", "w") as fh:
+                json.dump({
+                    "payload": json.load(sys.stdin),
+                    "event": os.environ["CHARMCRAFT_EVENT"],
+                    "name": os.environ["CHARMCRAFT_NAME"],
+                    "revision": os.environ["CHARMCRAFT_REVISION"],
+                    "channels": os.environ["CHARMCRAFT_CHANNELS"],
+                }, fh)
+            print("done!")
+            sys.exit(int(sys.argv[1]))
+            """
+        )
+    )
+    return "{} {}".format(sys.executable, filepath)
+
+
+def test_build_payload():
+    """Build the payload with the operation's details."""
+    payload = build_payload(
+        "upload", "testcharm", 7, ["edge", "beta"], [ResourceOption("db", 3)]
+    )
+    assert payload == {
+        "event": "upload",
+        "name": "testcharm",
+        "revision": 7,
+        "channels": ["edge", "beta"],
+        "resources": [{"name": "db", "revision": 3}],
+    }
+
+
+def test_build_payload_extra():
+    """Build the payload with extra details."""
+    payload = build_payload("publish-lib", "testcharm", library={"name": "lib"})
+    assert payload == {
+        "event": "publish-lib",
+        "name": "testcharm",
+        "revision": None,
+        "channels": [],
+        "resources": [],
+        "library": {"name": "lib"},
+    }
+
+
+def test_notify_nothing_configured(config, payload, responses):
+    """Nothing is notified if not configured."""
+    notify(config, payload)
+    assert len(responses.calls) == 0
+
+
+def test_notify_webhook(caplog, config, payload, responses):
+    """POST the payload to the webhook."""
+    caplog.set_level(logging.DEBUG, logger="charmcraft.notifications")
+    config.set(notifications=[NotificationConfig(url=WEBHOOK_URL)])
+    responses.add(responses.POST, WEBHOOK_URL)
+
+    notify(config, payload)
+
+    (sent,) = responses.calls
+    assert json.loads(sent.request.body) == payload
+    assert [rec.message for rec in caplog.records] == [
+        "Notified the release to the webhook at 'localhost:8000'."
+    ]
+
+
+def test_notify_webhook_error(caplog, config, payload, responses):
+    """The webhook's errors are reported, without failing."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.notifications")
+    config.set(notifications=[NotificationConfig(url=WEBHOOK_URL)])
+    responses.add(responses.POST, WEBHOOK_URL, status=500)
+
+    notify(config, payload)
+
+    (record,) = caplog.records
+    assert record.levelname == "WARNING"
+    assert record.message.startswith(
+        "Cannot notify the release to the webhook at 'localhost:8000': "
+    )
+
+
+def test_notify_webhook_unreachable(caplog, config, payload, responses):
+    """The connection problems are reported, without failing."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.notifications")
+    config.set(notifications=[NotificationConfig(url="https://example.com/hook")])
+
+    notify(config, payload)
+
+    (record,) = caplog.records
+    assert record.message.startswith(
+        "Cannot notify the release to the webhook at 'example.com': "
+    )
+
+
+def test_notify_only_configured_events(config, payload, responses):
+    """Only the configured events are notified."""
+    config.set(
+        notifications=[
+            NotificationConfig(url="https://example.com/uploads", events=["upload"]),
+            NotificationConfig(url=WEBHOOK_URL, events=["release"]),
+        ]
+    )
+    responses.add(responses.POST, WEBHOOK_URL)
+
+    notify(config, payload)
+
+    assert [call.request.url for call in responses.calls] == [WEBHOOK_URL]
+
+
+def test_notify_all_after_failure(caplog, config, payload, responses):
+    """A failure does not prevent the rest of the notifications."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.notifications")
+    config.set(
+        notifications=[
+            NotificationConfig(url="https://example.com/hook"),
+            NotificationConfig(url=WEBHOOK_URL),
+        ]
+    )
+    responses.add(responses.POST, WEBHOOK_URL)
+
+    notify(config, payload)
+
+    assert len(caplog.records) == 1
+    assert [call.request.url for call in responses.calls] == [WEBHOOK_URL]
+
+
+def test_notify_command(caplog, config, payload, script, tmp_path):
+    """Run the command in the project directory, giving it the payload."""
+    caplog.set_level(logging.DEBUG, logger="charmcraft.notifications")
+    config.set(notifications=[NotificationConfig(command=script + " 0")])
+
+    notify(config, payload)
+
+    received = json.loads((tmp_path / "received.json").read_text())
+    assert received == {
+        "payload": payload,
+        "event": "release",
+        "name": "testcharm",
+        "revision": "7",
+        "channels": "latest/edge",
+    }
+    assert [rec.message for rec in caplog.records] == [
+        "  :: done!",
+        "Notified the release to the command {!r}.".format(script + " 0"),
+    ]
+
+
+def test_notify_command_failed(caplog, config, payload, script):
+    """The command's failures are reported, without failing."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.notifications")
+    config.set(notifications=[NotificationConfig(command=script + " 3")])
+
+    notify(config, payload)
+
+    assert [rec.message for rec in caplog.records] == [
+        "Cannot notify the release to the command {!r}: it exited with return "
+        "code 3".format(script + " 3")
+    ]
+
+
+def test_notify_command_missing(caplog, config, payload):
+    """The command cannot be run."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.notifications")
+    config.set(notifications=[NotificationConfig(command="./missing.sh")])
+
+    notify(config, payload)
+
+    (record,) = caplog.records
+    assert record.message.startswith(
+        "Cannot notify the release to the command './missing.sh': "
+    )
+
+
+def test_notify_command_unparseable(caplog, config, payload):
+    """The command cannot be split in its arguments."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.notifications")
+    config.set(notifications=[NotificationConfig(command="./deploy.sh")])
+
+    error = ValueError("No closing quotation")
+    with patch("charmcraft.notifications.shlex.split", side_effect=error):
+        notify(config, payload)
+
+    assert [rec.message for rec in caplog.records] == [
+        "Cannot notify the release to the command './deploy.sh': No closing quotation"
+    ]
+
+
+def test_notify_command_timeout(caplog, config, payload):
+    """The command takes too long."""
+    caplog.set_level(logging.WARNING, logger="charmcraft.notifications")
+    command = "{} -c 'import time; time.sleep(5)'".format(sys.executable)
+    config.set(notifications=[NotificationConfig(command=command, timeout=1)])
+
+    notify(config, payload)
+
+    (record,) = caplog.records
+    assert "timed out after 1 seconds" in record.message
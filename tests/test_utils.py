@@ -26,6 +26,7 @@ import yaml
 
 from charmcraft import __version__
 from charmcraft.cmdbase import CommandError
+from charmcraft.config import Base
 from charmcraft.utils import (
     ARCH_TRANSLATIONS,
     ResourceOption,
@@ -377,6 +378,19 @@ def test_manifest_fields_from_strict_snap(tmp_path, monkeypatch):
     assert base["channel"] == "20.04"
 
 
+def test_manifest_indicated_base(tmp_path):
+    """The indicated base is used instead of the current system."""
+    os_platform = OSPlatform(system="Ubuntu", release="22.04", machine="x86_64")
+    base = Base(name="ubuntu", channel="20.04")
+    with patch("charmcraft.utils.get_os_platform", return_value=os_platform):
+        result_filepath = create_manifest(tmp_path, datetime.datetime.now(), base=base)
+
+    saved = yaml.safe_load(result_filepath.read_text())
+    assert saved["bases"] == [
+        {"name": "ubuntu", "channel": "20.04", "architectures": ["amd64"]}
+    ]
+
+
 def test_manifest_dont_overwrite(tmp_path):
     """Don't overwrite the already-existing file."""
     (tmp_path / "manifest.yaml").touch()
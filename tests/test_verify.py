@@ -0,0 +1,275 @@
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Tests for the artifacts verification (code in verify.py)."""
+
+import logging
+import stat
+import zipfile
+
+import pytest
+
+from charmcraft.cmdbase import CommandError
+from charmcraft.verify import ensure_deployable, verify_artifact
+
+DISPATCH = (
+    "#!/bin/sh\n"
+    'JUJU_DISPATCH_PATH="${JUJU_DISPATCH_PATH:-$0}" exec ./src/charm.py\n'
+)
+
+
+def _add(zf, name, content="", mode=0o644, kind=stat.S_IFREG):
+    """Add an entry to the zip file with the indicated permissions."""
+    info = zipfile.ZipInfo(name)
+    info.external_attr = (kind | mode) << 16
+    zf.writestr(info, content)
+
+
+def _add_link(zf, name, target):
+    """Add a symlink to the zip file."""
+    _add(zf, name, target, mode=0o777, kind=stat.S_IFLNK)
+
+
+def _create_charm(zippath, skip=(), **extra):
+    """Create a valid charm, without the indicated files and with the extra ones."""
+    files = {
+        "metadata.yaml": "name: test-charm\n",
+        "manifest.yaml": "charmcraft-version: 1.0\n",
+        "dispatch": DISPATCH,
+        "src/charm.py": "# the charm",
+    }
+    with zipfile.ZipFile(str(zippath), "w") as zf:
+        for name, content in files.items():
+            if name not in skip:
+                _add(zf, name, content, mode=0o755)
+        _add_link(zf, "hooks/install", "../dispatch")
+        for name, content in extra.items():
+            _add(zf, name, content)
+    return zippath
+
+
+def _create_bundle(zippath, skip=()):
+    """Create a valid bundle, without the indicated files."""
+    files = {
+        "bundle.yaml": "applications: {}\n",
+        "manifest.yaml": "charmcraft-version: 1.0\n",
+        "README.md": "A bundle.",
+    }
+    with zipfile.ZipFile(str(zippath), "w") as zf:
+        for name, content in files.items():
+            if name not in skip:
+                _add(zf, name, content)
+    return zippath
+
+
+def test_charm_ok(tmp_path):
+    """A valid charm."""
+    zippath = _create_charm(tmp_path / "test.charm")
+    assert verify_artifact(zippath) == ("charm", [])
+
+
+def test_bundle_ok(tmp_path):
+    """A valid bundle."""
+    zippath = _create_bundle(tmp_path / "bundle.zip")
+    assert verify_artifact(zippath) == ("bundle", [])
+
+
+def test_bundle_missing_files(tmp_path):
+    """The bundle must include its manifest and README."""
+    skip = ["manifest.yaml", "README.md"]
+    zippath = _create_bundle(tmp_path / "bundle.zip", skip=skip)
+    assert verify_artifact(zippath) == (
+        "bundle",
+        ["The 'manifest.yaml' file is missing.", "The 'README.md' file is missing."],
+    )
+
+
+def test_not_a_zip(tmp_path):
+    """The file is not a charm at all."""
+    filepath = tmp_path / "test.charm"
+    filepath.write_text("whatever")
+    with pytest.raises(CommandError) as cm:
+        verify_artifact(filepath)
+    assert str(cm.value) == (
+        "Cannot open {!r}: it is not a charm or bundle file.".format(str(filepath))
+    )
+
+
+def test_corrupted(tmp_path):
+    """A damaged entry in the archive."""
+    zippath = _create_charm(tmp_path / "test.charm")
+    content = zippath.read_bytes()
+    zippath.write_bytes(content.replace(b"# the charm", b"# THE CHARM"))
+    assert verify_artifact(zippath) == (
+        "charm",
+        ["The file 'src/charm.py' is corrupted."],
+    )
+
+
+def test_corrupted_compressed_data(tmp_path):
+    """A compressed entry that cannot be decompressed."""
+    zippath = tmp_path / "test.charm"
+    with zipfile.ZipFile(str(zippath), "w", zipfile.ZIP_DEFLATED) as zf:
+        zf.writestr("metadata.yaml", "name: test-charm\n" * 100)
+    with zipfile.ZipFile(str(zippath)) as zf:
+        info = zf.getinfo("metadata.yaml")
+        offset = info.header_offset + len(info.FileHeader())
+    content = bytearray(zippath.read_bytes())
+    content[offset] = 0xFF
+    zippath.write_bytes(bytes(content))
+
+    kind, problems = verify_artifact(zippath)
+    assert kind == "charm"
+    (problem,) = problems
+    assert problem.startswith("The artifact is corrupted: Error -3 ")
+
+
+def test_dispatch_not_executable(tmp_path):
+    """The dispatch script must be executable."""
+    zippath = tmp_path / "test.charm"
+    with zipfile.ZipFile(str(zippath), "w") as zf:
+        _add(zf, "metadata.yaml", "name: test-charm\n")
+        _add(zf, "manifest.yaml", "charmcraft-version: 1.0\n")
+        _add(zf, "dispatch", DISPATCH, mode=0o644)
+        _add(zf, "src/charm.py", "# the charm", mode=0o755)
+    assert verify_artifact(zippath) == (
+        "charm",
+        ["The 'dispatch' file is not executable."],
+    )
+
+
+def test_hooks_actions_not_executable(tmp_path, caplog):
+    """Hooks and actions that are not executable are just warned about."""
+    caplog.set_level(logging.WARNING, logger="charmcraft")
+    zippath = tmp_path / "test.charm"
+    with zipfile.ZipFile(str(zippath), "w") as zf:
+        _add(zf, "metadata.yaml", "name: test-charm\n")
+        _add(zf, "manifest.yaml", "charmcraft-version: 1.0\n")
+        _add(zf, "dispatch", DISPATCH, mode=0o755)
+        _add(zf, "src/charm.py", "# the charm", mode=0o755)
+        _add(zf, "hooks/start", "# a hook", mode=0o644)
+        _add(zf, "actions/backup", "# an action", mode=0o644)
+    assert verify_artifact(zippath) == ("charm", [])
+    assert [
+        rec.message for rec in caplog.records if rec.name == "charmcraft.verify"
+    ] == [
+        "The 'hooks/start' file is not executable.",
+        "The 'actions/backup' file is not executable.",
+    ]
+
+
+def test_no_dispatch_nor_hooks(tmp_path):
+    """The charm must have some way to be run."""
+    zippath = tmp_path / "test.charm"
+    with zipfile.ZipFile(str(zippath), "w") as zf:
+        _add(zf, "metadata.yaml", "name: test-charm\n")
+        _add(zf, "manifest.yaml", "charmcraft-version: 1.0\n")
+    assert verify_artifact(zippath) == (
+        "charm",
+        ["The charm has no 'dispatch' script nor hooks."],
+    )
+
+
+def test_old_style_hooks(tmp_path):
+    """A charm without dispatch but with executable hooks is fine."""
+    zippath = tmp_path / "test.charm"
+    with zipfile.ZipFile(str(zippath), "w") as zf:
+        _add(zf, "metadata.yaml", "name: test-charm\n")
+        _add(zf, "manifest.yaml", "charmcraft-version: 1.0\n")
+        _add(zf, "hooks/install", "#!/bin/sh\n", mode=0o755)
+    assert verify_artifact(zippath) == ("charm", [])
+
+
+def test_missing_entrypoint(tmp_path):
+    """The entrypoint called by dispatch must be in the charm."""
+    zippath = _create_charm(tmp_path / "test.charm", skip=["src/charm.py"])
+    assert verify_artifact(zippath) == (
+        "charm",
+        ["The entrypoint 'src/charm.py' called by the 'dispatch' script is missing."],
+    )
+
+
+def test_symlink_outside(tmp_path):
+    """The symlinks cannot point outside the charm."""
+    zippath = _create_charm(tmp_path / "test.charm")
+    with zipfile.ZipFile(str(zippath), "a") as zf:
+        _add_link(zf, "hooks/start", "../../dispatch")
+        _add_link(zf, "hooks/stop", "/bin/true")
+    assert verify_artifact(zippath) == (
+        "charm",
+        [
+            "The symlink 'hooks/start' points outside the artifact.",
+            "The symlink 'hooks/stop' points outside the artifact.",
+        ],
+    )
+
+
+def test_symlink_missing_target(tmp_path):
+    """The symlinks must point to files in the charm."""
+    zippath = _create_charm(tmp_path / "test.charm")
+    with zipfile.ZipFile(str(zippath), "a") as zf:
+        _add_link(zf, "hooks/start", "../missing")
+    assert verify_artifact(zippath) == (
+        "charm",
+        [
+            "The symlink 'hooks/start' points to 'missing', "
+            "which is not in the artifact."
+        ],
+    )
+
+
+@pytest.mark.parametrize("content", ["name: [broken", "just a string"])
+def test_bad_yaml(tmp_path, content):
+    """The metadata must be a valid YAML mapping."""
+    zippath = _create_charm(tmp_path / "test.charm", skip=["metadata.yaml"])
+    with zipfile.ZipFile(str(zippath), "a") as zf:
+        _add(zf, "metadata.yaml", content)
+    assert verify_artifact(zippath) == (
+        "charm",
+        ["The 'metadata.yaml' file is not a valid YAML mapping."],
+    )
+
+
+@pytest.mark.parametrize("name", ["/etc/passwd", "../outside", "lib/../../outside"])
+def test_paths_outside(tmp_path, name):
+    """All the paths must be relative and inside the artifact."""
+    zippath = tmp_path / "test.charm"
+    _create_charm(zippath)
+    with zipfile.ZipFile(str(zippath), "a") as zf:
+        info = zipfile.ZipInfo("placeholder")
+        info.external_attr = 0o644 << 16
+        info.filename = name  # ZipInfo would sanitize it, as a real attacker would not
+        zf.writestr(info, "content")
+    assert verify_artifact(zippath) == (
+        "charm",
+        ["The path {!r} is outside the root of the artifact.".format(name)],
+    )
+
+
+def test_ensure_deployable_ok(tmp_path):
+    """Nothing happens if the artifact is fine."""
+    ensure_deployable(_create_charm(tmp_path / "test.charm"))
+
+
+def test_ensure_deployable_problems(tmp_path):
+    """The problems are reported in the error."""
+    zippath = _create_charm(tmp_path / "test.charm", skip=["src/charm.py"])
+    with pytest.raises(CommandError) as cm:
+        ensure_deployable(zippath)
+    assert str(cm.value) == (
+        "The packed charm {!r} is not deployable: The entrypoint 'src/charm.py' "
+        "called by the 'dispatch' script is missing.".format(str(zippath))
+    )
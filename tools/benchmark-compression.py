@@ -0,0 +1,141 @@
+#!/usr/bin/env python3
+# Copyright 2021 Canonical Ltd.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# For further info, check https://github.com/canonical/charmcraft
+
+"""Compare packing a large charm with the parallel compression and zipfile's own.
+
+A tree resembling a venv with thousands of files is created in a temporary
+directory (or an existing one is used, e.g. the 'build' directory of a real
+charm), and zipped with each method; run it from the project's root:
+
+    tools/benchmark-compression.py [--files 5000] [--workers 1 2 4] [--dir DIR]
+
+Any gain from several workers depends on the processors available to them, so
+run it in the kind of machine where the charms are packed.
+"""
+
+import argparse
+import os
+import pathlib
+import random
+import sys
+import tempfile
+import time
+import zipfile
+
+sys.path.insert(0, str(pathlib.Path(__file__).absolute().parent.parent))
+
+from charmcraft.compression import DEFAULT_LEVEL, ParallelZipFile  # noqa: E402
+
+# words to build the files content, so it compresses like source code does
+WORDS = (
+    "def class return self import from if else for in while try except raise with "
+    "as None True False lambda yield assert the value result data name path items"
+).split()
+
+
+def create_tree(dirpath, files):
+    """Create a tree of files with sizes similar to those in a venv."""
+    rnd = random.Random(42)
+    for idx in range(files):
+        filepath = dirpath / "package{}".format(idx // 100) / "module{}.py".format(idx)
+        filepath.parent.mkdir(parents=True, exist_ok=True)
+        lines = rnd.randint(10, 1000)
+        content = "\n".join(
+            "    ".join(rnd.choice(WORDS) for _ in range(8)) for _ in range(lines)
+        )
+        filepath.write_text(content)
+
+
+def get_files(dirpath):
+    """Return the files to pack, in a stable order."""
+    return sorted(path for path in dirpath.rglob("*") if path.is_file())
+
+
+def pack_serial(zippath, basedir, filepaths, level):
+    """Pack the files with zipfile, one after the other."""
+    with zipfile.ZipFile(
+        str(zippath), "w", zipfile.ZIP_DEFLATED, compresslevel=level
+    ) as zf:
+        for filepath in filepaths:
+            zf.write(str(filepath), str(filepath.relative_to(basedir)))
+
+
+def pack_parallel(zippath, basedir, filepaths, level, workers):
+    """Pack the files compressing them in parallel."""
+    with ParallelZipFile(zippath, level=level, workers=workers) as zipfh:
+        for filepath in filepaths:
+            zipfh.write(filepath, filepath.relative_to(basedir))
+
+
+def measure(func, *args):
+    """Return the best time of some runs of the function."""
+    times = []
+    for _ in range(3):
+        start = time.perf_counter()
+        func(*args)
+        times.append(time.perf_counter() - start)
+    return min(times)
+
+
+def main():
+    """Run the benchmark."""
+    cpus = os.cpu_count() or 1
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("--files", type=int, default=5000, help="Files to create")
+    parser.add_argument("--dir", type=pathlib.Path, help="Pack this tree instead")
+    parser.add_argument("--level", type=int, default=DEFAULT_LEVEL)
+    parser.add_argument(
+        "--workers",
+        type=int,
+        nargs="+",
+        default=sorted({1, 2, 4, cpus}),
+        help="The amount of workers to try",
+    )
+    args = parser.parse_args()
+
+    with tempfile.TemporaryDirectory(prefix="charmcraft-benchmark-") as tmpdir:
+        tmpdir = pathlib.Path(tmpdir)
+        basedir = args.dir
+        if basedir is None:
+            basedir = tmpdir / "tree"
+            create_tree(basedir, args.files)
+        filepaths = get_files(basedir)
+        total_size = sum(path.stat().st_size for path in filepaths)
+        print(
+            "Packing {} files ({:.1f} MB) at level {}, {} processors".format(
+                len(filepaths), total_size / 2 ** 20, args.level, cpus
+            )
+        )
+
+        zippath = tmpdir / "serial.zip"
+        serial = measure(pack_serial, zippath, basedir, filepaths, args.level)
+        print("{:<16} {:>8.2f}s".format("zipfile", serial))
+
+        for workers in args.workers:
+            zippath = tmpdir / "parallel.zip"
+            parallel = measure(
+                pack_parallel, zippath, basedir, filepaths, args.level, workers
+            )
+            print(
+                "{:<16} {:>8.2f}s  x{:.2f}".format(
+                    "{} workers".format(workers), parallel, serial / parallel
+                )
+            )
+
+
+if __name__ == "__main__":
+    main()